@@ -0,0 +1,126 @@
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxSize 审计日志文件的默认最大字节数，超过后轮转
+	DefaultMaxSize = 100 * 1024 * 1024
+	// DefaultMaxBackups 默认保留的轮转文件数
+	DefaultMaxBackups = 10
+)
+
+// Event 一条审计记录，每次rpc调用写一行JSON
+type Event struct {
+	Time          time.Time `json:"time"`
+	User          string    `json:"user"`
+	ClientAddress string    `json:"clientAddress"`
+	Operation     string    `json:"operation"`
+	Src           string    `json:"src,omitempty"`
+	Dst           string    `json:"dst,omitempty"`
+	Allowed       bool      `json:"allowed"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// Logger 写入审计日志文件，文件超过maxSize后依次重命名为file.1、file.2……，最多保留maxBackups个轮转文件
+type Logger struct {
+	mutex      sync.Mutex
+	fileName   string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// NewLogger 打开审计日志文件，文件已存在时追加写入，maxSize为0时不轮转
+func NewLogger(fileName string, maxSize int64, maxBackups int) (*Logger, error) {
+	logger := &Logger{fileName: fileName, maxSize: maxSize, maxBackups: maxBackups}
+	err := logger.open()
+	if err != nil {
+		return nil, err
+	}
+	return logger, nil
+}
+
+// open 以追加方式打开日志文件，并记录当前大小
+func (logger *Logger) open() error {
+	file, err := os.OpenFile(logger.fileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	logger.file = file
+	logger.size = info.Size()
+	return nil
+}
+
+// Log 写入一条审计记录，写入前文件大小会超过maxSize时先轮转
+func (logger *Logger) Log(event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	if logger.file == nil {
+		return os.ErrClosed
+	}
+	if logger.maxSize > 0 && logger.size > 0 && logger.size+int64(len(line)) > logger.maxSize {
+		err = logger.rotate()
+		if err != nil {
+			return err
+		}
+	}
+	n, err := logger.file.Write(line)
+	logger.size += int64(n)
+	return err
+}
+
+// rotate 关闭当前文件，file.i依次重命名为file.i+1，超出maxBackups的删除，再打开新的文件
+func (logger *Logger) rotate() error {
+	err := logger.file.Close()
+	logger.file = nil
+	if err != nil {
+		return err
+	}
+	if logger.maxBackups <= 0 {
+		err = os.Remove(logger.fileName)
+	} else {
+		_ = os.Remove(logger.backupName(logger.maxBackups))
+		for i := logger.maxBackups - 1; i >= 1; i-- {
+			_ = os.Rename(logger.backupName(i), logger.backupName(i+1))
+		}
+		err = os.Rename(logger.fileName, logger.backupName(1))
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return logger.open()
+}
+
+// backupName 第i个轮转文件的文件名
+func (logger *Logger) backupName(i int) string {
+	return logger.fileName + "." + strconv.Itoa(i)
+}
+
+// Close 关闭日志文件，之后的写入返回错误
+func (logger *Logger) Close() error {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	if logger.file == nil {
+		return nil
+	}
+	err := logger.file.Close()
+	logger.file = nil
+	return err
+}
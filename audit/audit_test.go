@@ -0,0 +1,78 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readEvents 读取审计日志文件中的记录，每行一条JSON
+func readEvents(t *testing.T, fileName string) []Event {
+	file, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var events []Event
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("Invalid audit line %q: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// TestLogger 测试每条记录写为一行JSON，文件超过大小后轮转，只保留指定数量的轮转文件
+func TestLogger(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "audit.log")
+	event := Event{Time: time.Now(), User: "alice", ClientAddress: "127.0.0.1:50000", Operation: "ReName", Src: "/a/", Dst: "/b/", Allowed: true}
+	line, _ := json.Marshal(event)
+	// 每个文件最多放下两条记录
+	logger, err := NewLogger(fileName, int64(2*(len(line)+1)), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 7; i++ {
+		if err := logger.Log(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := logger.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if logger.Log(event) == nil {
+		t.Errorf("Closed logger should return error")
+	}
+	events := readEvents(t, fileName)
+	if len(events) != 1 || events[0].User != "alice" || events[0].Dst != "/b/" || !events[0].Allowed {
+		t.Errorf("Unable to read current audit log: %+v", events)
+	}
+	if len(readEvents(t, fileName+".1")) != 2 || len(readEvents(t, fileName+".2")) != 2 {
+		t.Errorf("Unable to rotate audit log")
+	}
+	if _, err := os.Stat(fileName + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Rotated files beyond max backups should be removed")
+	}
+
+	// 重新打开时追加到已有的文件
+	logger, err = NewLogger(fileName, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event.Allowed = false
+	event.Error = "denied"
+	if err := logger.Log(event); err != nil {
+		t.Fatal(err)
+	}
+	logger.Close()
+	events = readEvents(t, fileName)
+	if len(events) != 2 || events[1].Allowed || events[1].Error != "denied" {
+		t.Errorf("Unable to append audit log: %+v", events)
+	}
+}
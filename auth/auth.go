@@ -0,0 +1,437 @@
+package auth
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/rpc"
+	"os/user"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// handshakeMagic 连接建立后客户端发送的认证行的前缀，格式为"GODFS-AUTH <凭证>\n"
+	handshakeMagic = "GODFS-AUTH"
+	// handshakeOK 认证通过时服务端的回复，认证失败时回复错误信息后关闭连接
+	handshakeOK = "OK"
+	// handshakeTimeout 服务端等待认证行的时间，也是客户端建立连接和等待认证回复的时间
+	handshakeTimeout = 10 * time.Second
+	// maxHandshakeLength 认证行的最大长度
+	maxHandshakeLength = 4096
+	// serviceTokenLifetime 持有密钥的nameNode、dataNode每次建立连接时为自己签发的令牌的有效期
+	serviceTokenLifetime = 5 * time.Minute
+)
+
+// Identity 认证得到的用户身份，Expiry为凭证的过期时间，为零值时不过期
+type Identity struct {
+	Name   string
+	Groups []string
+	Expiry time.Time
+}
+
+// ServiceGroup nameNode、dataNode等服务进程的身份所在的组，服务之间的内部rpc方法只允许该组的用户调用
+const ServiceGroup = "godfs-service"
+
+// IsService 判断身份是否是服务进程的身份
+func (identity Identity) IsService() bool {
+	for _, group := range identity.Groups {
+		if group == ServiceGroup {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator rpc连接的认证方式：客户端在连接建立后发送Credential，服务端用Verify验证凭证并得到用户身份
+type Authenticator interface {
+	Credential() (string, error)
+	Verify(credential string) (Identity, error)
+}
+
+// authenticator 当前进程使用的认证方式，为nil时不认证，与没有认证层时的行为相同
+var authenticator Authenticator
+
+// SetAuthenticator 设置当前进程的认证方式，之后Dial建立的连接都发送凭证，Accept接受的连接都需要通过认证。
+// 需要在Accept之前设置，Accept开始后修改不影响已经开始的Accept
+func SetAuthenticator(a Authenticator) {
+	authenticator = a
+}
+
+// ErrTokenExpired 连接上的令牌过期后，之后的rpc调用都返回该错误
+var ErrTokenExpired = errors.New("令牌已过期")
+
+// CallInfo 服务端完成的一次rpc调用。User为认证得到的用户，没有认证时为空；
+// Request为解码后的请求，请求没有解码成功时为nil；Error为返回给客户端的错误，调用成功时为空
+type CallInfo struct {
+	ServiceMethod string
+	RemoteAddr    string
+	User          string
+	Request       any
+	Error         string
+}
+
+// callObserver 每次rpc调用返回响应后调用，为nil时不调用
+var callObserver func(CallInfo)
+
+// SetCallObserver 设置rpc调用的观察者，之后Accept接受的连接上每次调用返回响应后都调用observer，用于审计
+func SetCallObserver(observer func(CallInfo)) {
+	callObserver = observer
+}
+
+// Authorizer 检查认证得到的用户能否调用rpc方法，request为解码后的请求，返回错误时拒绝这次调用并把错误返回给客户端
+type Authorizer func(serviceMethod string, identity Identity, request any) error
+
+// authorizer 当前进程使用的授权检查，为nil时认证通过的用户可以调用所有rpc方法
+var authorizer Authorizer
+
+// SetAuthorizer 设置rpc调用的授权检查，只对通过认证的连接生效
+func SetAuthorizer(a Authorizer) {
+	authorizer = a
+}
+
+// HMACAuthenticator 使用共享密钥签名的令牌认证。客户端只需要Token；
+// 持有密钥的nameNode、dataNode没有Token时，每次建立连接用密钥为Identity签发短期令牌
+type HMACAuthenticator struct {
+	Key      []byte
+	Token    string
+	Identity Identity
+}
+
+// Credential 得到连接时发送的令牌
+func (a *HMACAuthenticator) Credential() (string, error) {
+	if a.Token != "" {
+		return a.Token, nil
+	}
+	if len(a.Key) == 0 {
+		return "", errors.New("没有令牌")
+	}
+	return IssueToken(a.Key, a.Identity, serviceTokenLifetime)
+}
+
+// Verify 用密钥校验令牌
+func (a *HMACAuthenticator) Verify(credential string) (Identity, error) {
+	if len(a.Key) == 0 {
+		return Identity{}, errors.New("没有密钥，无法校验令牌")
+	}
+	return VerifyToken(a.Key, credential)
+}
+
+// ServiceAuthenticator 从密钥文件创建nameNode、dataNode使用的认证方式，以服务进程的身份签发令牌
+func ServiceAuthenticator(keyFile string) (*HMACAuthenticator, error) {
+	key, err := LoadKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &HMACAuthenticator{Key: key, Identity: ServiceIdentity()}, nil
+}
+
+// ServiceIdentity nameNode、dataNode等服务进程的身份：运行进程的系统用户，属于ServiceGroup
+func ServiceIdentity() Identity {
+	identity := Identity{Name: "godfs", Groups: []string{ServiceGroup}}
+	if current, err := user.Current(); err == nil {
+		identity.Name = current.Username
+	}
+	return identity
+}
+
+// Server rpc服务端的认证配置，Authenticator为nil时不认证；Authorizer只对通过认证的连接生效，为nil时不检查；
+// Observer为nil时不通知
+type Server struct {
+	Authenticator Authenticator
+	Authorizer    Authorizer
+	Observer      func(CallInfo)
+}
+
+// Client rpc客户端的认证配置，Authenticator为nil时不发送凭证；
+// Timeout不为0时连接上每次读写的超时时间，对方没有响应时rpc调用返回错误，为0时不限制
+type Client struct {
+	Authenticator Authenticator
+	Timeout       time.Duration
+}
+
+// Dial 使用当前进程的认证方式与rpc服务建立连接
+func Dial(address string) (*rpc.Client, error) {
+	client := Client{Authenticator: authenticator}
+	return client.Dial(address)
+}
+
+// DialTimeout 使用当前进程的认证方式与rpc服务建立连接，连接上每次读写的超时时间为timeout
+func DialTimeout(address string, timeout time.Duration) (*rpc.Client, error) {
+	client := Client{Authenticator: authenticator, Timeout: timeout}
+	return client.Dial(address)
+}
+
+// Dial 与rpc服务建立连接，设置了TLS配置时先进行TLS握手，设置了认证方式时再发送凭证，认证通过后才返回rpc客户端
+func (client Client) Dial(address string) (*rpc.Client, error) {
+	conn, err := dial(address)
+	if err != nil {
+		return nil, err
+	}
+	if client.Authenticator != nil {
+		_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+		err = login(conn, client.Authenticator)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		_ = conn.SetDeadline(time.Time{})
+	}
+	if client.Timeout > 0 {
+		conn = &timeoutConn{Conn: conn, timeout: client.Timeout}
+	}
+	return rpc.NewClient(conn), nil
+}
+
+// timeoutConn 每次读写前设置超时时间的连接
+type timeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *timeoutConn) Read(b []byte) (int, error) {
+	_ = c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Read(b)
+}
+
+func (c *timeoutConn) Write(b []byte) (int, error) {
+	_ = c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(b)
+}
+
+// login 发送认证行并等待服务端的回复
+func login(conn net.Conn, authenticator Authenticator) error {
+	credential, err := authenticator.Credential()
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(conn, handshakeMagic+" "+credential+"\n")
+	if err != nil {
+		return err
+	}
+	reply, err := readLine(conn)
+	if err != nil {
+		return err
+	}
+	if reply != handshakeOK {
+		return errors.New("认证失败: " + reply)
+	}
+	return nil
+}
+
+// readLine 逐字节读取一行，不能使用带缓冲的读取，否则会读走之后的rpc数据
+func readLine(conn net.Conn) (string, error) {
+	var line []byte
+	buffer := make([]byte, 1)
+	for len(line) < maxHandshakeLength {
+		_, err := conn.Read(buffer)
+		if err != nil {
+			return "", err
+		}
+		if buffer[0] == '\n' {
+			return string(line), nil
+		}
+		line = append(line, buffer[0])
+	}
+	return "", errors.New("认证信息过长")
+}
+
+// currentServer 当前进程的认证方式、授权检查和观察者
+func currentServer() *Server {
+	return &Server{Authenticator: authenticator, Authorizer: authorizer, Observer: callObserver}
+}
+
+// Accept 使用当前进程的认证配置接受连接并提供rpc服务，代替rpc.Accept
+func Accept(listener net.Listener) {
+	currentServer().Accept(listener)
+}
+
+// ServeConn 使用当前进程的认证配置在连接上提供rpc服务
+func ServeConn(conn net.Conn) {
+	currentServer().ServeConn(conn)
+}
+
+// Accept 接受连接并提供rpc服务
+func (server *Server) Accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Print("rpc.Serve: accept:", err.Error())
+			return
+		}
+		go server.ServeConn(conn)
+	}
+}
+
+// ServeConn 在连接上提供rpc服务，设置了认证方式时先验证客户端的凭证，
+// 请求中有User字段时用认证得到的身份覆盖，客户端不能冒充其他用户
+func (server *Server) ServeConn(conn net.Conn) {
+	if server.Authenticator == nil && server.Observer == nil {
+		rpc.ServeConn(conn)
+		return
+	}
+	codec := &serverCodec{rwc: conn, remoteAddr: conn.RemoteAddr().String(), observer: server.Observer}
+	if server.Authenticator != nil {
+		identity, err := accept(conn, server.Authenticator)
+		if err != nil {
+			log.Printf("Authentication from %s failed: %v\n", conn.RemoteAddr(), err)
+			_, _ = io.WriteString(conn, strings.ReplaceAll(err.Error(), "\n", " ")+"\n")
+			conn.Close()
+			return
+		}
+		_, err = io.WriteString(conn, handshakeOK+"\n")
+		if err != nil {
+			conn.Close()
+			return
+		}
+		codec.identity = &identity
+		codec.authorizer = server.Authorizer
+	}
+	buf := bufio.NewWriter(conn)
+	codec.dec = gob.NewDecoder(conn)
+	codec.enc = gob.NewEncoder(buf)
+	codec.encBuf = buf
+	if codec.observer != nil {
+		codec.pending = make(map[uint64]*CallInfo)
+	}
+	rpc.ServeCodec(codec)
+}
+
+// accept 读取并验证认证行
+func accept(conn net.Conn, authenticator Authenticator) (Identity, error) {
+	_ = conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	// 先读取前缀，没有认证直接发送rpc请求的客户端不用等到超时
+	prefix := make([]byte, len(handshakeMagic)+1)
+	_, err := io.ReadFull(conn, prefix)
+	if err != nil {
+		return Identity{}, err
+	}
+	if string(prefix) != handshakeMagic+" " {
+		return Identity{}, errors.New("需要认证")
+	}
+	credential, err := readLine(conn)
+	if err != nil {
+		return Identity{}, err
+	}
+	return authenticator.Verify(credential)
+}
+
+// serverCodec 与net/rpc默认的gob编解码相同。认证过的连接读取请求时检查凭证是否过期，用认证得到的身份覆盖请求中的User字段，
+// 设置了授权检查时拒绝没有权限的调用；设置了观察者时记录每个请求，返回响应后通知观察者
+type serverCodec struct {
+	rwc        io.ReadWriteCloser
+	dec        *gob.Decoder
+	enc        *gob.Encoder
+	encBuf     *bufio.Writer
+	identity   *Identity //没有认证时为nil
+	closed     bool
+	remoteAddr string
+	method     string //正在读取请求体的调用的方法名
+	observer   func(CallInfo)
+	authorizer Authorizer
+	mutex      sync.Mutex
+	pending    map[uint64]*CallInfo //key:请求序号 value:还没有返回响应的调用
+	current    *CallInfo            //正在读取请求体的调用
+}
+
+func (c *serverCodec) ReadRequestHeader(r *rpc.Request) error {
+	err := c.dec.Decode(r)
+	if err != nil {
+		return err
+	}
+	c.method = r.ServiceMethod
+	if c.observer == nil {
+		return nil
+	}
+	c.current = &CallInfo{ServiceMethod: r.ServiceMethod, RemoteAddr: c.remoteAddr}
+	if c.identity != nil {
+		c.current.User = c.identity.Name
+	}
+	c.mutex.Lock()
+	c.pending[r.Seq] = c.current
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *serverCodec) ReadRequestBody(body any) error {
+	err := c.dec.Decode(body)
+	if err != nil {
+		return err
+	}
+	if c.identity != nil {
+		if !c.identity.Expiry.IsZero() && time.Now().After(c.identity.Expiry) {
+			return ErrTokenExpired
+		}
+		setUser(body, *c.identity)
+	}
+	if c.current != nil {
+		c.current.Request = body
+	}
+	if c.identity != nil && c.authorizer != nil {
+		return c.authorizer(c.method, *c.identity, body)
+	}
+	return nil
+}
+
+func (c *serverCodec) WriteResponse(r *rpc.Response, body any) (err error) {
+	if c.observer != nil {
+		c.mutex.Lock()
+		call, ok := c.pending[r.Seq]
+		delete(c.pending, r.Seq)
+		c.mutex.Unlock()
+		if ok {
+			defer func() {
+				call.Error = r.Error
+				c.observer(*call)
+			}()
+		}
+	}
+	if err = c.enc.Encode(r); err != nil {
+		if c.encBuf.Flush() == nil {
+			log.Println("rpc: gob error encoding response:", err)
+			c.Close()
+		}
+		return
+	}
+	if err = c.enc.Encode(body); err != nil {
+		if c.encBuf.Flush() == nil {
+			log.Println("rpc: gob error encoding body:", err)
+			c.Close()
+		}
+		return
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *serverCodec) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.rwc.Close()
+}
+
+// setUser 请求是结构体并且有User{Name, Groups}字段时，设置为认证得到的身份
+func setUser(body any, identity Identity) {
+	value := reflect.ValueOf(body)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return
+	}
+	userField := value.Elem().FieldByName("User")
+	if !userField.IsValid() || !userField.CanSet() || userField.Kind() != reflect.Struct {
+		return
+	}
+	name := userField.FieldByName("Name")
+	groups := userField.FieldByName("Groups")
+	if !name.IsValid() || name.Kind() != reflect.String || !groups.IsValid() || groups.Type() != reflect.TypeOf([]string(nil)) {
+		return
+	}
+	name.SetString(identity.Name)
+	groups.Set(reflect.ValueOf(identity.Groups))
+}
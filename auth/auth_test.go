@@ -0,0 +1,293 @@
+package auth
+
+import (
+	"errors"
+	"net"
+	"net/rpc"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// registerOnce 测试重复运行时rpc服务只注册一次
+var registerOnce sync.Once
+
+// TestUser 与nameNode请求中的User字段结构相同
+type TestUser struct {
+	Name   string
+	Groups []string
+}
+
+// TestRequest 带有User字段的请求
+type TestRequest struct {
+	Path string
+	User TestUser
+}
+
+// TestService 返回服务端看到的请求中的用户
+type TestService struct{}
+
+func (service *TestService) WhoAmI(request *TestRequest, reply *string) error {
+	*reply = request.User.Name + ":" + request.Path
+	return nil
+}
+
+// TestToken 测试令牌的签发、校验、篡改和过期
+func TestToken(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "godfs.key")
+	if err := GenerateKey(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if GenerateKey(keyFile) == nil {
+		t.Errorf("Existing key file should not be overwritten")
+	}
+	key, err := LoadKey(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := IssueToken(key, Identity{Name: "alice", Groups: []string{"staff"}}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err := VerifyToken(key, token)
+	if err != nil || identity.Name != "alice" || len(identity.Groups) != 1 || identity.Groups[0] != "staff" {
+		t.Fatalf("Unable to verify token: %+v %v", identity, err)
+	}
+	if _, err := VerifyToken([]byte("another-key-0123456789"), token); err == nil {
+		t.Errorf("Token signed with another key should be rejected")
+	}
+	forged, _ := IssueToken([]byte("another-key-0123456789"), Identity{Name: "root"}, time.Hour)
+	if _, err := VerifyToken(key, token[:len(token)-43]+forged[len(forged)-43:]); err == nil {
+		t.Errorf("Tampered token should be rejected")
+	}
+	expired, _ := IssueToken(key, Identity{Name: "alice"}, -time.Minute)
+	if _, err := VerifyToken(key, expired); err == nil {
+		t.Errorf("Expired token should be rejected")
+	}
+}
+
+// TestAuthenticatedConnection 测试连接认证，以及服务端用令牌中的身份覆盖请求中的User字段
+func TestAuthenticatedConnection(t *testing.T) {
+	registerOnce.Do(func() {
+		if err := rpc.Register(new(TestService)); err != nil {
+			t.Fatal(err)
+		}
+	})
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	key := []byte("0123456789abcdef0123456789abcdef")
+	server := &Server{Authenticator: &HMACAuthenticator{Key: key}}
+	go server.Accept(listener)
+
+	// 服务端和客户端在同一个进程中，使用不同的认证方式模拟不同的客户端，服务端都用同一个密钥校验
+	token, _ := IssueToken(key, Identity{Name: "alice"}, time.Hour)
+	rpcClient, err := Client{Authenticator: &HMACAuthenticator{Key: key, Token: token}}.Dial(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply string
+	err = rpcClient.Call("TestService.WhoAmI", TestRequest{Path: "/foo", User: TestUser{Name: "root"}}, &reply)
+	rpcClient.Close()
+	if err != nil || reply != "alice:/foo" {
+		t.Errorf("Identity should be taken from token: %v %v", reply, err)
+	}
+
+	// 持有密钥的服务方为自己签发令牌
+	rpcClient, err = Client{Authenticator: &HMACAuthenticator{Key: key, Identity: Identity{Name: "datanode"}}}.Dial(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rpcClient.Call("TestService.WhoAmI", TestRequest{Path: "/bar"}, &reply)
+	rpcClient.Close()
+	if err != nil || reply != "datanode:/bar" {
+		t.Errorf("Unable to authenticate with service token: %v %v", reply, err)
+	}
+
+	forged, _ := IssueToken([]byte("another-key-0123456789"), Identity{Name: "root"}, time.Hour)
+	if _, err := (Client{Authenticator: &HMACAuthenticator{Key: key, Token: forged}}).Dial(listener.Addr().String()); err == nil {
+		t.Errorf("Forged token should be rejected")
+	}
+
+	// 不认证的客户端直接发送rpc请求，连接被关闭
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpcClient = rpc.NewClient(conn)
+	err = rpcClient.Call("TestService.WhoAmI", TestRequest{Path: "/foo"}, &reply)
+	rpcClient.Close()
+	if err == nil {
+		t.Errorf("Unauthenticated connection should be rejected")
+	}
+}
+
+// TestBlockToken 测试块访问令牌只允许对令牌中目录下的块进行令牌允许的操作，并且不能用用户令牌代替
+func TestBlockToken(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	token, err := IssueBlockToken(key, []string{"blk_1", "blk_2"}, "/foo/", BlockAccessRead|BlockAccessWrite, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyBlockToken(key, token, "blk_2", "foo/", BlockAccessWrite); err != nil {
+		t.Errorf("Unable to verify block token: %v", err)
+	}
+	if VerifyBlockToken(key, token, "blk_3", "foo/", BlockAccessRead) == nil {
+		t.Errorf("Block token should not grant access to other blocks")
+	}
+	if VerifyBlockToken(key, token, "blk_1", "foo/", BlockAccessDelete) == nil {
+		t.Errorf("Block token should not grant other operations")
+	}
+	if VerifyBlockToken(key, token, "blk_1", "bar/", BlockAccessRead) == nil {
+		t.Errorf("Block token should not grant access to blocks in other directories")
+	}
+	if VerifyBlockToken([]byte("another-key-0123456789"), token, "blk_1", "foo/", BlockAccessRead) == nil {
+		t.Errorf("Block token signed with another key should be rejected")
+	}
+	expired, _ := IssueBlockToken(key, []string{"blk_1"}, "/foo/", BlockAccessRead, -time.Minute)
+	if VerifyBlockToken(key, expired, "blk_1", "foo/", BlockAccessRead) == nil {
+		t.Errorf("Expired block token should be rejected")
+	}
+	userToken, _ := IssueToken(key, Identity{Name: "alice"}, time.Hour)
+	if VerifyBlockToken(key, userToken, "blk_1", "foo/", BlockAccessRead) == nil || VerifyBlockToken(key, "", "blk_1", "foo/", BlockAccessRead) == nil {
+		t.Errorf("Block access without block token should be rejected")
+	}
+}
+
+// TestCallObserver 测试没有认证时观察者也能得到每次调用的方法、客户端地址、请求和错误，请求中的User字段保持不变
+func TestCallObserver(t *testing.T) {
+	registerOnce.Do(func() {
+		if err := rpc.Register(new(TestService)); err != nil {
+			t.Fatal(err)
+		}
+	})
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	calls := make(chan CallInfo, 2)
+	server := &Server{Observer: func(call CallInfo) {
+		calls <- call
+	}}
+	go server.Accept(listener)
+
+	rpcClient, err := Dial(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcClient.Close()
+	var reply string
+	err = rpcClient.Call("TestService.WhoAmI", TestRequest{Path: "/foo", User: TestUser{Name: "alice"}}, &reply)
+	if err != nil || reply != "alice:/foo" {
+		t.Errorf("User should not be changed without authentication: %v %v", reply, err)
+	}
+	if err = rpcClient.Call("TestService.Missing", TestRequest{Path: "/bar"}, &reply); err == nil {
+		t.Errorf("Missing method should return error")
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case call := <-calls:
+			if call.RemoteAddr == "" || call.User != "" {
+				t.Errorf("Unable to observe caller: %+v", call)
+			}
+			if call.ServiceMethod == "TestService.WhoAmI" {
+				request, ok := call.Request.(*TestRequest)
+				if !ok || request.Path != "/foo" || call.Error != "" {
+					t.Errorf("Unable to observe call: %+v", call)
+				}
+			} else if call.ServiceMethod != "TestService.Missing" || call.Error == "" {
+				t.Errorf("Unable to observe failed call: %+v", call)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Observer was not called")
+		}
+	}
+}
+
+// TestAuthorizer 测试授权检查拒绝没有权限的调用，服务进程的身份可以调用
+func TestAuthorizer(t *testing.T) {
+	registerOnce.Do(func() {
+		if err := rpc.Register(new(TestService)); err != nil {
+			t.Fatal(err)
+		}
+	})
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	key := []byte("0123456789abcdef0123456789abcdef")
+	server := &Server{Authenticator: &HMACAuthenticator{Key: key}}
+	server.Authorizer = func(serviceMethod string, identity Identity, request any) error {
+		if serviceMethod == "TestService.WhoAmI" && !identity.IsService() {
+			return errors.New("permission denied")
+		}
+		return nil
+	}
+	go server.Accept(listener)
+
+	token, _ := IssueToken(key, Identity{Name: "alice"}, time.Hour)
+	rpcClient, err := Client{Authenticator: &HMACAuthenticator{Key: key, Token: token}}.Dial(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply string
+	err = rpcClient.Call("TestService.WhoAmI", TestRequest{Path: "/foo"}, &reply)
+	if err == nil || err.Error() != "permission denied" {
+		t.Errorf("User should not call service method: %v %v", reply, err)
+	}
+	// 拒绝一次调用后连接仍然可以使用
+	err = rpcClient.Call("TestService.WhoAmI", TestRequest{Path: "/foo"}, &reply)
+	rpcClient.Close()
+	if err == nil {
+		t.Errorf("User should not call service method: %v", reply)
+	}
+
+	rpcClient, err = Client{Authenticator: &HMACAuthenticator{Key: key, Identity: Identity{Name: "datanode", Groups: []string{ServiceGroup}}}}.Dial(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rpcClient.Call("TestService.WhoAmI", TestRequest{Path: "/bar"}, &reply)
+	rpcClient.Close()
+	if err != nil || reply != "datanode:/bar" {
+		t.Errorf("Service should call service method: %v %v", reply, err)
+	}
+}
+
+// TestClientTimeout 测试设置了超时时间的连接，服务端没有响应时rpc调用返回错误而不是一直等待
+func TestClientTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	// 只接受连接，不读取请求也不返回响应
+	go func() {
+		for {
+			conn, acceptErr := listener.Accept()
+			if acceptErr != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	rpcClient, err := Client{Timeout: 100 * time.Millisecond}.Dial(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcClient.Close()
+	call := rpcClient.Go("TestService.WhoAmI", TestRequest{Path: "/foo"}, new(string), nil)
+	select {
+	case <-call.Done:
+		if call.Error == nil {
+			t.Errorf("Call without response should fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Call without response should time out")
+	}
+}
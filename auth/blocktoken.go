@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"errors"
+	"path"
+	"time"
+)
+
+// BlockAccessMode 块访问令牌允许的操作，可以按位组合
+type BlockAccessMode uint8
+
+const (
+	BlockAccessRead BlockAccessMode = 1 << iota
+	BlockAccessWrite
+	BlockAccessDelete
+)
+
+// BlockTokenLifetime nameNode签发的块访问令牌的有效期
+const BlockTokenLifetime = 10 * time.Minute
+
+// blockKeyPurpose 从共享密钥派生块访问令牌密钥时使用的标识，用户令牌不能当作块访问令牌使用
+const blockKeyPurpose = "block-access-token"
+
+// BlockToken 块访问令牌的负载：可以访问的块、块所在的目录、允许的操作和过期时间，纠删码块组的令牌包含所有内部块
+type BlockToken struct {
+	BlockIds []string
+	Path     string //块所在的目录，令牌只能访问该目录下的块
+	Modes    BlockAccessMode
+	Expiry   int64 //过期时间，Unix秒
+}
+
+// String 操作的名称，用于错误信息
+func (mode BlockAccessMode) String() string {
+	switch mode {
+	case BlockAccessRead:
+		return "read"
+	case BlockAccessWrite:
+		return "write"
+	case BlockAccessDelete:
+		return "delete"
+	}
+	return "unknown"
+}
+
+// IssueBlockToken 用共享密钥为blockPath目录下的块签发访问令牌
+func IssueBlockToken(key []byte, blockIds []string, blockPath string, modes BlockAccessMode, ttl time.Duration) (string, error) {
+	return encodeToken(sign(key, []byte(blockKeyPurpose)), BlockToken{BlockIds: blockIds, Path: cleanBlockPath(blockPath), Modes: modes, Expiry: time.Now().Add(ttl).Unix()})
+}
+
+// cleanBlockPath 规范化块所在的目录，"Test/"、"/Test"和"Test"视为同一个目录
+func cleanBlockPath(blockPath string) string {
+	return path.Clean("/" + blockPath)
+}
+
+// VerifyBlockToken 校验块访问令牌的签名和过期时间，以及令牌是否允许对blockPath目录下的blockId进行mode操作
+func VerifyBlockToken(key []byte, token string, blockId string, blockPath string, mode BlockAccessMode) error {
+	if token == "" {
+		return errors.New("没有块访问令牌")
+	}
+	var content BlockToken
+	err := decodeToken(sign(key, []byte(blockKeyPurpose)), token, &content)
+	if err != nil {
+		return err
+	}
+	if time.Now().After(time.Unix(content.Expiry, 0)) {
+		return errors.New("块访问令牌已过期")
+	}
+	if content.Modes&mode == 0 {
+		return errors.New("块访问令牌不允许" + mode.String() + "操作")
+	}
+	if content.Path != cleanBlockPath(blockPath) {
+		return errors.New("块访问令牌不允许访问目录" + blockPath)
+	}
+	for _, id := range content.BlockIds {
+		if id == blockId {
+			return nil
+		}
+	}
+	return errors.New("块访问令牌不包含块" + blockId)
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+)
+
+// serverTLSConfig Listen创建的监听使用的TLS配置，为nil时不加密
+var serverTLSConfig *tls.Config
+
+// clientTLSConfig Dial建立的连接使用的TLS配置，为nil时不加密
+var clientTLSConfig *tls.Config
+
+// SetTLSConfig 设置当前进程作为服务端和客户端的TLS配置，为nil时对应方向不加密
+func SetTLSConfig(server *tls.Config, client *tls.Config) {
+	serverTLSConfig = server
+	clientTLSConfig = client
+}
+
+// ConfigureTLS 从证书文件创建当前进程的TLS配置，certFile和caFile都为空时不加密。
+// 有certFile时作为服务端使用该证书，作为客户端时也出示该证书用于双向认证；
+// caFile为校验对方证书的CA，为空时使用系统的根证书；requireClientCert为true时服务端只接受出示了由caFile签发的证书的客户端
+func ConfigureTLS(certFile string, keyFile string, caFile string, requireClientCert bool) error {
+	if requireClientCert && (certFile == "" || caFile == "") {
+		return errors.New("校验客户端证书需要指定证书和CA")
+	}
+	if certFile == "" && caFile == "" {
+		SetTLSConfig(nil, nil)
+		return nil
+	}
+	var pool *x509.CertPool
+	if caFile != "" {
+		data, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return errors.New("CA文件中没有有效的证书: " + caFile)
+		}
+	}
+	client := &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	if certFile == "" {
+		SetTLSConfig(nil, client)
+		return nil
+	}
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	client.Certificates = []tls.Certificate{certificate}
+	server := &tls.Config{Certificates: []tls.Certificate{certificate}, ClientCAs: pool, MinVersion: tls.VersionTLS12}
+	if requireClientCert {
+		server.ClientAuth = tls.RequireAndVerifyClientCert
+	} else if pool != nil {
+		server.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	SetTLSConfig(server, client)
+	return nil
+}
+
+// TLSEnabled 当前进程作为服务端是否使用TLS
+func TLSEnabled() bool {
+	return serverTLSConfig != nil
+}
+
+// Listen 在address上监听，设置了TLS配置时接受的连接都先进行TLS握手，代替net.Listen
+func Listen(address string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if serverTLSConfig != nil {
+		listener = tls.NewListener(listener, serverTLSConfig)
+	}
+	return listener, nil
+}
+
+// dial 建立tcp连接，设置了TLS配置时进行TLS握手并校验服务端证书，连接和握手都在handshakeTimeout内完成
+func dial(address string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: handshakeTimeout}
+	if clientTLSConfig != nil {
+		return tls.DialWithDialer(dialer, "tcp", address, clientTLSConfig)
+	}
+	return dialer.Dial("tcp", address)
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate 用CA签发证书并写入PEM文件，parent为nil时生成自签名的CA，返回证书和私钥
+func writeCertificate(t *testing.T, dir string, name string, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate, key
+}
+
+// writeTestCertificates 在dir下生成自签名的CA，以及由CA签发的服务端证书server和客户端证书client
+func writeTestCertificates(t *testing.T, dir string) {
+	notBefore := time.Now().Add(-time.Hour)
+	notAfter := time.Now().Add(time.Hour)
+	ca, caKey := writeCertificate(t, dir, "ca", &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "GoDFS Test CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	writeCertificate(t, dir, "server", &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+	writeCertificate(t, dir, "client", &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+}
+
+// callWhoAmI 建立连接并调用一次rpc方法，返回调用是否成功
+func callWhoAmI(address string) error {
+	rpcClient, err := Dial(address)
+	if err != nil {
+		return err
+	}
+	defer rpcClient.Close()
+	var reply string
+	return rpcClient.Call("TestService.WhoAmI", TestRequest{Path: "/foo"}, &reply)
+}
+
+// TestTLSConnection 测试双向TLS：出示由CA签发的证书的客户端可以调用，没有证书、不信任服务端证书和不加密的客户端被拒绝
+func TestTLSConnection(t *testing.T) {
+	registerOnce.Do(func() {
+		if err := rpc.Register(new(TestService)); err != nil {
+			t.Fatal(err)
+		}
+	})
+	dir := t.TempDir()
+	writeTestCertificates(t, dir)
+	file := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+	defer SetTLSConfig(nil, nil)
+	if ConfigureTLS(file("server.pem"), file("server.key"), "", true) == nil {
+		t.Errorf("Verifying client certificates should require a CA")
+	}
+	if err := ConfigureTLS(file("server.pem"), file("server.key"), file("ca.pem"), true); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := Listen("localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go Accept(listener)
+	address := listener.Addr().String()
+
+	// 服务端和客户端在同一个进程中，切换TLS配置模拟不同的客户端，监听的TLS配置在Listen时已经确定
+	if err := ConfigureTLS(file("client.pem"), file("client.key"), file("ca.pem"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := callWhoAmI(address); err != nil {
+		t.Errorf("Unable to call over mutual TLS: %v", err)
+	}
+	if err := ConfigureTLS("", "", file("ca.pem"), false); err != nil {
+		t.Fatal(err)
+	}
+	if callWhoAmI(address) == nil {
+		t.Errorf("Client without certificate should be rejected")
+	}
+	if err := ConfigureTLS(file("client.pem"), file("client.key"), "", false); err != nil {
+		t.Fatal(err)
+	}
+	if callWhoAmI(address) == nil {
+		t.Errorf("Server certificate signed by unknown CA should be rejected")
+	}
+	SetTLSConfig(nil, nil)
+	if callWhoAmI(address) == nil {
+		t.Errorf("Plaintext connection should be rejected")
+	}
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// minKeyLength 密钥的最小字节数
+const minKeyLength = 16
+
+// Token 令牌中携带的用户身份和过期时间，由持有密钥的一方签名
+type Token struct {
+	Name   string
+	Groups []string
+	Expiry int64 //过期时间，Unix秒
+}
+
+// GenerateKey 生成随机密钥并以十六进制写入密钥文件，只有文件所有者可以读写，文件已存在时返回错误
+func GenerateKey(keyFile string) error {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(keyFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(hex.EncodeToString(key) + "\n")
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// LoadKey 从密钥文件读取密钥，忽略首尾的空白
+func LoadKey(keyFile string) ([]byte, error) {
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key := bytes.TrimSpace(data)
+	if len(key) < minKeyLength {
+		return nil, errors.New("密钥长度不足")
+	}
+	return key, nil
+}
+
+// IssueToken 为用户签发令牌，格式为base64(JSON负载).base64(HMAC-SHA256签名)
+func IssueToken(key []byte, identity Identity, ttl time.Duration) (string, error) {
+	return encodeToken(key, Token{Name: identity.Name, Groups: identity.Groups, Expiry: time.Now().Add(ttl).Unix()})
+}
+
+// VerifyToken 校验令牌的签名和过期时间，返回令牌中的用户身份
+func VerifyToken(key []byte, token string) (Identity, error) {
+	var content Token
+	err := decodeToken(key, token, &content)
+	if err != nil {
+		return Identity{}, err
+	}
+	expiry := time.Unix(content.Expiry, 0)
+	if time.Now().After(expiry) {
+		return Identity{}, ErrTokenExpired
+	}
+	if content.Name == "" {
+		return Identity{}, errors.New("令牌中没有用户")
+	}
+	return Identity{Name: content.Name, Groups: content.Groups, Expiry: expiry}, nil
+}
+
+// encodeToken 把负载编码为JSON并签名
+func encodeToken(key []byte, content any) (string, error) {
+	payload, err := json.Marshal(content)
+	if err != nil {
+		return "", err
+	}
+	encoding := base64.RawURLEncoding
+	return encoding.EncodeToString(payload) + "." + encoding.EncodeToString(sign(key, payload)), nil
+}
+
+// decodeToken 校验令牌的签名，并把负载解码到content
+func decodeToken(key []byte, token string, content any) error {
+	separator := strings.IndexByte(token, '.')
+	if separator < 0 {
+		return errors.New("令牌格式错误")
+	}
+	encoding := base64.RawURLEncoding
+	payload, err := encoding.DecodeString(token[:separator])
+	if err != nil {
+		return errors.New("令牌格式错误")
+	}
+	signature, err := encoding.DecodeString(token[separator+1:])
+	if err != nil {
+		return errors.New("令牌格式错误")
+	}
+	if !hmac.Equal(signature, sign(key, payload)) {
+		return errors.New("令牌签名无效")
+	}
+	if json.Unmarshal(payload, content) != nil {
+		return errors.New("令牌格式错误")
+	}
+	return nil
+}
+
+// sign 计算负载的HMAC-SHA256签名
+func sign(key []byte, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
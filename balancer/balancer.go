@@ -0,0 +1,209 @@
+package balancer
+
+import (
+	"github.com/liuzongzhou/GoDFS/namenode"
+	"log"
+	"net/rpc"
+	"sort"
+	"time"
+)
+
+// iterationInterval 每轮移动结束后等待的时间，等dataNode的心跳和块汇报更新nameNode上的使用情况
+const iterationInterval = time.Second * 10
+
+// Move 把块的一个副本从源dataNode移动到目标dataNode
+type Move struct {
+	BlockId          string
+	Size             uint64
+	SourceDataNodeId uint64
+	TargetDataNodeId uint64
+}
+
+// dataNodeUsage dataNode的使用率，以及作为源节点还能移出、作为目标节点还能移入的空间
+type dataNodeUsage struct {
+	report      namenode.DataNodeStorageReport
+	utilization float64 //使用率，百分比
+	budget      int64   //与平均使用率对应的已用空间相差的空间，大于0可以移出，小于0可以移入
+}
+
+// utilizations 计算每个dataNode的使用率和集群的平均使用率，还没有汇报容量的dataNode不参与均衡
+func utilizations(reports []namenode.DataNodeStorageReport) (usages []*dataNodeUsage, average float64) {
+	var totalUsed, totalCapacity uint64
+	for _, report := range reports {
+		if report.Capacity == 0 {
+			continue
+		}
+		totalUsed += report.Used
+		totalCapacity += report.Capacity
+	}
+	if totalCapacity == 0 {
+		return
+	}
+	average = float64(totalUsed) * 100 / float64(totalCapacity)
+	for _, report := range reports {
+		if report.Capacity == 0 {
+			continue
+		}
+		usages = append(usages, &dataNodeUsage{
+			report:      report,
+			utilization: float64(report.Used) * 100 / float64(report.Capacity),
+			budget:      int64(report.Used) - int64(average*float64(report.Capacity)/100),
+		})
+	}
+	return
+}
+
+// SourceDataNodes 使用率高于平均使用率、可能需要移出块的dataNode
+func SourceDataNodes(reports []namenode.DataNodeStorageReport) (dataNodeIds []uint64) {
+	usages, average := utilizations(reports)
+	for _, usage := range usages {
+		if usage.utilization > average {
+			dataNodeIds = append(dataNodeIds, usage.report.DataNodeId)
+		}
+	}
+	return
+}
+
+// PlanMoves 计算一轮的块移动方案，使每个dataNode的使用率与平均使用率相差不超过threshold（百分比）。
+// 按过度使用->使用不足、过度使用->低于平均、高于平均->使用不足的顺序配对，源节点最多移出到平均使用率，目标节点最多移入到平均使用率。
+// blocks为源节点上的块，目标节点上已经有副本的块不移动，移动后块所在的机架数不能减少
+func PlanMoves(reports []namenode.DataNodeStorageReport, blocks map[uint64][]namenode.BlockLocation, threshold float64) (moves []Move) {
+	usages, average := utilizations(reports)
+	racks := make(map[uint64]string)
+	var overUtilized, aboveAverage, belowAverage, underUtilized []*dataNodeUsage
+	for _, usage := range usages {
+		racks[usage.report.DataNodeId] = usage.report.Rack
+		switch {
+		case usage.utilization > average+threshold:
+			overUtilized = append(overUtilized, usage)
+		case usage.utilization > average:
+			aboveAverage = append(aboveAverage, usage)
+		case usage.utilization < average-threshold:
+			underUtilized = append(underUtilized, usage)
+		case usage.utilization < average:
+			belowAverage = append(belowAverage, usage)
+		}
+	}
+	if len(overUtilized) == 0 && len(underUtilized) == 0 {
+		return nil
+	}
+	// 使用率最高的源节点和使用率最低的目标节点优先
+	for _, group := range [][]*dataNodeUsage{overUtilized, aboveAverage} {
+		sort.Slice(group, func(i, j int) bool { return group[i].utilization > group[j].utilization })
+	}
+	for _, group := range [][]*dataNodeUsage{underUtilized, belowAverage} {
+		sort.Slice(group, func(i, j int) bool { return group[i].utilization < group[j].utilization })
+	}
+	scheduled := make(map[string]bool)
+	pairs := [][2][]*dataNodeUsage{{overUtilized, underUtilized}, {overUtilized, belowAverage}, {aboveAverage, underUtilized}}
+	for _, pair := range pairs {
+		for _, source := range pair[0] {
+			for _, target := range pair[1] {
+				moves = append(moves, planPairMoves(source, target, blocks[source.report.DataNodeId], racks, scheduled)...)
+			}
+		}
+	}
+	return
+}
+
+// planPairMoves 计算从源节点移动到目标节点的块，直到任意一方达到平均使用率
+func planPairMoves(source *dataNodeUsage, target *dataNodeUsage, blocks []namenode.BlockLocation, racks map[uint64]string, scheduled map[string]bool) (moves []Move) {
+	sourceId := source.report.DataNodeId
+	targetId := target.report.DataNodeId
+	for _, block := range blocks {
+		size := int64(block.Size)
+		if source.budget <= 0 || target.budget >= 0 {
+			return
+		}
+		if size == 0 || scheduled[block.BlockId] || size > source.budget || size > -target.budget {
+			continue
+		}
+		if containsDataNodeId(block.DataNodeIds, targetId) || !containsDataNodeId(block.DataNodeIds, sourceId) {
+			continue
+		}
+		// 移动后块所在的机架数不能减少
+		before := rackCount(block.DataNodeIds, racks)
+		after := rackCount(append(removeDataNodeId(block.DataNodeIds, sourceId), targetId), racks)
+		if after < before {
+			continue
+		}
+		scheduled[block.BlockId] = true
+		source.budget -= size
+		target.budget += size
+		moves = append(moves, Move{BlockId: block.BlockId, Size: block.Size, SourceDataNodeId: sourceId, TargetDataNodeId: targetId})
+	}
+	return
+}
+
+// Balance 循环进行均衡，直到各dataNode的使用率与平均使用率相差都不超过threshold，或者没有可以移动的块，或者达到最大轮数。
+// bandwidth为每秒最多移动的字节数，为0时不限制。返回移动的块数
+func Balance(nameNodeInstance *rpc.Client, threshold float64, bandwidth uint64, iterations int) (moved int, err error) {
+	for iteration := 1; iteration <= iterations; iteration++ {
+		var reports []namenode.DataNodeStorageReport
+		err = nameNodeInstance.Call("Service.GetStorageReport", true, &reports)
+		if err != nil {
+			return
+		}
+		//获取源节点上的块
+		blocks := make(map[uint64][]namenode.BlockLocation)
+		for _, dataNodeId := range SourceDataNodes(reports) {
+			var locations []namenode.BlockLocation
+			err = nameNodeInstance.Call("Service.GetBlockLocations", dataNodeId, &locations)
+			if err != nil {
+				return
+			}
+			blocks[dataNodeId] = locations
+		}
+		moves := PlanMoves(reports, blocks, threshold)
+		if len(moves) == 0 {
+			log.Printf("Iteration %d: cluster is balanced or no block can be moved\n", iteration)
+			return
+		}
+		log.Printf("Iteration %d: moving %d blocks\n", iteration, len(moves))
+		for _, move := range moves {
+			request := namenode.MoveBlockRequest{BlockId: move.BlockId, SourceDataNodeId: move.SourceDataNodeId, TargetDataNodeId: move.TargetDataNodeId}
+			var reply bool
+			moveErr := nameNodeInstance.Call("Service.MoveBlock", request, &reply)
+			if moveErr != nil {
+				log.Printf("Unable to move block %s from DataNode %d to %d: %v\n", move.BlockId, move.SourceDataNodeId, move.TargetDataNodeId, moveErr)
+				continue
+			}
+			moved++
+			// 限制移动的带宽
+			if bandwidth > 0 {
+				time.Sleep(time.Duration(move.Size * uint64(time.Second) / bandwidth))
+			}
+		}
+		time.Sleep(iterationInterval)
+	}
+	return
+}
+
+// rackCount 统计副本所在的机架数
+func rackCount(dataNodeIds []uint64, racks map[uint64]string) int {
+	distinct := make(map[string]bool)
+	for _, id := range dataNodeIds {
+		distinct[racks[id]] = true
+	}
+	return len(distinct)
+}
+
+// containsDataNodeId 判断dataNodeId是否在副本位置中
+func containsDataNodeId(dataNodeIds []uint64, dataNodeId uint64) bool {
+	for _, id := range dataNodeIds {
+		if id == dataNodeId {
+			return true
+		}
+	}
+	return false
+}
+
+// removeDataNodeId 从副本位置中移除dataNodeId，返回新的切片
+func removeDataNodeId(dataNodeIds []uint64, dataNodeId uint64) (newDataNodeIds []uint64) {
+	for _, id := range dataNodeIds {
+		if id != dataNodeId {
+			newDataNodeIds = append(newDataNodeIds, id)
+		}
+	}
+	return
+}
@@ -0,0 +1,72 @@
+package balancer
+
+import (
+	"github.com/liuzongzhou/GoDFS/namenode"
+	"testing"
+)
+
+// testReports 三个容量都为100的dataNode，dataNode 0过度使用，dataNode 1使用不足
+func testReports() []namenode.DataNodeStorageReport {
+	return []namenode.DataNodeStorageReport{
+		{DataNodeId: 0, Rack: "/rack1", Capacity: 100, Used: 90},
+		{DataNodeId: 1, Rack: "/rack1", Capacity: 100, Used: 10},
+		{DataNodeId: 2, Rack: "/rack2", Capacity: 100, Used: 50},
+	}
+}
+
+// TestPlanMoves 测试从过度使用的节点移动到使用不足的节点，移动量不超过与平均使用率的差距
+func TestPlanMoves(t *testing.T) {
+	blocks := map[uint64][]namenode.BlockLocation{
+		0: {
+			{BlockId: "a", Size: 10, DataNodeIds: []uint64{0}},
+			{BlockId: "b", Size: 10, DataNodeIds: []uint64{0, 1}},
+			{BlockId: "c", Size: 30, DataNodeIds: []uint64{0, 2}},
+			{BlockId: "d", Size: 10, DataNodeIds: []uint64{0}},
+			{BlockId: "e", Size: 10, DataNodeIds: []uint64{0}},
+		},
+	}
+	if sources := SourceDataNodes(testReports()); len(sources) != 1 || sources[0] != 0 {
+		t.Errorf("Unable to find source DataNodes: %v", sources)
+	}
+	moves := PlanMoves(testReports(), blocks, 10)
+	var total uint64
+	for _, move := range moves {
+		if move.SourceDataNodeId != 0 || move.TargetDataNodeId != 1 {
+			t.Errorf("Unexpected move: %+v", move)
+		}
+		if move.BlockId == "b" {
+			t.Errorf("Block already on target should not be moved")
+		}
+		total += move.Size
+	}
+	if total != 40 {
+		t.Errorf("Unable to move blocks up to the average utilization: %v", moves)
+	}
+}
+
+// TestPlanMovesBalanced 测试使用率都在阈值范围内时不移动
+func TestPlanMovesBalanced(t *testing.T) {
+	reports := testReports()
+	reports[0].Used = 55
+	reports[1].Used = 45
+	blocks := map[uint64][]namenode.BlockLocation{0: {{BlockId: "a", Size: 5, DataNodeIds: []uint64{0}}}}
+	if moves := PlanMoves(reports, blocks, 10); len(moves) != 0 {
+		t.Errorf("Balanced cluster should not move blocks: %v", moves)
+	}
+}
+
+// TestPlanMovesKeepRacks 测试移动后块所在的机架数不能减少
+func TestPlanMovesKeepRacks(t *testing.T) {
+	reports := testReports()
+	reports[1].Rack = "/rack2"
+	blocks := map[uint64][]namenode.BlockLocation{
+		0: {
+			{BlockId: "a", Size: 10, DataNodeIds: []uint64{0, 2}},
+			{BlockId: "b", Size: 10, DataNodeIds: []uint64{0}},
+		},
+	}
+	moves := PlanMoves(reports, blocks, 10)
+	if len(moves) != 1 || moves[0].BlockId != "b" {
+		t.Errorf("Move should not reduce the number of racks: %v", moves)
+	}
+}
@@ -0,0 +1,28 @@
+package client
+
+import (
+	"github.com/liuzongzhou/GoDFS/namenode"
+	"log"
+	"net/rpc"
+)
+
+// SetAcl 修改文件或者目录的ACL，action为modify、remove、set、removeall或者removedefault，返回修改是否成功
+func SetAcl(nameNodeInstance *rpc.Client, path string, action string, entries []namenode.AclEntry) (setStatus bool) {
+	request := namenode.NameNodeSetAclRequest{Path: path, Action: action, Entries: entries, User: userInfo()}
+	err := nameNodeInstance.Call("Service.SetAcl", request, &setStatus)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return
+}
+
+// GetAcl 获取文件或者目录完整的ACL，返回ACL和操作是否成功
+func GetAcl(nameNodeInstance *rpc.Client, path string) (status namenode.AclStatus, ok bool) {
+	err := nameNodeInstance.Call("Service.GetAcl", path, &status)
+	if err != nil {
+		log.Println(err)
+		return status, false
+	}
+	return status, true
+}
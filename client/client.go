@@ -1,15 +1,20 @@
 package client
 
 import (
+	"errors"
+	"github.com/liuzongzhou/GoDFS/auth"
 	"github.com/liuzongzhou/GoDFS/datanode"
+	"github.com/liuzongzhou/GoDFS/encryption"
 	"github.com/liuzongzhou/GoDFS/namenode"
+	"io"
 	"log"
 	"net/rpc"
 	"os"
+	"time"
 )
 
-// Put 上传文件
-func Put(nameNodeInstance *rpc.Client, sourcePath string, fileName string, remotefilepath string) (putStatus bool) {
+// Put 上传文件，replicationFactor为0时使用目录的默认副本因子
+func Put(nameNodeInstance *rpc.Client, sourcePath string, fileName string, remotefilepath string, replicationFactor uint64) (putStatus bool) {
 	//完整的文件路径
 	fullFilePath := sourcePath + fileName
 	//查看文件元信息
@@ -22,8 +27,20 @@ func Put(nameNodeInstance *rpc.Client, sourcePath string, fileName string, remot
 	}
 	//获取文件的大小
 	fileSize := uint64(fileSizeHandler.Size())
-	//文件写入请求：路径，文件名，文件大小
-	request := namenode.NameNodeWriteRequest{RemoteFilePath: remotefilepath, FileName: fileName, FileSize: fileSize}
+	//目录在加密区中时生成数据密钥，写入的是密文，文件大小按密文计算
+	encryptionInfo, dataKey, err := newFileEncryption(nameNodeInstance, remotefilepath)
+	if err != nil {
+		log.Println(err)
+		putStatus = false
+		return
+	}
+	if encryptionInfo.KeyName != "" {
+		fileSize = encryption.EncryptedSize(encryptionInfo.Cipher, fileSize)
+	}
+	//文件写入请求：路径，文件名，文件大小，副本因子
+	//写入客户端所在的主机，nameNode据此就近放置第一个副本
+	clientHost, _ := os.Hostname()
+	request := namenode.NameNodeWriteRequest{RemoteFilePath: remotefilepath, FileName: fileName, FileSize: fileSize, ReplicationFactor: replicationFactor, ClientHost: clientHost, User: userInfo(), Encryption: encryptionInfo}
 	//返回数据：元数据数组，包含每个BlockId对应多个datanodeId(备份)
 	var reply []namenode.NameNodeMetaData
 	//rpc调用Service.WriteData方法，写数据
@@ -52,15 +69,95 @@ func Put(nameNodeInstance *rpc.Client, sourcePath string, fileName string, remot
 		putStatus = false
 		return
 	}
+	defer fileHandler.Close()
+	var reader io.Reader = fileHandler
+	if encryptionInfo.KeyName != "" {
+		reader, err = encryption.NewEncryptReader(encryptionInfo.Cipher, dataKey, encryptionInfo.IV, fileHandler)
+		if err != nil {
+			log.Println(err)
+			putStatus = false
+			return
+		}
+	}
 
-	dataStagingBytes := make([]byte, blockSize)
+	putStatus = writeBlocks(reader, remotefilepath, reply, blockSize)
+	return
+}
+
+// Get 从远端下载文件,返回结果：下载是否成功
+func Get(nameNodeInstance *rpc.Client, remoteFilepath string, fileName string, local_file_path string) (getStatus bool) {
+	//文件读取请求：文件名：路径+文件名
+	request := namenode.NameNodeReadRequest{FileName: remoteFilepath + fileName, User: userInfo()}
+	//返回数据：元数据数组，包含每个BlockId对应多个datanodeId(备份)
+	var reply []namenode.NameNodeMetaData
+	// rpc调用读取文件对应的元数据数组
+	err := nameNodeInstance.Call("Service.ReadData", request, &reply)
+	//rpc调用出现问题，直接返回false
+	if err != nil {
+		log.Println(err)
+		getStatus = false
+		return
+	}
+	//遍历元数据数组信息,如果元数据为空，则直接返回false
+	if len(reply) == 0 {
+		return false
+	}
+	//在本地目标路径打开文件，采用追加写入的方式，不存在则创建
+	f, err := os.OpenFile(local_file_path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	//打开失败（不是有效路径）,直接返回失败
+	if err != nil {
+		log.Println("open file error :", err)
+		return false
+	}
+	defer f.Close()
+	//加密区中的文件边读边解密
+	writer, err := decryptWriter(nameNodeInstance, remoteFilepath+fileName, f)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
 	for _, metaData := range reply {
-		n, err := fileHandler.Read(dataStagingBytes)
+		data, err := readBlock(remoteFilepath, metaData)
+		//如果所有datanode,包含备份都遍历完了，但是还没读取成功，那就返回false
+		if err != nil {
+			log.Println(err)
+			return false
+		}
+		//写入返回的数据
+		_, err = writer.Write(data)
+		//出现问题，反回false
 		if err != nil {
 			log.Println(err)
 			return false
 		}
-		//append形式读取blocksize大小的文件
+	}
+	//GCM在关闭时校验最后一个分块
+	if err = writer.Close(); err != nil {
+		log.Println(err)
+		return false
+	}
+	return true
+}
+
+// writeBlocks 按nameNode分配的元数据依次从reader读取数据写入dataNode，返回是否全部写入成功
+func writeBlocks(reader io.Reader, remotefilepath string, metadata []namenode.NameNodeMetaData, blockSize uint64) bool {
+	dataStagingBytes := make([]byte, blockSize)
+	for _, metaData := range metadata {
+		//纠删码文件按块组条带化写入
+		if metaData.ECPolicy.DataUnits > 0 {
+			err := putBlockGroup(reader, remotefilepath, metaData)
+			if err != nil {
+				log.Println(err)
+				return false
+			}
+			continue
+		}
+		//读取blocksize大小的数据，文件的最后一个块可能不满
+		n, err := io.ReadFull(reader, dataStagingBytes)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			log.Println(err)
+			return false
+		}
 		dataStagingBytes = dataStagingBytes[:n]
 		//要存取的blockId
 		blockId := metaData.BlockId
@@ -71,12 +168,11 @@ func Put(nameNodeInstance *rpc.Client, sourcePath string, fileName string, remot
 		//剩下的节点都为备份节点
 		remainingDataNodes := blockAddresses[1:]
 		//与主datanode节点建立连接
-		dataNodeInstance, rpcErr := rpc.Dial("tcp", startingDataNode.Host+":"+startingDataNode.ServicePort)
+		dataNodeInstance, rpcErr := auth.Dial(startingDataNode.Host + ":" + startingDataNode.ServicePort)
 		//rpc连接出现问题，直接返回false
 		if rpcErr != nil {
 			log.Println(rpcErr)
-			putStatus = false
-			return
+			return false
 		}
 
 		defer dataNodeInstance.Close()
@@ -86,6 +182,8 @@ func Put(nameNodeInstance *rpc.Client, sourcePath string, fileName string, remot
 			BlockId:          blockId,
 			Data:             string(dataStagingBytes),
 			ReplicationNodes: remainingDataNodes,
+			GenerationStamp:  metaData.GenerationStamp,
+			BlockToken:       metaData.BlockToken,
 		}
 		//
 		var reply datanode.DataNodeReplyStatus
@@ -94,134 +192,71 @@ func Put(nameNodeInstance *rpc.Client, sourcePath string, fileName string, remot
 		//rpc调用出现问题，直接返回false
 		if rpcErr != nil {
 			log.Println(rpcErr)
-			putStatus = false
-			return
+			return false
 		}
 		//如果写入失败，返回false
 		if !reply.Status {
-			putStatus = false
-			return
+			return false
 		}
 	}
-	putStatus = true
-	return
+	return true
 }
 
-// Get 从远端下载文件,返回结果：下载是否成功
-func Get(nameNodeInstance *rpc.Client, remoteFilepath string, fileName string, local_file_path string) (getStatus bool) {
-	//文件读取请求：文件名：路径+文件名
-	request := namenode.NameNodeReadRequest{FileName: remoteFilepath + fileName}
-	//返回数据：元数据数组，包含每个BlockId对应多个datanodeId(备份)
-	var reply []namenode.NameNodeMetaData
-	// rpc调用读取文件对应的元数据数组
-	err := nameNodeInstance.Call("Service.ReadData", request, &reply)
-	//rpc调用出现问题，直接返回false
-	if err != nil {
-		log.Println(err)
-		getStatus = false
-		return
+// readBlock 读取一个块的数据，纠删码块组读取足够的内部块后解码
+func readBlock(remoteFilepath string, metaData namenode.NameNodeMetaData) ([]byte, error) {
+	//快照中的文件的块可能不在文件所在的目录下
+	if metaData.BlockPath != "" {
+		remoteFilepath = metaData.BlockPath
 	}
-	//遍历元数据数组信息,如果元数据为空，则直接返回false
-	if len(reply) == 0 {
-		return false
+	if metaData.ECPolicy.DataUnits > 0 {
+		return getBlockGroup(remoteFilepath, metaData)
 	}
-	for _, metaData := range reply {
-		//1个blockId 对应多个节点信息
-		blockId := metaData.BlockId
-		blockAddresses := metaData.BlockAddresses
-		//该blockId文件抓取内容状态
-		blockFetchStatus := false
-		//遍历blockAddresses，第一个肯定是主节点
-		for _, selectedDataNode := range blockAddresses {
-			//rpc建立连接
-			dataNodeInstance, rpcErr := rpc.Dial("tcp", selectedDataNode.Host+":"+selectedDataNode.ServicePort)
-			//如果连接不上，还有备份节点，不必急于结束，实现了当单节点故障时，无障碍读取数据
-			if rpcErr != nil {
-				log.Printf("DataNode %v : %v read data fail,next datanode\n", selectedDataNode.Host, selectedDataNode.ServicePort)
-				continue
-			}
+	//1个blockId 对应多个节点信息
+	blockId := metaData.BlockId
+	blockAddresses := metaData.BlockAddresses
+	//遍历blockAddresses，第一个肯定是主节点
+	for _, selectedDataNode := range blockAddresses {
+		//rpc建立连接
+		dataNodeInstance, rpcErr := auth.Dial(selectedDataNode.Host + ":" + selectedDataNode.ServicePort)
+		//如果连接不上，还有备份节点，不必急于结束，实现了当单节点故障时，无障碍读取数据
+		if rpcErr != nil {
+			log.Printf("DataNode %v : %v read data fail,next datanode\n", selectedDataNode.Host, selectedDataNode.ServicePort)
+			continue
+		}
 
-			defer dataNodeInstance.Close()
-			// 连接成功的话，读取请求：FilePath+BlockId
-			request := datanode.DataNodeGetRequest{
-				RemoteFilePath: remoteFilepath,
-				BlockId:        blockId,
-			}
-			//返回读取的数据内容
-			var reply datanode.DataNodeData
-			//rpc调用GetData方法，获取blockId对应的数据内容
-			rpcErr = dataNodeInstance.Call("Service.GetData", request, &reply)
-			//如果返回有故障，不必急于结束，实现了当单节点故障时，无障碍读取数据
-			if rpcErr != nil {
-				log.Printf("DataNode %v : %v read data fail,next datanode\n", selectedDataNode.Host, selectedDataNode.ServicePort)
-				continue
-			}
-			//返回数据正常，则在本地目标路径打开文件，采用追加写入的方式，不存在则创建
-			f, err := os.OpenFile(local_file_path, os.O_RDONLY|os.O_CREATE|os.O_APPEND, 0666)
-			//打开失败（不是有效路径）,直接返回失败
-			if err != nil {
-				log.Println("open file error :", err)
-				return false
-			}
-			defer f.Close()
-			//打开成功，写入返回的数据，可以采用缓冲流写，也可以不采用
-			_, err = f.WriteString(reply.Data)
-			//出现问题，反回false
-			if err != nil {
-				log.Println(err)
-				return false
-			}
-			//如果写入成功，那这个blockId文件的写入就完成，进行下一个blockId即可，更新blockFetchStatus状态
-			blockFetchStatus = true
-			log.Printf("DataNode %v : %v read data success,next BlockId\n", selectedDataNode.Host, selectedDataNode.ServicePort)
-			break
+		// 连接成功的话，读取请求：FilePath+BlockId
+		request := datanode.DataNodeGetRequest{
+			RemoteFilePath:  remoteFilepath,
+			BlockId:         blockId,
+			GenerationStamp: metaData.GenerationStamp,
+			BlockToken:      metaData.BlockToken,
 		}
-		//如果所有datanode,包含备份都遍历完了，但是还没写入成功，那就返回false
-		if !blockFetchStatus {
-			return false
+		//返回读取的数据内容
+		var reply datanode.DataNodeData
+		//rpc调用GetData方法，获取blockId对应的数据内容
+		rpcErr = dataNodeInstance.Call("Service.GetData", request, &reply)
+		dataNodeInstance.Close()
+		//如果返回有故障，不必急于结束，实现了当单节点故障时，无障碍读取数据
+		if rpcErr != nil {
+			log.Printf("DataNode %v : %v read data fail,next datanode\n", selectedDataNode.Host, selectedDataNode.ServicePort)
+			continue
 		}
+		log.Printf("DataNode %v : %v read data success,next BlockId\n", selectedDataNode.Host, selectedDataNode.ServicePort)
+		return []byte(reply.Data), nil
 	}
-	return true
+	return nil, errors.New("所有dataNode都读取失败: " + blockId)
 }
 
 // Mkdir 创建远端存储文件目录,返回创建成功与否
 func Mkdir(nameNodeInstance *rpc.Client, remoteFilePath string) (mkDir bool) {
-	var reply []datanode.DataNodeInstance
-	var request = true
-	//获取当前存活的datanode元数据组信息：host+port
-	err := nameNodeInstance.Call("Service.GetIdToDataNodes", request, &reply)
-	//rpc调用出现问题，直接返回false
+	//nameNode记录目录的所有者和权限，再由nameNode在dataNode上创建目录，没有权限时不创建
+	var created bool
+	err := nameNodeInstance.Call("Service.Mkdir", namenode.NameNodeMkdirRequest{RemoteFilePath: remoteFilePath, User: userInfo()}, &created)
 	if err != nil {
 		log.Println(err)
 		return false
 	}
-	//没有存活的节点，直接返回false
-	if len(reply) == 0 {
-		return false
-	}
-	//遍历所有存活的datanode节点
-	for _, replydataNode := range reply {
-		//建立rpc连接
-		dataNodeInstance, rpcErr := rpc.Dial("tcp", replydataNode.Host+":"+replydataNode.ServicePort)
-		//rpc连接出现问题，直接返回false
-		if rpcErr != nil {
-			log.Println(rpcErr)
-			return false
-		}
-		defer dataNodeInstance.Close()
-		//返回回复状态，是否成功
-		var reply datanode.DataNodeReplyStatus
-		//写入请求：相对路径
-		var request = remoteFilePath
-		//rpc 调用创建datanode节点文件目录
-		rpcErr = dataNodeInstance.Call("Service.MakeDir", request, &reply)
-		//rpc调用出现问题，直接返回false，当有一个节点创建目录失败时，返回操作失败，可以建议提示用户再试一次
-		if rpcErr != nil {
-			log.Println(rpcErr)
-			return false
-		}
-	}
-	return true
+	return created
 }
 
 // Stat 获取文件元数据信息：文件名+文件大小
@@ -242,49 +277,28 @@ func Stat(nameNodeInstance *rpc.Client, remoteFilePath string, fileName string)
 // ReName 文件夹的重命名 返回重命名是否成功
 func ReName(nameNodeInstance *rpc.Client, renameSrcPath string, renameDestPath string) (reNameStatus bool) {
 	var reply []datanode.DataNodeInstance
-	var request = true
-	//获取当前存活的datanode元数据组信息：host+port
-	err := nameNodeInstance.Call("Service.GetIdToDataNodes", request, &reply)
-	if nil != err {
-		log.Println(err)
-	}
-	// 对文件夹所在的DataNode节点进行遍历
-	for _, dataNodeInstance1 := range reply {
-		// 取得DataNode实例
-		dataNodeInstance, rpcErr := rpc.Dial("tcp", dataNodeInstance1.Host+":"+dataNodeInstance1.ServicePort)
-		if nil != rpcErr {
-			log.Println(rpcErr)
-		}
-		defer dataNodeInstance.Close()
-		var reply datanode.DataNodeReplyStatus
-		// 调用DataNode的ReNameDir方法去修改实际的路径信息
-		var request = datanode.DataNodeReNameRequest{ReNameSrcPath: renameSrcPath, ReNameDestPath: renameDestPath}
-		rpcErr = dataNodeInstance.Call("Service.ReNameDir", request, &reply)
-		if nil != rpcErr {
-			log.Println(rpcErr)
-		}
-		reNameStatus = reply.Status
-	}
-	// 将NameNode元数据信息和DataNode的实际数据信息修改完之后返回
 	// rpc调用NameNode的ReName方法，传入重命名的renameSrcPath和renameDestPath
-	// 修改NameNode的元数据信息并返回文件夹所在的DataNode节点
-	NameNodeRequest := namenode.NameNodeReNameRequest{ReNameSrcPath: renameSrcPath, ReNameDestPath: renameDestPath}
-	err = nameNodeInstance.Call("Service.ReName", NameNodeRequest, &reply)
+	// 由NameNode移动DataNode上的目录并修改元数据信息，安全模式下或者没有权限时拒绝重命名
+	NameNodeRequest := namenode.NameNodeReNameRequest{ReNameSrcPath: renameSrcPath, ReNameDestPath: renameDestPath, User: userInfo()}
+	err := nameNodeInstance.Call("Service.ReName", NameNodeRequest, &reply)
 	if nil != err {
 		log.Println(err)
+		return false
 	}
-	return
+	return true
 }
 
 // ReNameFile 文件的重命名，返回文件重命名是否成功
 func ReNameFile(nameNodeInstance *rpc.Client, renameSrcFile string, renameDestFile string) (reNameStatus bool) {
-	request := namenode.NameNodeReNameFileRequest{ReNameSrcFileName: renameSrcFile, ReNameDestFileName: renameDestFile}
+	request := namenode.NameNodeReNameFileRequest{ReNameSrcFileName: renameSrcFile, ReNameDestFileName: renameDestFile, User: userInfo()}
 	var reply *bool
 	// rpc调用NameNode的ReName方法，传入重命名的ReNameSrcFileName和ReNameDestFileName
 	// 修改NameNode的元数据信息并返回修改是否成功
 	err := nameNodeInstance.Call("Service.ReNameFile", request, &reply)
+	//安全模式下nameNode拒绝修改，reply为空
 	if nil != err {
 		log.Println(err)
+		return false
 	}
 	reNameStatus = *reply
 	return
@@ -292,7 +306,7 @@ func ReNameFile(nameNodeInstance *rpc.Client, renameSrcFile string, renameDestFi
 
 // List 展示文件目录下面的文件信息， 传入文件目录，返回文件信息
 func List(nameNodeInstance *rpc.Client, remoteDirName string) (fileInfo map[string]uint64) {
-	request := namenode.NameNodeListRequest{RemoteDirPath: remoteDirName}
+	request := namenode.NameNodeListRequest{RemoteDirPath: remoteDirName, User: userInfo()}
 	var reply []namenode.ListMetaData
 	// 在List过程中，我们只需要操作文件元数据，所以只需要调用NameNode的List方法即可
 	err := nameNodeInstance.Call("Service.List", request, &reply)
@@ -309,62 +323,30 @@ func List(nameNodeInstance *rpc.Client, remoteDirName string) (fileInfo map[stri
 
 // DeletePath 删除远端文件目录
 func DeletePath(nameNodeInstance *rpc.Client, remoteFilePath string) (deletePathStatus bool) {
-	var request = true
-	var reply []datanode.DataNodeInstance
-	//1.先得到当前存活的所有dataNodes节点信息
-	err := nameNodeInstance.Call("Service.GetIdToDataNodes", request, &reply)
-	if nil != err {
-		log.Println(err)
-	}
-	//2.删除datanode下对应的文件路径
-	for _, selectedDataNode := range reply {
-		dataNodeInstance, rpcErr := rpc.Dial("tcp", selectedDataNode.Host+":"+selectedDataNode.ServicePort)
-		//如果连接失败，可能当前datanode节点死亡，跳过
-		if rpcErr != nil {
-			log.Printf("DataNode %v : %v delete path fail,next datanode\n", selectedDataNode.Host, selectedDataNode.ServicePort)
-			continue
-		}
-		defer dataNodeInstance.Close()
-		var reply datanode.DataNodeReplyStatus
-		//包装delete请求数据
-		var request = datanode.DataNodeDeleteRequest{RemoteFilepath: remoteFilePath}
-		//通过rpc调用DeletePath,返回删除成功与否
-		rpcErr = dataNodeInstance.Call("Service.DeletePath", request, &reply)
-		//rpc调用失败，打印错误信息，返回错误
-		if rpcErr != nil {
-			log.Println(rpcErr)
-			return false
-		}
-		deletePathStatus = reply.Status
-		//当出现错误时，说明某个节点删除失败，所以直接返回
-		if !deletePathStatus {
-			return
-		}
-	}
-	//3.删除路径成功，这个时候所有的block都删除了，需要处理nameNode里面的缓存数据：
-	//FileNameToBlocks,
-	//BlockToDataNodeIds,
-	//FileNameSize,
-	//DirectoryToFileName
-	var reply1 bool
-	var request1 = namenode.NameNodeDeleteRequest{RemoteFilePath: remoteFilePath}
+	//删除nameNode里面的元数据：FileNameToBlocks,BlockToDataNodeIds,FileNameSize,DirectoryToFileName，
+	//被快照引用的块由nameNode移出目录后保留，之后由nameNode删除dataNode上的目录；安全模式下或者没有权限时拒绝删除
+	var reply bool
+	var request = namenode.NameNodeDeleteRequest{RemoteFilePath: remoteFilePath, User: userInfo()}
 	//rpc 调用DeleteMetaData方法，删除相关元数据信息
-	err = nameNodeInstance.Call("Service.DeleteMetaData", request1, &reply1)
+	err := nameNodeInstance.Call("Service.DeleteMetaData", request, &reply)
 	//rpc调用失败，打印错误信息，返回错误
 	if err != nil {
 		log.Println(err)
 		return false
 	}
-	deletePathStatus = reply1
-	return
+	return reply
 }
 
 //DeleteFile 删除远端文件
 func DeleteFile(nameNodeInstance *rpc.Client, remoteFilePath string, filename string) (deleteFileStatus bool) {
-	request := namenode.NameNodeReadRequest{FileName: remoteFilePath + filename}
+	//安全模式下或者没有权限时nameNode拒绝删除元数据，不能先删除dataNode上的数据
+	if inSafeMode(nameNodeInstance) || !checkAccess(nameNodeInstance, namenode.NameNodeCheckAccessRequest{Path: remoteFilePath + filename, Parent: true}) {
+		return false
+	}
+	request := namenode.NameNodeReadRequest{FileName: remoteFilePath + filename, User: userInfo(), Delete: true}
 	var reply []namenode.NameNodeMetaData
 	// 通过rpc调用 ReadData 读取文件对应的元数据数组
-	// 返回信息：BlockIds对应的BlockAddresses（datanode的host+port）
+	// 返回信息：BlockIds对应的BlockAddresses（datanode的host+port），以及删除块的令牌
 	err := nameNodeInstance.Call("Service.ReadData", request, &reply)
 	//rpc调用失败，打印错误信息，返回错误
 	if err != nil {
@@ -375,8 +357,15 @@ func DeleteFile(nameNodeInstance *rpc.Client, remoteFilePath string, filename st
 		blockId := metaData.BlockId
 		blockAddresses := metaData.BlockAddresses
 		//每个BlockId对应多个BlockAddress，所以要遍历删除
-		for _, selectedDataNode := range blockAddresses {
-			dataNodeInstance, rpcErr := rpc.Dial("tcp", selectedDataNode.Host+":"+selectedDataNode.ServicePort)
+		for index, selectedDataNode := range blockAddresses {
+			//纠删码块组的每个地址对应一个内部块，丢失的内部块没有地址
+			if metaData.ECPolicy.DataUnits > 0 {
+				if selectedDataNode.Host == "" {
+					continue
+				}
+				blockId = namenode.InternalBlockId(metaData.BlockId, index)
+			}
+			dataNodeInstance, rpcErr := auth.Dial(selectedDataNode.Host + ":" + selectedDataNode.ServicePort)
 			//如果连接失败，可能当前datanode节点死亡，跳过
 			if rpcErr != nil {
 				log.Printf("DataNode %v : %v delete file fail,next datanode\n", selectedDataNode.Host, selectedDataNode.ServicePort)
@@ -388,6 +377,7 @@ func DeleteFile(nameNodeInstance *rpc.Client, remoteFilePath string, filename st
 			request := datanode.DataNodeDeleteRequest{
 				RemoteFilepath: remoteFilePath,
 				BlockId:        blockId,
+				BlockToken:     metaData.BlockToken,
 			}
 			var reply datanode.DataNodeReplyStatus
 			//通过rpc调用DeleteFile,返回删除成功与否
@@ -408,7 +398,7 @@ func DeleteFile(nameNodeInstance *rpc.Client, remoteFilePath string, filename st
 	//FileNameSize,
 	//DirectoryToFileName value中的filename
 	var reply1 bool
-	var request1 = namenode.NameNodeDeleteRequest{RemoteFilePath: remoteFilePath, FileName: filename}
+	var request1 = namenode.NameNodeDeleteRequest{RemoteFilePath: remoteFilePath, FileName: filename, User: userInfo()}
 	err = nameNodeInstance.Call("Service.DeleteFileNameMetaData", request1, &reply1)
 	//rpc调用失败，打印错误信息，返回错误
 	if err != nil {
@@ -418,3 +408,36 @@ func DeleteFile(nameNodeInstance *rpc.Client, remoteFilePath string, filename st
 	deleteFileStatus = reply1
 	return
 }
+
+// SetReplication 修改文件的副本因子，fileName为空时设置目录的默认副本因子，返回修改是否成功
+func SetReplication(nameNodeInstance *rpc.Client, remoteFilePath string, fileName string, replicationFactor uint64) (setReplicationStatus bool) {
+	request := namenode.NameNodeSetReplicationRequest{RemoteFilePath: remoteFilePath, FileName: fileName, ReplicationFactor: replicationFactor, User: userInfo()}
+	// 只需要修改nameNode的元数据，副本的复制和删除由nameNode负责
+	err := nameNodeInstance.Call("Service.SetReplication", request, &setReplicationStatus)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return
+}
+
+// SetDataNodeAdminState 修改dataNode的管理状态：下线、恢复正常或者进入维护模式，返回修改是否成功
+func SetDataNodeAdminState(nameNodeInstance *rpc.Client, dataNode string, state string, maintenanceDuration time.Duration) (setStatus bool) {
+	request := namenode.DataNodeAdminRequest{DataNode: dataNode, State: state, MaintenanceDuration: maintenanceDuration}
+	err := nameNodeInstance.Call("Service.SetDataNodeAdminState", request, &setStatus)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return
+}
+
+// GetDataNodeAdminState 获取dataNode的管理状态，出现异常时返回空
+func GetDataNodeAdminState(nameNodeInstance *rpc.Client, dataNode string) (state string) {
+	err := nameNodeInstance.Call("Service.GetDataNodeAdminState", dataNode, &state)
+	if err != nil {
+		log.Println(err)
+		return ""
+	}
+	return
+}
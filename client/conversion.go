@@ -0,0 +1,81 @@
+package client
+
+import (
+	"github.com/liuzongzhou/GoDFS/namenode"
+	"io"
+	"log"
+	"net/rpc"
+	"os"
+)
+
+// blockReader 按顺序读取文件的块，实现io.Reader，转换存储方式时数据只经过内存，不落本地磁盘
+type blockReader struct {
+	remoteFilePath string
+	metadata       []namenode.NameNodeMetaData
+	buffer         []byte
+}
+
+func (reader *blockReader) Read(p []byte) (int, error) {
+	for len(reader.buffer) == 0 {
+		if len(reader.metadata) == 0 {
+			return 0, io.EOF
+		}
+		data, err := readBlock(reader.remoteFilePath, reader.metadata[0])
+		if err != nil {
+			return 0, err
+		}
+		reader.metadata = reader.metadata[1:]
+		reader.buffer = data
+	}
+	n := copy(p, reader.buffer)
+	reader.buffer = reader.buffer[n:]
+	return n, nil
+}
+
+// Convert 转换文件的存储方式，policy为纠删码策略名或者replication，转换为多副本存储时replicationFactor为0则继承目录的默认副本因子。
+// 先由nameNode分配新布局的块，客户端读出原来的块写入新布局，再由nameNode切换文件的块，路径不变
+func Convert(nameNodeInstance *rpc.Client, remoteFilePath string, fileName string, policy string, replicationFactor uint64) (convertStatus bool) {
+	var oldMetaData []namenode.NameNodeMetaData
+	err := nameNodeInstance.Call("Service.ReadData", namenode.NameNodeReadRequest{FileName: remoteFilePath + fileName, User: userInfo()}, &oldMetaData)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	var blockSize uint64
+	err = nameNodeInstance.Call("Service.GetBlockSize", true, &blockSize)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	clientHost, _ := os.Hostname()
+	request := namenode.NameNodeConvertRequest{
+		RemoteFilePath:    remoteFilePath,
+		FileName:          fileName,
+		Policy:            policy,
+		ReplicationFactor: replicationFactor,
+		ClientHost:        clientHost,
+		User:              userInfo(),
+	}
+	var newMetaData []namenode.NameNodeMetaData
+	err = nameNodeInstance.Call("Service.ConvertFile", request, &newMetaData)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	reader := &blockReader{remoteFilePath: remoteFilePath, metadata: oldMetaData}
+	var reply bool
+	//写入新布局失败，放弃转换，nameNode删除已经写入的块
+	if !writeBlocks(reader, remoteFilePath, newMetaData, blockSize) {
+		err = nameNodeInstance.Call("Service.AbortConversion", request, &reply)
+		if err != nil {
+			log.Println(err)
+		}
+		return false
+	}
+	err = nameNodeInstance.Call("Service.CommitConversion", request, &convertStatus)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"errors"
+	"github.com/liuzongzhou/GoDFS/encryption"
+	"github.com/liuzongzhou/GoDFS/namenode"
+	"io"
+	"log"
+	"net/rpc"
+)
+
+// keyStoreFile 客户端的加密区密钥库文件，读写加密区中的文件时从中读取加密区密钥
+var keyStoreFile string
+
+// SetKeyStore 设置客户端使用的加密区密钥库文件
+func SetKeyStore(file string) {
+	keyStoreFile = file
+}
+
+// zoneKey 从密钥库中读取加密区密钥
+func zoneKey(keyName string) ([]byte, error) {
+	if keyStoreFile == "" {
+		return nil, errors.New("没有指定密钥库，无法读写加密区中的文件")
+	}
+	return encryption.ZoneKey(keyStoreFile, keyName)
+}
+
+// newFileEncryption 为写入的文件生成数据密钥，不在加密区中时返回空的加密信息
+func newFileEncryption(nameNodeInstance *rpc.Client, remoteFilePath string) (info namenode.FileEncryptionInfo, dataKey []byte, err error) {
+	var zone namenode.EncryptionZoneStatus
+	err = nameNodeInstance.Call("Service.GetEncryptionZone", remoteFilePath, &zone)
+	if err != nil || zone.Path == "" {
+		return
+	}
+	key, err := zoneKey(zone.KeyName)
+	if err != nil {
+		return
+	}
+	dataKey, iv, err := encryption.NewDataKey()
+	if err != nil {
+		return
+	}
+	wrapped, err := encryption.WrapKey(key, dataKey)
+	if err != nil {
+		return
+	}
+	info = namenode.FileEncryptionInfo{KeyName: zone.KeyName, Cipher: zone.Cipher, EncryptedDataKey: wrapped, IV: iv}
+	return
+}
+
+// decryptWriter 得到解密写入writer的WriteCloser，文件没有加密时直接写入
+func decryptWriter(nameNodeInstance *rpc.Client, fileName string, writer io.Writer) (io.WriteCloser, error) {
+	var info namenode.FileEncryptionInfo
+	err := nameNodeInstance.Call("Service.GetFileEncryptionInfo", namenode.NameNodeReadRequest{FileName: fileName, User: userInfo()}, &info)
+	if err != nil {
+		return nil, err
+	}
+	if info.KeyName == "" {
+		return nopWriteCloser{writer}, nil
+	}
+	key, err := zoneKey(info.KeyName)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := encryption.UnwrapKey(key, info.EncryptedDataKey)
+	if err != nil {
+		return nil, err
+	}
+	return encryption.NewDecryptWriter(info.Cipher, dataKey, info.IV, writer)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// CreateEncryptionZone 把空目录设置为加密区，密钥需要已经在客户端的密钥库中，返回设置是否成功
+func CreateEncryptionZone(nameNodeInstance *rpc.Client, remoteFilePath string, keyName string, cipherName string) (createStatus bool) {
+	if _, err := zoneKey(keyName); err != nil {
+		log.Println(err)
+		return false
+	}
+	request := namenode.NameNodeCreateEncryptionZoneRequest{RemoteFilePath: remoteFilePath, KeyName: keyName, Cipher: cipherName, User: userInfo()}
+	err := nameNodeInstance.Call("Service.CreateEncryptionZone", request, &createStatus)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return
+}
+
+// ListEncryptionZones 列出所有加密区
+func ListEncryptionZones(nameNodeInstance *rpc.Client) (zones []namenode.EncryptionZoneStatus, ok bool) {
+	err := nameNodeInstance.Call("Service.ListEncryptionZones", true, &zones)
+	if err != nil {
+		log.Println(err)
+		return nil, false
+	}
+	return zones, true
+}
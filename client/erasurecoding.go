@@ -0,0 +1,141 @@
+package client
+
+import (
+	"errors"
+	"github.com/liuzongzhou/GoDFS/auth"
+	"github.com/liuzongzhou/GoDFS/datanode"
+	"github.com/liuzongzhou/GoDFS/erasure"
+	"github.com/liuzongzhou/GoDFS/namenode"
+	"io"
+	"log"
+	"net/rpc"
+)
+
+// putBlockGroup 读取一个块组的数据，切分成数据分片并编码出校验分片，按分片下标分别写入对应的dataNode
+func putBlockGroup(fileHandler io.Reader, remoteFilePath string, metaData namenode.NameNodeMetaData) error {
+	encoder, err := erasure.New(metaData.ECPolicy.DataUnits, metaData.ECPolicy.ParityUnits)
+	if err != nil {
+		return err
+	}
+	data := make([]byte, metaData.GroupSize)
+	_, err = io.ReadFull(fileHandler, data)
+	if err != nil {
+		return err
+	}
+	shards := encoder.Split(data)
+	err = encoder.Encode(shards)
+	if err != nil {
+		return err
+	}
+	for index, address := range metaData.BlockAddresses {
+		request := datanode.DataNodePutRequest{
+			RemoteFilePath:  remoteFilePath,
+			BlockId:         namenode.InternalBlockId(metaData.BlockId, index),
+			Data:            string(shards[index]),
+			GenerationStamp: metaData.GenerationStamp,
+			BlockToken:      metaData.BlockToken,
+		}
+		err = putInternalBlock(address, request)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putInternalBlock 把一个内部块写入dataNode，内部块只有一个副本，不需要转发
+func putInternalBlock(address datanode.DataNodeInstance, request datanode.DataNodePutRequest) error {
+	dataNodeInstance, err := auth.Dial(address.Host + ":" + address.ServicePort)
+	if err != nil {
+		return err
+	}
+	defer dataNodeInstance.Close()
+	var reply datanode.DataNodeReplyStatus
+	err = dataNodeInstance.Call("Service.PutData", request, &reply)
+	if err != nil {
+		return err
+	}
+	if !reply.Status {
+		return errors.New("写入内部块失败")
+	}
+	return nil
+}
+
+// getBlockGroup 读取一个块组的数据，优先读取数据分片，有分片丢失或者所在节点不可用时继续读取校验分片，
+// 读到DataUnits个分片后解码还原出丢失的数据分片
+func getBlockGroup(remoteFilePath string, metaData namenode.NameNodeMetaData) ([]byte, error) {
+	encoder, err := erasure.New(metaData.ECPolicy.DataUnits, metaData.ECPolicy.ParityUnits)
+	if err != nil {
+		return nil, err
+	}
+	shards := make([][]byte, metaData.ECPolicy.DataUnits+metaData.ECPolicy.ParityUnits)
+	fetched := 0
+	for index, address := range metaData.BlockAddresses {
+		if fetched == metaData.ECPolicy.DataUnits {
+			break
+		}
+		//内部块已经丢失，等待nameNode后台重建
+		if address.Host == "" {
+			continue
+		}
+		request := datanode.DataNodeGetRequest{
+			RemoteFilePath:  remoteFilePath,
+			BlockId:         namenode.InternalBlockId(metaData.BlockId, index),
+			GenerationStamp: metaData.GenerationStamp,
+			BlockToken:      metaData.BlockToken,
+		}
+		data, err := getInternalBlock(address, request)
+		if err != nil {
+			log.Printf("DataNode %v : %v read internal block %d fail,next internal block\n", address.Host, address.ServicePort, index)
+			continue
+		}
+		shards[index] = []byte(data)
+		fetched++
+	}
+	if fetched < metaData.ECPolicy.DataUnits {
+		return nil, errors.New("可用的内部块不足，无法还原块组" + metaData.BlockId)
+	}
+	for index := 0; index < metaData.ECPolicy.DataUnits; index++ {
+		if shards[index] == nil {
+			err = encoder.Reconstruct(shards)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	return encoder.Join(shards, int(metaData.GroupSize))
+}
+
+// getInternalBlock 从dataNode读取一个内部块
+func getInternalBlock(address datanode.DataNodeInstance, request datanode.DataNodeGetRequest) (string, error) {
+	dataNodeInstance, err := auth.Dial(address.Host + ":" + address.ServicePort)
+	if err != nil {
+		return "", err
+	}
+	defer dataNodeInstance.Close()
+	var reply datanode.DataNodeData
+	err = dataNodeInstance.Call("Service.GetData", request, &reply)
+	return reply.Data, err
+}
+
+// SetErasureCodingPolicy 设置目录的纠删码策略，policy为replication时使用多副本存储，为空时继承上级目录的策略，返回设置是否成功
+func SetErasureCodingPolicy(nameNodeInstance *rpc.Client, remoteFilePath string, policy string) (setStatus bool) {
+	request := namenode.NameNodeSetErasureCodingPolicyRequest{RemoteFilePath: remoteFilePath, Policy: policy, User: userInfo()}
+	err := nameNodeInstance.Call("Service.SetErasureCodingPolicy", request, &setStatus)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return
+}
+
+// GetErasureCodingPolicy 获取文件或者目录生效的纠删码策略，fileName为空时获取目录的策略，出现异常时返回空
+func GetErasureCodingPolicy(nameNodeInstance *rpc.Client, remoteFilePath string, fileName string) (policy string) {
+	err := nameNodeInstance.Call("Service.GetErasureCodingPolicy", remoteFilePath+fileName, &policy)
+	if err != nil {
+		log.Println(err)
+		return ""
+	}
+	return
+}
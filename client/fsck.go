@@ -0,0 +1,85 @@
+package client
+
+import (
+	"bytes"
+	"github.com/liuzongzhou/GoDFS/namenode"
+	"log"
+	"net/rpc"
+	"os"
+	"strings"
+)
+
+// lostFoundDirectory 移动损坏文件的目标目录，文件保持原来的相对路径
+const lostFoundDirectory = "/lost+found/"
+
+// Fsck 检查指定路径下文件的块，move为true时把损坏文件中还能读取的块移动到lost+found，deleteCorrupt为true时删除损坏的文件。
+// 返回检查结果和操作是否成功
+func Fsck(nameNodeInstance *rpc.Client, path string, move bool, deleteCorrupt bool) (report namenode.FsckReport, fsckStatus bool) {
+	request := namenode.NameNodeFsckRequest{Path: path, Delete: deleteCorrupt && !move, User: userInfo()}
+	err := nameNodeInstance.Call("Service.Fsck", request, &report)
+	if err != nil {
+		log.Println(err)
+		return report, false
+	}
+	if !move {
+		return report, true
+	}
+	//先把还能读取的块写入lost+found，再由nameNode删除原来的文件
+	for _, fileName := range report.CorruptFiles {
+		if !salvageFile(nameNodeInstance, fileName) {
+			return report, false
+		}
+		report.MovedFiles = append(report.MovedFiles, fileName)
+		var deleteReport namenode.FsckReport
+		err = nameNodeInstance.Call("Service.Fsck", namenode.NameNodeFsckRequest{Path: fileName, Delete: true, User: userInfo()}, &deleteReport)
+		if err != nil {
+			log.Println(err)
+			return report, false
+		}
+		report.DeletedFiles = append(report.DeletedFiles, deleteReport.DeletedFiles...)
+	}
+	return report, true
+}
+
+// salvageFile 读取损坏文件中还能读取的块，按顺序拼接后写入lost+found下的同名文件，没有可读取的块时不写入
+func salvageFile(nameNodeInstance *rpc.Client, fileName string) bool {
+	separator := strings.LastIndex(fileName, "/") + 1
+	remoteFilePath := fileName[:separator]
+	var metadata []namenode.NameNodeMetaData
+	err := nameNodeInstance.Call("Service.ReadData", namenode.NameNodeReadRequest{FileName: fileName, User: userInfo()}, &metadata)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	var salvaged []byte
+	for _, metaData := range metadata {
+		data, err := readBlock(remoteFilePath, metaData)
+		if err != nil {
+			log.Printf("Block %s of %s is lost: %v\n", metaData.BlockId, fileName, err)
+			continue
+		}
+		salvaged = append(salvaged, data...)
+	}
+	if len(salvaged) == 0 {
+		return true
+	}
+	lostFoundPath := lostFoundDirectory + strings.TrimPrefix(remoteFilePath, "/")
+	if !Mkdir(nameNodeInstance, lostFoundPath) {
+		return false
+	}
+	clientHost, _ := os.Hostname()
+	request := namenode.NameNodeWriteRequest{RemoteFilePath: lostFoundPath, FileName: fileName[separator:], FileSize: uint64(len(salvaged)), ClientHost: clientHost, User: userInfo()}
+	var reply []namenode.NameNodeMetaData
+	err = nameNodeInstance.Call("Service.WriteData", request, &reply)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	var blockSize uint64
+	err = nameNodeInstance.Call("Service.GetBlockSize", true, &blockSize)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return writeBlocks(bytes.NewReader(salvaged), lostFoundPath, reply, blockSize)
+}
@@ -0,0 +1,39 @@
+package client
+
+import (
+	"github.com/liuzongzhou/GoDFS/namenode"
+	"log"
+	"net/rpc"
+)
+
+// SetQuota 设置目录的名字配额和空间配额，为0的配额保持不变，返回设置是否成功
+func SetQuota(nameNodeInstance *rpc.Client, path string, nameQuota uint64, spaceQuota uint64) (setStatus bool) {
+	request := namenode.NameNodeSetQuotaRequest{Path: path, NameQuota: nameQuota, SpaceQuota: spaceQuota, User: userInfo()}
+	err := nameNodeInstance.Call("Service.SetQuota", request, &setStatus)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return
+}
+
+// ClearQuota 清除目录的名字配额或者空间配额，name和space都为false时清除两种配额，返回清除是否成功
+func ClearQuota(nameNodeInstance *rpc.Client, path string, name bool, space bool) (clearStatus bool) {
+	request := namenode.NameNodeClearQuotaRequest{Path: path, Name: name, Space: space, User: userInfo()}
+	err := nameNodeInstance.Call("Service.ClearQuota", request, &clearStatus)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return
+}
+
+// Count 统计目录下的目录数、文件数、占用的空间和配额
+func Count(nameNodeInstance *rpc.Client, path string) (summary namenode.ContentSummary, ok bool) {
+	err := nameNodeInstance.Call("Service.GetContentSummary", path, &summary)
+	if err != nil {
+		log.Println(err)
+		return summary, false
+	}
+	return summary, true
+}
@@ -0,0 +1,17 @@
+package client
+
+import (
+	"github.com/liuzongzhou/GoDFS/namenode"
+	"log"
+	"net/rpc"
+)
+
+// Report 获取集群报告，返回集群报告和操作是否成功
+func Report(nameNodeInstance *rpc.Client) (report namenode.ClusterReport, ok bool) {
+	err := nameNodeInstance.Call("Service.Report", true, &report)
+	if err != nil {
+		log.Println(err)
+		return report, false
+	}
+	return report, true
+}
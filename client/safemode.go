@@ -0,0 +1,26 @@
+package client
+
+import (
+	"github.com/liuzongzhou/GoDFS/namenode"
+	"log"
+	"net/rpc"
+)
+
+// SafeMode 管理nameNode的安全模式：enter、leave、get，返回操作后的安全模式状态和操作是否成功
+func SafeMode(nameNodeInstance *rpc.Client, action string) (status namenode.SafeModeStatus, ok bool) {
+	err := nameNodeInstance.Call("Service.SafeMode", action, &status)
+	if err != nil {
+		log.Println(err)
+		return status, false
+	}
+	return status, true
+}
+
+// inSafeMode 先修改dataNode再修改nameNode元数据的操作，需要提前确认nameNode不在安全模式，否则元数据修改会被拒绝
+func inSafeMode(nameNodeInstance *rpc.Client) bool {
+	status, ok := SafeMode(nameNodeInstance, namenode.SafeModeGet)
+	if ok && status.On {
+		log.Println("NameNode is in safe mode")
+	}
+	return !ok || status.On
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"github.com/liuzongzhou/GoDFS/namenode"
+	"log"
+	"net/rpc"
+)
+
+// AllowSnapshot 允许在目录上创建快照，返回设置是否成功
+func AllowSnapshot(nameNodeInstance *rpc.Client, path string) (allowStatus bool) {
+	request := namenode.NameNodeSnapshotRequest{Path: path, User: userInfo()}
+	err := nameNodeInstance.Call("Service.AllowSnapshot", request, &allowStatus)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return
+}
+
+// DisallowSnapshot 不再允许在目录上创建快照，返回设置是否成功
+func DisallowSnapshot(nameNodeInstance *rpc.Client, path string) (disallowStatus bool) {
+	request := namenode.NameNodeSnapshotRequest{Path: path, User: userInfo()}
+	err := nameNodeInstance.Call("Service.DisallowSnapshot", request, &disallowStatus)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return
+}
+
+// CreateSnapshot 为目录创建快照，snapshotName为空时按创建时间命名，返回快照的路径
+func CreateSnapshot(nameNodeInstance *rpc.Client, path string, snapshotName string) (snapshotPath string, ok bool) {
+	request := namenode.NameNodeSnapshotRequest{Path: path, SnapshotName: snapshotName, User: userInfo()}
+	err := nameNodeInstance.Call("Service.CreateSnapshot", request, &snapshotPath)
+	if err != nil {
+		log.Println(err)
+		return "", false
+	}
+	return snapshotPath, true
+}
+
+// DeleteSnapshot 删除目录的快照，返回删除是否成功
+func DeleteSnapshot(nameNodeInstance *rpc.Client, path string, snapshotName string) (deleteStatus bool) {
+	request := namenode.NameNodeSnapshotRequest{Path: path, SnapshotName: snapshotName, User: userInfo()}
+	err := nameNodeInstance.Call("Service.DeleteSnapshot", request, &deleteStatus)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return
+}
+
+// ListSnapshots 列出目录的所有快照，path为空时列出所有可以创建快照的目录
+func ListSnapshots(nameNodeInstance *rpc.Client, path string) (directories []namenode.SnapshottableDirectoryStatus, ok bool) {
+	err := nameNodeInstance.Call("Service.ListSnapshots", path, &directories)
+	if err != nil {
+		log.Println(err)
+		return nil, false
+	}
+	return directories, true
+}
@@ -0,0 +1,19 @@
+package client
+
+import (
+	"github.com/liuzongzhou/GoDFS/namenode"
+	"log"
+	"net/rpc"
+)
+
+// MoveToTrash 把文件或者目录移到用户的回收站，fileName为空时移动目录remoteFilePath，块的移动由nameNode完成。
+// 回收站没有开启或者路径已经在回收站中时reply.Moved为false，需要直接删除
+func MoveToTrash(nameNodeInstance *rpc.Client, remoteFilePath string, fileName string) (reply namenode.NameNodeMoveToTrashReply, ok bool) {
+	request := namenode.NameNodeMoveToTrashRequest{RemoteFilePath: remoteFilePath, FileName: fileName, User: userInfo()}
+	err := nameNodeInstance.Call("Service.MoveToTrash", request, &reply)
+	if err != nil {
+		log.Println(err)
+		return reply, false
+	}
+	return reply, true
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"github.com/liuzongzhou/GoDFS/namenode"
+	"log"
+	"net/rpc"
+	"os/user"
+)
+
+// currentUser 客户端使用的用户身份，为空时使用运行客户端的系统用户
+var currentUser namenode.UserInfo
+
+// SetUser 设置客户端使用的用户身份，之后的请求都以该用户的身份进行权限检查
+func SetUser(name string, groups []string) {
+	currentUser = namenode.UserInfo{Name: name, Groups: groups}
+}
+
+// userInfo 得到请求中携带的用户身份，没有设置时使用运行客户端的系统用户和所在的组
+func userInfo() namenode.UserInfo {
+	if currentUser.Name != "" {
+		return currentUser
+	}
+	current, err := user.Current()
+	if err != nil {
+		log.Println(err)
+		return namenode.UserInfo{}
+	}
+	info := namenode.UserInfo{Name: current.Username}
+	groupIds, err := current.GroupIds()
+	if err != nil {
+		return info
+	}
+	for _, groupId := range groupIds {
+		if group, err := user.LookupGroupId(groupId); err == nil {
+			info.Groups = append(info.Groups, group.Name)
+		}
+	}
+	return info
+}
+
+// checkAccess 先修改dataNode再修改nameNode元数据的操作，需要提前确认用户有权限，否则dataNode上的数据被修改后nameNode才拒绝
+func checkAccess(nameNodeInstance *rpc.Client, request namenode.NameNodeCheckAccessRequest) bool {
+	request.User = userInfo()
+	var reply bool
+	err := nameNodeInstance.Call("Service.CheckAccess", request, &reply)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return reply
+}
+
+// SetPermission 修改文件或者目录的权限位，返回修改是否成功
+func SetPermission(nameNodeInstance *rpc.Client, path string, mode uint32) (setStatus bool) {
+	request := namenode.NameNodeSetPermissionRequest{Path: path, Mode: mode, User: userInfo()}
+	err := nameNodeInstance.Call("Service.SetPermission", request, &setStatus)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return
+}
+
+// SetOwner 修改文件或者目录的所有者和所属组，owner或者group为空时不修改，返回修改是否成功
+func SetOwner(nameNodeInstance *rpc.Client, path string, owner string, group string) (setStatus bool) {
+	request := namenode.NameNodeSetOwnerRequest{Path: path, Owner: owner, Group: group, User: userInfo()}
+	err := nameNodeInstance.Call("Service.SetOwner", request, &setStatus)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return
+}
+
+// GetPermission 获取文件或者目录的权限，返回权限和操作是否成功
+func GetPermission(nameNodeInstance *rpc.Client, path string) (permission namenode.Permission, ok bool) {
+	err := nameNodeInstance.Call("Service.GetPermission", path, &permission)
+	if err != nil {
+		log.Println(err)
+		return permission, false
+	}
+	return permission, true
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"github.com/liuzongzhou/GoDFS/auth"
+	"github.com/liuzongzhou/GoDFS/encryption"
+	"log"
+	"strings"
+	"time"
+)
+
+// GenerateKeyHandler 生成新的密钥文件，nameNode和dataNode使用同一个密钥文件，返回是否生成成功
+func GenerateKeyHandler(keyFile string) bool {
+	err := auth.GenerateKey(keyFile)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return true
+}
+
+// ConfigureTLSHandler 设置当前进程rpc连接使用的TLS证书，certFile和caFile都为空时不加密，返回是否设置成功
+func ConfigureTLSHandler(certFile string, keyFile string, caFile string, requireClientCert bool) bool {
+	err := auth.ConfigureTLS(certFile, keyFile, caFile, requireClientCert)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return true
+}
+
+// CreateZoneKeyHandler 在密钥库中生成新的加密区密钥，返回是否生成成功
+func CreateZoneKeyHandler(keyStore string, name string) bool {
+	if keyStore == "" || name == "" {
+		log.Println("密钥库和密钥名都不能为空")
+		return false
+	}
+	err := encryption.CreateKey(keyStore, name)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return true
+}
+
+// IssueTokenHandler 用密钥文件为用户签发令牌，groups以逗号分隔，返回令牌和是否签发成功
+func IssueTokenHandler(keyFile string, user string, groups string, ttl time.Duration) (string, bool) {
+	if user == "" {
+		log.Println("用户不能为空")
+		return "", false
+	}
+	key, err := auth.LoadKey(keyFile)
+	if err != nil {
+		log.Println(err)
+		return "", false
+	}
+	identity := auth.Identity{Name: user}
+	if groups != "" {
+		identity.Groups = strings.Split(groups, ",")
+	}
+	token, err := auth.IssueToken(key, identity, ttl)
+	if err != nil {
+		log.Println(err)
+		return "", false
+	}
+	return token, true
+}
@@ -0,0 +1,26 @@
+package balancer
+
+import (
+	"github.com/liuzongzhou/GoDFS/auth"
+	"github.com/liuzongzhou/GoDFS/balancer"
+	"log"
+)
+
+// BalancerHandler 连接nameNode进行集群均衡，返回移动的块数，token不为空时用令牌进行认证
+func BalancerHandler(nameNodeAddress string, threshold float64, bandwidth uint64, iterations int, token string) int {
+	if token != "" {
+		auth.SetAuthenticator(&auth.HMACAuthenticator{Token: token})
+	}
+	rpcClient, err := auth.Dial(nameNodeAddress)
+	if err != nil {
+		log.Printf("NameNode to connect to is %s fail,please use replication namenode\n", nameNodeAddress)
+		return 0
+	}
+	defer rpcClient.Close()
+	log.Printf("Threshold is %.2f%%, bandwidth is %d bytes/s\n", threshold, bandwidth)
+	moved, err := balancer.Balance(rpcClient, threshold, bandwidth, iterations)
+	if err != nil {
+		log.Println(err)
+	}
+	return moved
+}
@@ -1,10 +1,14 @@
 package client
 
 import (
+	"github.com/liuzongzhou/GoDFS/auth"
 	"github.com/liuzongzhou/GoDFS/client"
+	"github.com/liuzongzhou/GoDFS/namenode"
 	"log"
 	"net"
 	"net/rpc"
+	"strings"
+	"time"
 )
 
 // initializeClientUtil client与nameNode建立连接，生成一个client操作实例
@@ -17,7 +21,7 @@ func initializeClientUtil(nameNodeAddress string) (*rpc.Client, error) {
 		return nil, err
 	}
 	// 连接失败，可能nameNode节点进程终止了，提示启用备份节点
-	rpcClient, err := rpc.Dial("tcp", host+":"+port)
+	rpcClient, err := auth.Dial(host + ":" + port)
 	if err != nil {
 		log.Printf("NameNode to connect to is %s fail,please use replication namenode\n", nameNodeAddress)
 		return rpcClient, err
@@ -27,7 +31,7 @@ func initializeClientUtil(nameNodeAddress string) (*rpc.Client, error) {
 }
 
 // PutHandler 给子进程发送put消息,返回是否put成功的结果
-func PutHandler(nameNodeAddress string, sourcePath string, fileName string, remoteFilepath string) bool {
+func PutHandler(nameNodeAddress string, sourcePath string, fileName string, remoteFilepath string, replicationFactor uint64) bool {
 	rpcClient, err := initializeClientUtil(nameNodeAddress)
 	if err != nil {
 		log.Println(err)
@@ -36,7 +40,7 @@ func PutHandler(nameNodeAddress string, sourcePath string, fileName string, remo
 	//client与nameNode建立连接，生成一个client操作实例
 	//未获得有效实例，返回false
 	defer rpcClient.Close()
-	return client.Put(rpcClient, sourcePath, fileName, remoteFilepath)
+	return client.Put(rpcClient, sourcePath, fileName, remoteFilepath, replicationFactor)
 }
 
 func GetHandler(nameNodeAddress string, remoteFilepath string, fileName string, localFilePath string) bool {
@@ -99,21 +103,309 @@ func ListHandler(nameNodeAddress string, remoteDirPath string) (fileInfo map[str
 	return client.List(rpcClient, remoteDirPath)
 }
 
-func DeletePathHandler(nameNodeAddress string, remoteFilePath string) bool {
+// DeletePathHandler 删除目录，skipTrash为false时先移到回收站，返回在回收站中的位置；
+// 回收站没有开启或者目录已经在回收站中时直接删除
+func DeletePathHandler(nameNodeAddress string, remoteFilePath string, skipTrash bool) (trashPath string, status bool) {
+	rpcClient, err := initializeClientUtil(nameNodeAddress)
+	if err != nil {
+		log.Println(err)
+		return "", false
+	}
+	defer rpcClient.Close()
+	if !skipTrash {
+		reply, ok := client.MoveToTrash(rpcClient, remoteFilePath, "")
+		if !ok || reply.Moved {
+			return reply.TrashPath, ok
+		}
+	}
+	return "", client.DeletePath(rpcClient, remoteFilePath)
+}
+
+// DeleteFileHandler 删除文件，skipTrash为false时先移到回收站，返回在回收站中的位置；
+// 回收站没有开启或者文件已经在回收站中时直接删除
+func DeleteFileHandler(nameNodeAddress string, remoteFilePath string, filename string, skipTrash bool) (trashPath string, status bool) {
+	rpcClient, err := initializeClientUtil(nameNodeAddress)
+	if err != nil {
+		log.Println(err)
+		return "", false
+	}
+	defer rpcClient.Close()
+	if !skipTrash {
+		reply, ok := client.MoveToTrash(rpcClient, remoteFilePath, filename)
+		if !ok || reply.Moved {
+			return reply.TrashPath, ok
+		}
+	}
+	return "", client.DeleteFile(rpcClient, remoteFilePath, filename)
+}
+
+func SetReplicationHandler(nameNodeAddress string, remoteFilePath string, filename string, replicationFactor uint64) bool {
+	rpcClient, err := initializeClientUtil(nameNodeAddress)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	defer rpcClient.Close()
+	return client.SetReplication(rpcClient, remoteFilePath, filename, replicationFactor)
+}
+
+func SetDataNodeAdminStateHandler(nameNodeAddress string, dataNode string, state string, maintenanceDuration time.Duration) bool {
+	rpcClient, err := initializeClientUtil(nameNodeAddress)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	defer rpcClient.Close()
+	return client.SetDataNodeAdminState(rpcClient, dataNode, state, maintenanceDuration)
+}
+
+func GetDataNodeAdminStateHandler(nameNodeAddress string, dataNode string) string {
+	rpcClient, err := initializeClientUtil(nameNodeAddress)
+	if err != nil {
+		log.Println(err)
+		return ""
+	}
+	defer rpcClient.Close()
+	return client.GetDataNodeAdminState(rpcClient, dataNode)
+}
+
+func SetErasureCodingPolicyHandler(nameNodeAddress string, remoteFilePath string, policy string) bool {
+	rpcClient, err := initializeClientUtil(nameNodeAddress)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	defer rpcClient.Close()
+	return client.SetErasureCodingPolicy(rpcClient, remoteFilePath, policy)
+}
+
+func GetErasureCodingPolicyHandler(nameNodeAddress string, remoteFilePath string, filename string) string {
+	rpcClient, err := initializeClientUtil(nameNodeAddress)
+	if err != nil {
+		log.Println(err)
+		return ""
+	}
+	defer rpcClient.Close()
+	return client.GetErasureCodingPolicy(rpcClient, remoteFilePath, filename)
+}
+
+func ConvertHandler(nameNodeAddress string, remoteFilePath string, filename string, policy string, replicationFactor uint64) bool {
 	rpcClient, err := initializeClientUtil(nameNodeAddress)
 	if err != nil {
 		log.Println(err)
 		return false
 	}
 	defer rpcClient.Close()
-	return client.DeletePath(rpcClient, remoteFilePath)
+	return client.Convert(rpcClient, remoteFilePath, filename, policy, replicationFactor)
 }
-func DeleteFileHandler(nameNodeAddress string, remoteFilePath string, filename string) bool {
+
+func SafeModeHandler(nameNodeAddress string, action string) (namenode.SafeModeStatus, bool) {
+	rpcClient, err := initializeClientUtil(nameNodeAddress)
+	if err != nil {
+		log.Println(err)
+		return namenode.SafeModeStatus{}, false
+	}
+	defer rpcClient.Close()
+	return client.SafeMode(rpcClient, action)
+}
+
+func FsckHandler(nameNodeAddress string, path string, move bool, deleteCorrupt bool) (namenode.FsckReport, bool) {
+	rpcClient, err := initializeClientUtil(nameNodeAddress)
+	if err != nil {
+		log.Println(err)
+		return namenode.FsckReport{}, false
+	}
+	defer rpcClient.Close()
+	return client.Fsck(rpcClient, path, move, deleteCorrupt)
+}
+
+func ReportHandler(nameNodeAddress string) (namenode.ClusterReport, bool) {
+	rpcClient, err := initializeClientUtil(nameNodeAddress)
+	if err != nil {
+		log.Println(err)
+		return namenode.ClusterReport{}, false
+	}
+	defer rpcClient.Close()
+	return client.Report(rpcClient)
+}
+
+// SetTokenHandler 设置客户端连接nameNode和dataNode时使用的令牌，为空时不认证
+func SetTokenHandler(token string) {
+	if token == "" {
+		return
+	}
+	auth.SetAuthenticator(&auth.HMACAuthenticator{Token: token})
+}
+
+// SetUserHandler 设置客户端使用的用户身份，name为空时使用运行客户端的系统用户，groups以逗号分隔
+func SetUserHandler(name string, groups string) {
+	if name == "" {
+		return
+	}
+	var groupList []string
+	if groups != "" {
+		groupList = strings.Split(groups, ",")
+	}
+	client.SetUser(name, groupList)
+}
+
+func SetPermissionHandler(nameNodeAddress string, path string, mode uint32) bool {
+	rpcClient, err := initializeClientUtil(nameNodeAddress)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	defer rpcClient.Close()
+	return client.SetPermission(rpcClient, path, mode)
+}
+
+func SetOwnerHandler(nameNodeAddress string, path string, owner string, group string) bool {
 	rpcClient, err := initializeClientUtil(nameNodeAddress)
 	if err != nil {
 		log.Println(err)
 		return false
 	}
 	defer rpcClient.Close()
-	return client.DeleteFile(rpcClient, remoteFilePath, filename)
+	return client.SetOwner(rpcClient, path, owner, group)
+}
+
+func GetPermissionHandler(nameNodeAddress string, path string) (namenode.Permission, bool) {
+	rpcClient, err := initializeClientUtil(nameNodeAddress)
+	if err != nil {
+		log.Println(err)
+		return namenode.Permission{}, false
+	}
+	defer rpcClient.Close()
+	return client.GetPermission(rpcClient, path)
+}
+
+func SetAclHandler(nameNodeAddress string, path string, action string, entries []namenode.AclEntry) bool {
+	rpcClient, err := initializeClientUtil(nameNodeAddress)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	defer rpcClient.Close()
+	return client.SetAcl(rpcClient, path, action, entries)
+}
+
+func GetAclHandler(nameNodeAddress string, path string) (namenode.AclStatus, bool) {
+	rpcClient, err := initializeClientUtil(nameNodeAddress)
+	if err != nil {
+		log.Println(err)
+		return namenode.AclStatus{}, false
+	}
+	defer rpcClient.Close()
+	return client.GetAcl(rpcClient, path)
+}
+
+// SetKeyStoreHandler 设置客户端读写加密区中的文件时使用的密钥库，为空时无法读写加密区中的文件
+func SetKeyStoreHandler(keyStore string) {
+	client.SetKeyStore(keyStore)
+}
+
+func CreateEncryptionZoneHandler(nameNodeAddress string, remoteFilePath string, keyName string, cipherName string) bool {
+	rpcClient, err := initializeClientUtil(nameNodeAddress)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	defer rpcClient.Close()
+	return client.CreateEncryptionZone(rpcClient, remoteFilePath, keyName, cipherName)
+}
+
+func ListEncryptionZonesHandler(nameNodeAddress string) ([]namenode.EncryptionZoneStatus, bool) {
+	rpcClient, err := initializeClientUtil(nameNodeAddress)
+	if err != nil {
+		log.Println(err)
+		return nil, false
+	}
+	defer rpcClient.Close()
+	return client.ListEncryptionZones(rpcClient)
+}
+
+func SetQuotaHandler(nameNodeAddress string, path string, nameQuota uint64, spaceQuota uint64) bool {
+	rpcClient, err := initializeClientUtil(nameNodeAddress)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	defer rpcClient.Close()
+	return client.SetQuota(rpcClient, path, nameQuota, spaceQuota)
+}
+
+// ClearQuotaHandler 清除目录的配额，quotaType为name、space或者all
+func ClearQuotaHandler(nameNodeAddress string, path string, quotaType string) bool {
+	if quotaType != "name" && quotaType != "space" && quotaType != "all" {
+		log.Println("配额类型必须是name、space或者all")
+		return false
+	}
+	rpcClient, err := initializeClientUtil(nameNodeAddress)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	defer rpcClient.Close()
+	return client.ClearQuota(rpcClient, path, quotaType == "name", quotaType == "space")
+}
+
+func CountHandler(nameNodeAddress string, path string) (namenode.ContentSummary, bool) {
+	rpcClient, err := initializeClientUtil(nameNodeAddress)
+	if err != nil {
+		log.Println(err)
+		return namenode.ContentSummary{}, false
+	}
+	defer rpcClient.Close()
+	return client.Count(rpcClient, path)
+}
+
+func AllowSnapshotHandler(nameNodeAddress string, path string) bool {
+	rpcClient, err := initializeClientUtil(nameNodeAddress)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	defer rpcClient.Close()
+	return client.AllowSnapshot(rpcClient, path)
+}
+
+func DisallowSnapshotHandler(nameNodeAddress string, path string) bool {
+	rpcClient, err := initializeClientUtil(nameNodeAddress)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	defer rpcClient.Close()
+	return client.DisallowSnapshot(rpcClient, path)
+}
+
+// CreateSnapshotHandler 为目录创建快照，返回快照的路径
+func CreateSnapshotHandler(nameNodeAddress string, path string, snapshotName string) (string, bool) {
+	rpcClient, err := initializeClientUtil(nameNodeAddress)
+	if err != nil {
+		log.Println(err)
+		return "", false
+	}
+	defer rpcClient.Close()
+	return client.CreateSnapshot(rpcClient, path, snapshotName)
+}
+
+func DeleteSnapshotHandler(nameNodeAddress string, path string, snapshotName string) bool {
+	rpcClient, err := initializeClientUtil(nameNodeAddress)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	defer rpcClient.Close()
+	return client.DeleteSnapshot(rpcClient, path, snapshotName)
+}
+
+func ListSnapshotsHandler(nameNodeAddress string, path string) ([]namenode.SnapshottableDirectoryStatus, bool) {
+	rpcClient, err := initializeClientUtil(nameNodeAddress)
+	if err != nil {
+		log.Println(err)
+		return nil, false
+	}
+	defer rpcClient.Close()
+	return client.ListSnapshots(rpcClient, path)
 }
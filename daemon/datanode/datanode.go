@@ -2,6 +2,7 @@ package datanode
 
 import (
 	"errors"
+	"github.com/liuzongzhou/GoDFS/auth"
 	"github.com/liuzongzhou/GoDFS/datanode"
 	"log"
 	"net"
@@ -10,16 +11,40 @@ import (
 )
 
 // InitializeDataNodeUtil 初始化dataNode节点进程
-func InitializeDataNodeUtil(serverPort int, dataLocation string) {
+// nameNodeAddress不为空时，启动后主动向nameNode注册，dead之后重启的节点可以借此重新加入集群
+// keyFile不为空时开启认证，只接受携带用该密钥签名的令牌的连接，读写和删除块还需要nameNode签发的块访问令牌，
+// 管理目录和复制块等内部方法只允许服务身份调用
+func InitializeDataNodeUtil(serverHost string, serverPort int, dataLocation string, nameNodeAddress string, rack string, capacity uint64, keyFile string) {
 	// 生成dataNode实例
 	dataNodeInstance := new(datanode.Service)
 	// 记录元数据信息：根目录，端口号
 	dataNodeInstance.DataDirectory = dataLocation
+	dataNodeInstance.Host = serverHost
 	dataNodeInstance.ServicePort = uint16(serverPort)
+	dataNodeInstance.Rack = rack
+	dataNodeInstance.Capacity = capacity
 
 	log.Printf("Data storage location is %s\n", dataLocation)
+	// 监听使用的认证配置
+	server := &auth.Server{}
+	if keyFile != "" {
+		authenticator, authErr := auth.ServiceAuthenticator(keyFile)
+		if authErr != nil {
+			log.Println(authErr)
+			return
+		}
+		auth.SetAuthenticator(authenticator)
+		server.Authenticator = authenticator
+		server.Authorizer = dataNodeInstance.Authorize
+		dataNodeInstance.SetBlockKey(authenticator.Key)
+		log.Printf("Authentication is enabled\n")
+	}
+	err := dataNodeInstance.LoadUuid()
+	if err != nil {
+		log.Println(err)
+	}
 	// 向注册中心注册实例
-	err := rpc.Register(dataNodeInstance)
+	err = rpc.Register(dataNodeInstance)
 	if err != nil {
 		return
 	}
@@ -30,15 +55,50 @@ func InitializeDataNodeUtil(serverPort int, dataLocation string) {
 	initErr := errors.New("init")
 	//选择空闲的端口号作为连接
 	for initErr != nil {
-		listener, initErr = net.Listen("tcp", ":"+strconv.Itoa(serverPort))
+		listener, initErr = auth.Listen(":" + strconv.Itoa(serverPort))
 		serverPort += 1
 	}
 	log.Printf("DataNode port is %d\n", serverPort-1)
+	log.Printf("TLS is %t\n", auth.TLSEnabled())
 	//变更实例对应的端口号
 	dataNodeInstance.ServicePort = uint16(serverPort - 1)
 	defer listener.Close()
+	if nameNodeAddress != "" {
+		registerToNameNode(dataNodeInstance, nameNodeAddress)
+	}
 	//返回正确的端口连接，方便正确启动nameNode节点管理
 	log.Printf("DataNode daemon started on port: " + strconv.Itoa(serverPort-1))
 	//采纳这个连接
-	rpc.Accept(listener)
+	server.Accept(listener)
+}
+
+// registerToNameNode 向nameNode注册当前dataNode，并记录nameNode的地址
+func registerToNameNode(dataNodeInstance *datanode.Service, nameNodeAddress string) {
+	host, port, err := net.SplitHostPort(nameNodeAddress)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	nameNodePort, err := strconv.Atoi(port)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	dataNodeInstance.NameNodeHost = host
+	dataNodeInstance.NameNodePort = uint16(nameNodePort)
+
+	nameNodeInstance, err := auth.Dial(nameNodeAddress)
+	if err != nil {
+		log.Printf("Unable to register to NameNode %s\n", nameNodeAddress)
+		return
+	}
+	defer nameNodeInstance.Close()
+	request := datanode.DataNodeInstance{Host: dataNodeInstance.Host, ServicePort: strconv.Itoa(int(dataNodeInstance.ServicePort)), Rack: dataNodeInstance.Rack}
+	var dataNodeId uint64
+	err = nameNodeInstance.Call("Service.RegisterDataNode", request, &dataNodeId)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	log.Printf("Registered to NameNode %s as DataNode %d\n", nameNodeAddress, dataNodeId)
 }
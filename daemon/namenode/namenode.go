@@ -2,19 +2,25 @@ package namenode
 
 import (
 	"errors"
+	"github.com/liuzongzhou/GoDFS/audit"
+	"github.com/liuzongzhou/GoDFS/auth"
 	"github.com/liuzongzhou/GoDFS/datanode"
 	"github.com/liuzongzhou/GoDFS/namenode"
 	"log"
 	"net"
 	"net/rpc"
+	"os"
+	"os/user"
+	"path/filepath"
 	"strconv"
 	"time"
 )
 
-// removeElementFromSlice 在切片中移除无用信息值,返回切片
-func removeElementFromSlice(elements []string, index int) []string {
-	return append(elements[:index], elements[index+1:]...)
-}
+// imageFileName 元数据目录下命名空间镜像的文件名
+const imageFileName = "fsimage"
+
+// checkpointInterval 命名空间镜像的保存间隔
+const checkpointInterval = time.Second * 10
 
 // discoverDataNodes 发现当前的dataNodes，并维护到listOfDataNodes
 // 两种方式：
@@ -36,7 +42,7 @@ func discoverDataNodes(nameNodeInstance *namenode.Service, listOfDataNodes *[]st
 		//从7000-7050遍历尝试连接发现，并加入listOfDataNodes
 		for serverPort < 7050 {
 			dataNodeUri := host + ":" + strconv.Itoa(serverPort)
-			dataNodeInstance, initErr := rpc.Dial("tcp", dataNodeUri)
+			dataNodeInstance, initErr := auth.Dial(dataNodeUri)
 			if initErr == nil {
 				*listOfDataNodes = append(*listOfDataNodes, dataNodeUri)
 				log.Printf("Discovered DataNode %s\n", dataNodeUri)
@@ -53,6 +59,22 @@ func discoverDataNodes(nameNodeInstance *namenode.Service, listOfDataNodes *[]st
 			}
 			serverPort += 1
 		}
+	} else {
+		//终端提供的dataNodes同样需要ping，让dataNode记录nameNode的地址，用于汇报新写入的副本
+		pingRequest := datanode.NameNodePingRequest{Host: nameNodeInstance.Host, Port: nameNodeInstance.Port}
+		for _, dataNodeUri := range *listOfDataNodes {
+			dataNodeInstance, initErr := auth.Dial(dataNodeUri)
+			if initErr != nil {
+				log.Println(initErr)
+				continue
+			}
+			var pingResponse datanode.NameNodePingResponse
+			pingErr := dataNodeInstance.Call("Service.Ping", pingRequest, &pingResponse)
+			dataNodeInstance.Close()
+			if pingErr != nil {
+				log.Println(pingErr)
+			}
+		}
 	}
 
 	availableNumberOfDataNodes = len(*listOfDataNodes)
@@ -71,18 +93,88 @@ func discoverDataNodes(nameNodeInstance *namenode.Service, listOfDataNodes *[]st
 }
 
 // InitializeNameNodeUtil 初始化nameNode节点进程
-func InitializeNameNodeUtil(primaryPort string, serverHost string, serverPort int, blockSize int, replicationFactor int, listOfDataNodes []string) {
+func InitializeNameNodeUtil(primaryPort string, serverHost string, serverPort int, blockSize int, replicationFactor int, maxReplicationStreams int, topologyFile string, placementPolicy string, minFreeSpace uint64, metadataDir string, safeModeThreshold float64, superUser string, keyFile string, auditLogFile string, auditLogMaxSize int64, auditLogMaxBackups int, trashInterval time.Duration, trashCheckpointInterval time.Duration, listOfDataNodes []string) {
 	// 生成nameNode实例
 	nameNodeInstance := namenode.NewService(primaryPort, serverHost, uint64(blockSize), uint64(replicationFactor), uint16(serverPort))
+	nameNodeInstance.MaxReplicationStreams = maxReplicationStreams
+	nameNodeInstance.MinFreeSpace = minFreeSpace
+	nameNodeInstance.SafeModeThreshold = safeModeThreshold
+	nameNodeInstance.TrashInterval = trashInterval
+	nameNodeInstance.TrashCheckpointInterval = trashCheckpointInterval
+	// 没有指定超级用户时，由启动nameNode的用户担任
+	if superUser == "" {
+		if current, userErr := user.Current(); userErr == nil {
+			superUser = current.Username
+		}
+	}
+	nameNodeInstance.SuperUser = superUser
+	// 监听使用的认证配置
+	server := &auth.Server{}
+	// 指定密钥文件时开启认证，客户端和dataNode的连接都需要携带用该密钥签名的令牌，
+	// 同时用该密钥为块签发访问令牌，客户端凭令牌读写和删除dataNode上的块
+	if keyFile != "" {
+		authenticator, authErr := auth.ServiceAuthenticator(keyFile)
+		if authErr != nil {
+			log.Println(authErr)
+			return
+		}
+		auth.SetAuthenticator(authenticator)
+		server.Authenticator = authenticator
+		// 内部方法和集群管理操作只允许dataNode、备份nameNode和超级用户调用
+		server.Authorizer = nameNodeInstance.Authorize
+		nameNodeInstance.SetBlockKey(authenticator.Key)
+	}
+	// 指定审计日志文件时，每个客户端rpc调用写一行JSON审计记录，与调试日志分开，文件超过大小后轮转
+	if auditLogFile != "" {
+		auditLogger, auditErr := audit.NewLogger(auditLogFile, auditLogMaxSize, auditLogMaxBackups)
+		if auditErr != nil {
+			log.Println(auditErr)
+			return
+		}
+		defer auditLogger.Close()
+		server.Observer = namenode.AuditObserver(auditLogger)
+	}
+	// 选择副本放置策略
+	policy, err := namenode.NewBlockPlacementPolicy(placementPolicy)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	nameNodeInstance.SetPlacementPolicy(policy)
+	// 加载拓扑映射文件，用于机架感知的副本放置
+	if topologyFile != "" {
+		err = nameNodeInstance.LoadTopology(topologyFile)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	}
+	// 加载上次保存的命名空间镜像，有块时进入启动安全模式，等待dataNodes汇报块
+	if metadataDir != "" {
+		imageFile := filepath.Join(metadataDir, imageFileName)
+		err = nameNodeInstance.LoadImage(imageFile)
+		if err != nil && !os.IsNotExist(err) {
+			log.Println(err)
+			return
+		}
+		// 协程：定时保存命名空间镜像
+		go checkpointNameNode(nameNodeInstance, imageFile)
+	} else {
+		log.Println("metadata-dir is empty, the namespace will not be persisted")
+	}
 	// 发现当前存在的dataNodes或者终端给的，并维护到listOfDataNodes
-	err := discoverDataNodes(nameNodeInstance, &listOfDataNodes)
+	err = discoverDataNodes(nameNodeInstance, &listOfDataNodes)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 
 	// 协程：对管理的dataNodes进行心跳检测
-	go heartbeatToDataNodes(listOfDataNodes, nameNodeInstance)
+	go heartbeatToDataNodes(nameNodeInstance)
+	// 协程：后台副本复制监控
+	go nameNodeInstance.ReplicationMonitor()
+	// 协程：定期保存回收站检查点，删除过期的检查点
+	go nameNodeInstance.TrashEmptier()
 	// 向注册中心注册实例
 	err = rpc.Register(nameNodeInstance)
 	if err != nil {
@@ -95,7 +187,7 @@ func InitializeNameNodeUtil(primaryPort string, serverHost string, serverPort in
 	initErr := errors.New("init")
 	//选择空闲的端口号作为连接
 	for initErr != nil {
-		listener, initErr = net.Listen("tcp", ":"+strconv.Itoa(serverPort))
+		listener, initErr = auth.Listen(":" + strconv.Itoa(serverPort))
 		serverPort += 1
 	}
 	//变更实例对应的端口号
@@ -103,18 +195,26 @@ func InitializeNameNodeUtil(primaryPort string, serverHost string, serverPort in
 	defer listener.Close()
 
 	//当端口号不是主节点端口号时，说明是备份nameNode节点，开启协程进行元数据间的备份
-	atoi, _ := strconv.Atoi(nameNodeInstance.PrimaryPort)
-	if nameNodeInstance.Port != uint16(atoi) {
+	if !nameNodeInstance.IsPrimary() {
 		go ReplicationnameNode(nameNodeInstance)
 	}
 
 	log.Printf("BlockSize is %d\n", blockSize)
 	log.Printf("Replication Factor is %d\n", replicationFactor)
+	log.Printf("Max replication streams is %d\n", maxReplicationStreams)
+	log.Printf("Block placement policy is %s\n", placementPolicy)
+	log.Printf("Min free space of DataNode is %d\n", minFreeSpace)
+	log.Printf("Super user is %s\n", superUser)
+	log.Printf("Authentication is %t\n", keyFile != "")
+	log.Printf("TLS is %t\n", auth.TLSEnabled())
+	log.Printf("Audit log is %q\n", auditLogFile)
+	log.Printf("Trash interval is %v\n", trashInterval)
+	log.Printf("Safe mode is %t, threshold is %v\n", nameNodeInstance.InSafeMode(), safeModeThreshold)
 	log.Printf("List of DataNode(s) in service is %q\n", listOfDataNodes)
 	log.Printf("NameNode port is %d\n", serverPort-1)
 	log.Printf("NameNode daemon started on port: " + strconv.Itoa(serverPort-1))
 	//采纳这个连接
-	rpc.Accept(listener)
+	server.Accept(listener)
 
 }
 
@@ -124,11 +224,11 @@ func ReplicationnameNode(nameNode *namenode.Service) {
 	//开启定时任务，每隔1s进行一次元数据同步
 	for range time.Tick(time.Second * 1) {
 		//与主节点建立rpc连接，当连接不上时说明主节点挂了，不需要再同步，直接使用备份节点进行操作，并将备份节点升级成为主节点
-		nameNodeInstance, err := rpc.Dial("tcp", "localhost:"+nameNode.PrimaryPort)
+		nameNodeInstance, err := auth.Dial("localhost:" + nameNode.PrimaryPort)
 		if err != nil {
 			log.Println("primary nameNode is dead,second nameNode become primary nameNode")
-			nameNode.PrimaryPort = strconv.FormatUint(uint64(nameNode.Port), 10)
-			log.Printf("now primary nameNode port is %s\n", nameNode.PrimaryPort)
+			nameNode.BecomePrimary()
+			log.Printf("now primary nameNode port is %d\n", nameNode.Port)
 			return
 		}
 		defer nameNodeInstance.Close()
@@ -141,61 +241,84 @@ func ReplicationnameNode(nameNode *namenode.Service) {
 			continue
 		}
 		//更新备份nameNode的元数据信息
-		nameNode.FileNameToBlocks = reply.FileNameToBlocks
-		nameNode.IdToDataNodes = reply.IdToDataNodes
-		nameNode.BlockToDataNodeIds = reply.BlockToDataNodeIds
-		nameNode.FileNameSize = reply.FileNameSize
-		nameNode.DirectoryToFileName = reply.DirectoryToFileName
+		nameNode.SyncMetaData(&reply)
 	}
 }
 
-// heartbeatToDataNodes nameNode与dataNodes实现心跳检测
-func heartbeatToDataNodes(listOfDataNodes []string, nameNode *namenode.Service) {
+// checkpointNameNode 定时保存命名空间镜像，nameNode重启后从镜像恢复文件和块的元数据
+func checkpointNameNode(nameNode *namenode.Service, imageFile string) {
+	for range time.Tick(checkpointInterval) {
+		err := nameNode.SaveImage(imageFile)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// heartbeatToDataNodes nameNode与dataNodes实现心跳检测，心跳正常的节点再拉取块汇报
+func heartbeatToDataNodes(nameNode *namenode.Service) {
 	// 设置一个5秒的定时任务
 	for range time.Tick(time.Second * 5) {
+		// 当前管理的dataNodes的快照，dataNode可能在遍历过程中注册或者被移除
+		dataNodes := nameNode.DataNodes()
 		// 遍历所有的dataNodes节点
-		for i, hostPort := range listOfDataNodes {
+		for id, dn := range dataNodes {
+			hostPort := dn.Host + ":" + dn.ServicePort
 			//1.与dataNode建立连接，检测连接是否正常
-			nameNodeClient, connectionErr := rpc.Dial("tcp", hostPort)
-
+			nameNodeClient, connectionErr := auth.Dial(hostPort)
 			if connectionErr != nil {
 				log.Printf("Unable to connect to node %s\n", hostPort)
-				var reply bool
-				// 断连了，需要重新分配数据，将断连节点上的数据进行备份
-				// 因为存在备份nameNode节点，防止两个nameNode同时操作数据，导致数据重复，需要鉴权
-				//如果当前nameNode节点不是主节点，则不做任何操作
-				atoi, _ := strconv.Atoi(nameNode.PrimaryPort)
-				if nameNode.Port != uint16(atoi) {
-					listOfDataNodes = removeElementFromSlice(listOfDataNodes, i)
-					continue
-				}
-				reDistributeError := nameNode.ReDistributeData(&namenode.ReDistributeDataRequest{DataNodeUri: hostPort}, &reply)
-				if reDistributeError != nil {
-					log.Println(reDistributeError)
-				}
-				//维护IdToDataNodes元数据信息，删除对应的kye：Id
-				delete(nameNode.IdToDataNodes, uint64(i))
-				//listOfDataNodes 更新数据，移除死亡的节点
-				listOfDataNodes = removeElementFromSlice(listOfDataNodes, i)
+				handleDeadDataNode(nameNode, hostPort)
 				continue
 			}
 			//2.调用datanode Heartbeat方法,返检测调用方法是否正常
-			var response bool
+			var response datanode.DataNodeHeartbeat
 			hbErr := nameNodeClient.Call("Service.Heartbeat", true, &response)
-			if hbErr != nil || !response {
+			if hbErr != nil || !response.Ack {
 				//如果调用rpc方法失败了 说明dataNode节点信息也出现问题
 				log.Printf("No heartbeat received from %s\n", hostPort)
-				var reply bool
-				// 断连了，需要重新分配数据，将断连节点上的数据进行备份
-				reDistributeError := nameNode.ReDistributeData(&namenode.ReDistributeDataRequest{DataNodeUri: hostPort}, &reply)
-				if reDistributeError != nil {
-					log.Println(reDistributeError)
-				}
-				//维护IdToDataNodes元数据信息，删除对应的kye：Id
-				delete(nameNode.IdToDataNodes, uint64(i))
-				//listOfDataNodes 更新数据，移除死亡的节点
-				listOfDataNodes = removeElementFromSlice(listOfDataNodes, i)
+				nameNodeClient.Close()
+				handleDeadDataNode(nameNode, hostPort)
+				continue
+			}
+			//记录心跳汇报的容量和负载，主备nameNode都需要，用于选择写入的节点
+			var reply bool
+			hbErr = nameNode.ProcessHeartbeat(&namenode.HeartbeatRequest{DataNodeId: id, Heartbeat: response}, &reply)
+			if hbErr != nil {
+				log.Println(hbErr)
+			}
+			//3.拉取块汇报，只有主nameNode处理块汇报，防止两个nameNode同时删除副本
+			if !nameNode.IsPrimary() {
+				nameNodeClient.Close()
+				continue
+			}
+			var blocks []datanode.BlockInfo
+			reportErr := nameNodeClient.Call("Service.BlockReport", true, &blocks)
+			nameNodeClient.Close()
+			if reportErr != nil {
+				log.Println(reportErr)
+				continue
+			}
+			reportErr = nameNode.ProcessBlockReport(&namenode.BlockReportRequest{DataNodeId: id, Blocks: blocks}, &reply)
+			if reportErr != nil {
+				log.Println(reportErr)
 			}
 		}
 	}
 }
+
+// handleDeadDataNode 断连了，需要重新分配数据，将断连节点上的数据进行备份
+func handleDeadDataNode(nameNode *namenode.Service, hostPort string) {
+	// 因为存在备份nameNode节点，防止两个nameNode同时操作数据，导致数据重复，需要鉴权
+	//如果当前nameNode节点不是主节点，则不做任何操作，元数据会从主节点同步
+	if !nameNode.IsPrimary() {
+		return
+	}
+	var reply bool
+	//ReDistributeData 内部会维护IdToDataNodes元数据信息，删除dead的节点
+	reDistributeError := nameNode.ReDistributeData(&namenode.ReDistributeDataRequest{DataNodeUri: hostPort}, &reply)
+	if reDistributeError != nil {
+		log.Println(reDistributeError)
+	}
+}
+
@@ -4,17 +4,34 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
+	"github.com/google/uuid"
+	"github.com/liuzongzhou/GoDFS/auth"
 	"io/ioutil"
 	"log"
-	"net/rpc"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
 )
 
+// metaFileSuffix 副本元数据文件的后缀，与块文件存放在同一目录，记录该副本的generation stamp
+const metaFileSuffix = ".meta"
+
+// uuidFileName 数据目录下保存dataNode UUID的文件，重启后保持不变，用于在集群报告中唯一标识dataNode
+const uuidFileName = "datanode.uuid"
+
 type Service struct {
 	DataDirectory string
+	Host          string
 	ServicePort   uint16
 	NameNodeHost  string
 	NameNodePort  uint16
+	Rack          string //机架位置，注册时汇报给nameNode
+	Capacity      uint64 //配置的容量上限，为0时使用数据目录所在磁盘的容量
+	Uuid          string //dataNode的UUID，通过心跳汇报给nameNode
+	transfers     int64  //正在进行的块读写和转发数，通过心跳汇报给nameNode
+	blockKey      []byte //与nameNode共享的密钥，设置后读写和删除块都需要nameNode签发的块访问令牌
 }
 
 type DataNodePutRequest struct {
@@ -22,15 +39,20 @@ type DataNodePutRequest struct {
 	BlockId          string
 	Data             string
 	ReplicationNodes []DataNodeInstance
+	GenerationStamp  uint64
+	BlockToken       string //nameNode签发的块访问令牌，需要允许写入
 }
 
 type DataNodeGetRequest struct {
-	RemoteFilePath string
-	BlockId        string
+	RemoteFilePath  string
+	BlockId         string
+	GenerationStamp uint64 //期望的最小generation stamp，为0时不做校验
+	BlockToken      string //nameNode签发的块访问令牌，需要允许读取
 }
 type DataNodeDeleteRequest struct {
 	RemoteFilepath string
 	BlockId        string
+	BlockToken     string //nameNode签发的块访问令牌，删除块时需要允许删除，删除目录时不使用
 }
 type DataNodeReplyStatus struct {
 	Status bool
@@ -54,9 +76,116 @@ type DataNodeReNameRequest struct {
 	ReNameDestPath string
 }
 
+// DataNodeMoveRequest 把目录或者目录下的块移动到新的路径，BlockIds为空时移动整个目录
+type DataNodeMoveRequest struct {
+	SrcPath  string
+	DestPath string
+	BlockIds []string
+}
+
 type DataNodeInstance struct {
 	Host        string //地址
 	ServicePort string //端口号
+	Rack        string //机架位置，为空时由nameNode的拓扑映射文件决定
+}
+
+// BlockInfo dataNode上存储的一个副本的信息，用于向nameNode进行块汇报
+type BlockInfo struct {
+	RemoteFilePath  string
+	BlockId         string
+	GenerationStamp uint64
+	Size            uint64
+}
+
+type DataNodeGenerationStampRequest struct {
+	RemoteFilePath  string
+	BlockId         string
+	GenerationStamp uint64
+}
+
+// DataNodeReplicateRequest nameNode发给源dataNode的复制命令，源dataNode把块直接传输给目标dataNode
+type DataNodeReplicateRequest struct {
+	RemoteFilePath  string
+	BlockId         string
+	GenerationStamp uint64
+	Targets         []DataNodeInstance
+}
+
+// DataNodeHeartbeat 心跳应答，携带dataNode的容量和负载，nameNode据此选择写入的节点
+type DataNodeHeartbeat struct {
+	Ack             bool
+	Capacity        uint64 //总容量
+	Remaining       uint64 //剩余可用空间
+	ActiveTransfers int64  //正在进行的块读写和转发数
+	Uuid            string
+}
+
+// BlockReceivedRequest dataNode写入副本后向nameNode的增量块汇报
+type BlockReceivedRequest struct {
+	DataNode DataNodeInstance
+	Block    BlockInfo
+}
+
+// SetBlockKey 设置与nameNode共享的密钥，之后读写和删除块都需要校验块访问令牌
+func (dataNode *Service) SetBlockKey(key []byte) {
+	dataNode.blockKey = key
+}
+
+// checkBlockToken 校验块访问令牌是否允许对remoteFilePath目录下的块进行mode操作，没有设置密钥时不校验
+func (dataNode *Service) checkBlockToken(token string, blockId string, remoteFilePath string, mode auth.BlockAccessMode) error {
+	if len(dataNode.blockKey) == 0 {
+		return nil
+	}
+	return auth.VerifyBlockToken(dataNode.blockKey, token, blockId, remoteFilePath, mode)
+}
+
+// issueBlockToken dataNode执行nameNode的复制和重建命令时为自己签发块访问令牌，没有设置密钥时返回空
+func (dataNode *Service) issueBlockToken(blockId string, remoteFilePath string, mode auth.BlockAccessMode) string {
+	if len(dataNode.blockKey) == 0 {
+		return ""
+	}
+	token, err := auth.IssueBlockToken(dataNode.blockKey, []string{blockId}, remoteFilePath, mode, auth.BlockTokenLifetime)
+	if err != nil {
+		log.Println(err)
+	}
+	return token
+}
+
+// serviceOperations 只有nameNode等服务才能调用的rpc方法：管理目录、复制和重建块、心跳和块汇报。
+// 读写和删除单个块的方法由nameNode签发的块访问令牌控制
+var serviceOperations = map[string]bool{
+	"Service.Ping":                  true,
+	"Service.Heartbeat":             true,
+	"Service.BlockReport":           true,
+	"Service.MakeDir":               true,
+	"Service.DeletePath":            true,
+	"Service.ReNameDir":             true,
+	"Service.MovePath":              true,
+	"Service.UpdateGenerationStamp": true,
+	"Service.ReplicateBlock":        true,
+	"Service.ReconstructBlock":      true,
+}
+
+// Authorize 校验已认证的调用者能否调用rpc方法，管理目录和复制块等方法只允许服务身份调用
+func (dataNode *Service) Authorize(serviceMethod string, identity auth.Identity, request any) error {
+	if !serviceOperations[serviceMethod] || identity.IsService() {
+		return nil
+	}
+	return errors.New("权限不足: user=" + identity.Name + ", operation=" + strings.TrimPrefix(serviceMethod, "Service."))
+}
+
+// localPath 把请求中的相对路径拼接到数据目录下，拒绝通过".."等方式超出数据目录的路径；
+// allowRoot为false时也拒绝数据目录本身，避免删除或者移动整个数据目录
+func (dataNode *Service) localPath(remotePath string, allowRoot bool) (string, error) {
+	fullPath := dataNode.DataDirectory + remotePath
+	rel, err := filepath.Rel(filepath.Clean(dataNode.DataDirectory), filepath.Clean(fullPath))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.New("路径超出数据目录: " + remotePath)
+	}
+	if rel == "." && !allowRoot {
+		return "", errors.New("不能操作数据目录本身")
+	}
+	return fullPath, nil
 }
 
 // Ping 维护dataNode的元数据信息：NameNodeHost，NameNodePort，同时测试调用rpc方法消息的应答
@@ -69,16 +198,65 @@ func (dataNode *Service) Ping(request *NameNodePingRequest, reply *NameNodePingR
 	return nil
 }
 
-// Heartbeat 心跳检测，用来检测rpc调用方法成功与否，来判断是否能获取heartbeat
-func (dataNode *Service) Heartbeat(request bool, response *bool) error {
+// Heartbeat 心跳检测，用来检测rpc调用方法成功与否，来判断是否能获取heartbeat，同时汇报容量和负载
+func (dataNode *Service) Heartbeat(request bool, response *DataNodeHeartbeat) error {
 	if request {
 		log.Println("Received heartbeat from NameNode")
-		*response = true
+		capacity, remaining, err := dataNode.storageReport()
+		if err != nil {
+			return err
+		}
+		*response = DataNodeHeartbeat{
+			Ack:             true,
+			Capacity:        capacity,
+			Remaining:       remaining,
+			ActiveTransfers: atomic.LoadInt64(&dataNode.transfers),
+			Uuid:            dataNode.Uuid,
+		}
 		return nil
 	}
 	return errors.New("HeartBeatError")
 }
 
+// LoadUuid 读取数据目录下保存的UUID，第一次启动时生成新的UUID并保存
+func (dataNode *Service) LoadUuid() error {
+	uuidFile := filepath.Join(dataNode.DataDirectory, uuidFileName)
+	data, err := ioutil.ReadFile(uuidFile)
+	if err == nil {
+		dataNode.Uuid = strings.TrimSpace(string(data))
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	dataNode.Uuid = uuid.New().String()
+	return ioutil.WriteFile(uuidFile, []byte(dataNode.Uuid), 0644)
+}
+
+// storageReport 统计容量和剩余空间，配置了容量上限时，剩余空间不超过上限减去已存储的副本大小
+func (dataNode *Service) storageReport() (capacity uint64, remaining uint64, err error) {
+	capacity, remaining, err = diskUsage(dataNode.DataDirectory)
+	if err != nil || dataNode.Capacity == 0 {
+		return
+	}
+	var blocks []BlockInfo
+	err = dataNode.BlockReport(true, &blocks)
+	if err != nil {
+		return
+	}
+	var used uint64
+	for _, block := range blocks {
+		used += block.Size
+	}
+	capacity = dataNode.Capacity
+	if used >= capacity {
+		remaining = 0
+	} else if capacity-used < remaining {
+		remaining = capacity - used
+	}
+	return
+}
+
 //forwardForReplication 同步备份节点
 func (dataNode *Service) forwardForReplication(request *DataNodePutRequest, reply *DataNodeReplyStatus) error {
 	blockId := request.BlockId
@@ -87,11 +265,13 @@ func (dataNode *Service) forwardForReplication(request *DataNodePutRequest, repl
 	if len(blockAddresses) == 0 {
 		return nil
 	}
+	atomic.AddInt64(&dataNode.transfers, 1)
+	defer atomic.AddInt64(&dataNode.transfers, -1)
 	//与主datanode节点写入的方式保持一致
 	startingDataNode := blockAddresses[0]
 	remainingDataNodes := blockAddresses[1:]
 
-	dataNodeInstance, rpcErr := rpc.Dial("tcp", startingDataNode.Host+":"+startingDataNode.ServicePort)
+	dataNodeInstance, rpcErr := auth.Dial(startingDataNode.Host + ":" + startingDataNode.ServicePort)
 	//rpc调用出现问题，直接返回false
 	if rpcErr != nil {
 		log.Println(rpcErr)
@@ -105,6 +285,8 @@ func (dataNode *Service) forwardForReplication(request *DataNodePutRequest, repl
 		BlockId:          blockId,
 		Data:             request.Data,
 		ReplicationNodes: remainingDataNodes,
+		GenerationStamp:  request.GenerationStamp,
+		BlockToken:       request.BlockToken,
 	}
 
 	rpcErr = dataNodeInstance.Call("Service.PutData", payloadRequest, &reply)
@@ -121,8 +303,20 @@ func (dataNode *Service) forwardForReplication(request *DataNodePutRequest, repl
 //文件写入请求：路径，BlockId，实际数据，备份的节点信息
 // reply：是否写入成功
 func (dataNode *Service) PutData(request *DataNodePutRequest, reply *DataNodeReplyStatus) error {
-	//创建对应节点路径下的文件：datanode节点根目录+相对路径+BlockId
-	fileWriteHandler, err := os.Create(dataNode.DataDirectory + request.RemoteFilePath + request.BlockId)
+	if err := dataNode.checkBlockToken(request.BlockToken, request.BlockId, request.RemoteFilePath, auth.BlockAccessWrite); err != nil {
+		*reply = DataNodeReplyStatus{Status: false}
+		return err
+	}
+	//datanode节点根目录+相对路径+BlockId
+	blockPath, err := dataNode.localPath(request.RemoteFilePath+request.BlockId, false)
+	if err != nil {
+		*reply = DataNodeReplyStatus{Status: false}
+		return err
+	}
+	atomic.AddInt64(&dataNode.transfers, 1)
+	defer atomic.AddInt64(&dataNode.transfers, -1)
+	//创建对应节点路径下的文件
+	fileWriteHandler, err := os.Create(blockPath)
 	//创建失败，返回false
 	if err != nil {
 		log.Println(err)
@@ -139,17 +333,106 @@ func (dataNode *Service) PutData(request *DataNodePutRequest, reply *DataNodeRep
 		return err
 	}
 	fileWriter.Flush()
+	//同时写入副本的generation stamp，用于块汇报时识别过期副本
+	err = writeGenerationStamp(blockPath, request.GenerationStamp)
+	if err != nil {
+		log.Println(err)
+		*reply = DataNodeReplyStatus{Status: false}
+		return err
+	}
 	*reply = DataNodeReplyStatus{Status: true}
 	//协程：同步备份节点，异步存储
 	go dataNode.forwardForReplication(request, reply)
+	//协程：向nameNode汇报收到的副本
+	go dataNode.blockReceived(BlockInfo{
+		RemoteFilePath:  request.RemoteFilePath,
+		BlockId:         request.BlockId,
+		GenerationStamp: request.GenerationStamp,
+		Size:            uint64(len(request.Data)),
+	})
+	return nil
+}
+
+// blockReceived 向nameNode汇报新写入的副本，还不知道nameNode地址时不汇报，由块汇报补上
+func (dataNode *Service) blockReceived(block BlockInfo) {
+	if dataNode.NameNodeHost == "" {
+		return
+	}
+	nameNodeInstance, rpcErr := auth.Dial(dataNode.NameNodeHost + ":" + strconv.Itoa(int(dataNode.NameNodePort)))
+	if rpcErr != nil {
+		log.Println(rpcErr)
+		return
+	}
+	defer nameNodeInstance.Close()
+	request := BlockReceivedRequest{
+		DataNode: DataNodeInstance{Host: dataNode.Host, ServicePort: strconv.Itoa(int(dataNode.ServicePort))},
+		Block:    block,
+	}
+	var reply bool
+	rpcErr = nameNodeInstance.Call("Service.BlockReceived", request, &reply)
+	if rpcErr != nil {
+		log.Println(rpcErr)
+	}
+}
+
+// ReplicateBlock 接收nameNode的复制命令，校验本地副本后异步把块直接传输给目标dataNode
+func (dataNode *Service) ReplicateBlock(request *DataNodeReplicateRequest, reply *DataNodeReplyStatus) error {
+	if len(request.Targets) == 0 {
+		*reply = DataNodeReplyStatus{Status: false}
+		return errors.New("没有复制的目标节点")
+	}
+	var data DataNodeData
+	//复制命令来自nameNode，由dataNode自己签发读取本地副本和写入目标节点的令牌
+	getRequest := DataNodeGetRequest{
+		RemoteFilePath:  request.RemoteFilePath,
+		BlockId:         request.BlockId,
+		GenerationStamp: request.GenerationStamp,
+		BlockToken:      dataNode.issueBlockToken(request.BlockId, request.RemoteFilePath, auth.BlockAccessRead),
+	}
+	//本地副本不存在或者已过期，拒绝复制
+	err := dataNode.GetData(&getRequest, &data)
+	if err != nil {
+		*reply = DataNodeReplyStatus{Status: false}
+		return err
+	}
+	putRequest := &DataNodePutRequest{
+		RemoteFilePath:   request.RemoteFilePath,
+		BlockId:          request.BlockId,
+		Data:             data.Data,
+		ReplicationNodes: request.Targets,
+		GenerationStamp:  request.GenerationStamp,
+		BlockToken:       dataNode.issueBlockToken(request.BlockId, request.RemoteFilePath, auth.BlockAccessWrite),
+	}
+	//与备份节点同步的方式一致，目标节点之间依次转发
+	go dataNode.forwardForReplication(putRequest, &DataNodeReplyStatus{})
+	*reply = DataNodeReplyStatus{Status: true}
 	return nil
 }
 
 //GetData 获取blockId对应的数据内容
 //读取请求：FilePath+BlockId
 func (dataNode *Service) GetData(request *DataNodeGetRequest, reply *DataNodeData) error {
+	if err := dataNode.checkBlockToken(request.BlockToken, request.BlockId, request.RemoteFilePath, auth.BlockAccessRead); err != nil {
+		return err
+	}
 	//这边是去datanode底层读取数据，所以光有相对路径不行，还得拼接上根目录DataDirectory
-	dataBytes, err := ioutil.ReadFile(dataNode.DataDirectory + request.RemoteFilePath + request.BlockId)
+	blockPath, err := dataNode.localPath(request.RemoteFilePath+request.BlockId, false)
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&dataNode.transfers, 1)
+	defer atomic.AddInt64(&dataNode.transfers, -1)
+	//副本的generation stamp落后于nameNode记录的值，说明是过期副本，拒绝读取，让上层尝试其他节点
+	if request.GenerationStamp > 0 {
+		stamp, err := readGenerationStamp(blockPath)
+		if err != nil {
+			return err
+		}
+		if stamp < request.GenerationStamp {
+			return errors.New("副本版本已过期")
+		}
+	}
+	dataBytes, err := ioutil.ReadFile(blockPath)
 	//读取失败，返回err，返回上层，尝试其他节点
 	if err != nil {
 		return err
@@ -160,15 +443,19 @@ func (dataNode *Service) GetData(request *DataNodeGetRequest, reply *DataNodeDat
 
 //MakeDir 创建datanode节点文件目录
 func (dataNode *Service) MakeDir(request string, reply *DataNodeReplyStatus) error {
-	//datanode节点的根目录
-	directory := dataNode.DataDirectory
+	//datanode节点的根目录+相对路径
+	directory, err := dataNode.localPath(request, true)
+	if err != nil {
+		*reply = DataNodeReplyStatus{Status: false}
+		return err
+	}
 	//判断当前目录是否存在，存在说明创建过了，直接返回nil
-	if _, err2 := os.Stat(directory + request); err2 == nil {
+	if _, err2 := os.Stat(directory); err2 == nil {
 		*reply = DataNodeReplyStatus{Status: true}
 		return nil
 	}
 	//当前目录不存在，开始创建
-	err := os.MkdirAll(directory+request, os.ModePerm)
+	err = os.MkdirAll(directory, os.ModePerm)
 	if err == nil {
 		*reply = DataNodeReplyStatus{Status: true}
 		fmt.Println("创建目录成功") //可以创建成功
@@ -180,16 +467,20 @@ func (dataNode *Service) MakeDir(request string, reply *DataNodeReplyStatus) err
 // DeletePath 删除远端文件目录
 // 输入：相对路径 返回：执行成功与否
 func (dataNode *Service) DeletePath(request *DataNodeDeleteRequest, reply *DataNodeReplyStatus) error {
-	//每个datanode的根目录，根目录+相对路径才是完整路径
-	directory := dataNode.DataDirectory
+	//每个datanode的根目录，根目录+相对路径才是完整路径，不能删除根目录本身
+	directory, err := dataNode.localPath(request.RemoteFilepath, false)
+	if err != nil {
+		*reply = DataNodeReplyStatus{Status: false}
+		return err
+	}
 	//判断当前目录是否存在，不存在说明已经成功删除了，直接返回nil
-	_, err := os.Stat(directory + request.RemoteFilepath)
+	_, err = os.Stat(directory)
 	if os.IsNotExist(err) {
 		*reply = DataNodeReplyStatus{Status: true}
 		return nil
 	}
 	//当前目录存在，则删除
-	err2 := os.RemoveAll(directory + request.RemoteFilepath)
+	err2 := os.RemoveAll(directory)
 	if err2 == nil {
 		*reply = DataNodeReplyStatus{Status: true}
 		fmt.Println("删除目录成功") //可以删除成功
@@ -203,16 +494,25 @@ func (dataNode *Service) DeletePath(request *DataNodeDeleteRequest, reply *DataN
 //DeleteFile 删除远端文件
 //输入：相对路径+BlockId 返回：执行成功与否
 func (dataNode *Service) DeleteFile(request *DataNodeDeleteRequest, reply *DataNodeReplyStatus) error {
-	directory := dataNode.DataDirectory
+	if err := dataNode.checkBlockToken(request.BlockToken, request.BlockId, request.RemoteFilepath, auth.BlockAccessDelete); err != nil {
+		*reply = DataNodeReplyStatus{Status: false}
+		return err
+	}
+	blockPath, err := dataNode.localPath(request.RemoteFilepath+request.BlockId, false)
+	if err != nil {
+		*reply = DataNodeReplyStatus{Status: false}
+		return err
+	}
 	//判断当前文件是否存在，不存在说明已经删除了，直接返回nil
-	_, err := os.Stat(directory + request.RemoteFilepath + request.BlockId)
+	_, err = os.Stat(blockPath)
 	if os.IsNotExist(err) {
 		*reply = DataNodeReplyStatus{Status: true}
 		return nil
 	}
-	//当前文件存在，则删除
-	err2 := os.Remove(directory + request.RemoteFilepath + request.BlockId)
+	//当前文件存在，则删除，副本的元数据文件一并删除
+	err2 := os.Remove(blockPath)
 	if err2 == nil {
+		_ = os.Remove(blockPath + metaFileSuffix)
 		*reply = DataNodeReplyStatus{Status: true}
 		fmt.Println("删除文件成功") //可以删除成功
 		return nil
@@ -222,9 +522,18 @@ func (dataNode *Service) DeleteFile(request *DataNodeDeleteRequest, reply *DataN
 
 // ReNameDir 重命名文件目录
 func (dataNode *Service) ReNameDir(request *DataNodeReNameRequest, reply *DataNodeReplyStatus) error {
-	directory := dataNode.DataDirectory
+	srcPath, err := dataNode.localPath(request.ReNameSrcPath, false)
+	if err != nil {
+		*reply = DataNodeReplyStatus{Status: false}
+		return err
+	}
+	destPath, err := dataNode.localPath(request.ReNameDestPath, false)
+	if err != nil {
+		*reply = DataNodeReplyStatus{Status: false}
+		return err
+	}
 	// 调用os的ReName完成目录的重命名
-	err := os.Rename(directory+request.ReNameSrcPath, directory+request.ReNameDestPath)
+	err = os.Rename(srcPath, destPath)
 	if err == nil {
 		*reply = DataNodeReplyStatus{Status: true}
 		fmt.Println("重命名成功") //可以创建成功
@@ -232,3 +541,143 @@ func (dataNode *Service) ReNameDir(request *DataNodeReNameRequest, reply *DataNo
 	}
 	return errors.New("重命名失败")
 }
+
+// MovePath 把目录或者目录下的块及其元数据文件移动到新的路径，目标路径的上级目录不存在时一起创建。
+// 源路径不存在说明这个dataNode上没有需要移动的块，只创建目标目录
+func (dataNode *Service) MovePath(request *DataNodeMoveRequest, reply *DataNodeReplyStatus) error {
+	*reply = DataNodeReplyStatus{Status: false}
+	// 移动整个目录时不能是数据目录本身，移动块时目录可以是数据目录
+	allowRoot := len(request.BlockIds) > 0
+	srcPath, err := dataNode.localPath(request.SrcPath, allowRoot)
+	if err != nil {
+		return err
+	}
+	destPath, err := dataNode.localPath(request.DestPath, allowRoot)
+	if err != nil {
+		return err
+	}
+	if len(request.BlockIds) == 0 {
+		if _, err := os.Stat(srcPath); os.IsNotExist(err) {
+			if err = os.MkdirAll(destPath, os.ModePerm); err != nil {
+				return err
+			}
+			*reply = DataNodeReplyStatus{Status: true}
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(filepath.Clean(destPath)), os.ModePerm); err != nil {
+			return err
+		}
+		if err := os.Rename(srcPath, destPath); err != nil {
+			return err
+		}
+		*reply = DataNodeReplyStatus{Status: true}
+		return nil
+	}
+	if err := os.MkdirAll(destPath, os.ModePerm); err != nil {
+		return err
+	}
+	for _, blockId := range request.BlockIds {
+		srcBlockPath, err := dataNode.localPath(request.SrcPath+blockId, false)
+		if err != nil {
+			return err
+		}
+		destBlockPath, err := dataNode.localPath(request.DestPath+blockId, false)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(srcBlockPath); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.Rename(srcBlockPath, destBlockPath); err != nil {
+			return err
+		}
+		_ = os.Rename(srcBlockPath+metaFileSuffix, destBlockPath+metaFileSuffix)
+	}
+	*reply = DataNodeReplyStatus{Status: true}
+	return nil
+}
+
+// BlockReport 块汇报，遍历根目录下所有副本，返回副本所在路径、BlockId、generation stamp和大小
+func (dataNode *Service) BlockReport(request bool, reply *[]BlockInfo) error {
+	if !request {
+		return errors.New("BlockReportError")
+	}
+	root := filepath.Clean(dataNode.DataDirectory)
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		//只有带元数据文件的才是副本
+		if info.IsDir() || !strings.HasSuffix(path, metaFileSuffix) {
+			return nil
+		}
+		blockPath := strings.TrimSuffix(path, metaFileSuffix)
+		blockInfo, statErr := os.Stat(blockPath)
+		if statErr != nil {
+			return nil
+		}
+		stamp, stampErr := readGenerationStamp(blockPath)
+		if stampErr != nil {
+			log.Println(stampErr)
+			return nil
+		}
+		//还原出相对路径，与写入时的RemoteFilePath保持一致
+		remoteFilePath, relErr := filepath.Rel(root, filepath.Dir(blockPath))
+		if relErr != nil {
+			return relErr
+		}
+		if remoteFilePath == "." {
+			remoteFilePath = ""
+		} else {
+			remoteFilePath = filepath.ToSlash(remoteFilePath) + "/"
+		}
+		*reply = append(*reply, BlockInfo{
+			RemoteFilePath:  remoteFilePath,
+			BlockId:         filepath.Base(blockPath),
+			GenerationStamp: stamp,
+			Size:            uint64(blockInfo.Size()),
+		})
+		return nil
+	})
+}
+
+// UpdateGenerationStamp 更新副本的generation stamp，副本恢复时由nameNode调用，使其余未更新的副本成为过期副本
+func (dataNode *Service) UpdateGenerationStamp(request *DataNodeGenerationStampRequest, reply *DataNodeReplyStatus) error {
+	blockPath, err := dataNode.localPath(request.RemoteFilePath+request.BlockId, false)
+	if err != nil {
+		*reply = DataNodeReplyStatus{Status: false}
+		return err
+	}
+	//副本不存在，无法更新
+	if _, err := os.Stat(blockPath); err != nil {
+		*reply = DataNodeReplyStatus{Status: false}
+		return err
+	}
+	stamp, err := readGenerationStamp(blockPath)
+	//generation stamp只增不减
+	if err == nil && stamp >= request.GenerationStamp {
+		*reply = DataNodeReplyStatus{Status: true}
+		return nil
+	}
+	err = writeGenerationStamp(blockPath, request.GenerationStamp)
+	if err != nil {
+		*reply = DataNodeReplyStatus{Status: false}
+		return err
+	}
+	*reply = DataNodeReplyStatus{Status: true}
+	return nil
+}
+
+// writeGenerationStamp 将generation stamp写入块文件对应的元数据文件
+func writeGenerationStamp(blockPath string, stamp uint64) error {
+	return ioutil.WriteFile(blockPath+metaFileSuffix, []byte(strconv.FormatUint(stamp, 10)), 0666)
+}
+
+// readGenerationStamp 读取块文件对应的元数据文件中的generation stamp
+func readGenerationStamp(blockPath string) (uint64, error) {
+	data, err := ioutil.ReadFile(blockPath + metaFileSuffix)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
@@ -1,8 +1,11 @@
 package datanode
 
 import (
+	"github.com/liuzongzhou/GoDFS/auth"
+	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 // TestDataNodeServiceCreation 测试能否创建DataNode Service服务
@@ -100,3 +103,230 @@ func TestDataNodeServiceReNameDir(t *testing.T) {
 		t.Error("Unable to rename directory or filename")
 	}
 }
+
+// TestDataNodeServiceMovePath 测试把文件的块和整个目录移动到不存在的目录下
+func TestDataNodeServiceMovePath(t *testing.T) {
+	testDataNodeService := new(Service)
+	testDataNodeService.DataDirectory = t.TempDir() + "/"
+	testDataNodeService.ServicePort = 8000
+	var status DataNodeReplyStatus
+	testDataNodeService.MakeDir("Test/", &status)
+	testDataNodeService.PutData(&DataNodePutRequest{RemoteFilePath: "Test/", BlockId: "1", Data: "Hello world", GenerationStamp: 1}, &status)
+	testDataNodeService.PutData(&DataNodePutRequest{RemoteFilePath: "Test/", BlockId: "2", Data: "Hello", GenerationStamp: 1}, &status)
+
+	err := testDataNodeService.MovePath(&DataNodeMoveRequest{SrcPath: "Test/", DestPath: "Trash/Current/Test/", BlockIds: []string{"1", "3"}}, &status)
+	if err != nil || !status.Status {
+		t.Fatalf("Unable to move blocks: %v", err)
+	}
+	var data DataNodeData
+	if err = testDataNodeService.GetData(&DataNodeGetRequest{RemoteFilePath: "Trash/Current/Test/", BlockId: "1", GenerationStamp: 1}, &data); err != nil || data.Data != "Hello world" {
+		t.Errorf("Unable to read moved block: %v", err)
+	}
+	err = testDataNodeService.MovePath(&DataNodeMoveRequest{SrcPath: "Test/", DestPath: "Trash/Current/Test2/"}, &status)
+	if err != nil || !status.Status {
+		t.Fatalf("Unable to move directory: %v", err)
+	}
+	if err = testDataNodeService.GetData(&DataNodeGetRequest{RemoteFilePath: "Trash/Current/Test2/", BlockId: "2", GenerationStamp: 1}, &data); err != nil || data.Data != "Hello" {
+		t.Errorf("Unable to read block in moved directory: %v", err)
+	}
+	// 源目录不存在时只创建目标目录
+	err = testDataNodeService.MovePath(&DataNodeMoveRequest{SrcPath: "Missing/", DestPath: "Trash/Current/Missing/"}, &status)
+	if err != nil || !status.Status {
+		t.Errorf("Missing directory should be skipped: %v", err)
+	}
+}
+
+// TestDataNodeServiceBlockReport 测试块汇报以及generation stamp的校验
+func TestDataNodeServiceBlockReport(t *testing.T) {
+	testDataNodeService := new(Service)
+	testDataNodeService.DataDirectory = t.TempDir() + "/"
+	testDataNodeService.ServicePort = 8000
+	var status DataNodeReplyStatus
+	testDataNodeService.MakeDir("Test/", &status)
+	putRequest := DataNodePutRequest{RemoteFilePath: "Test/", BlockId: "1", Data: "Hello world", GenerationStamp: 3}
+	testDataNodeService.PutData(&putRequest, &status)
+
+	var report []BlockInfo
+	err := testDataNodeService.BlockReport(true, &report)
+	if err != nil || len(report) != 1 {
+		t.Fatalf("Unable to report blocks: %v", err)
+	}
+	if report[0].RemoteFilePath != "Test/" || report[0].BlockId != "1" || report[0].GenerationStamp != 3 || report[0].Size != 11 {
+		t.Errorf("Unable to report block info correctly: %+v", report[0])
+	}
+
+	var data DataNodeData
+	err = testDataNodeService.GetData(&DataNodeGetRequest{RemoteFilePath: "Test/", BlockId: "1", GenerationStamp: 4}, &data)
+	if err == nil {
+		t.Error("Stale replica should not be read")
+	}
+	stampRequest := DataNodeGenerationStampRequest{RemoteFilePath: "Test/", BlockId: "1", GenerationStamp: 4}
+	testDataNodeService.UpdateGenerationStamp(&stampRequest, &status)
+	err = testDataNodeService.GetData(&DataNodeGetRequest{RemoteFilePath: "Test/", BlockId: "1", GenerationStamp: 4}, &data)
+	if err != nil || data.Data != "Hello world" {
+		t.Error("Unable to read data after updating generation stamp")
+	}
+}
+
+// TestDataNodeServiceReplicateBlock 测试本地副本过期时拒绝复制命令
+func TestDataNodeServiceReplicateBlock(t *testing.T) {
+	testDataNodeService := new(Service)
+	testDataNodeService.DataDirectory = t.TempDir() + "/"
+	testDataNodeService.ServicePort = 8000
+	var status DataNodeReplyStatus
+	testDataNodeService.MakeDir("Test/", &status)
+	putRequest := DataNodePutRequest{RemoteFilePath: "Test/", BlockId: "1", Data: "Hello world", GenerationStamp: 3}
+	testDataNodeService.PutData(&putRequest, &status)
+
+	targets := []DataNodeInstance{{Host: "localhost", ServicePort: "4321"}}
+	request := DataNodeReplicateRequest{RemoteFilePath: "Test/", BlockId: "1", GenerationStamp: 4, Targets: targets}
+	err := testDataNodeService.ReplicateBlock(&request, &status)
+	if err == nil || status.Status {
+		t.Error("Stale replica should not be replicated")
+	}
+	request.GenerationStamp = 3
+	err = testDataNodeService.ReplicateBlock(&request, &status)
+	if err != nil || !status.Status {
+		t.Error("Unable to accept replicate command")
+	}
+}
+
+// TestDataNodeServiceBlockToken 测试设置密钥后读写和删除块都需要允许对应操作、并且绑定块所在目录的块访问令牌
+func TestDataNodeServiceBlockToken(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	testDataNodeService := new(Service)
+	testDataNodeService.DataDirectory = t.TempDir() + "/"
+	testDataNodeService.SetBlockKey(key)
+	var status DataNodeReplyStatus
+	testDataNodeService.MakeDir("Test/", &status)
+	putRequest := DataNodePutRequest{RemoteFilePath: "Test/", BlockId: "1", Data: "Hello world", GenerationStamp: 1}
+	if err := testDataNodeService.PutData(&putRequest, &status); err == nil || status.Status {
+		t.Error("Block should not be written without block token")
+	}
+	putRequest.BlockToken, _ = auth.IssueBlockToken(key, []string{"1"}, "Test/", auth.BlockAccessWrite, time.Minute)
+	if err := testDataNodeService.PutData(&putRequest, &status); err != nil || !status.Status {
+		t.Fatalf("Unable to write block with block token: %v", err)
+	}
+
+	var data DataNodeData
+	getRequest := DataNodeGetRequest{RemoteFilePath: "Test/", BlockId: "1", BlockToken: putRequest.BlockToken}
+	if testDataNodeService.GetData(&getRequest, &data) == nil {
+		t.Error("Write token should not allow reading")
+	}
+	getRequest.BlockToken, _ = auth.IssueBlockToken(key, []string{"1"}, "Test/", auth.BlockAccessRead, time.Minute)
+	if err := testDataNodeService.GetData(&getRequest, &data); err != nil || data.Data != "Hello world" {
+		t.Errorf("Unable to read block with block token: %v", err)
+	}
+	otherRequest := DataNodeGetRequest{RemoteFilePath: "Other/", BlockId: "1", BlockToken: getRequest.BlockToken}
+	if testDataNodeService.GetData(&otherRequest, &data) == nil {
+		t.Error("Block token should not allow reading blocks in other directories")
+	}
+
+	deleteRequest := DataNodeDeleteRequest{RemoteFilepath: "Test/", BlockId: "1", BlockToken: getRequest.BlockToken}
+	if testDataNodeService.DeleteFile(&deleteRequest, &status) == nil {
+		t.Error("Read token should not allow deleting")
+	}
+	deleteRequest.BlockToken, _ = auth.IssueBlockToken(key, []string{"1"}, "Test/", auth.BlockAccessDelete, time.Minute)
+	if err := testDataNodeService.DeleteFile(&deleteRequest, &status); err != nil || !status.Status {
+		t.Errorf("Unable to delete block with block token: %v", err)
+	}
+
+	// 执行复制命令时由dataNode为自己签发令牌读取本地副本
+	testDataNodeService.PutData(&putRequest, &status)
+	request := DataNodeReplicateRequest{RemoteFilePath: "Test/", BlockId: "1", GenerationStamp: 1, Targets: []DataNodeInstance{{Host: "localhost", ServicePort: "4321"}}}
+	if err := testDataNodeService.ReplicateBlock(&request, &status); err != nil || !status.Status {
+		t.Errorf("Unable to accept replicate command with block key: %v", err)
+	}
+}
+
+// TestDataNodeServiceLocalPath 测试超出数据目录的路径和数据目录本身被拒绝
+func TestDataNodeServiceLocalPath(t *testing.T) {
+	testDataNodeService := new(Service)
+	testDataNodeService.DataDirectory = t.TempDir() + "/data/"
+	var status DataNodeReplyStatus
+	if err := testDataNodeService.MakeDir("Test/", &status); err != nil {
+		t.Fatal(err)
+	}
+	for _, remoteFilepath := range []string{"", "/", "./", "../", "Test/../"} {
+		if testDataNodeService.DeletePath(&DataNodeDeleteRequest{RemoteFilepath: remoteFilepath}, &status) == nil {
+			t.Errorf("Deleting %q should be rejected", remoteFilepath)
+		}
+	}
+	if _, err := os.Stat(testDataNodeService.DataDirectory + "Test/"); err != nil {
+		t.Errorf("Data directory should not be deleted: %v", err)
+	}
+	if testDataNodeService.MakeDir("../escaped/", &status) == nil {
+		t.Error("Creating a directory outside the data directory should be rejected")
+	}
+	putRequest := DataNodePutRequest{RemoteFilePath: "../", BlockId: "1", Data: "Hello world", GenerationStamp: 1}
+	if testDataNodeService.PutData(&putRequest, &status) == nil {
+		t.Error("Writing a block outside the data directory should be rejected")
+	}
+	if testDataNodeService.MovePath(&DataNodeMoveRequest{SrcPath: "Test/", DestPath: "../Test/"}, &status) == nil {
+		t.Error("Moving a directory outside the data directory should be rejected")
+	}
+	if err := testDataNodeService.DeletePath(&DataNodeDeleteRequest{RemoteFilepath: "Test/"}, &status); err != nil {
+		t.Errorf("Unable to delete directory in the data directory: %v", err)
+	}
+}
+
+// TestDataNodeServiceAuthorize 测试管理目录和复制块的方法只允许服务身份调用，读写块的方法由块访问令牌控制
+func TestDataNodeServiceAuthorize(t *testing.T) {
+	testDataNodeService := new(Service)
+	alice := auth.Identity{Name: "alice"}
+	for _, serviceMethod := range []string{"Service.DeletePath", "Service.MakeDir", "Service.MovePath", "Service.ReNameDir", "Service.UpdateGenerationStamp", "Service.ReplicateBlock"} {
+		if testDataNodeService.Authorize(serviceMethod, alice, nil) == nil {
+			t.Errorf("%s should be restricted to services", serviceMethod)
+		}
+		if err := testDataNodeService.Authorize(serviceMethod, auth.ServiceIdentity(), nil); err != nil {
+			t.Errorf("%s should be allowed for services: %v", serviceMethod, err)
+		}
+	}
+	if err := testDataNodeService.Authorize("Service.GetData", alice, nil); err != nil {
+		t.Errorf("Reading blocks should be controlled by block tokens: %v", err)
+	}
+}
+
+// TestDataNodeServiceHeartbeat 测试心跳汇报容量，配置了容量上限时剩余空间扣除已存储的副本
+func TestDataNodeServiceHeartbeat(t *testing.T) {
+	testDataNodeService := new(Service)
+	testDataNodeService.DataDirectory = t.TempDir() + "/"
+	var heartbeat DataNodeHeartbeat
+	err := testDataNodeService.Heartbeat(true, &heartbeat)
+	if err != nil || !heartbeat.Ack || heartbeat.Capacity == 0 || heartbeat.Remaining > heartbeat.Capacity {
+		t.Fatalf("Unable to report disk capacity: %+v %v", heartbeat, err)
+	}
+
+	testDataNodeService.Capacity = 16
+	var status DataNodeReplyStatus
+	testDataNodeService.MakeDir("Test/", &status)
+	putRequest := DataNodePutRequest{RemoteFilePath: "Test/", BlockId: "1", Data: "Hello world", GenerationStamp: 1}
+	testDataNodeService.PutData(&putRequest, &status)
+	err = testDataNodeService.Heartbeat(true, &heartbeat)
+	if err != nil || heartbeat.Capacity != 16 || heartbeat.Remaining != 5 || heartbeat.ActiveTransfers != 0 {
+		t.Errorf("Unable to report configured capacity: %+v %v", heartbeat, err)
+	}
+}
+
+// TestDataNodeServiceLoadUuid 测试第一次启动时生成UUID，重启后读取到相同的UUID，并通过心跳汇报
+func TestDataNodeServiceLoadUuid(t *testing.T) {
+	dataDirectory := t.TempDir() + "/"
+	testDataNodeService := new(Service)
+	testDataNodeService.DataDirectory = dataDirectory
+	if err := testDataNodeService.LoadUuid(); err != nil || testDataNodeService.Uuid == "" {
+		t.Fatalf("Unable to generate uuid: %v", err)
+	}
+	restarted := new(Service)
+	restarted.DataDirectory = dataDirectory
+	if err := restarted.LoadUuid(); err != nil || restarted.Uuid != testDataNodeService.Uuid {
+		t.Errorf("Unable to load uuid after restart: %v", err)
+	}
+	var heartbeat DataNodeHeartbeat
+	if err := restarted.Heartbeat(true, &heartbeat); err != nil || heartbeat.Uuid != restarted.Uuid {
+		t.Errorf("Unable to report uuid in heartbeat: %v", err)
+	}
+	var blocks []BlockInfo
+	if err := restarted.BlockReport(true, &blocks); err != nil || len(blocks) != 0 {
+		t.Errorf("Uuid file should not be reported as a block: %v", blocks)
+	}
+}
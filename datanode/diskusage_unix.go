@@ -0,0 +1,17 @@
+//go:build !windows
+
+package datanode
+
+import "syscall"
+
+// diskUsage 获取目录所在磁盘的总容量和非特权用户可用的剩余空间
+func diskUsage(path string) (total uint64, free uint64, err error) {
+	var stat syscall.Statfs_t
+	err = syscall.Statfs(path, &stat)
+	if err != nil {
+		return
+	}
+	total = uint64(stat.Blocks) * uint64(stat.Bsize)
+	free = uint64(stat.Bavail) * uint64(stat.Bsize)
+	return
+}
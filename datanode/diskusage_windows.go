@@ -0,0 +1,27 @@
+//go:build windows
+
+package datanode
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// diskUsage 获取目录所在磁盘的总容量和当前用户可用的剩余空间
+func diskUsage(path string) (total uint64, free uint64, err error) {
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return
+	}
+	getDiskFreeSpaceEx := syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+	ret, _, callErr := getDiskFreeSpaceEx.Call(
+		uintptr(unsafe.Pointer(pathPtr)),
+		uintptr(unsafe.Pointer(&free)),
+		uintptr(unsafe.Pointer(&total)),
+		0,
+	)
+	if ret == 0 {
+		err = callErr
+	}
+	return
+}
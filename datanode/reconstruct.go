@@ -0,0 +1,97 @@
+package datanode
+
+import (
+	"errors"
+	"github.com/liuzongzhou/GoDFS/auth"
+	"github.com/liuzongzhou/GoDFS/erasure"
+	"log"
+)
+
+// ReconstructSource 重建时可以读取的其他内部块
+type ReconstructSource struct {
+	BlockId         string
+	Index           int //内部块在块组中的分片下标
+	GenerationStamp uint64
+	DataNode        DataNodeInstance
+}
+
+// DataNodeReconstructRequest nameNode发给目标dataNode的纠删码重建命令
+type DataNodeReconstructRequest struct {
+	RemoteFilePath  string
+	BlockId         string //要重建的内部块
+	Index           int    //要重建的内部块在块组中的分片下标
+	GenerationStamp uint64
+	DataUnits       int
+	ParityUnits     int
+	Sources         []ReconstructSource
+}
+
+// ReconstructBlock 接收nameNode的重建命令，异步从其他dataNode读取DataUnits个内部块，解码出丢失的内部块后写入本地
+func (dataNode *Service) ReconstructBlock(request *DataNodeReconstructRequest, reply *DataNodeReplyStatus) error {
+	if len(request.Sources) < request.DataUnits {
+		*reply = DataNodeReplyStatus{Status: false}
+		return errors.New("可用的内部块不足，无法重建")
+	}
+	encoder, err := erasure.New(request.DataUnits, request.ParityUnits)
+	if err != nil {
+		*reply = DataNodeReplyStatus{Status: false}
+		return err
+	}
+	go dataNode.reconstruct(encoder, request)
+	*reply = DataNodeReplyStatus{Status: true}
+	return nil
+}
+
+// reconstruct 读取足够的内部块解码，写入重建的内部块，写入后通过blockReceived汇报给nameNode
+func (dataNode *Service) reconstruct(encoder *erasure.Encoder, request *DataNodeReconstructRequest) {
+	shards := make([][]byte, request.DataUnits+request.ParityUnits)
+	fetched := 0
+	for _, source := range request.Sources {
+		if fetched == request.DataUnits {
+			break
+		}
+		data, err := fetchBlock(request.RemoteFilePath, source, dataNode.issueBlockToken(source.BlockId, request.RemoteFilePath, auth.BlockAccessRead))
+		if err != nil {
+			log.Printf("Unable to read block %s from %s:%s: %v\n", source.BlockId, source.DataNode.Host, source.DataNode.ServicePort, err)
+			continue
+		}
+		shards[source.Index] = []byte(data)
+		fetched++
+	}
+	if fetched < request.DataUnits {
+		log.Printf("Unable to reconstruct block %s, only %d internal blocks available\n", request.BlockId, fetched)
+		return
+	}
+	err := encoder.Reconstruct(shards)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	putRequest := DataNodePutRequest{
+		RemoteFilePath:  request.RemoteFilePath,
+		BlockId:         request.BlockId,
+		Data:            string(shards[request.Index]),
+		GenerationStamp: request.GenerationStamp,
+		BlockToken:      dataNode.issueBlockToken(request.BlockId, request.RemoteFilePath, auth.BlockAccessWrite),
+	}
+	var reply DataNodeReplyStatus
+	err = dataNode.PutData(&putRequest, &reply)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	log.Printf("Reconstructed block %s\n", request.BlockId)
+}
+
+// fetchBlock 从其他dataNode读取同一文件目录下的内部块
+func fetchBlock(remoteFilePath string, source ReconstructSource, blockToken string) (string, error) {
+	dataNodeInstance, err := auth.Dial(source.DataNode.Host + ":" + source.DataNode.ServicePort)
+	if err != nil {
+		return "", err
+	}
+	defer dataNodeInstance.Close()
+	request := DataNodeGetRequest{RemoteFilePath: remoteFilePath, BlockId: source.BlockId, GenerationStamp: source.GenerationStamp, BlockToken: blockToken}
+	var reply DataNodeData
+	err = dataNodeInstance.Call("Service.GetData", request, &reply)
+	return reply.Data, err
+}
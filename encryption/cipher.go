@@ -0,0 +1,243 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	// CipherAESCTR AES-CTR流加密，密文与明文等长，不校验完整性
+	CipherAESCTR = "AES/CTR/NoPadding"
+	// CipherAESGCM AES-GCM分段加密，每段带有校验标签，可以发现密文被篡改、截断或者调换顺序
+	CipherAESGCM = "AES/GCM/NoPadding"
+)
+
+const (
+	// ivLength 文件初始向量的字节数，AES-CTR使用全部16字节，AES-GCM使用前12字节作为nonce的基础
+	ivLength = aes.BlockSize
+	// gcmChunkSize AES-GCM每段明文的字节数，最后一段可以不满
+	gcmChunkSize = 64 * 1024
+	// gcmTagSize AES-GCM每段密文附加的校验标签的字节数
+	gcmTagSize = 16
+)
+
+// ValidCipher 判断是否为支持的加密算法
+func ValidCipher(name string) bool {
+	return name == CipherAESCTR || name == CipherAESGCM
+}
+
+// NewDataKey 为文件生成随机的数据密钥和初始向量
+func NewDataKey() (dataKey []byte, iv []byte, err error) {
+	dataKey = make([]byte, KeyLength)
+	iv = make([]byte, ivLength)
+	if _, err = rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+	if _, err = rand.Read(iv); err != nil {
+		return nil, nil, err
+	}
+	return dataKey, iv, nil
+}
+
+// WrapKey 用加密区密钥加密数据密钥，格式为nonce+AES-GCM密文
+func WrapKey(zoneKey []byte, dataKey []byte) ([]byte, error) {
+	aead, err := newGCM(zoneKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, nil), nil
+}
+
+// UnwrapKey 用加密区密钥解密数据密钥，密钥不对或者被篡改时返回错误
+func UnwrapKey(zoneKey []byte, wrapped []byte) ([]byte, error) {
+	aead, err := newGCM(zoneKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("加密的数据密钥格式错误")
+	}
+	dataKey, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("无法解密数据密钥，加密区密钥不正确")
+	}
+	return dataKey, nil
+}
+
+// EncryptedSize 明文加密后的字节数，nameNode按密文大小分配块
+func EncryptedSize(cipherName string, size uint64) uint64 {
+	if cipherName != CipherAESGCM {
+		return size
+	}
+	chunks := size / gcmChunkSize
+	if size%gcmChunkSize != 0 || size == 0 {
+		chunks++
+	}
+	return size + chunks*gcmTagSize
+}
+
+// NewEncryptReader 读取reader中的明文，返回读出密文的reader
+func NewEncryptReader(cipherName string, dataKey []byte, iv []byte, reader io.Reader) (io.Reader, error) {
+	if cipherName == CipherAESCTR {
+		stream, err := newCTR(dataKey, iv)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.StreamReader{S: stream, R: reader}, nil
+	}
+	// AES-GCM需要知道哪一段是最后一段，通过管道把分段加密的写入转换为读取
+	pipeReader, pipeWriter := io.Pipe()
+	writer, err := newGCMWriter(cipherName, dataKey, iv, pipeWriter, true)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		_, err := io.Copy(writer, reader)
+		if err == nil {
+			err = writer.Close()
+		}
+		pipeWriter.CloseWithError(err)
+	}()
+	return pipeReader, nil
+}
+
+// NewDecryptWriter 返回写入密文的writer，解密后把明文写入writer，Close时校验并写入最后的数据，不关闭writer
+func NewDecryptWriter(cipherName string, dataKey []byte, iv []byte, writer io.Writer) (io.WriteCloser, error) {
+	if cipherName == CipherAESCTR {
+		stream, err := newCTR(dataKey, iv)
+		if err != nil {
+			return nil, err
+		}
+		return &ctrWriter{stream: stream, writer: writer}, nil
+	}
+	return newGCMWriter(cipherName, dataKey, iv, writer, false)
+}
+
+// newCTR 创建AES-CTR密钥流
+func newCTR(key []byte, iv []byte) (cipher.Stream, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != ivLength {
+		return nil, errors.New("初始向量长度错误")
+	}
+	return cipher.NewCTR(block, iv), nil
+}
+
+// newGCM 创建AES-GCM
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ctrWriter AES-CTR解密的writer，密文与明文等长，逐段异或后直接写入
+type ctrWriter struct {
+	stream cipher.Stream
+	writer io.Writer
+}
+
+func (w *ctrWriter) Write(p []byte) (int, error) {
+	out := make([]byte, len(p))
+	w.stream.XORKeyStream(out, p)
+	return w.writer.Write(out)
+}
+
+func (w *ctrWriter) Close() error {
+	return nil
+}
+
+// gcmWriter AES-GCM分段加密或者解密的writer。第i段的nonce为初始向量前12字节的后8字节异或i，
+// 附加数据标记是否为最后一段，加密时缓存一段明文，解密时缓存一段密文，Close时处理最后一段
+type gcmWriter struct {
+	aead    cipher.AEAD
+	iv      []byte
+	writer  io.Writer
+	encrypt bool
+	buffer  []byte
+	counter uint64
+	closed  bool
+}
+
+// newGCMWriter 创建AES-GCM分段加密或者解密的writer
+func newGCMWriter(cipherName string, dataKey []byte, iv []byte, writer io.Writer, encrypt bool) (*gcmWriter, error) {
+	if cipherName != CipherAESGCM {
+		return nil, errors.New("不支持的加密算法: " + cipherName)
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) < aead.NonceSize() {
+		return nil, errors.New("初始向量长度错误")
+	}
+	return &gcmWriter{aead: aead, iv: iv[:aead.NonceSize()], writer: writer, encrypt: encrypt}, nil
+}
+
+// chunkSize 每段输入的字节数
+func (w *gcmWriter) chunkSize() int {
+	if w.encrypt {
+		return gcmChunkSize
+	}
+	return gcmChunkSize + gcmTagSize
+}
+
+func (w *gcmWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("writer已关闭")
+	}
+	w.buffer = append(w.buffer, p...)
+	// 多缓存一个字节才能确定当前段不是最后一段
+	for len(w.buffer) > w.chunkSize() {
+		if err := w.flushChunk(w.buffer[:w.chunkSize()], false); err != nil {
+			return 0, err
+		}
+		w.buffer = w.buffer[w.chunkSize():]
+	}
+	return len(p), nil
+}
+
+// Close 处理最后一段，不关闭底层的writer
+func (w *gcmWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.flushChunk(w.buffer, true)
+}
+
+// flushChunk 加密或者解密一段并写入底层的writer
+func (w *gcmWriter) flushChunk(chunk []byte, last bool) error {
+	nonce := make([]byte, len(w.iv))
+	copy(nonce, w.iv)
+	counter := binary.BigEndian.Uint64(nonce[len(nonce)-8:]) ^ w.counter
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], counter)
+	w.counter++
+	additionalData := []byte{0}
+	if last {
+		additionalData[0] = 1
+	}
+	var out []byte
+	if w.encrypt {
+		out = w.aead.Seal(nil, nonce, chunk, additionalData)
+	} else {
+		var err error
+		out, err = w.aead.Open(nil, nonce, chunk, additionalData)
+		if err != nil {
+			return errors.New("密文校验失败，数据已损坏或者被篡改")
+		}
+	}
+	_, err := w.writer.Write(out)
+	return err
+}
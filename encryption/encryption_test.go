@@ -0,0 +1,157 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+// encryptAll 加密全部数据，返回密文
+func encryptAll(t *testing.T, cipherName string, dataKey []byte, iv []byte, plaintext []byte) []byte {
+	reader, err := NewEncryptReader(cipherName, dataKey, iv, bytes.NewReader(plaintext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ciphertext
+}
+
+// decryptAll 按step大小分多次写入密文解密，模拟按块读取后解密
+func decryptAll(cipherName string, dataKey []byte, iv []byte, ciphertext []byte, step int) ([]byte, error) {
+	var plaintext bytes.Buffer
+	writer, err := NewDecryptWriter(cipherName, dataKey, iv, &plaintext)
+	if err != nil {
+		return nil, err
+	}
+	for len(ciphertext) > 0 {
+		n := step
+		if n > len(ciphertext) {
+			n = len(ciphertext)
+		}
+		if _, err := writer.Write(ciphertext[:n]); err != nil {
+			return nil, err
+		}
+		ciphertext = ciphertext[n:]
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return plaintext.Bytes(), nil
+}
+
+// TestCipherRoundTrip 测试两种加密算法在不同大小下加密后解密得到原文，密文大小与EncryptedSize一致
+func TestCipherRoundTrip(t *testing.T) {
+	dataKey, iv, err := NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cipherName := range []string{CipherAESCTR, CipherAESGCM} {
+		for _, size := range []int{0, 1, gcmChunkSize - 1, gcmChunkSize, gcmChunkSize + 1, 3*gcmChunkSize + 100} {
+			plaintext := make([]byte, size)
+			_, _ = rand.Read(plaintext)
+			ciphertext := encryptAll(t, cipherName, dataKey, iv, plaintext)
+			if uint64(len(ciphertext)) != EncryptedSize(cipherName, uint64(size)) {
+				t.Errorf("%v size %d: ciphertext size %d, expected %d", cipherName, size, len(ciphertext), EncryptedSize(cipherName, uint64(size)))
+			}
+			if size > 0 && bytes.Equal(ciphertext[:size], plaintext) {
+				t.Errorf("%v size %d: data is not encrypted", cipherName, size)
+			}
+			for _, step := range []int{7, 4096, len(ciphertext) + 1} {
+				decrypted, err := decryptAll(cipherName, dataKey, iv, ciphertext, step)
+				if err != nil {
+					t.Fatalf("%v size %d step %d: %v", cipherName, size, step, err)
+				}
+				if !bytes.Equal(decrypted, plaintext) {
+					t.Errorf("%v size %d step %d: decrypted data mismatch", cipherName, size, step)
+				}
+			}
+		}
+	}
+	if ValidCipher("AES/ECB/NoPadding") {
+		t.Errorf("Unsupported cipher should be invalid")
+	}
+}
+
+// TestCipherGCMTamper 测试GCM能发现密文被修改、截断或者使用错误的数据密钥
+func TestCipherGCMTamper(t *testing.T) {
+	dataKey, iv, err := NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := make([]byte, 2*gcmChunkSize+10)
+	ciphertext := encryptAll(t, CipherAESGCM, dataKey, iv, plaintext)
+
+	tampered := append([]byte(nil), ciphertext...)
+	tampered[gcmChunkSize+gcmTagSize+1] ^= 1
+	if _, err := decryptAll(CipherAESGCM, dataKey, iv, tampered, 4096); err == nil {
+		t.Errorf("Tampered ciphertext should be detected")
+	}
+	// 恰好截断在分块边界上，最后一个分块的标记可以发现
+	if _, err := decryptAll(CipherAESGCM, dataKey, iv, ciphertext[:gcmChunkSize+gcmTagSize], 4096); err == nil {
+		t.Errorf("Truncated ciphertext should be detected")
+	}
+	otherKey, _, _ := NewDataKey()
+	if _, err := decryptAll(CipherAESGCM, otherKey, iv, ciphertext, 4096); err == nil {
+		t.Errorf("Wrong data key should be detected")
+	}
+}
+
+// TestWrapKey 测试用加密区密钥加密数据密钥后，只有同一个加密区密钥可以解开
+func TestWrapKey(t *testing.T) {
+	zoneKey, _, _ := NewDataKey()
+	otherKey, _, _ := NewDataKey()
+	dataKey, _, _ := NewDataKey()
+	wrapped, err := WrapKey(zoneKey, dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(wrapped, dataKey) {
+		t.Errorf("Data key should not be stored in plain text")
+	}
+	unwrapped, err := UnwrapKey(zoneKey, wrapped)
+	if err != nil || !bytes.Equal(unwrapped, dataKey) {
+		t.Errorf("Unable to unwrap data key: %v", err)
+	}
+	if _, err := UnwrapKey(otherKey, wrapped); err == nil {
+		t.Errorf("Wrong zone key should not unwrap data key")
+	}
+}
+
+// TestKeyStore 测试在密钥库中生成、读取密钥，同名密钥不能重复生成
+func TestKeyStore(t *testing.T) {
+	keyStore := filepath.Join(t.TempDir(), "keystore")
+	if _, err := ZoneKey(keyStore, "key1"); err == nil {
+		t.Errorf("Missing key store should return error")
+	}
+	if err := CreateKey(keyStore, "key1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateKey(keyStore, "key2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateKey(keyStore, "key1"); err == nil {
+		t.Errorf("Duplicate key should be rejected")
+	}
+	if err := CreateKey(keyStore, "bad name"); err == nil {
+		t.Errorf("Invalid key name should be rejected")
+	}
+	keys, err := LoadKeyStore(keyStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || len(keys["key1"]) != KeyLength || bytes.Equal(keys["key1"], keys["key2"]) {
+		t.Errorf("Unable to load key store: %v", keys)
+	}
+	key, err := ZoneKey(keyStore, "key2")
+	if err != nil || !bytes.Equal(key, keys["key2"]) {
+		t.Errorf("Unable to get zone key: %v", err)
+	}
+	if _, err := ZoneKey(keyStore, "key3"); err == nil {
+		t.Errorf("Missing key should return error")
+	}
+}
@@ -0,0 +1,81 @@
+package encryption
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+)
+
+// KeyLength 加密区密钥和数据密钥的字节数，使用AES-256
+const KeyLength = 32
+
+// LoadKeyStore 读取密钥库文件，每行为"密钥名 十六进制密钥"，忽略空行和#开头的注释，返回密钥名到密钥的映射
+func LoadKeyStore(keyStoreFile string) (map[string][]byte, error) {
+	file, err := os.Open(keyStoreFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	keys := make(map[string][]byte)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, errors.New("密钥库格式错误: " + line)
+		}
+		key, err := hex.DecodeString(fields[1])
+		if err != nil || len(key) != KeyLength {
+			return nil, errors.New("密钥格式错误: " + fields[0])
+		}
+		keys[fields[0]] = key
+	}
+	return keys, scanner.Err()
+}
+
+// ZoneKey 从密钥库文件读取指定名字的加密区密钥
+func ZoneKey(keyStoreFile string, name string) ([]byte, error) {
+	keys, err := LoadKeyStore(keyStoreFile)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := keys[name]
+	if !ok {
+		return nil, errors.New("密钥库中没有密钥: " + name)
+	}
+	return key, nil
+}
+
+// CreateKey 生成随机的加密区密钥并追加到密钥库文件，文件不存在时创建，只有文件所有者可以读写，同名密钥已存在时返回错误
+func CreateKey(keyStoreFile string, name string) error {
+	if name == "" || strings.ContainsAny(name, " \t\n#") {
+		return errors.New("密钥名不能为空，也不能包含空白和#")
+	}
+	if keys, err := LoadKeyStore(keyStoreFile); err == nil {
+		if _, ok := keys[name]; ok {
+			return errors.New("密钥已存在: " + name)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	key := make([]byte, KeyLength)
+	_, err := rand.Read(key)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(keyStoreFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(name + " " + hex.EncodeToString(key) + "\n")
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,151 @@
+package erasure
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Encoder Reed-Solomon编码器，dataShards个数据分片编码出parityShards个校验分片，
+// 任意dataShards个分片都可以还原出全部分片
+type Encoder struct {
+	dataShards   int
+	parityShards int
+	matrix       matrix //编码矩阵，上面dataShards行为单位矩阵，数据分片编码后保持不变
+}
+
+// New 生成编码器
+func New(dataShards int, parityShards int) (*Encoder, error) {
+	if dataShards <= 0 || parityShards <= 0 || dataShards+parityShards > 256 {
+		return nil, errors.New("分片数不合法: " + strconv.Itoa(dataShards) + "+" + strconv.Itoa(parityShards))
+	}
+	total := dataShards + parityShards
+	// 范德蒙矩阵乘以其上方方阵的逆矩阵，得到上方为单位矩阵、任意dataShards行都可逆的编码矩阵
+	v := vandermonde(total, dataShards)
+	top, err := v.subMatrix(rangeOf(dataShards)).invert()
+	if err != nil {
+		return nil, err
+	}
+	return &Encoder{dataShards: dataShards, parityShards: parityShards, matrix: v.multiply(top)}, nil
+}
+
+// Split 把数据平均切分成数据分片，最后一个分片不足时补0，同时分配好校验分片，返回的分片可以直接Encode
+func (encoder *Encoder) Split(data []byte) [][]byte {
+	shardSize := (len(data) + encoder.dataShards - 1) / encoder.dataShards
+	shards := make([][]byte, encoder.dataShards+encoder.parityShards)
+	for i := range shards {
+		shards[i] = make([]byte, shardSize)
+		if i < encoder.dataShards && i*shardSize < len(data) {
+			copy(shards[i], data[i*shardSize:])
+		}
+	}
+	return shards
+}
+
+// Join 拼接数据分片，截取前size个字节
+func (encoder *Encoder) Join(shards [][]byte, size int) ([]byte, error) {
+	data := make([]byte, 0, size)
+	for i := 0; i < encoder.dataShards && len(data) < size; i++ {
+		if shards[i] == nil {
+			return nil, errors.New("数据分片缺失，需要先还原")
+		}
+		data = append(data, shards[i]...)
+	}
+	if len(data) < size {
+		return nil, errors.New("数据分片长度不足")
+	}
+	return data[:size], nil
+}
+
+// Encode 根据数据分片计算校验分片，所有分片的长度必须相同
+func (encoder *Encoder) Encode(shards [][]byte) error {
+	if err := encoder.checkShards(shards, false); err != nil {
+		return err
+	}
+	for i := encoder.dataShards; i < len(shards); i++ {
+		encoder.encodeShard(shards, i, rangeOf(encoder.dataShards), identityMatrix(encoder.dataShards))
+	}
+	return nil
+}
+
+// Reconstruct 还原缺失的分片，缺失的分片为nil，至少需要dataShards个分片
+func (encoder *Encoder) Reconstruct(shards [][]byte) error {
+	if err := encoder.checkShards(shards, true); err != nil {
+		return err
+	}
+	var present []int
+	for i, shard := range shards {
+		if shard != nil && len(present) < encoder.dataShards {
+			present = append(present, i)
+		}
+	}
+	if len(present) < encoder.dataShards {
+		return errors.New("可用的分片不足，无法还原")
+	}
+	// 用可用分片对应的编码矩阵行求逆，得到由可用分片计算数据分片的矩阵
+	decode, err := encoder.matrix.subMatrix(present).invert()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < encoder.dataShards; i++ {
+		if shards[i] == nil {
+			encoder.encodeShard(shards, i, present, decode)
+		}
+	}
+	for i := encoder.dataShards; i < len(shards); i++ {
+		if shards[i] == nil {
+			encoder.encodeShard(shards, i, rangeOf(encoder.dataShards), identityMatrix(encoder.dataShards))
+		}
+	}
+	return nil
+}
+
+// encodeShard 计算第index个分片：先用inputs分片通过decode矩阵得到数据分片的系数，再乘以编码矩阵的第index行
+func (encoder *Encoder) encodeShard(shards [][]byte, index int, inputs []int, decode matrix) {
+	var coefficients []byte
+	if index < encoder.dataShards {
+		coefficients = decode[index]
+	} else {
+		coefficients = matrix{encoder.matrix[index]}.multiply(decode)[0]
+	}
+	shardSize := len(shards[inputs[0]])
+	output := make([]byte, shardSize)
+	for k, input := range inputs {
+		coefficient := coefficients[k]
+		if coefficient == 0 {
+			continue
+		}
+		for b, value := range shards[input] {
+			output[b] ^= galMul(coefficient, value)
+		}
+	}
+	shards[index] = output
+}
+
+// checkShards 检查分片数和分片长度，allowMissing为true时允许分片为nil
+func (encoder *Encoder) checkShards(shards [][]byte, allowMissing bool) error {
+	if len(shards) != encoder.dataShards+encoder.parityShards {
+		return errors.New("分片数不正确")
+	}
+	shardSize := -1
+	for _, shard := range shards {
+		if shard == nil {
+			if !allowMissing {
+				return errors.New("分片缺失")
+			}
+			continue
+		}
+		if shardSize >= 0 && len(shard) != shardSize {
+			return errors.New("分片长度不一致")
+		}
+		shardSize = len(shard)
+	}
+	return nil
+}
+
+func rangeOf(n int) []int {
+	indexes := make([]int, n)
+	for i := range indexes {
+		indexes[i] = i
+	}
+	return indexes
+}
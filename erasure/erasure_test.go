@@ -0,0 +1,60 @@
+package erasure
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// TestEncoderReconstruct 测试RS(6,3)丢失任意3个分片后都能还原
+func TestEncoderReconstruct(t *testing.T) {
+	encoder, err := New(6, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 1000)
+	rand.New(rand.NewSource(1)).Read(data)
+	shards := encoder.Split(data)
+	if err = encoder.Encode(shards); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, missing := range [][]int{{0, 1, 2}, {3, 7, 8}, {0, 5, 6}, {6, 7, 8}} {
+		damaged := make([][]byte, len(shards))
+		copy(damaged, shards)
+		for _, i := range missing {
+			damaged[i] = nil
+		}
+		if err = encoder.Reconstruct(damaged); err != nil {
+			t.Fatal(err)
+		}
+		for i := range shards {
+			if !bytes.Equal(damaged[i], shards[i]) {
+				t.Errorf("Unable to reconstruct shard %d when missing %v", i, missing)
+			}
+		}
+		joined, err := encoder.Join(damaged, len(data))
+		if err != nil || !bytes.Equal(joined, data) {
+			t.Errorf("Unable to join reconstructed shards when missing %v", missing)
+		}
+	}
+}
+
+// TestEncoderTooManyMissing 测试丢失的分片超过校验分片数时无法还原
+func TestEncoderTooManyMissing(t *testing.T) {
+	encoder, err := New(3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards := encoder.Split([]byte("hello erasure coding"))
+	if err = encoder.Encode(shards); err != nil {
+		t.Fatal(err)
+	}
+	shards[0], shards[2], shards[4] = nil, nil, nil
+	if err = encoder.Reconstruct(shards); err == nil {
+		t.Errorf("Reconstruct should fail with too many missing shards")
+	}
+	if _, err = New(0, 2); err == nil {
+		t.Errorf("Invalid shard count should fail")
+	}
+}
@@ -0,0 +1,51 @@
+package erasure
+
+// GF(2^8)有限域运算，本原多项式为x^8+x^4+x^3+x^2+1(0x11d)，生成元为2
+const fieldPolynomial = 0x11d
+
+var (
+	expTable [512]byte //expTable[i] = 2^i，长度翻倍省去乘法时的取模
+	logTable [256]byte //logTable[2^i] = i
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		expTable[i] = byte(x)
+		logTable[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= fieldPolynomial
+		}
+	}
+	for i := 255; i < len(expTable); i++ {
+		expTable[i] = expTable[i-255]
+	}
+}
+
+// galMul 有限域乘法
+func galMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+int(logTable[b])]
+}
+
+// galDiv 有限域除法，b不能为0
+func galDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+255-int(logTable[b])]
+}
+
+// galExp 有限域幂运算
+func galExp(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])*n)%255]
+}
@@ -0,0 +1,104 @@
+package erasure
+
+import "errors"
+
+// errSingular 矩阵不可逆
+var errSingular = errors.New("矩阵不可逆")
+
+type matrix [][]byte
+
+func newMatrix(rows, cols int) matrix {
+	m := make(matrix, rows)
+	for r := range m {
+		m[r] = make([]byte, cols)
+	}
+	return m
+}
+
+// identityMatrix 单位矩阵
+func identityMatrix(size int) matrix {
+	m := newMatrix(size, size)
+	for i := range m {
+		m[i][i] = 1
+	}
+	return m
+}
+
+// vandermonde 范德蒙矩阵，第r行第c列为r^c，任意cols行组成的方阵都可逆
+func vandermonde(rows, cols int) matrix {
+	m := newMatrix(rows, cols)
+	for r := range m {
+		for c := range m[r] {
+			m[r][c] = galExp(byte(r), c)
+		}
+	}
+	return m
+}
+
+// multiply 矩阵乘法
+func (m matrix) multiply(right matrix) matrix {
+	result := newMatrix(len(m), len(right[0]))
+	for r := range result {
+		for c := range result[r] {
+			var value byte
+			for i := range right {
+				value ^= galMul(m[r][i], right[i][c])
+			}
+			result[r][c] = value
+		}
+	}
+	return result
+}
+
+// subMatrix 取出指定的行组成新矩阵
+func (m matrix) subMatrix(rows []int) matrix {
+	result := make(matrix, len(rows))
+	for i, r := range rows {
+		result[i] = append([]byte(nil), m[r]...)
+	}
+	return result
+}
+
+// invert 高斯消元求逆矩阵
+func (m matrix) invert() (matrix, error) {
+	size := len(m)
+	work := newMatrix(size, size*2)
+	for r := range m {
+		copy(work[r], m[r])
+		work[r][size+r] = 1
+	}
+	for c := 0; c < size; c++ {
+		// 找到该列不为0的行作为主元
+		if work[c][c] == 0 {
+			for r := c + 1; r < size; r++ {
+				if work[r][c] != 0 {
+					work[c], work[r] = work[r], work[c]
+					break
+				}
+			}
+		}
+		if work[c][c] == 0 {
+			return nil, errSingular
+		}
+		if work[c][c] != 1 {
+			scale := galDiv(1, work[c][c])
+			for i := range work[c] {
+				work[c][i] = galMul(work[c][i], scale)
+			}
+		}
+		for r := 0; r < size; r++ {
+			if r == c || work[r][c] == 0 {
+				continue
+			}
+			factor := work[r][c]
+			for i := range work[r] {
+				work[r][i] ^= galMul(factor, work[c][i])
+			}
+		}
+	}
+	result := newMatrix(size, size)
+	for r := range result {
+		copy(result[r], work[r][size:])
+	}
+	return result, nil
+}
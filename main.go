@@ -1,23 +1,43 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"github.com/liuzongzhou/GoDFS/audit"
+	authService "github.com/liuzongzhou/GoDFS/daemon/auth"
+	"github.com/liuzongzhou/GoDFS/daemon/balancer"
 	"github.com/liuzongzhou/GoDFS/daemon/client"
 	"github.com/liuzongzhou/GoDFS/daemon/datanode"
 	"github.com/liuzongzhou/GoDFS/daemon/namenode"
+	"github.com/liuzongzhou/GoDFS/encryption"
+	namenodeService "github.com/liuzongzhou/GoDFS/namenode"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func main() {
-	// 启动命令的标志区分位：datanode，namenode，client
+	// 启动命令的标志区分位：datanode，namenode，client，balancer，token，key
 	dataNodeCommand := flag.NewFlagSet("datanode", flag.ExitOnError)
 	nameNodeCommand := flag.NewFlagSet("namenode", flag.ExitOnError)
 	clientCommand := flag.NewFlagSet("client", flag.ExitOnError)
+	balancerCommand := flag.NewFlagSet("balancer", flag.ExitOnError)
+	tokenCommand := flag.NewFlagSet("token", flag.ExitOnError)
+	keyCommand := flag.NewFlagSet("key", flag.ExitOnError)
 	//dataNode相关参数：端口，根目录
 	dataNodePortPtr := dataNodeCommand.Int("port", 7000, "DataNode communication port")
 	dataNodeDataLocationPtr := dataNodeCommand.String("data-location", ".", "DataNode data storage location")
+	dataNodeHostPtr := dataNodeCommand.String("host", "localhost", "DataNode communication host")
+	dataNodeNameNodePtr := dataNodeCommand.String("namenode", "", "NameNode to register to, e.g. localhost:9000")
+	dataNodeRackPtr := dataNodeCommand.String("rack", "", "Rack of the DataNode, e.g. /rack1")
+	dataNodeCapacityPtr := dataNodeCommand.Uint64("capacity", 0, "Capacity of the DataNode in bytes, 0 means the capacity of the disk")
+	dataNodeKeyFilePtr := dataNodeCommand.String("keyfile", "", "Key file shared with the NameNode to verify tokens, empty means no authentication")
+	dataNodeTLSCertPtr := dataNodeCommand.String("tls-cert", "", "TLS certificate file, empty means no TLS")
+	dataNodeTLSKeyPtr := dataNodeCommand.String("tls-key", "", "TLS private key file of the certificate")
+	dataNodeTLSCAPtr := dataNodeCommand.String("tls-ca", "", "CA certificate file to verify peers, empty means the system roots")
+	dataNodeTLSVerifyClientPtr := dataNodeCommand.Bool("tls-verify-client", false, "Require clients to present a certificate signed by the CA (mutual TLS)")
 	//nameNode相关参数：地址，端口，根目录，管理的dataNodes列表，文件块大小，备份总数（主+备），当前主端口
 	nameNodeHostPtr := nameNodeCommand.String("host", "localhost", "NameNode communication host")
 	nameNodePortPtr := nameNodeCommand.Int("port", 9000, "NameNode communication port")
@@ -25,6 +45,23 @@ func main() {
 	nameNodeBlockSizePtr := nameNodeCommand.Int("block-size", 32, "Block size to store")
 	nameNodeReplicationFactorPtr := nameNodeCommand.Int("replication-factor", 1, "Replication factor of the system")
 	nameNodePrimaryPortPtr := nameNodeCommand.String("primary-port", "9000", "Primary NameNode port")
+	nameNodeMaxReplicationStreamsPtr := nameNodeCommand.Int("replication-max-streams", 2, "Max number of concurrent block replications")
+	nameNodeTopologyFilePtr := nameNodeCommand.String("topology-file", "", "File mapping DataNode host[:port] to rack")
+	nameNodeMinFreeSpacePtr := nameNodeCommand.Uint64("min-free-space", 0, "Min free space in bytes of DataNodes to write to, at least one block")
+	nameNodeMetadataDirPtr := nameNodeCommand.String("metadata-dir", ".", "Directory to save and load the namespace image, empty means not persisted")
+	nameNodeSafeModeThresholdPtr := nameNodeCommand.Float64("safemode-threshold", 0.999, "Fraction of blocks with at least one reported replica to leave startup safe mode")
+	nameNodeSuperUserPtr := nameNodeCommand.String("superuser", "", "Super user that bypasses permission checks, empty means the user running the NameNode")
+	nameNodeKeyFilePtr := nameNodeCommand.String("keyfile", "", "Key file to verify tokens, empty means no authentication")
+	nameNodeTLSCertPtr := nameNodeCommand.String("tls-cert", "", "TLS certificate file, empty means no TLS")
+	nameNodeTLSKeyPtr := nameNodeCommand.String("tls-key", "", "TLS private key file of the certificate")
+	nameNodeTLSCAPtr := nameNodeCommand.String("tls-ca", "", "CA certificate file to verify peers, empty means the system roots")
+	nameNodeTLSVerifyClientPtr := nameNodeCommand.Bool("tls-verify-client", false, "Require clients to present a certificate signed by the CA (mutual TLS)")
+	nameNodeAuditLogPtr := nameNodeCommand.String("audit-log", "", "Audit log file with one JSON line per client RPC, empty means no audit log")
+	nameNodeAuditLogMaxSizePtr := nameNodeCommand.Int64("audit-log-max-size", audit.DefaultMaxSize, "Max bytes of the audit log file before it is rotated")
+	nameNodeAuditLogMaxBackupsPtr := nameNodeCommand.Int("audit-log-max-backups", audit.DefaultMaxBackups, "Max number of rotated audit log files to keep")
+	nameNodeTrashIntervalPtr := nameNodeCommand.Duration("trash-interval", 24*time.Hour, "How long deleted paths are kept in trash checkpoints, 0 disables trash")
+	nameNodeTrashCheckpointIntervalPtr := nameNodeCommand.Duration("trash-checkpoint-interval", 0, "Interval between trash checkpoints, 0 means the same as trash-interval")
+	nameNodePlacementPolicyPtr := nameNodeCommand.String("placement-policy", "rack-aware", "Block placement policy: random, round-robin, capacity-weighted or rack-aware")
 	//client相关参数：通过哪个nameNode端口操作，操作行为分类，本地文件路径，文件名，远端文件路径，下载文件路径，重命名原始路径，重命名目标路径，list目标路径
 	clientNameNodePortPtr := clientCommand.String("namenode", "localhost:9000", "NameNode communication port")
 	clientOperationPtr := clientCommand.String("operation", "", "Operation to perform")
@@ -35,6 +72,52 @@ func main() {
 	renameSrcPath := clientCommand.String("rename_src_name", "", "rename_src_name")
 	renameDestPath := clientCommand.String("rename_dest_name", "", "rename_dest_name")
 	remoteDirPath := clientCommand.String("remote_dir_path", "", "remote_dir_path")
+	clientDataNodePtr := clientCommand.String("datanode", "", "DataNode host:port to decommission, recommission or put into maintenance")
+	clientDurationPtr := clientCommand.Duration("duration", 30*time.Minute, "Duration of DataNode maintenance")
+	clientReplicationPtr := clientCommand.Uint64("replication", 0, "Replication factor of the file, 0 means inherit from the directory")
+	clientSafeModeActionPtr := clientCommand.String("action", "get", "Safe mode action: enter, leave or get")
+	clientMovePtr := clientCommand.Bool("move", false, "fsck: move readable blocks of corrupt files to lost+found and delete them")
+	clientDeletePtr := clientCommand.Bool("delete", false, "fsck: delete corrupt files")
+	clientJsonPtr := clientCommand.Bool("json", false, "fsck and report: print the report as JSON")
+	clientTokenPtr := clientCommand.String("token", os.Getenv("GODFS_TOKEN"), "Token to authenticate with, defaults to $GODFS_TOKEN")
+	clientTLSCertPtr := clientCommand.String("tls-cert", "", "TLS client certificate file for mutual TLS")
+	clientTLSKeyPtr := clientCommand.String("tls-key", "", "TLS private key file of the client certificate")
+	clientTLSCAPtr := clientCommand.String("tls-ca", "", "CA certificate file to verify the cluster, empty means no TLS unless tls-cert is set")
+	clientUserPtr := clientCommand.String("user", "", "User to perform the operation as, empty means the user running the client")
+	clientGroupsPtr := clientCommand.String("groups", "", "Comma-separated groups of the user")
+	clientModePtr := clientCommand.String("mode", "", "chmod: permission bits in octal, e.g. 755 or 1777")
+	clientOwnerPtr := clientCommand.String("owner", "", "chown: new owner")
+	clientGroupPtr := clientCommand.String("group", "", "chown and chgrp: new group")
+	clientAclPtr := clientCommand.String("acl", "", "setfacl: comma-separated ACL entries, e.g. user:bob:rw-,default:group:dev:r-x")
+	clientAclActionPtr := clientCommand.String("acl-action", "modify", "setfacl action: modify, remove, set, removeall or removedefault")
+	clientECPolicyPtr := clientCommand.String("ecpolicy", "", "Erasure coding policy of the directory: RS-6-3, RS-3-2 or replication, empty means inherit from the parent directory")
+	clientNameQuotaPtr := clientCommand.Uint64("namequota", 0, "setquota: max number of files and directories under the directory, including itself")
+	clientSpaceQuotaPtr := clientCommand.Uint64("spacequota", 0, "setquota: max raw bytes consumed under the directory, counting replication")
+	clientQuotaTypePtr := clientCommand.String("quota-type", "all", "clrquota: quota to clear, name, space or all")
+	clientShowQuotaPtr := clientCommand.Bool("q", false, "count: also print quotas and remaining quotas")
+	clientSnapshotNamePtr := clientCommand.String("snapshotname", "", "createSnapshot and deleteSnapshot: name of the snapshot, empty means a name based on the creation time for createSnapshot")
+	clientSkipTrashPtr := clientCommand.Bool("skipTrash", false, "deletefile and deletepath: delete immediately instead of moving to trash")
+	clientKeyStorePtr := clientCommand.String("keystore", os.Getenv("GODFS_KEYSTORE"), "Key store file with encryption zone keys, defaults to $GODFS_KEYSTORE")
+	clientKeyNamePtr := clientCommand.String("keyname", "", "createzone: name of the encryption zone key in the key store")
+	clientCipherPtr := clientCommand.String("cipher", encryption.CipherAESCTR, "createzone: cipher of the encryption zone, AES/CTR/NoPadding or AES/GCM/NoPadding")
+	//balancer相关参数：通过哪个nameNode操作，使用率阈值，带宽限制，最大轮数
+	balancerNameNodePtr := balancerCommand.String("namenode", "localhost:9000", "NameNode communication port")
+	balancerThresholdPtr := balancerCommand.Float64("threshold", 10, "Max difference in percent between DataNode utilization and cluster average")
+	balancerBandwidthPtr := balancerCommand.Uint64("bandwidth", 1024*1024, "Max bytes per second to move, 0 means unlimited")
+	balancerIterationsPtr := balancerCommand.Int("iterations", 5, "Max number of balancing iterations")
+	balancerTokenPtr := balancerCommand.String("token", os.Getenv("GODFS_TOKEN"), "Token to authenticate with, defaults to $GODFS_TOKEN")
+	balancerTLSCertPtr := balancerCommand.String("tls-cert", "", "TLS client certificate file for mutual TLS")
+	balancerTLSKeyPtr := balancerCommand.String("tls-key", "", "TLS private key file of the client certificate")
+	balancerTLSCAPtr := balancerCommand.String("tls-ca", "", "CA certificate file to verify the cluster, empty means no TLS unless tls-cert is set")
+	//token相关参数：密钥文件，是否生成密钥，令牌的用户、所属组和有效期
+	tokenKeyFilePtr := tokenCommand.String("keyfile", "", "Key file to sign tokens")
+	tokenGeneratePtr := tokenCommand.Bool("generate", false, "Generate a new key file instead of issuing a token")
+	tokenUserPtr := tokenCommand.String("user", "", "User of the token")
+	tokenGroupsPtr := tokenCommand.String("groups", "", "Comma-separated groups of the user")
+	tokenTtlPtr := tokenCommand.Duration("ttl", 24*time.Hour, "Lifetime of the token")
+	//key相关参数：密钥库文件，加密区密钥的名字
+	keyStorePtr := keyCommand.String("keystore", os.Getenv("GODFS_KEYSTORE"), "Key store file, defaults to $GODFS_KEYSTORE")
+	keyNamePtr := keyCommand.String("name", "", "Name of the encryption zone key to create")
 
 	//判断命令参数的传入，至少要2个参数，不然非法
 	if len(os.Args) < 2 {
@@ -45,11 +128,19 @@ func main() {
 	switch os.Args[1] {
 	case "datanode":
 		_ = dataNodeCommand.Parse(os.Args[2:])
+		if !authService.ConfigureTLSHandler(*dataNodeTLSCertPtr, *dataNodeTLSKeyPtr, *dataNodeTLSCAPtr, *dataNodeTLSVerifyClientPtr) {
+			fmt.Println("==> Unable to configure TLS")
+			os.Exit(1)
+		}
 		//建立dataNode节点进程，当不指定端口时默认7000，当端口被占用，自动+1，直到有空的端口可以被使用
-		datanode.InitializeDataNodeUtil(*dataNodePortPtr, *dataNodeDataLocationPtr)
+		datanode.InitializeDataNodeUtil(*dataNodeHostPtr, *dataNodePortPtr, *dataNodeDataLocationPtr, *dataNodeNameNodePtr, *dataNodeRackPtr, *dataNodeCapacityPtr, *dataNodeKeyFilePtr)
 
 	case "namenode":
 		_ = nameNodeCommand.Parse(os.Args[2:])
+		if !authService.ConfigureTLSHandler(*nameNodeTLSCertPtr, *nameNodeTLSKeyPtr, *nameNodeTLSCAPtr, *nameNodeTLSVerifyClientPtr) {
+			fmt.Println("==> Unable to configure TLS")
+			os.Exit(1)
+		}
 		var listOfDataNodes []string
 		//判断是否有输入dataNodes list,如果没有，则自己进行网络发现，然后加入管理
 		if len(*nameNodeListPtr) > 1 {
@@ -57,13 +148,20 @@ func main() {
 		} else {
 			listOfDataNodes = []string{}
 		}
-		namenode.InitializeNameNodeUtil(*nameNodePrimaryPortPtr, *nameNodeHostPtr, *nameNodePortPtr, *nameNodeBlockSizePtr, *nameNodeReplicationFactorPtr, listOfDataNodes)
+		namenode.InitializeNameNodeUtil(*nameNodePrimaryPortPtr, *nameNodeHostPtr, *nameNodePortPtr, *nameNodeBlockSizePtr, *nameNodeReplicationFactorPtr, *nameNodeMaxReplicationStreamsPtr, *nameNodeTopologyFilePtr, *nameNodePlacementPolicyPtr, *nameNodeMinFreeSpacePtr, *nameNodeMetadataDirPtr, *nameNodeSafeModeThresholdPtr, *nameNodeSuperUserPtr, *nameNodeKeyFilePtr, *nameNodeAuditLogPtr, *nameNodeAuditLogMaxSizePtr, *nameNodeAuditLogMaxBackupsPtr, *nameNodeTrashIntervalPtr, *nameNodeTrashCheckpointIntervalPtr, listOfDataNodes)
 
 	case "client":
 		_ = clientCommand.Parse(os.Args[2:])
+		if !authService.ConfigureTLSHandler(*clientTLSCertPtr, *clientTLSKeyPtr, *clientTLSCAPtr, false) {
+			fmt.Println("==> Unable to configure TLS")
+			os.Exit(1)
+		}
+		client.SetTokenHandler(*clientTokenPtr)
+		client.SetUserHandler(*clientUserPtr, *clientGroupsPtr)
+		client.SetKeyStoreHandler(*clientKeyStorePtr)
 		//上传文件，返回操作结果
 		if *clientOperationPtr == "put" {
-			status := client.PutHandler(*clientNameNodePortPtr, *clientSourcePathPtr, *clientFilenamePtr, *clientRemotefilepath)
+			status := client.PutHandler(*clientNameNodePortPtr, *clientSourcePathPtr, *clientFilenamePtr, *clientRemotefilepath, *clientReplicationPtr)
 			fmt.Printf("==> Put status: %t\n", status)
 			//下载文件，返回操作结果
 		} else if *clientOperationPtr == "get" {
@@ -80,6 +178,9 @@ func main() {
 				fmt.Printf("==> %v :File does not exist\n", *clientFilenamePtr)
 			} else {
 				fmt.Printf("==> FileName:%v\tFileSize:%v bytes\n", filename, filesize)
+				if permission, ok := client.GetPermissionHandler(*clientNameNodePortPtr, *clientRemotefilepath+*clientFilenamePtr); ok {
+					fmt.Printf("==> Owner:%v\tGroup:%v\tMode:%04o\n", permission.Owner, permission.Group, permission.Mode)
+				}
 			}
 			// 重命名文件目录或者文件名都可以，返回操作结果
 		} else if *clientOperationPtr == "rename" {
@@ -96,14 +197,291 @@ func main() {
 			for fileName, fileSize := range fileInfo {
 				fmt.Printf("==> FileName:%v\tFileSize:%v bytes\n", fileName, fileSize)
 			}
-			//删除路径以及路径下所有子文件和文件夹，返回操作结果
+			//删除路径以及路径下所有子文件和文件夹，默认移到回收站，返回操作结果
 		} else if *clientOperationPtr == "deletepath" {
-			status := client.DeletePathHandler(*clientNameNodePortPtr, *clientRemotefilepath)
+			trashPath, status := client.DeletePathHandler(*clientNameNodePortPtr, *clientRemotefilepath, *clientSkipTrashPtr)
+			if trashPath != "" {
+				fmt.Printf("==> Moved %v to trash at %v\n", *clientRemotefilepath, trashPath)
+			}
 			fmt.Printf("==> DeletePath status: %t\n", status)
-			//删除指定路径下的指定文件，返回操作结果
+			//删除指定路径下的指定文件，默认移到回收站，返回操作结果
 		} else if *clientOperationPtr == "deletefile" {
-			status := client.DeleteFileHandler(*clientNameNodePortPtr, *clientRemotefilepath, *clientFilenamePtr)
+			trashPath, status := client.DeleteFileHandler(*clientNameNodePortPtr, *clientRemotefilepath, *clientFilenamePtr, *clientSkipTrashPtr)
+			if trashPath != "" {
+				fmt.Printf("==> Moved %v to trash at %v\n", *clientRemotefilepath+*clientFilenamePtr, trashPath)
+			}
 			fmt.Printf("==> DeleteFile status: %t\n", status)
+			//修改文件的副本因子，不指定文件名时设置目录的默认副本因子，返回操作结果
+		} else if *clientOperationPtr == "setrep" {
+			status := client.SetReplicationHandler(*clientNameNodePortPtr, *clientRemotefilepath, *clientFilenamePtr, *clientReplicationPtr)
+			fmt.Printf("==> SetReplication status: %t\n", status)
+			//下线dataNode，上面的块复制到其他节点后变为已下线，返回操作结果
+		} else if *clientOperationPtr == "decommission" {
+			status := client.SetDataNodeAdminStateHandler(*clientNameNodePortPtr, *clientDataNodePtr, namenodeService.AdminStateDecommissionInProgress, 0)
+			fmt.Printf("==> Decommission status: %t\n", status)
+			//dataNode恢复正常，返回操作结果
+		} else if *clientOperationPtr == "recommission" {
+			status := client.SetDataNodeAdminStateHandler(*clientNameNodePortPtr, *clientDataNodePtr, namenodeService.AdminStateNormal, 0)
+			fmt.Printf("==> Recommission status: %t\n", status)
+			//dataNode进入维护模式，维护期间不重新复制上面的块，返回操作结果
+		} else if *clientOperationPtr == "maintenance" {
+			status := client.SetDataNodeAdminStateHandler(*clientNameNodePortPtr, *clientDataNodePtr, namenodeService.AdminStateInMaintenance, *clientDurationPtr)
+			fmt.Printf("==> Maintenance status: %t\n", status)
+			//查看dataNode的管理状态
+		} else if *clientOperationPtr == "datanodestate" {
+			state := client.GetDataNodeAdminStateHandler(*clientNameNodePortPtr, *clientDataNodePtr)
+			if state == "" {
+				fmt.Printf("==> %v :DataNode does not exist\n", *clientDataNodePtr)
+			} else {
+				fmt.Printf("==> DataNode:%v\tState:%v\n", *clientDataNodePtr, state)
+			}
+			//设置目录的纠删码策略，之后写入的文件按块组条带化存储，返回操作结果
+		} else if *clientOperationPtr == "setec" {
+			status := client.SetErasureCodingPolicyHandler(*clientNameNodePortPtr, *clientRemotefilepath, *clientECPolicyPtr)
+			fmt.Printf("==> SetErasureCodingPolicy status: %t\n", status)
+			//转换已有文件的存储方式：多副本和纠删码之间互相转换，路径不变，返回操作结果
+		} else if *clientOperationPtr == "convert" {
+			status := client.ConvertHandler(*clientNameNodePortPtr, *clientRemotefilepath, *clientFilenamePtr, *clientECPolicyPtr, *clientReplicationPtr)
+			fmt.Printf("==> Convert status: %t\n", status)
+			//管理nameNode的安全模式：进入、离开或者查看，打印安全模式状态
+		} else if *clientOperationPtr == "safemode" {
+			status, ok := client.SafeModeHandler(*clientNameNodePortPtr, *clientSafeModeActionPtr)
+			if !ok {
+				fmt.Printf("==> SafeMode status: false\n")
+			} else {
+				fmt.Printf("==> SafeMode:%v\tManual:%v\tReportedBlocks:%v/%v\tThreshold:%v\n", status.On, status.Manual, status.ReportedBlocks, status.TotalBlocks, status.Threshold)
+			}
+			//检查路径下文件的块，打印丢失、损坏、副本不足和副本放置不合理的块，可以移动或者删除损坏的文件
+		} else if *clientOperationPtr == "fsck" {
+			report, ok := client.FsckHandler(*clientNameNodePortPtr, *clientRemotefilepath+*clientFilenamePtr, *clientMovePtr, *clientDeletePtr)
+			if !ok {
+				fmt.Printf("==> Fsck status: false\n")
+			} else if *clientJsonPtr {
+				output, _ := json.MarshalIndent(report, "", "  ")
+				fmt.Println(string(output))
+			} else {
+				printFsckReport(report)
+			}
+			//打印集群报告：nameNode角色、每个dataNode的状态和容量、集群汇总信息
+		} else if *clientOperationPtr == "report" {
+			report, ok := client.ReportHandler(*clientNameNodePortPtr)
+			if !ok {
+				fmt.Printf("==> Report status: false\n")
+			} else if *clientJsonPtr {
+				output, _ := json.MarshalIndent(report, "", "  ")
+				fmt.Println(string(output))
+			} else {
+				printClusterReport(report)
+			}
+			//修改文件或者目录的权限位，不指定文件名时修改目录，返回操作结果
+		} else if *clientOperationPtr == "chmod" {
+			mode, err := strconv.ParseUint(*clientModePtr, 8, 32)
+			if err != nil {
+				fmt.Printf("==> Invalid mode: %v\n", *clientModePtr)
+			} else {
+				status := client.SetPermissionHandler(*clientNameNodePortPtr, *clientRemotefilepath+*clientFilenamePtr, uint32(mode))
+				fmt.Printf("==> Chmod status: %t\n", status)
+			}
+			//修改文件或者目录的所有者和所属组，返回操作结果
+		} else if *clientOperationPtr == "chown" {
+			status := client.SetOwnerHandler(*clientNameNodePortPtr, *clientRemotefilepath+*clientFilenamePtr, *clientOwnerPtr, *clientGroupPtr)
+			fmt.Printf("==> Chown status: %t\n", status)
+			//修改文件或者目录的所属组，返回操作结果
+		} else if *clientOperationPtr == "chgrp" {
+			status := client.SetOwnerHandler(*clientNameNodePortPtr, *clientRemotefilepath+*clientFilenamePtr, "", *clientGroupPtr)
+			fmt.Printf("==> Chgrp status: %t\n", status)
+			//修改文件或者目录的ACL，返回操作结果
+		} else if *clientOperationPtr == "setfacl" {
+			var entries []namenodeService.AclEntry
+			var err error
+			if *clientAclActionPtr != namenodeService.AclActionRemoveAll && *clientAclActionPtr != namenodeService.AclActionRemoveDefault {
+				entries, err = namenodeService.ParseAclSpec(*clientAclPtr, *clientAclActionPtr != namenodeService.AclActionRemove)
+			}
+			if err != nil {
+				fmt.Printf("==> Invalid ACL: %v\n", err)
+			} else {
+				status := client.SetAclHandler(*clientNameNodePortPtr, *clientRemotefilepath+*clientFilenamePtr, *clientAclActionPtr, entries)
+				fmt.Printf("==> Setfacl status: %t\n", status)
+			}
+			//打印文件或者目录完整的ACL
+		} else if *clientOperationPtr == "getfacl" {
+			status, ok := client.GetAclHandler(*clientNameNodePortPtr, *clientRemotefilepath+*clientFilenamePtr)
+			if !ok {
+				fmt.Printf("==> %v%v :File does not exist\n", *clientRemotefilepath, *clientFilenamePtr)
+			} else {
+				printAclStatus(*clientRemotefilepath+*clientFilenamePtr, status)
+			}
+			//查看文件或者目录生效的纠删码策略，不指定文件名时查看目录
+		} else if *clientOperationPtr == "getec" {
+			policy := client.GetErasureCodingPolicyHandler(*clientNameNodePortPtr, *clientRemotefilepath, *clientFilenamePtr)
+			if policy == "" {
+				fmt.Printf("==> %v%v :File does not exist\n", *clientRemotefilepath, *clientFilenamePtr)
+			} else {
+				fmt.Printf("==> Path:%v%v\tErasureCodingPolicy:%v\n", *clientRemotefilepath, *clientFilenamePtr, policy)
+			}
+			//设置目录的名字配额和空间配额，返回操作结果
+		} else if *clientOperationPtr == "setquota" {
+			status := client.SetQuotaHandler(*clientNameNodePortPtr, *clientRemotefilepath, *clientNameQuotaPtr, *clientSpaceQuotaPtr)
+			fmt.Printf("==> SetQuota status: %t\n", status)
+			//清除目录的配额，返回操作结果
+		} else if *clientOperationPtr == "clrquota" {
+			status := client.ClearQuotaHandler(*clientNameNodePortPtr, *clientRemotefilepath, *clientQuotaTypePtr)
+			fmt.Printf("==> ClrQuota status: %t\n", status)
+			//打印目录下的目录数、文件数和占用的空间，q为true时同时打印配额和剩余配额
+		} else if *clientOperationPtr == "count" {
+			summary, ok := client.CountHandler(*clientNameNodePortPtr, *clientRemotefilepath)
+			if !ok {
+				fmt.Printf("==> %v :Directory does not exist\n", *clientRemotefilepath)
+			} else {
+				printContentSummary(summary, *clientShowQuotaPtr)
+			}
+			//把空目录设置为加密区，之后写入的文件由客户端加密，返回操作结果
+		} else if *clientOperationPtr == "createzone" {
+			status := client.CreateEncryptionZoneHandler(*clientNameNodePortPtr, *clientRemotefilepath, *clientKeyNamePtr, *clientCipherPtr)
+			fmt.Printf("==> CreateEncryptionZone status: %t\n", status)
+			//列出所有加密区
+		} else if *clientOperationPtr == "listzones" {
+			zones, ok := client.ListEncryptionZonesHandler(*clientNameNodePortPtr)
+			if !ok {
+				fmt.Printf("==> ListEncryptionZones status: false\n")
+			}
+			for _, zone := range zones {
+				fmt.Printf("==> Path:%v\tKeyName:%v\tCipher:%v\n", zone.Path, zone.KeyName, zone.Cipher)
+			}
+			//允许或者不再允许在目录上创建快照，返回操作结果
+		} else if *clientOperationPtr == "allowSnapshot" {
+			status := client.AllowSnapshotHandler(*clientNameNodePortPtr, *clientRemotefilepath)
+			fmt.Printf("==> AllowSnapshot status: %t\n", status)
+		} else if *clientOperationPtr == "disallowSnapshot" {
+			status := client.DisallowSnapshotHandler(*clientNameNodePortPtr, *clientRemotefilepath)
+			fmt.Printf("==> DisallowSnapshot status: %t\n", status)
+			//为目录创建只读快照，快照中的文件可以通过<目录>/.snapshot/<快照名>/读取
+		} else if *clientOperationPtr == "createSnapshot" {
+			snapshotPath, status := client.CreateSnapshotHandler(*clientNameNodePortPtr, *clientRemotefilepath, *clientSnapshotNamePtr)
+			fmt.Printf("==> CreateSnapshot status: %t\n", status)
+			if status {
+				fmt.Printf("==> Created snapshot %v\n", snapshotPath)
+			}
+		} else if *clientOperationPtr == "deleteSnapshot" {
+			status := client.DeleteSnapshotHandler(*clientNameNodePortPtr, *clientRemotefilepath, *clientSnapshotNamePtr)
+			fmt.Printf("==> DeleteSnapshot status: %t\n", status)
+			//列出目录的所有快照，没有指定目录时列出所有可以创建快照的目录
+		} else if *clientOperationPtr == "listSnapshots" {
+			directories, ok := client.ListSnapshotsHandler(*clientNameNodePortPtr, *clientRemotefilepath)
+			if !ok {
+				fmt.Printf("==> ListSnapshots status: false\n")
+			}
+			for _, directory := range directories {
+				fmt.Printf("==> Path:%v\tSnapshots:%d\n", directory.Path, len(directory.Snapshots))
+				for _, snapshot := range directory.Snapshots {
+					fmt.Printf("==> %v%v\tCreatedAt:%v\n", directory.Path, ".snapshot/"+snapshot.Name+"/", snapshot.CreatedAt.Format(time.RFC3339))
+				}
+			}
+		}
+
+	case "balancer":
+		_ = balancerCommand.Parse(os.Args[2:])
+		if !authService.ConfigureTLSHandler(*balancerTLSCertPtr, *balancerTLSKeyPtr, *balancerTLSCAPtr, false) {
+			fmt.Println("==> Unable to configure TLS")
+			os.Exit(1)
+		}
+		//将块从使用率高的dataNode移动到使用率低的dataNode，返回移动的块数
+		moved := balancer.BalancerHandler(*balancerNameNodePtr, *balancerThresholdPtr, *balancerBandwidthPtr, *balancerIterationsPtr, *balancerTokenPtr)
+		fmt.Printf("==> Balancer moved %d blocks\n", moved)
+
+	case "token":
+		_ = tokenCommand.Parse(os.Args[2:])
+		//生成nameNode和dataNode共用的密钥文件，或者用密钥文件为用户签发令牌
+		if *tokenGeneratePtr {
+			status := authService.GenerateKeyHandler(*tokenKeyFilePtr)
+			fmt.Printf("==> Generate key status: %t\n", status)
+		} else if token, ok := authService.IssueTokenHandler(*tokenKeyFilePtr, *tokenUserPtr, *tokenGroupsPtr, *tokenTtlPtr); ok {
+			fmt.Println(token)
+		} else {
+			fmt.Println("==> Unable to issue token")
+		}
+
+	case "key":
+		_ = keyCommand.Parse(os.Args[2:])
+		//在客户端的密钥库中生成新的加密区密钥
+		status := authService.CreateZoneKeyHandler(*keyStorePtr, *keyNamePtr)
+		fmt.Printf("==> Create key status: %t\n", status)
+	}
+}
+
+// printFsckReport 打印有问题的文件和汇总信息
+func printFsckReport(report namenodeService.FsckReport) {
+	for _, file := range report.Files {
+		if file.IsCorrupt() {
+			fmt.Printf("==> %v: CORRUPT\tMissing:%v\tCorrupt:%v\n", file.FileName, file.MissingBlocks, file.CorruptBlocks)
+		}
+		if len(file.UnderReplicatedBlocks) > 0 {
+			fmt.Printf("==> %v: Under replicated blocks:%v\n", file.FileName, file.UnderReplicatedBlocks)
+		}
+		if len(file.MisReplicatedBlocks) > 0 {
+			fmt.Printf("==> %v: Mis replicated blocks:%v\n", file.FileName, file.MisReplicatedBlocks)
+		}
+	}
+	for _, fileName := range report.MovedFiles {
+		fmt.Printf("==> Moved %v to lost+found\n", fileName)
+	}
+	for _, fileName := range report.DeletedFiles {
+		fmt.Printf("==> Deleted %v\n", fileName)
+	}
+	fmt.Printf("==> Files:%v\tBlocks:%v\tMissing:%v\tCorrupt:%v\tUnderReplicated:%v\tMisReplicated:%v\tDataNodes:%v\tRacks:%v\n",
+		report.TotalFiles, report.TotalBlocks, report.MissingBlocks, report.CorruptBlocks, report.UnderReplicatedBlocks, report.MisReplicatedBlocks, report.DataNodes, report.Racks)
+	if report.Healthy {
+		fmt.Printf("==> The filesystem under path '%v' is HEALTHY\n", report.Path)
+	} else {
+		fmt.Printf("==> The filesystem under path '%v' is CORRUPT\n", report.Path)
+	}
+}
+
+// printAclStatus 按getfacl的格式打印ACL，受mask限制的条目同时打印实际生效的权限
+func printAclStatus(path string, status namenodeService.AclStatus) {
+	fmt.Printf("# file: %v\n# owner: %v\n# group: %v\n", path, status.Owner, status.Group)
+	if status.Sticky {
+		fmt.Println("# flags: --t")
+	}
+	for _, entry := range status.Entries {
+		if effective := status.Effective(entry); effective != entry.Permission {
+			fmt.Printf("%v\t#effective:%v\n", entry, effective)
+		} else {
+			fmt.Println(entry)
+		}
+	}
+}
+
+// printContentSummary 打印目录的用量，showQuota为true时先打印配额和剩余配额，没有配额时显示为none和inf
+func printContentSummary(summary namenodeService.ContentSummary, showQuota bool) {
+	if showQuota {
+		nameQuota, remainingNameQuota := "none", "inf"
+		if summary.NameQuota > 0 {
+			nameQuota = strconv.FormatUint(summary.NameQuota, 10)
+			remainingNameQuota = strconv.FormatInt(int64(summary.NameQuota)-int64(summary.DirectoryCount+summary.FileCount), 10)
+		}
+		spaceQuota, remainingSpaceQuota := "none", "inf"
+		if summary.SpaceQuota > 0 {
+			spaceQuota = strconv.FormatUint(summary.SpaceQuota, 10)
+			remainingSpaceQuota = strconv.FormatInt(int64(summary.SpaceQuota)-int64(summary.SpaceConsumed), 10)
+		}
+		fmt.Printf("==> NameQuota:%v\tRemainingNameQuota:%v\tSpaceQuota:%v\tRemainingSpaceQuota:%v\n", nameQuota, remainingNameQuota, spaceQuota, remainingSpaceQuota)
+	}
+	fmt.Printf("==> Path:%v\tDirectories:%v\tFiles:%v\tLength:%v bytes\tSpaceConsumed:%v bytes\n", summary.Path, summary.DirectoryCount, summary.FileCount, summary.Length, summary.SpaceConsumed)
+}
+
+// printClusterReport 打印nameNode信息、集群汇总信息和每个dataNode的信息
+func printClusterReport(report namenodeService.ClusterReport) {
+	fmt.Printf("==> NameNode:%v:%v\tRole:%v\tPrimaryPort:%v\tSafeMode:%v\n", report.Host, report.Port, report.Role, report.PrimaryPort, report.SafeMode)
+	fmt.Printf("==> Capacity:%v bytes\tUsed:%v bytes\tRemaining:%v bytes\n", report.Capacity, report.Used, report.Remaining)
+	fmt.Printf("==> Files:%v\tBlocks:%v\tMissing:%v\tCorrupt:%v\tUnderReplicated:%v\n", report.Files, report.Blocks, report.MissingBlocks, report.CorruptBlocks, report.UnderReplicatedBlocks)
+	fmt.Printf("==> DataNodes live:%v\tstale:%v\tdead:%v\n", report.LiveDataNodes, report.StaleDataNodes, report.DeadDataNodes)
+	for _, dn := range report.DataNodes {
+		lastHeartbeat := "never"
+		if dn.LastHeartbeat >= 0 {
+			lastHeartbeat = dn.LastHeartbeat.Round(time.Second).String() + " ago"
 		}
+		fmt.Printf("==> DataNode:%v:%v\tUUID:%v\tRack:%v\tState:%v\tCapacity:%v\tUsed:%v\tRemaining:%v\tBlocks:%v\tLastHeartbeat:%v\n",
+			dn.Host, dn.ServicePort, dn.Uuid, dn.Rack, dn.State, dn.Capacity, dn.Used, dn.Remaining, dn.Blocks, lastHeartbeat)
 	}
 }
@@ -0,0 +1,514 @@
+package namenode
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// AclEntryType ACL条目的类型
+type AclEntryType string
+
+const (
+	AclUser  AclEntryType = "user"
+	AclGroup AclEntryType = "group"
+	AclMask  AclEntryType = "mask"
+	AclOther AclEntryType = "other"
+)
+
+// setfacl的操作
+const (
+	AclActionModify        = "modify"        //添加或者修改条目
+	AclActionRemove        = "remove"        //删除条目
+	AclActionSet           = "set"           //用给定的条目替换整个ACL
+	AclActionRemoveAll     = "removeall"     //删除所有扩展条目和默认ACL，只保留权限位
+	AclActionRemoveDefault = "removedefault" //删除目录的默认ACL
+)
+
+const (
+	// fileCreateMode 上级目录有默认ACL时新建文件请求的权限，和默认ACL取交集
+	fileCreateMode uint32 = 0666
+	// directoryCreateMode 上级目录有默认ACL时新建目录请求的权限，和默认ACL取交集
+	directoryCreateMode uint32 = 0777
+)
+
+// AclEntry ACL条目，Name为空的user和group条目对应所有者和所属组；
+// Default为true的条目是目录的默认ACL，由目录下新建的文件和子目录继承
+type AclEntry struct {
+	Default    bool
+	Type       AclEntryType
+	Name       string
+	Permission FsAction
+}
+
+// AclStatus 文件或者目录完整的ACL，Entries包含所有者、所属组、mask和其他用户的条目
+type AclStatus struct {
+	Owner   string
+	Group   string
+	Sticky  bool
+	Entries []AclEntry
+}
+
+// NameNodeSetAclRequest 修改ACL请求，Action为remove时条目的权限被忽略
+type NameNodeSetAclRequest struct {
+	Path    string
+	Action  string
+	Entries []AclEntry
+	User    UserInfo
+}
+
+// String 按rwx格式输出权限，没有的权限为-
+func (action FsAction) String() string {
+	permission := []byte("rwx")
+	for i, bit := range []FsAction{ActionRead, ActionWrite, ActionExecute} {
+		if action&bit == 0 {
+			permission[i] = '-'
+		}
+	}
+	return string(permission)
+}
+
+// String 按setfacl的格式输出条目，如default:user:bob:rw-
+func (entry AclEntry) String() string {
+	prefix := ""
+	if entry.Default {
+		prefix = "default:"
+	}
+	return prefix + string(entry.Type) + ":" + entry.Name + ":" + entry.Permission.String()
+}
+
+// sameEntry 判断两个条目是否为同一个作用对象
+func (entry AclEntry) sameEntry(other AclEntry) bool {
+	return entry.Default == other.Default && entry.Type == other.Type && entry.Name == other.Name
+}
+
+// Effective 条目实际生效的权限，命名用户、所属组和命名组的权限受同一作用域的mask限制
+func (status AclStatus) Effective(entry AclEntry) FsAction {
+	if entry.Type == AclMask || entry.Type == AclOther || (entry.Type == AclUser && entry.Name == "") {
+		return entry.Permission
+	}
+	for _, mask := range status.Entries {
+		if mask.Type == AclMask && mask.Default == entry.Default {
+			return entry.Permission & mask.Permission
+		}
+	}
+	return entry.Permission
+}
+
+// ParseAclSpec 解析逗号分隔的ACL条目，格式为[default:]user|group|mask|other:[name][:rwx]，
+// withPermission为false时（删除条目）可以省略权限
+func ParseAclSpec(spec string, withPermission bool) ([]AclEntry, error) {
+	var entries []AclEntry
+	for _, text := range strings.Split(spec, ",") {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		var entry AclEntry
+		if strings.HasPrefix(text, "default:") {
+			entry.Default = true
+			text = strings.TrimPrefix(text, "default:")
+		}
+		fields := strings.Split(text, ":")
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, errors.New("ACL条目格式错误: " + text)
+		}
+		entry.Type = AclEntryType(fields[0])
+		entry.Name = fields[1]
+		switch entry.Type {
+		case AclUser, AclGroup:
+		case AclMask, AclOther:
+			if entry.Name != "" {
+				return nil, errors.New("mask和other条目不能指定名称: " + text)
+			}
+		default:
+			return nil, errors.New("ACL条目类型错误: " + text)
+		}
+		if len(fields) == 3 {
+			permission, err := parseFsAction(fields[2])
+			if err != nil {
+				return nil, err
+			}
+			entry.Permission = permission
+		} else if withPermission {
+			return nil, errors.New("ACL条目缺少权限: " + text)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("ACL条目不能为空")
+	}
+	return entries, nil
+}
+
+// parseFsAction 解析rwx格式的权限
+func parseFsAction(text string) (FsAction, error) {
+	if len(text) != 3 {
+		return 0, errors.New("权限格式错误: " + text)
+	}
+	var action FsAction
+	for i, bit := range []FsAction{ActionRead, ActionWrite, ActionExecute} {
+		switch text[i] {
+		case "rwx"[i]:
+			action |= bit
+		case '-':
+		default:
+			return 0, errors.New("权限格式错误: " + text)
+		}
+	}
+	return action, nil
+}
+
+// aclAllows 检查用户的权限：所有者使用所有者的权限位；命名用户使用条目的权限；属于所属组或者命名组时，
+// 任一匹配的条目有权限即可；都不匹配时使用其他用户的权限位。有扩展条目时权限位中的组权限为mask，限制命名用户和所有组条目
+func aclAllows(user UserInfo, permission Permission, acl []AclEntry, access FsAction) bool {
+	mode := permission.Mode
+	if user.Name == permission.Owner {
+		return FsAction(mode>>6)&access == access
+	}
+	mask := FsAction(mode >> 3 & 7)
+	extended := false
+	for _, entry := range acl {
+		if entry.Default {
+			continue
+		}
+		extended = true
+		if entry.Type == AclUser && entry.Name == user.Name {
+			return entry.Permission&mask&access == access
+		}
+	}
+	if !extended {
+		if inGroup(user, permission.Group) {
+			return mask&access == access
+		}
+		return FsAction(mode)&access == access
+	}
+	groupMatched := false
+	for _, entry := range acl {
+		if entry.Default || entry.Type != AclGroup {
+			continue
+		}
+		group := entry.Name
+		if group == "" {
+			group = permission.Group
+		}
+		if inGroup(user, group) {
+			groupMatched = true
+			if entry.Permission&mask&access == access {
+				return true
+			}
+		}
+	}
+	if groupMatched {
+		return false
+	}
+	return FsAction(mode)&access == access
+}
+
+// aclStatus 由权限位和记录的扩展条目得到完整的ACL
+func aclStatus(permission Permission, acl []AclEntry) AclStatus {
+	mode := permission.Mode
+	entries := []AclEntry{
+		{Type: AclUser, Permission: FsAction(mode >> 6 & 7)},
+		{Type: AclGroup, Permission: FsAction(mode >> 3 & 7)},
+		{Type: AclOther, Permission: FsAction(mode & 7)},
+	}
+	extended := false
+	for _, entry := range acl {
+		if !entry.Default {
+			if !extended {
+				extended = true
+				entries = append(entries, AclEntry{Type: AclMask, Permission: FsAction(mode >> 3 & 7)})
+			}
+			if entry.Type == AclGroup && entry.Name == "" {
+				entries[1] = entry
+				continue
+			}
+		}
+		entries = append(entries, entry)
+	}
+	sortAclEntries(entries)
+	return AclStatus{Owner: permission.Owner, Group: permission.Group, Sticky: mode&StickyBit != 0, Entries: entries}
+}
+
+// sortAclEntries 按默认ACL在后，所有者、命名用户、所属组、命名组、mask、其他用户的顺序排序
+func sortAclEntries(entries []AclEntry) {
+	rank := func(entry AclEntry) int {
+		order := 0
+		switch {
+		case entry.Type == AclUser && entry.Name == "":
+			order = 0
+		case entry.Type == AclUser:
+			order = 1
+		case entry.Type == AclGroup && entry.Name == "":
+			order = 2
+		case entry.Type == AclGroup:
+			order = 3
+		case entry.Type == AclMask:
+			order = 4
+		default:
+			order = 5
+		}
+		if entry.Default {
+			order += 6
+		}
+		return order
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if rank(entries[i]) != rank(entries[j]) {
+			return rank(entries[i]) < rank(entries[j])
+		}
+		return entries[i].Name < entries[j].Name
+	})
+}
+
+// applyAclAction 对完整的ACL执行setfacl操作，返回新的完整ACL
+func applyAclAction(entries []AclEntry, action string, spec []AclEntry) ([]AclEntry, error) {
+	switch action {
+	case AclActionModify:
+		for _, entry := range spec {
+			entries = removeAclEntry(entries, entry)
+			entries = append(entries, entry)
+		}
+	case AclActionRemove:
+		for _, entry := range spec {
+			if !entry.Default && entry.Name == "" && entry.Type != AclMask {
+				return nil, errors.New("不能删除所有者、所属组和其他用户的条目")
+			}
+			entries = removeAclEntry(entries, entry)
+		}
+	case AclActionSet:
+		entries = append([]AclEntry{}, spec...)
+	case AclActionRemoveAll:
+		// 所属组的权限位恢复为所属组条目的权限
+		var base []AclEntry
+		for _, entry := range entries {
+			if !entry.Default && entry.Name == "" && entry.Type != AclMask {
+				base = append(base, entry)
+			}
+		}
+		entries = base
+	case AclActionRemoveDefault:
+		var access []AclEntry
+		for _, entry := range entries {
+			if !entry.Default {
+				access = append(access, entry)
+			}
+		}
+		entries = access
+	default:
+		return nil, errors.New("不支持的ACL操作: " + action)
+	}
+	return normalizeAcl(entries, spec, action)
+}
+
+// removeAclEntry 删除作用对象相同的条目
+func removeAclEntry(entries []AclEntry, target AclEntry) []AclEntry {
+	var remaining []AclEntry
+	for _, entry := range entries {
+		if !entry.sameEntry(target) {
+			remaining = append(remaining, entry)
+		}
+	}
+	return remaining
+}
+
+// normalizeAcl 检查ACL的完整性：访问ACL必须有所有者、所属组和其他用户的条目，默认ACL缺少时从访问ACL复制；
+// 有命名条目并且没有在spec中指定mask时，mask重新计算为命名用户、所属组和命名组权限的并集
+func normalizeAcl(entries []AclEntry, spec []AclEntry, action string) ([]AclEntry, error) {
+	var result []AclEntry
+	for _, isDefault := range []bool{false, true} {
+		var scope []AclEntry
+		for _, entry := range entries {
+			if entry.Default == isDefault {
+				for _, existing := range scope {
+					if existing.sameEntry(entry) {
+						return nil, errors.New("ACL条目重复: " + entry.String())
+					}
+				}
+				scope = append(scope, entry)
+			}
+		}
+		if isDefault && len(scope) == 0 {
+			break
+		}
+		for _, base := range []AclEntryType{AclUser, AclGroup, AclOther} {
+			if findAclEntry(scope, AclEntry{Default: isDefault, Type: base}) >= 0 {
+				continue
+			}
+			index := findAclEntry(result, AclEntry{Type: base})
+			if !isDefault || index < 0 {
+				return nil, errors.New("ACL缺少" + string(base) + "条目")
+			}
+			inherited := result[index]
+			inherited.Default = true
+			scope = append(scope, inherited)
+		}
+		named := false
+		var union FsAction
+		for _, entry := range scope {
+			if entry.Type == AclGroup || (entry.Type == AclUser && entry.Name != "") {
+				union |= entry.Permission
+				named = named || entry.Name != ""
+			}
+		}
+		maskIndex := findAclEntry(scope, AclEntry{Default: isDefault, Type: AclMask})
+		switch {
+		case action != AclActionRemove && findAclEntry(spec, AclEntry{Default: isDefault, Type: AclMask}) >= 0:
+			// 使用spec中指定的mask
+		case !named:
+			// 没有命名条目时不需要mask
+			if maskIndex >= 0 {
+				scope = append(scope[:maskIndex], scope[maskIndex+1:]...)
+			}
+		case maskIndex >= 0:
+			scope[maskIndex].Permission = union
+		default:
+			scope = append(scope, AclEntry{Default: isDefault, Type: AclMask, Permission: union})
+		}
+		result = append(result, scope...)
+	}
+	sortAclEntries(result)
+	return result, nil
+}
+
+// findAclEntry 查找作用对象相同的条目，没有时返回-1
+func findAclEntry(entries []AclEntry, target AclEntry) int {
+	for i, entry := range entries {
+		if entry.sameEntry(target) {
+			return i
+		}
+	}
+	return -1
+}
+
+// splitAcl 把完整的ACL拆分为权限位和需要记录的扩展条目，有扩展条目时权限位中的组权限为mask
+func splitAcl(permission Permission, entries []AclEntry) (Permission, []AclEntry) {
+	var acl []AclEntry
+	var user, group, other, mask FsAction
+	hasMask := false
+	for _, entry := range entries {
+		if entry.Default {
+			acl = append(acl, entry)
+			continue
+		}
+		switch {
+		case entry.Type == AclUser && entry.Name == "":
+			user = entry.Permission
+		case entry.Type == AclGroup && entry.Name == "":
+			group = entry.Permission
+		case entry.Type == AclOther:
+			other = entry.Permission
+		case entry.Type == AclMask:
+			mask, hasMask = entry.Permission, true
+		default:
+			acl = append(acl, entry)
+		}
+	}
+	if hasMask {
+		acl = append(acl, AclEntry{Type: AclGroup, Permission: group})
+		group = mask
+	}
+	sortAclEntries(acl)
+	permission.Mode = permission.Mode&StickyBit | uint32(user)<<6 | uint32(group)<<3 | uint32(other)
+	return permission, acl
+}
+
+// inheritPermission 记录新建路径的权限，所有者为创建的用户，所属组继承上级目录；上级目录有默认ACL时，
+// 路径的ACL由默认ACL和新建请求的权限取交集得到，新建的目录同时继承默认ACL
+func (nameNode *Service) inheritPermission(user UserInfo, path string, mode uint32) {
+	parent := nameNode.recordedPath(parentDirectory(path))
+	permission := Permission{Owner: user.Name, Group: nameNode.permissionOf(parent).Group, Mode: mode}
+	var defaults []AclEntry
+	for _, entry := range nameNode.PathToAcl[parent] {
+		if entry.Default {
+			defaults = append(defaults, entry)
+		}
+	}
+	if len(defaults) == 0 {
+		nameNode.PathToPermission[path] = permission
+		return
+	}
+	directory := strings.HasSuffix(path, "/")
+	createMode := fileCreateMode
+	if directory {
+		createMode = directoryCreateMode
+	}
+	var entries []AclEntry
+	for _, entry := range defaults {
+		entry.Default = false
+		switch entry.Type {
+		case AclUser:
+			if entry.Name == "" {
+				entry.Permission &= FsAction(createMode >> 6 & 7)
+			}
+		case AclGroup:
+			if entry.Name == "" && findAclEntry(defaults, AclEntry{Default: true, Type: AclMask}) < 0 {
+				entry.Permission &= FsAction(createMode >> 3 & 7)
+			}
+		case AclMask:
+			entry.Permission &= FsAction(createMode >> 3 & 7)
+		case AclOther:
+			entry.Permission &= FsAction(createMode & 7)
+		}
+		entries = append(entries, entry)
+	}
+	if directory {
+		entries = append(entries, defaults...)
+	}
+	permission, acl := splitAcl(permission, entries)
+	nameNode.PathToPermission[path] = permission
+	if len(acl) > 0 {
+		nameNode.PathToAcl[path] = acl
+	}
+}
+
+// SetAcl 修改文件或者目录的ACL，只有所有者和超级用户可以修改，只有目录可以设置默认ACL
+func (nameNode *Service) SetAcl(request *NameNodeSetAclRequest, reply *bool) error {
+	nameNode.lock()
+	defer nameNode.unlock()
+	if err := nameNode.checkNotInSafeMode(); err != nil {
+		return err
+	}
+	path := request.Path
+	if isRoot(path) {
+		path = "/"
+	}
+	err := nameNode.checkOwner(request.User, path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range request.Entries {
+		if entry.Default && !strings.HasSuffix(path, "/") {
+			return errors.New("只有目录可以设置默认ACL")
+		}
+	}
+	permission := nameNode.permissionOf(path)
+	status := aclStatus(permission, nameNode.PathToAcl[path])
+	entries, err := applyAclAction(status.Entries, request.Action, request.Entries)
+	if err != nil {
+		return err
+	}
+	permission, acl := splitAcl(permission, entries)
+	nameNode.PathToPermission[path] = permission
+	if len(acl) > 0 {
+		nameNode.PathToAcl[path] = acl
+	} else {
+		delete(nameNode.PathToAcl, path)
+	}
+	nameNode.chargeQuota(path)
+	*reply = true
+	return nil
+}
+
+// GetAcl 获取文件或者目录完整的ACL
+func (nameNode *Service) GetAcl(request string, reply *AclStatus) error {
+	nameNode.rLock()
+	defer nameNode.rUnlock()
+	if !nameNode.pathExists(request) {
+		return errors.New("路径不存在")
+	}
+	recorded := nameNode.recordedPath(request)
+	*reply = aclStatus(nameNode.permissionOf(recorded), nameNode.PathToAcl[recorded])
+	return nil
+}
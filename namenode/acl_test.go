@@ -0,0 +1,109 @@
+package namenode
+
+import (
+	"github.com/liuzongzhou/GoDFS/datanode"
+	"github.com/liuzongzhou/GoDFS/util"
+	"testing"
+)
+
+// TestNameNodeServiceAcl 测试命名用户和命名组条目的权限检查、mask的限制，以及删除扩展条目后恢复权限位
+func TestNameNodeServiceAcl(t *testing.T) {
+	testNameNodeService := newTestService("1234", "4321")
+	alice := UserInfo{Name: "alice"}
+	bob := UserInfo{Name: "bob"}
+	carol := UserInfo{Name: "carol", Groups: []string{"dev"}}
+	var metadata []NameNodeMetaData
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/alice/", FileName: "foo", FileSize: 8, User: alice}, &metadata))
+	var ok bool
+	util.Check(testNameNodeService.SetPermission(&NameNodeSetPermissionRequest{Path: "/alice/foo", Mode: 0600, User: alice}, &ok))
+
+	entries, err := ParseAclSpec("user:bob:rw-,group:dev:r--", true)
+	util.Check(err)
+	if err := testNameNodeService.SetAcl(&NameNodeSetAclRequest{Path: "/alice/foo", Action: AclActionModify, Entries: entries, User: bob}, &ok); err == nil {
+		t.Errorf("Only owner should set ACL")
+	}
+	util.Check(testNameNodeService.SetAcl(&NameNodeSetAclRequest{Path: "/alice/foo", Action: AclActionModify, Entries: entries, User: alice}, &ok))
+	if !testNameNodeService.hasAccess(bob, "/alice/foo", ActionRead|ActionWrite) || !testNameNodeService.hasAccess(carol, "/alice/foo", ActionRead) {
+		t.Errorf("Named entries should grant access")
+	}
+	if testNameNodeService.hasAccess(carol, "/alice/foo", ActionWrite) || testNameNodeService.hasAccess(UserInfo{Name: "dave"}, "/alice/foo", ActionRead) {
+		t.Errorf("ACL should not grant access beyond entries")
+	}
+	// mask为命名条目和所属组权限的并集，权限位中的组权限为mask
+	var status AclStatus
+	util.Check(testNameNodeService.GetAcl("/alice/foo", &status))
+	if len(status.Entries) != 6 || status.Entries[5].String() != "other::---" || testNameNodeService.PathToPermission["/alice/foo"].Mode != 0660 {
+		t.Fatalf("Unable to get ACL: %+v", status)
+	}
+
+	// chmod修改mask，限制命名用户的权限
+	util.Check(testNameNodeService.SetPermission(&NameNodeSetPermissionRequest{Path: "/alice/foo", Mode: 0640, User: alice}, &ok))
+	if testNameNodeService.hasAccess(bob, "/alice/foo", ActionWrite) || !testNameNodeService.hasAccess(bob, "/alice/foo", ActionRead) {
+		t.Errorf("Mask should limit named user")
+	}
+	util.Check(testNameNodeService.GetAcl("/alice/foo", &status))
+	if status.Effective(status.Entries[1]) != ActionRead {
+		t.Errorf("Unable to get effective permission: %+v", status)
+	}
+
+	entries, err = ParseAclSpec("user:bob", false)
+	util.Check(err)
+	util.Check(testNameNodeService.SetAcl(&NameNodeSetAclRequest{Path: "/alice/foo", Action: AclActionRemove, Entries: entries, User: alice}, &ok))
+	if testNameNodeService.hasAccess(bob, "/alice/foo", ActionRead) {
+		t.Errorf("Removed entry should not grant access")
+	}
+	// 删除所有扩展条目后，组权限恢复为所属组条目的权限
+	util.Check(testNameNodeService.SetAcl(&NameNodeSetAclRequest{Path: "/alice/foo", Action: AclActionRemoveAll, User: alice}, &ok))
+	if _, ok := testNameNodeService.PathToAcl["/alice/foo"]; ok || testNameNodeService.PathToPermission["/alice/foo"].Mode != 0600 {
+		t.Errorf("Unable to remove ACL: %+v", testNameNodeService.PathToPermission["/alice/foo"])
+	}
+	if err := testNameNodeService.SetAcl(&NameNodeSetAclRequest{Path: "/alice/foo", Action: AclActionModify, Entries: []AclEntry{{Default: true, Type: AclUser, Name: "bob", Permission: ActionRead}}, User: alice}, &ok); err == nil {
+		t.Errorf("File should not have default ACL")
+	}
+}
+
+// TestNameNodeServiceDefaultAcl 测试目录的默认ACL由新建的文件和子目录继承，并随重命名迁移
+func TestNameNodeServiceDefaultAcl(t *testing.T) {
+	testNameNodeService := newTestService("1234", "4321")
+	alice := UserInfo{Name: "alice"}
+	bob := UserInfo{Name: "bob"}
+	var ok bool
+	util.Check(testNameNodeService.Mkdir(&NameNodeMkdirRequest{RemoteFilePath: "/shared/", User: alice}, &ok))
+	entries, err := ParseAclSpec("default:user:bob:rwx,default:other::---", true)
+	util.Check(err)
+	util.Check(testNameNodeService.SetAcl(&NameNodeSetAclRequest{Path: "/shared/", Action: AclActionModify, Entries: entries, User: alice}, &ok))
+	if testNameNodeService.hasAccess(bob, "/shared/", ActionWrite) {
+		t.Errorf("Default ACL should not apply to the directory itself")
+	}
+
+	// 新建的子目录继承默认ACL，其中的文件再继承一次，文件没有执行权限
+	util.Check(testNameNodeService.Mkdir(&NameNodeMkdirRequest{RemoteFilePath: "/shared/sub/", User: alice}, &ok))
+	var metadata []NameNodeMetaData
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/shared/sub/", FileName: "foo", FileSize: 8, User: bob}, &metadata))
+	var status AclStatus
+	util.Check(testNameNodeService.GetAcl("/shared/sub/foo", &status))
+	if status.Owner != "bob" || testNameNodeService.PathToPermission["/shared/sub/foo"].Mode != 0660 {
+		t.Errorf("Unable to inherit default ACL: %+v %+v", status, testNameNodeService.PathToPermission["/shared/sub/foo"])
+	}
+	for _, entry := range status.Entries {
+		if entry.Default {
+			t.Errorf("File should not inherit default ACL: %v", entry)
+		}
+	}
+	if testNameNodeService.hasAccess(UserInfo{Name: "dave"}, "/shared/sub/foo", ActionRead) {
+		t.Errorf("Default other entry should be inherited")
+	}
+	util.Check(testNameNodeService.GetAcl("/shared/sub/", &status))
+	if status.Entries[1].String() != "user:bob:rwx" || status.Entries[len(status.Entries)-1].String() != "default:other::---" {
+		t.Errorf("Directory should inherit default ACL: %v", status.Entries)
+	}
+
+	util.Check(testNameNodeService.SetAcl(&NameNodeSetAclRequest{Path: "/shared/", Action: AclActionRemoveDefault, User: alice}, &ok))
+	if _, ok := testNameNodeService.PathToAcl["/shared/"]; ok {
+		t.Errorf("Unable to remove default ACL")
+	}
+	util.Check(testNameNodeService.ReName(&NameNodeReNameRequest{ReNameSrcPath: "/shared/", ReNameDestPath: "/public/", User: alice}, &[]datanode.DataNodeInstance{}))
+	if _, ok := testNameNodeService.PathToAcl["/public/sub/foo"]; !ok {
+		t.Errorf("Unable to rename ACL")
+	}
+}
@@ -0,0 +1,128 @@
+package namenode
+
+import (
+	"github.com/liuzongzhou/GoDFS/audit"
+	"github.com/liuzongzhou/GoDFS/auth"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// unauditedOperations dataNode和备份nameNode的内部调用，不是命名空间操作，不写审计日志
+var unauditedOperations = map[string]bool{
+	"Service.RegisterDataNode":    true,
+	"Service.ProcessHeartbeat":    true,
+	"Service.ProcessBlockReport":  true,
+	"Service.BlockReceived":       true,
+	"Service.ReplicationnameNode": true,
+}
+
+// pathArgumentOperations 请求本身就是路径的操作
+var pathArgumentOperations = map[string]bool{
+	"Service.GetPermission":          true,
+	"Service.GetAcl":                 true,
+	"Service.GetErasureCodingPolicy": true,
+	"Service.GetEncryptionZone":      true,
+	"Service.GetContentSummary":      true,
+	"Service.ListSnapshots":          true,
+}
+
+// AuditObserver 得到写审计日志的rpc调用观察者，每个客户端调用写一条记录
+func AuditObserver(logger *audit.Logger) func(auth.CallInfo) {
+	return func(call auth.CallInfo) {
+		event, ok := auditEvent(call)
+		if !ok {
+			return
+		}
+		if err := logger.Log(event); err != nil {
+			log.Println("audit:", err)
+		}
+	}
+}
+
+// auditEvent 由rpc调用得到审计记录，内部调用返回false。请求中有User字段时使用其中的用户，
+// 权限检查失败和令牌过期的调用记为拒绝，其他错误仍记为允许，错误信息记录在Error中
+func auditEvent(call auth.CallInfo) (audit.Event, bool) {
+	if unauditedOperations[call.ServiceMethod] {
+		return audit.Event{}, false
+	}
+	event := audit.Event{
+		Time:          time.Now(),
+		User:          call.User,
+		ClientAddress: call.RemoteAddr,
+		Operation:     strings.TrimPrefix(call.ServiceMethod, "Service."),
+		Allowed:       !strings.HasPrefix(call.Error, permissionDeniedPrefix) && call.Error != auth.ErrTokenExpired.Error(),
+		Error:         call.Error,
+	}
+	if user, ok := requestUser(call.Request); ok && user.Name != "" {
+		event.User = user.Name
+	}
+	event.Src, event.Dst = auditPaths(call.ServiceMethod, call.Request)
+	return event, true
+}
+
+// requestUser 请求中的User字段
+func requestUser(request any) (UserInfo, bool) {
+	value := reflect.ValueOf(request)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return UserInfo{}, false
+	}
+	field := value.Elem().FieldByName("User")
+	if !field.IsValid() {
+		return UserInfo{}, false
+	}
+	user, ok := field.Interface().(UserInfo)
+	return user, ok
+}
+
+// auditPaths 请求涉及的源路径和目标路径，只有重命名有目标路径
+func auditPaths(serviceMethod string, request any) (src string, dst string) {
+	switch request := request.(type) {
+	case *NameNodeReadRequest:
+		return request.FileName, ""
+	case *NameNodeWriteRequest:
+		return request.RemoteFilePath + request.FileName, ""
+	case *NameNodeDeleteRequest:
+		return request.RemoteFilePath + request.FileName, ""
+	case *NameNodeReNameRequest:
+		return request.ReNameSrcPath, request.ReNameDestPath
+	case *NameNodeReNameFileRequest:
+		return request.ReNameSrcFileName, request.ReNameDestFileName
+	case *NameNodeListRequest:
+		return request.RemoteDirPath, ""
+	case *NameNodeMkdirRequest:
+		return request.RemoteFilePath, ""
+	case *NameNodeSetReplicationRequest:
+		return request.RemoteFilePath + request.FileName, ""
+	case *NameNodeSetPermissionRequest:
+		return request.Path, ""
+	case *NameNodeSetOwnerRequest:
+		return request.Path, ""
+	case *NameNodeCheckAccessRequest:
+		return request.Path, request.DestPath
+	case *NameNodeSetAclRequest:
+		return request.Path, ""
+	case *NameNodeConvertRequest:
+		return request.RemoteFilePath + request.FileName, ""
+	case *NameNodeSetErasureCodingPolicyRequest:
+		return request.RemoteFilePath, ""
+	case *NameNodeCreateEncryptionZoneRequest:
+		return request.RemoteFilePath, ""
+	case *NameNodeSetQuotaRequest:
+		return request.Path, ""
+	case *NameNodeClearQuotaRequest:
+		return request.Path, ""
+	case *NameNodeSnapshotRequest:
+		return request.Path, ""
+	case *NameNodeMoveToTrashRequest:
+		return request.RemoteFilePath + request.FileName, ""
+	case *NameNodeFsckRequest:
+		return request.Path, ""
+	case *string:
+		if pathArgumentOperations[serviceMethod] {
+			return *request, ""
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,55 @@
+package namenode
+
+import (
+	"github.com/liuzongzhou/GoDFS/auth"
+	"testing"
+)
+
+// TestAuditEvent 测试由rpc调用得到的审计记录：用户、路径、允许或拒绝，以及内部调用不记录
+func TestAuditEvent(t *testing.T) {
+	testNameNodeService := newTestService("1234", "4321")
+	alice := UserInfo{Name: "alice"}
+	bob := UserInfo{Name: "bob"}
+	var metadata []NameNodeMetaData
+	if err := testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/alice/", FileName: "foo", FileSize: 8, User: alice}, &metadata); err != nil {
+		t.Fatal(err)
+	}
+
+	var ok bool
+	request := &NameNodeReNameFileRequest{ReNameSrcFileName: "/alice/foo", ReNameDestFileName: "/alice/bar", User: bob}
+	err := testNameNodeService.ReNameFile(request, &ok)
+	if err == nil {
+		t.Fatal("Rename without permission should be denied")
+	}
+	event, audited := auditEvent(auth.CallInfo{ServiceMethod: "Service.ReNameFile", RemoteAddr: "127.0.0.1:50000", Request: request, Error: err.Error()})
+	if !audited || event.User != "bob" || event.Operation != "ReNameFile" || event.Src != "/alice/foo" || event.Dst != "/alice/bar" || event.Allowed || event.ClientAddress != "127.0.0.1:50000" {
+		t.Errorf("Unable to audit denied rename: %+v", event)
+	}
+
+	// 文件不存在等其他错误不是拒绝
+	deleteRequest := &NameNodeDeleteRequest{RemoteFilePath: "/alice/", FileName: "missing", User: alice}
+	event, _ = auditEvent(auth.CallInfo{ServiceMethod: "Service.DeleteFileNameMetaData", Request: deleteRequest, Error: "文件不存在"})
+	if event.Src != "/alice/missing" || !event.Allowed || event.Error == "" {
+		t.Errorf("Unable to audit failed delete: %+v", event)
+	}
+
+	// 请求中没有User字段时使用认证得到的用户，请求本身是路径的操作记录路径
+	path := "/alice/foo"
+	event, _ = auditEvent(auth.CallInfo{ServiceMethod: "Service.GetAcl", User: "carol", Request: &path})
+	if event.User != "carol" || event.Src != "/alice/foo" || !event.Allowed {
+		t.Errorf("Unable to audit path request: %+v", event)
+	}
+	action := "enter"
+	event, _ = auditEvent(auth.CallInfo{ServiceMethod: "Service.SafeMode", Request: &action})
+	if event.Src != "" {
+		t.Errorf("Non-path request should not be audited as path: %+v", event)
+	}
+	event, _ = auditEvent(auth.CallInfo{ServiceMethod: "Service.Mkdir", User: "carol", Request: &NameNodeMkdirRequest{RemoteFilePath: "/tmp/", User: UserInfo{Name: "carol"}}, Error: auth.ErrTokenExpired.Error()})
+	if event.Allowed {
+		t.Errorf("Expired token should be audited as denied: %+v", event)
+	}
+
+	if _, audited := auditEvent(auth.CallInfo{ServiceMethod: "Service.ProcessHeartbeat", Request: &HeartbeatRequest{}}); audited {
+		t.Errorf("Internal calls should not be audited")
+	}
+}
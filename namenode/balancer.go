@@ -0,0 +1,177 @@
+package namenode
+
+import (
+	"errors"
+	"github.com/liuzongzhou/GoDFS/datanode"
+	"log"
+	"sort"
+	"time"
+)
+
+// pendingMoveTimeout 移动命令发出后等待目标节点汇报的超时时间，超时后不再记录该移动
+const pendingMoveTimeout = pendingReplicationTimeout
+
+// pendingMove 已经发出复制命令、等待目标节点汇报的移动
+type pendingMove struct {
+	sourceDataNodeId uint64    //移出副本的源dataNode，目标节点汇报后优先删除该节点上的副本
+	deadline         time.Time //目标节点一直没有汇报时，超过该时间后不再记录
+}
+
+// DataNodeStorageReport dataNode的存储使用情况
+type DataNodeStorageReport struct {
+	DataNodeId uint64
+	DataNode   datanode.DataNodeInstance
+	Rack       string
+	Capacity   uint64 //心跳汇报的总容量，还没有收到心跳时为0
+	Remaining  uint64 //心跳汇报的剩余空间
+	Used       uint64 //根据块汇报统计的副本占用空间
+}
+
+// BlockLocation 块的大小和副本位置
+type BlockLocation struct {
+	BlockId     string
+	Size        uint64
+	DataNodeIds []uint64
+}
+
+// MoveBlockRequest 把块的一个副本从源dataNode移动到目标dataNode
+type MoveBlockRequest struct {
+	BlockId          string
+	SourceDataNodeId uint64
+	TargetDataNodeId uint64
+}
+
+// GetStorageReport 获取所有dataNode的存储使用情况，按dataNodeId排序
+func (nameNode *Service) GetStorageReport(request bool, reply *[]DataNodeStorageReport) error {
+	nameNode.rLock()
+	defer nameNode.rUnlock()
+	if !request {
+		return errors.New("获取存储使用情况失败")
+	}
+	for id, dn := range nameNode.IdToDataNodes {
+		stats := nameNode.DataNodeStats[id]
+		*reply = append(*reply, DataNodeStorageReport{
+			DataNodeId: id,
+			DataNode:   dn,
+			Rack:       nameNode.RackOf(id),
+			Capacity:   stats.Capacity,
+			Remaining:  stats.Remaining,
+			Used:       nameNode.DataNodeUsedSpace[id],
+		})
+	}
+	sort.Slice(*reply, func(i, j int) bool { return (*reply)[i].DataNodeId < (*reply)[j].DataNodeId })
+	return nil
+}
+
+// GetBlockLocations 获取指定dataNode上所有块的大小和副本位置，按BlockId排序
+func (nameNode *Service) GetBlockLocations(request uint64, reply *[]BlockLocation) error {
+	nameNode.rLock()
+	defer nameNode.rUnlock()
+	if _, ok := nameNode.IdToDataNodes[request]; !ok {
+		return errors.New("dataNode未注册")
+	}
+	for blockId, dataNodeIds := range nameNode.BlockToDataNodeIds {
+		if !containsDataNodeId(dataNodeIds, request) {
+			continue
+		}
+		locations := make([]uint64, len(dataNodeIds))
+		copy(locations, dataNodeIds)
+		*reply = append(*reply, BlockLocation{BlockId: blockId, Size: nameNode.blockSize(blockId), DataNodeIds: locations})
+	}
+	sort.Slice(*reply, func(i, j int) bool { return (*reply)[i].BlockId < (*reply)[j].BlockId })
+	return nil
+}
+
+// MoveBlock 移动块的一个副本：先由源dataNode把块复制到目标dataNode，目标dataNode汇报收到副本后，
+// 块的副本数超过副本因子，这时优先删除源dataNode上的副本，移动过程中副本数不会减少
+func (nameNode *Service) MoveBlock(request *MoveBlockRequest, reply *bool) error {
+	nameNode.lock()
+	defer nameNode.unlock()
+	if !nameNode.isPrimary() {
+		return errors.New("当前nameNode不是主节点")
+	}
+	if err := nameNode.checkNotInSafeMode(); err != nil {
+		return err
+	}
+	dataNodeIds, ok := nameNode.BlockToDataNodeIds[request.BlockId]
+	if !ok {
+		return errors.New("块不存在")
+	}
+	if !containsDataNodeId(dataNodeIds, request.SourceDataNodeId) {
+		return errors.New("源dataNode上没有该块的副本")
+	}
+	if containsDataNodeId(dataNodeIds, request.TargetDataNodeId) {
+		return errors.New("目标dataNode上已经有该块的副本")
+	}
+	if groupId, _, ok := nameNode.blockGroupOf(request.BlockId); ok && containsDataNodeId(nameNode.groupDataNodeIds(groupId), request.TargetDataNodeId) {
+		return errors.New("目标dataNode上已经有同一块组的内部块")
+	}
+	target, ok := nameNode.IdToDataNodes[request.TargetDataNodeId]
+	if !ok {
+		return errors.New("目标dataNode未注册")
+	}
+	if _, moving := nameNode.pendingMoves[request.BlockId]; moving {
+		return errors.New("块正在移动中")
+	}
+	nameNode.pendingMoves[request.BlockId] = pendingMove{
+		sourceDataNodeId: request.SourceDataNodeId,
+		deadline:         time.Now().Add(pendingMoveTimeout),
+	}
+	err := sendReplicateCommand(nameNode.IdToDataNodes[request.SourceDataNodeId], datanode.DataNodeReplicateRequest{
+		RemoteFilePath:  nameNode.blockRemoteFilePath(request.BlockId),
+		BlockId:         request.BlockId,
+		GenerationStamp: nameNode.BlockToGenerationStamp[request.BlockId],
+		Targets:         []datanode.DataNodeInstance{target},
+	})
+	if err != nil {
+		delete(nameNode.pendingMoves, request.BlockId)
+		return err
+	}
+	*reply = true
+	return nil
+}
+
+// expireMoves 移除等待汇报超时的移动：复制失败或者目标节点不可用时块可以重新移动，
+// 之后多出的副本也不再优先删除源节点上的
+func (nameNode *Service) expireMoves(now time.Time) {
+	for blockId, move := range nameNode.pendingMoves {
+		if now.After(move.deadline) {
+			log.Printf("Block %s move timed out\n", blockId)
+			delete(nameNode.pendingMoves, blockId)
+		}
+	}
+}
+
+// blockSize 根据块在文件中的位置计算块的大小，只有文件的最后一个块可能不满，纠删码的内部块大小为块组的分片大小
+func (nameNode *Service) blockSize(blockId string) uint64 {
+	if groupId, _, ok := nameNode.blockGroupOf(blockId); ok {
+		group := nameNode.BlockGroups[groupId]
+		dataUnits := uint64(erasureCodingPolicies[group.Policy].DataUnits)
+		return (group.Size + dataUnits - 1) / dataUnits
+	}
+	fileName, ok := nameNode.blockFileName(blockId)
+	blockIds := nameNode.FileNameToBlocks[fileName]
+	if !ok {
+		//正在转换的文件新布局的块，文件大小不变
+		var conversion Conversion
+		if fileName, conversion, ok = nameNode.conversionOf(blockId); !ok {
+			return 0
+		}
+		blockIds = conversion.Blocks
+	}
+	for i, id := range blockIds {
+		if id != blockId {
+			continue
+		}
+		offset := uint64(i) * nameNode.BlockSize
+		fileSize := nameNode.FileNameSize[fileName]
+		if fileSize <= offset {
+			return 0
+		}
+		if fileSize-offset < nameNode.BlockSize {
+			return fileSize - offset
+		}
+		return nameNode.BlockSize
+	}
+	return 0
+}
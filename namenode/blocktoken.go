@@ -0,0 +1,42 @@
+package namenode
+
+import (
+	"github.com/liuzongzhou/GoDFS/auth"
+	"log"
+)
+
+// SetBlockKey 设置与dataNode共享的密钥，之后返回给客户端的块元数据都携带块访问令牌
+func (nameNode *Service) SetBlockKey(key []byte) {
+	nameNode.blockKey = key
+}
+
+// blockToken 为remoteFilePath目录下的块签发访问令牌，没有设置密钥时返回空
+func (nameNode *Service) blockToken(blockIds []string, remoteFilePath string, mode auth.BlockAccessMode) string {
+	if len(nameNode.blockKey) == 0 {
+		return ""
+	}
+	token, err := auth.IssueBlockToken(nameNode.blockKey, blockIds, remoteFilePath, mode, auth.BlockTokenLifetime)
+	if err != nil {
+		log.Println(err)
+	}
+	return token
+}
+
+// attachBlockTokens 为每个块签发访问令牌，令牌绑定块所在的目录：BlockPath不为空时为BlockPath，否则为文件所在的目录remoteFilePath。
+// 纠删码块组的令牌包含按分片下标排列的所有内部块
+func (nameNode *Service) attachBlockTokens(metadata []NameNodeMetaData, remoteFilePath string, mode auth.BlockAccessMode) {
+	for i := range metadata {
+		blockIds := []string{metadata[i].BlockId}
+		if metadata[i].ECPolicy.DataUnits > 0 {
+			blockIds = nil
+			for index := range metadata[i].BlockAddresses {
+				blockIds = append(blockIds, InternalBlockId(metadata[i].BlockId, index))
+			}
+		}
+		blockPath := remoteFilePath
+		if metadata[i].BlockPath != "" {
+			blockPath = metadata[i].BlockPath
+		}
+		metadata[i].BlockToken = nameNode.blockToken(blockIds, blockPath, mode)
+	}
+}
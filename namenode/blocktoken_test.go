@@ -0,0 +1,63 @@
+package namenode
+
+import (
+	"github.com/liuzongzhou/GoDFS/auth"
+	"github.com/liuzongzhou/GoDFS/util"
+	"testing"
+)
+
+// TestNameNodeServiceBlockToken 测试写入和读取返回对应操作的块访问令牌，删除令牌需要在上级目录中删除文件的权限
+func TestNameNodeServiceBlockToken(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	testNameNodeService := newTestService("1234", "4321")
+	alice := UserInfo{Name: "alice"}
+	var metadata []NameNodeMetaData
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/alice/", FileName: "foo", FileSize: 8, User: alice}, &metadata))
+	if metadata[0].BlockToken != "" {
+		t.Errorf("Block token should be empty without block key")
+	}
+
+	testNameNodeService.SetBlockKey(key)
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/alice/", FileName: "bar", FileSize: 8, User: alice}, &metadata))
+	blockId := metadata[0].BlockId
+	if err := auth.VerifyBlockToken(key, metadata[0].BlockToken, blockId, "/alice/", auth.BlockAccessWrite); err != nil {
+		t.Errorf("Unable to issue write token: %v", err)
+	}
+	var readMetaData []NameNodeMetaData
+	util.Check(testNameNodeService.ReadData(&NameNodeReadRequest{FileName: "/alice/bar", User: alice}, &readMetaData))
+	if auth.VerifyBlockToken(key, readMetaData[0].BlockToken, blockId, "/alice/", auth.BlockAccessRead) != nil || auth.VerifyBlockToken(key, readMetaData[0].BlockToken, blockId, "/alice/", auth.BlockAccessWrite) == nil {
+		t.Errorf("Read token should only allow reading")
+	}
+	if auth.VerifyBlockToken(key, readMetaData[0].BlockToken, blockId, "/bob/", auth.BlockAccessRead) == nil {
+		t.Errorf("Read token should only allow the directory of the file")
+	}
+
+	var ok bool
+	util.Check(testNameNodeService.SetPermission(&NameNodeSetPermissionRequest{Path: "/alice/", Mode: 0755, User: alice}, &ok))
+	util.Check(testNameNodeService.SetPermission(&NameNodeSetPermissionRequest{Path: "/alice/bar", Mode: 0644, User: alice}, &ok))
+	if testNameNodeService.ReadData(&NameNodeReadRequest{FileName: "/alice/bar", User: UserInfo{Name: "bob"}, Delete: true}, &readMetaData) == nil {
+		t.Errorf("Delete token should require permission to delete the file")
+	}
+	readMetaData = nil
+	util.Check(testNameNodeService.ReadData(&NameNodeReadRequest{FileName: "/alice/bar", User: alice, Delete: true}, &readMetaData))
+	if err := auth.VerifyBlockToken(key, readMetaData[0].BlockToken, blockId, "/alice/", auth.BlockAccessDelete); err != nil {
+		t.Errorf("Unable to issue delete token: %v", err)
+	}
+}
+
+// TestNameNodeServiceBlockGroupToken 测试纠删码块组的令牌包含所有内部块
+func TestNameNodeServiceBlockGroupToken(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	testNameNodeService := newErasureCodingTestService()
+	testNameNodeService.SetBlockKey(key)
+	var metadata []NameNodeMetaData
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/Test1/", FileName: "foo", FileSize: 8}, &metadata))
+	for index := range metadata[0].BlockAddresses {
+		if err := auth.VerifyBlockToken(key, metadata[0].BlockToken, InternalBlockId(metadata[0].BlockId, index), "/Test1/", auth.BlockAccessWrite); err != nil {
+			t.Errorf("Unable to issue token for internal block %d: %v", index, err)
+		}
+	}
+	if auth.VerifyBlockToken(key, metadata[0].BlockToken, metadata[0].BlockId, "/Test1/", auth.BlockAccessWrite) == nil {
+		t.Errorf("Block group token should only contain internal blocks")
+	}
+}
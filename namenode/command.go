@@ -0,0 +1,75 @@
+package namenode
+
+import (
+	"github.com/liuzongzhou/GoDFS/auth"
+	"github.com/liuzongzhou/GoDFS/datanode"
+	"log"
+	"sync"
+	"time"
+)
+
+// dataNodeCommandTimeout 向dataNode发送命令时每次读写的超时时间，dataNode没有响应时放弃该命令
+const dataNodeCommandTimeout = 30 * time.Second
+
+// dataNodeCommand 持有命名空间写锁时记录的dataNode命令，释放锁之后才发送，没有响应的dataNode不会阻塞其他命名空间操作。
+// done不为nil时在命令发送完成后调用，succeeded表示dataNode执行成功
+type dataNodeCommand struct {
+	dataNode      datanode.DataNodeInstance
+	serviceMethod string
+	request       any
+	done          func(succeeded bool)
+}
+
+// queueCommand 记录发给dataNode的命令，需要持有命名空间写锁，unlock释放锁之后发送
+func (nameNode *Service) queueCommand(dataNode datanode.DataNodeInstance, serviceMethod string, request any, done func(bool)) {
+	nameNode.pendingCommands = append(nameNode.pendingCommands, dataNodeCommand{dataNode: dataNode, serviceMethod: serviceMethod, request: request, done: done})
+}
+
+// sendCommands 发送命令，同一个dataNode的命令按记录的顺序在一个连接上发送，不同dataNode并行发送，全部完成后返回
+func sendCommands(commands []dataNodeCommand) {
+	if len(commands) == 0 {
+		return
+	}
+	var addresses []string
+	addressToCommands := make(map[string][]dataNodeCommand)
+	for _, command := range commands {
+		address := command.dataNode.Host + ":" + command.dataNode.ServicePort
+		if _, ok := addressToCommands[address]; !ok {
+			addresses = append(addresses, address)
+		}
+		addressToCommands[address] = append(addressToCommands[address], command)
+	}
+	var wg sync.WaitGroup
+	for _, address := range addresses {
+		wg.Add(1)
+		go func(address string, commands []dataNodeCommand) {
+			defer wg.Done()
+			sendDataNodeCommands(address, commands)
+		}(address, addressToCommands[address])
+	}
+	wg.Wait()
+}
+
+// sendDataNodeCommands 在一个连接上依次向dataNode发送命令，失败时记录日志并继续
+func sendDataNodeCommands(address string, commands []dataNodeCommand) {
+	dataNodeInstance, rpcErr := auth.DialTimeout(address, dataNodeCommandTimeout)
+	if rpcErr != nil {
+		log.Println(rpcErr)
+	} else {
+		defer dataNodeInstance.Close()
+	}
+	for _, command := range commands {
+		succeeded := false
+		if dataNodeInstance != nil {
+			var reply datanode.DataNodeReplyStatus
+			callErr := dataNodeInstance.Call(command.serviceMethod, command.request, &reply)
+			if callErr != nil {
+				log.Println(callErr)
+			}
+			succeeded = callErr == nil && reply.Status
+		}
+		if command.done != nil {
+			command.done(succeeded)
+		}
+	}
+}
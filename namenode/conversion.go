@@ -0,0 +1,241 @@
+package namenode
+
+import (
+	"errors"
+	"github.com/liuzongzhou/GoDFS/auth"
+	"log"
+	"strings"
+	"time"
+)
+
+// conversionTimeout 开始转换后等待客户端完成或者放弃的时间，超时后放弃转换，删除新布局已经写入的块
+const conversionTimeout = 10 * time.Minute
+
+// Conversion 正在转换存储方式的文件：新布局的块不挂在任何文件下，完成转换时替换文件原来的块
+type Conversion struct {
+	Blocks      []string  //新布局的BlockId，纠删码为块组id
+	ECPolicy    string    //新布局的纠删码策略名，为空时为多副本存储
+	Replication uint64    //新布局为多副本存储时的副本因子
+	Deadline    time.Time //超过该时间还没有完成转换时放弃
+}
+
+// NameNodeConvertRequest 转换文件的存储方式请求，Policy为纠删码策略名或者replication
+type NameNodeConvertRequest struct {
+	RemoteFilePath    string
+	FileName          string
+	Policy            string
+	ReplicationFactor uint64 //转换为多副本存储时的副本因子，为0时继承目录的默认副本因子
+	ClientHost        string
+	User              UserInfo //开始、完成和放弃转换都需要文件的写权限
+}
+
+// ConvertFile 开始转换文件的存储方式：为文件按新的存储方式分配块，记录在FileNameToConversion中，返回新布局的元数据。
+// 客户端读出原来的块写入新布局后在conversionTimeout内调用CommitConversion完成转换，失败时调用AbortConversion放弃
+func (nameNode *Service) ConvertFile(request *NameNodeConvertRequest, reply *[]NameNodeMetaData) error {
+	nameNode.lock()
+	defer nameNode.unlock()
+	if !nameNode.isPrimary() {
+		return errors.New("当前nameNode不是主节点")
+	}
+	if err := nameNode.checkNotInSafeMode(); err != nil {
+		return err
+	}
+	fileName := request.RemoteFilePath + request.FileName
+	if _, ok := nameNode.FileNameToBlocks[fileName]; !ok {
+		return errors.New("文件不存在")
+	}
+	if err := nameNode.checkPermission(request.User, fileName, ActionWrite); err != nil {
+		return err
+	}
+	if _, ok := nameNode.FileNameToConversion[fileName]; ok {
+		return errors.New("文件正在转换中")
+	}
+	current, erasureCoded := nameNode.FileNameToECPolicy[fileName]
+	var ecPolicy ErasureCodingPolicy
+	replicationFactor := request.ReplicationFactor
+	if request.Policy == ReplicationPolicyName {
+		if !erasureCoded {
+			return errors.New("文件已经是多副本存储，修改副本因子请使用setrep")
+		}
+		erasureCoded = false
+		if replicationFactor == 0 {
+			replicationFactor = nameNode.directoryReplication(request.RemoteFilePath)
+		}
+	} else {
+		var ok bool
+		ecPolicy, ok = erasureCodingPolicies[request.Policy]
+		if !ok {
+			return errors.New("不支持的纠删码策略: " + request.Policy)
+		}
+		if erasureCoded && current == request.Policy {
+			return errors.New("文件已经使用纠删码策略" + request.Policy)
+		}
+		erasureCoded = true
+	}
+	var dataNodeIds []uint64
+	for id := range nameNode.IdToDataNodes {
+		dataNodeIds = append(dataNodeIds, id)
+	}
+	writableDataNodes := nameNode.writableDataNodes(dataNodeIds)
+	if len(writableDataNodes) == 0 || (erasureCoded && len(writableDataNodes) < ecPolicy.totalUnits()) {
+		return errors.New("可用的dataNode数量不足")
+	}
+	// 转换过程中新旧两种布局的块同时存在，新布局的块占用空间配额
+	space := nameNode.spaceConsumed(nameNode.FileNameSize[fileName], ecPolicy, erasureCoded, replicationFactor)
+	if err := nameNode.checkQuota(fileName, 0, space, ""); err != nil {
+		return err
+	}
+	metadata, err := nameNode.allocateLayout(nameNode.FileNameSize[fileName], ecPolicy, erasureCoded, replicationFactor, request.ClientHost)
+	if err != nil {
+		return err
+	}
+	nameNode.FileNameToConversion[fileName] = Conversion{
+		Blocks:      layoutBlockIds(metadata),
+		ECPolicy:    layoutPolicyName(ecPolicy, erasureCoded),
+		Replication: replicationFactor,
+		Deadline:    time.Now().Add(conversionTimeout),
+	}
+	nameNode.chargeQuota(fileName)
+	nameNode.attachBlockTokens(metadata, request.RemoteFilePath, auth.BlockAccessWrite)
+	*reply = metadata
+	return nil
+}
+
+// CommitConversion 完成转换：用新布局的块替换文件原来的块，同时切换存储方式，再通知dataNode删除原来的块
+func (nameNode *Service) CommitConversion(request *NameNodeConvertRequest, reply *bool) error {
+	nameNode.lock()
+	defer nameNode.unlock()
+	if !nameNode.isPrimary() {
+		return errors.New("当前nameNode不是主节点")
+	}
+	if err := nameNode.checkNotInSafeMode(); err != nil {
+		return err
+	}
+	fileName := request.RemoteFilePath + request.FileName
+	conversion, ok := nameNode.FileNameToConversion[fileName]
+	if !ok {
+		return errors.New("文件没有在转换中")
+	}
+	if err := nameNode.checkPermission(request.User, fileName, ActionWrite); err != nil {
+		return err
+	}
+	oldBlocks, ok := nameNode.FileNameToBlocks[fileName]
+	if !ok {
+		nameNode.abortConversion(fileName)
+		return errors.New("文件不存在")
+	}
+	delete(nameNode.FileNameToConversion, fileName)
+	// 目录有快照时先记录文件原来的布局，被快照引用的原来的块保留下来
+	nameNode.preserveFile(fileName)
+	oldBlocks = nameNode.retainSnapshotBlocks(fileName, oldBlocks)
+	// 先切换元数据，读请求立刻使用新布局，再删除原来的块
+	nameNode.setFileLayout(fileName, conversion.Blocks, conversion.ECPolicy, conversion.Replication)
+	nameNode.chargeQuota(fileName)
+	nameNode.discardBlocks(request.RemoteFilePath, oldBlocks)
+	*reply = true
+	return nil
+}
+
+// AbortConversion 放弃转换，删除新布局已经写入的块，文件保持原来的存储方式
+func (nameNode *Service) AbortConversion(request *NameNodeConvertRequest, reply *bool) error {
+	nameNode.lock()
+	defer nameNode.unlock()
+	if !nameNode.isPrimary() {
+		return errors.New("当前nameNode不是主节点")
+	}
+	if err := nameNode.checkNotInSafeMode(); err != nil {
+		return err
+	}
+	fileName := request.RemoteFilePath + request.FileName
+	if _, ok := nameNode.FileNameToConversion[fileName]; !ok {
+		return errors.New("文件没有在转换中")
+	}
+	if err := nameNode.checkPermission(request.User, fileName, ActionWrite); err != nil {
+		return err
+	}
+	nameNode.abortConversion(fileName)
+	*reply = true
+	return nil
+}
+
+// abortConversion 放弃文件的转换，删除新布局的块的元数据，并通知dataNode删除已经写入的块
+func (nameNode *Service) abortConversion(fileName string) {
+	conversion, ok := nameNode.FileNameToConversion[fileName]
+	if !ok {
+		return
+	}
+	delete(nameNode.FileNameToConversion, fileName)
+	nameNode.chargeQuota(fileName)
+	nameNode.discardBlocks(fileName[:strings.LastIndex(fileName, "/")+1], conversion.Blocks)
+}
+
+// cancelConversions 放弃path上的转换，path以/结尾时放弃目录下所有文件的转换。
+// 文件被覆盖、删除或者移动前调用，新布局的块还在原来的目录下
+func (nameNode *Service) cancelConversions(path string) {
+	for fileName := range nameNode.FileNameToConversion {
+		if fileName == path || (strings.HasSuffix(path, "/") && strings.HasPrefix(fileName, path)) {
+			nameNode.abortConversion(fileName)
+		}
+	}
+}
+
+// expireConversions 放弃超过期限还没有完成的转换，客户端异常退出时新布局的块不会一直留下
+func (nameNode *Service) expireConversions(now time.Time) {
+	for fileName, conversion := range nameNode.FileNameToConversion {
+		if now.After(conversion.Deadline) {
+			log.Printf("Conversion of %s timed out\n", fileName)
+			nameNode.abortConversion(fileName)
+		}
+	}
+}
+
+// conversionOf 根据BlockId查找块所属的转换，返回转换的文件名，纠删码的内部块按所属的块组查找
+func (nameNode *Service) conversionOf(blockId string) (string, Conversion, bool) {
+	if groupId, _, ok := nameNode.blockGroupOf(blockId); ok {
+		blockId = groupId
+	}
+	for fileName, conversion := range nameNode.FileNameToConversion {
+		for _, id := range conversion.Blocks {
+			if id == blockId {
+				return fileName, conversion, true
+			}
+		}
+	}
+	return "", Conversion{}, false
+}
+
+// discardFile 删除文件的元数据，并通知dataNode删除已经写入的块，被快照引用的块保留下来
+func (nameNode *Service) discardFile(fileName string) {
+	nameNode.cancelConversions(fileName)
+	nameNode.preserveFile(fileName)
+	blocks := nameNode.retainSnapshotBlocks(fileName, nameNode.FileNameToBlocks[fileName])
+	remoteFilePath := fileName[:strings.LastIndex(fileName, "/")+1]
+	nameNode.deleteFileBlocks(fileName)
+	delete(nameNode.FileNameSize, fileName)
+	delete(nameNode.FileNameToECPolicy, fileName)
+	delete(nameNode.FileNameToReplication, fileName)
+	delete(nameNode.FileNameToEncryption, fileName)
+	nameNode.deletePermission(fileName)
+	nameNode.chargeQuota(fileName)
+	nameNode.discardBlocks(remoteFilePath, blocks)
+}
+
+// discardBlocks 删除块的元数据，并通知所在的dataNode删除，纠删码块组删除所有内部块
+func (nameNode *Service) discardBlocks(remoteFilePath string, blockIds []string) {
+	for _, blockId := range blockIds {
+		internalBlockIds := []string{blockId}
+		if group, ok := nameNode.BlockGroups[blockId]; ok {
+			internalBlockIds = group.Blocks
+		}
+		locations := make(map[string][]uint64)
+		for _, internalBlockId := range internalBlockIds {
+			locations[internalBlockId] = nameNode.BlockToDataNodeIds[internalBlockId]
+		}
+		nameNode.deleteBlockMetaData(blockId)
+		for internalBlockId, dataNodeIds := range locations {
+			for _, dataNodeId := range dataNodeIds {
+				nameNode.invalidateBlock(dataNodeId, remoteFilePath, internalBlockId)
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package namenode
+
+import (
+	"github.com/liuzongzhou/GoDFS/datanode"
+	"github.com/liuzongzhou/GoDFS/util"
+	"testing"
+	"time"
+)
+
+// TestNameNodeServiceConvertFile 测试多副本文件转换为纠删码后切换文件的块，原来的块元数据被删除
+func TestNameNodeServiceConvertFile(t *testing.T) {
+	testNameNodeService := newErasureCodingTestService()
+	var written []NameNodeMetaData
+	err := testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/Test2/", FileName: "foo", FileSize: 8}, &written)
+	util.Check(err)
+	oldBlockId := written[0].BlockId
+
+	request := NameNodeConvertRequest{RemoteFilePath: "/Test2/", FileName: "foo", Policy: "RS-3-2"}
+	var reply []NameNodeMetaData
+	err = testNameNodeService.ConvertFile(&request, &reply)
+	util.Check(err)
+	if len(reply) != 1 || reply[0].ECPolicy.Name != "RS-3-2" || reply[0].GroupSize != 8 {
+		t.Fatalf("Unable to allocate new layout: %v", reply)
+	}
+	// 转换完成前读请求仍然使用原来的块
+	if testNameNodeService.FileNameToBlocks["/Test2/foo"][0] != oldBlockId {
+		t.Errorf("File should keep old blocks before commit")
+	}
+	if err = testNameNodeService.ConvertFile(&request, &reply); err == nil {
+		t.Errorf("Converting file twice should fail")
+	}
+
+	var ok bool
+	util.Check(testNameNodeService.CommitConversion(&request, &ok))
+	if blocks := testNameNodeService.FileNameToBlocks["/Test2/foo"]; len(blocks) != 1 || blocks[0] != reply[0].BlockId {
+		t.Errorf("Unable to swap block list")
+	}
+	if _, exists := testNameNodeService.BlockToDataNodeIds[oldBlockId]; exists {
+		t.Errorf("Unable to delete old blocks")
+	}
+	if testNameNodeService.FileNameToECPolicy["/Test2/foo"] != "RS-3-2" {
+		t.Errorf("Unable to switch erasure coding policy")
+	}
+	if _, exists := testNameNodeService.FileNameToReplication["/Test2/foo"]; exists {
+		t.Errorf("Erasure coded file should not have replication factor")
+	}
+	if _, exists := testNameNodeService.FileNameToConversion["/Test2/foo"]; exists {
+		t.Errorf("Unable to remove finished conversion")
+	}
+}
+
+// TestNameNodeServiceCancelConversion 测试转换超时或者文件被重命名后放弃转换，与转换无关的同名文件不受影响
+func TestNameNodeServiceCancelConversion(t *testing.T) {
+	testNameNodeService := newErasureCodingTestService()
+	var written []NameNodeMetaData
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/Test2/", FileName: "foo", FileSize: 8}, &written))
+	var userFile []NameNodeMetaData
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/Test2/", FileName: "foo.converting", FileSize: 8}, &userFile))
+
+	request := NameNodeConvertRequest{RemoteFilePath: "/Test2/", FileName: "foo", Policy: "RS-3-2"}
+	var reply []NameNodeMetaData
+	util.Check(testNameNodeService.ConvertFile(&request, &reply))
+	if _, exists := testNameNodeService.FileNameToBlocks["/Test2/foo.converting"]; !exists {
+		t.Fatalf("Conversion should not touch user file foo.converting")
+	}
+	testNameNodeService.expireConversions(time.Now())
+	if _, exists := testNameNodeService.FileNameToConversion["/Test2/foo"]; !exists {
+		t.Fatalf("Conversion should not expire before deadline")
+	}
+	testNameNodeService.expireConversions(time.Now().Add(conversionTimeout * 2))
+	if _, exists := testNameNodeService.FileNameToConversion["/Test2/foo"]; exists {
+		t.Errorf("Unable to expire conversion")
+	}
+	if _, exists := testNameNodeService.BlockGroups[reply[0].BlockId]; exists {
+		t.Errorf("Unable to delete blocks of expired conversion")
+	}
+
+	// 重命名上级目录后放弃转换，完成转换失败
+	reply = nil
+	util.Check(testNameNodeService.ConvertFile(&request, &reply))
+	var dataNodes []datanode.DataNodeInstance
+	util.Check(testNameNodeService.ReName(&NameNodeReNameRequest{ReNameSrcPath: "/Test2/", ReNameDestPath: "/Test3/"}, &dataNodes))
+	if _, exists := testNameNodeService.BlockGroups[reply[0].BlockId]; exists {
+		t.Errorf("Unable to delete blocks of cancelled conversion")
+	}
+	var ok bool
+	if err := testNameNodeService.CommitConversion(&NameNodeConvertRequest{RemoteFilePath: "/Test3/", FileName: "foo"}, &ok); err == nil {
+		t.Errorf("Commit after rename should fail")
+	}
+	if blocks := testNameNodeService.FileNameToBlocks["/Test3/foo.converting"]; len(blocks) != len(userFile) || blocks[0] != userFile[0].BlockId {
+		t.Errorf("User file foo.converting should keep its blocks: %v", blocks)
+	}
+}
+
+// TestNameNodeServiceAbortConversion 测试放弃转换后文件保持原来的存储方式
+func TestNameNodeServiceAbortConversion(t *testing.T) {
+	testNameNodeService := newErasureCodingTestService()
+	var written []NameNodeMetaData
+	err := testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/Test1/", FileName: "foo", FileSize: 8}, &written)
+	util.Check(err)
+
+	request := NameNodeConvertRequest{RemoteFilePath: "/Test1/", FileName: "foo", Policy: "RS-3-2"}
+	var reply []NameNodeMetaData
+	if err = testNameNodeService.ConvertFile(&request, &reply); err == nil {
+		t.Errorf("Converting to the same policy should fail")
+	}
+	request.Policy = ReplicationPolicyName
+	request.ReplicationFactor = 3
+	util.Check(testNameNodeService.ConvertFile(&request, &reply))
+	if len(reply) != 2 || len(reply[0].BlockAddresses) != 3 || reply[0].ECPolicy.DataUnits != 0 {
+		t.Fatalf("Unable to allocate replicated layout: %v", reply)
+	}
+
+	var ok bool
+	util.Check(testNameNodeService.AbortConversion(&request, &ok))
+	if _, exists := testNameNodeService.BlockToDataNodeIds[reply[0].BlockId]; exists {
+		t.Errorf("Unable to delete blocks of aborted conversion")
+	}
+	if blocks := testNameNodeService.FileNameToBlocks["/Test1/foo"]; len(blocks) != 1 || blocks[0] != written[0].BlockId {
+		t.Errorf("File should keep old blocks after abort")
+	}
+	if err = testNameNodeService.CommitConversion(&request, &ok); err == nil {
+		t.Errorf("Commit without conversion should fail")
+	}
+}
@@ -0,0 +1,174 @@
+package namenode
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// dataNode的管理状态
+const (
+	AdminStateNormal                 = "normal"                   //正常提供服务
+	AdminStateDecommissionInProgress = "decommission-in-progress" //下线中，不再写入新块，上面的块正在复制到其他节点
+	AdminStateDecommissioned         = "decommissioned"           //已下线，上面的块在其他节点都有足够的副本，可以安全移除
+	AdminStateInMaintenance          = "in-maintenance"           //维护中，不再写入新块，短暂不可用时不重新复制上面的块
+)
+
+// DataNodeAdminState dataNode的管理状态
+type DataNodeAdminState struct {
+	State             string
+	MaintenanceExpiry time.Time //维护模式的截止时间，超时后恢复正常
+}
+
+// DataNodeAdminRequest 修改dataNode管理状态的请求
+type DataNodeAdminRequest struct {
+	DataNode            string        //host:port
+	State               string        //AdminStateNormal、AdminStateDecommissionInProgress或者AdminStateInMaintenance
+	MaintenanceDuration time.Duration //维护模式的时长
+}
+
+// SetDataNodeAdminState 修改dataNode的管理状态：
+// 下线时把节点上的块加入待复制队列，所有块在其他节点上都有足够的副本后变为已下线；
+// 进入维护模式时节点上的副本仍然计入副本数，维护期间节点dead不会重新复制；恢复正常时删除多余的副本
+func (nameNode *Service) SetDataNodeAdminState(request *DataNodeAdminRequest, reply *bool) error {
+	nameNode.lock()
+	defer nameNode.unlock()
+	if !nameNode.isPrimary() {
+		return errors.New("当前nameNode不是主节点")
+	}
+	dataNodeId, ok := nameNode.dataNodeIdOf(request.DataNode)
+	if !ok {
+		return errors.New("dataNode未注册")
+	}
+	switch request.State {
+	case AdminStateNormal:
+		delete(nameNode.DataNodeAdminStates, request.DataNode)
+		for _, blockId := range nameNode.blocksOnDataNode(dataNodeId) {
+			nameNode.removeExcessReplicas(blockId)
+		}
+	case AdminStateDecommissionInProgress:
+		//已经下线的节点不需要重新下线
+		if nameNode.adminState(dataNodeId) == AdminStateDecommissioned {
+			break
+		}
+		nameNode.DataNodeAdminStates[request.DataNode] = DataNodeAdminState{State: AdminStateDecommissionInProgress}
+		for _, blockId := range nameNode.blocksOnDataNode(dataNodeId) {
+			nameNode.checkReplication(blockId)
+		}
+	case AdminStateInMaintenance:
+		if request.MaintenanceDuration <= 0 {
+			return errors.New("维护时长必须大于0")
+		}
+		nameNode.DataNodeAdminStates[request.DataNode] = DataNodeAdminState{
+			State:             AdminStateInMaintenance,
+			MaintenanceExpiry: time.Now().Add(request.MaintenanceDuration),
+		}
+	default:
+		return errors.New("不支持的管理状态: " + request.State)
+	}
+	log.Printf("DataNode %s admin state is %s\n", request.DataNode, nameNode.adminState(dataNodeId))
+	*reply = true
+	return nil
+}
+
+// GetDataNodeAdminState 获取dataNode的管理状态
+func (nameNode *Service) GetDataNodeAdminState(request string, reply *string) error {
+	nameNode.rLock()
+	defer nameNode.rUnlock()
+	dataNodeId, ok := nameNode.dataNodeIdOf(request)
+	if !ok {
+		return errors.New("dataNode未注册")
+	}
+	*reply = nameNode.adminState(dataNodeId)
+	return nil
+}
+
+// checkAdminStates 下线中的节点上所有块在其他节点都有足够的副本时变为已下线，维护模式超时的节点恢复正常
+func (nameNode *Service) checkAdminStates(now time.Time) {
+	for id, dn := range nameNode.IdToDataNodes {
+		hostPort := dn.Host + ":" + dn.ServicePort
+		state := nameNode.DataNodeAdminStates[hostPort]
+		switch state.State {
+		case AdminStateDecommissionInProgress:
+			if nameNode.isDecommissionComplete(id) {
+				log.Printf("DataNode %s is decommissioned, it is safe to remove\n", hostPort)
+				nameNode.DataNodeAdminStates[hostPort] = DataNodeAdminState{State: AdminStateDecommissioned}
+			}
+		case AdminStateInMaintenance:
+			if now.After(state.MaintenanceExpiry) {
+				log.Printf("DataNode %s maintenance expired\n", hostPort)
+				delete(nameNode.DataNodeAdminStates, hostPort)
+			}
+		}
+	}
+}
+
+// isDecommissionComplete 下线中的节点上的每个块，在其他节点上的有效副本数都达到了副本因子
+func (nameNode *Service) isDecommissionComplete(dataNodeId uint64) bool {
+	for _, blockId := range nameNode.blocksOnDataNode(dataNodeId) {
+		if len(nameNode.liveReplicas(blockId)) < int(nameNode.expectedReplication(blockId)) {
+			return false
+		}
+	}
+	return true
+}
+
+// adminState 得到dataNode的管理状态
+func (nameNode *Service) adminState(dataNodeId uint64) string {
+	dn := nameNode.IdToDataNodes[dataNodeId]
+	if state, ok := nameNode.DataNodeAdminStates[dn.Host+":"+dn.ServicePort]; ok {
+		return state.State
+	}
+	return AdminStateNormal
+}
+
+// isInMaintenance 判断dataNode是否处于维护模式
+func (nameNode *Service) isInMaintenance(dataNodeUri string) bool {
+	state, ok := nameNode.DataNodeAdminStates[dataNodeUri]
+	return ok && state.State == AdminStateInMaintenance && time.Now().Before(state.MaintenanceExpiry)
+}
+
+// liveReplicas 计入副本数的副本位置：正常和维护中的节点上的副本，下线中和已下线的节点上的副本不计入
+func (nameNode *Service) liveReplicas(blockId string) []uint64 {
+	return filterDataNodes(nameNode.BlockToDataNodeIds[blockId], func(id uint64) bool {
+		state := nameNode.adminState(id)
+		return state == AdminStateNormal || state == AdminStateInMaintenance
+	})
+}
+
+// chooseReplicationSource 选择复制的源节点，优先选择正常的节点，其次是下线中的节点，维护中的节点可能不在线不作为源节点
+func (nameNode *Service) chooseReplicationSource(blockId string) (uint64, bool) {
+	var decommissioning []uint64
+	for _, id := range nameNode.BlockToDataNodeIds[blockId] {
+		switch nameNode.adminState(id) {
+		case AdminStateNormal:
+			return id, true
+		case AdminStateDecommissionInProgress, AdminStateDecommissioned:
+			decommissioning = append(decommissioning, id)
+		}
+	}
+	if len(decommissioning) > 0 {
+		return decommissioning[0], true
+	}
+	return 0, false
+}
+
+// blocksOnDataNode 得到dataNode上所有的块
+func (nameNode *Service) blocksOnDataNode(dataNodeId uint64) (blockIds []string) {
+	for blockId, dataNodeIds := range nameNode.BlockToDataNodeIds {
+		if containsDataNodeId(dataNodeIds, dataNodeId) {
+			blockIds = append(blockIds, blockId)
+		}
+	}
+	return
+}
+
+// dataNodeIdOf 根据host:port查找dataNodeId
+func (nameNode *Service) dataNodeIdOf(dataNodeUri string) (uint64, bool) {
+	for id, dn := range nameNode.IdToDataNodes {
+		if dn.Host+":"+dn.ServicePort == dataNodeUri {
+			return id, true
+		}
+	}
+	return 0, false
+}
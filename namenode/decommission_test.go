@@ -0,0 +1,89 @@
+package namenode
+
+import (
+	"github.com/liuzongzhou/GoDFS/util"
+	"testing"
+	"time"
+)
+
+// newDecommissionTestService 三个dataNode，块0在dataNode 0和1上，副本因子为2
+func newDecommissionTestService() *Service {
+	testNameNodeService := newTestService("1234", "4321", "5678")
+	testNameNodeService.setFileBlocks("/Test1/foo", []string{"0"})
+	testNameNodeService.BlockToDataNodeIds["0"] = []uint64{0, 1}
+	testNameNodeService.BlockToGenerationStamp["0"] = 1
+	return testNameNodeService
+}
+
+// TestNameNodeServiceDecommission 测试下线中的节点不再写入，上面的块复制到其他节点后变为已下线，恢复正常后删除多余的副本
+func TestNameNodeServiceDecommission(t *testing.T) {
+	testNameNodeService := newDecommissionTestService()
+	var reply bool
+	err := testNameNodeService.SetDataNodeAdminState(&DataNodeAdminRequest{DataNode: "localhost:1234", State: AdminStateDecommissionInProgress}, &reply)
+	util.Check(err)
+
+	if blockId, ok := testNameNodeService.replicationQueue.poll(); !ok || blockId != "0" {
+		t.Errorf("Unable to queue blocks on decommissioning DataNode")
+	}
+	if writable := testNameNodeService.writableDataNodes([]uint64{0, 1, 2}); containsDataNodeId(writable, 0) {
+		t.Errorf("Decommissioning DataNode should not be written to: %v", writable)
+	}
+	if targets := testNameNodeService.chooseReplicationTargets("0"); len(targets) != 1 || targets[0] != 2 {
+		t.Errorf("Unable to choose replication target: %v", targets)
+	}
+	if source, ok := testNameNodeService.chooseReplicationSource("0"); !ok || source != 1 {
+		t.Errorf("Normal DataNode should be preferred as replication source: %d", source)
+	}
+	testNameNodeService.checkAdminStates(time.Now())
+	var state string
+	util.Check(testNameNodeService.GetDataNodeAdminState("localhost:1234", &state))
+	if state != AdminStateDecommissionInProgress {
+		t.Errorf("Decommission should be in progress: %s", state)
+	}
+
+	//复制完成，下线中的节点上的副本不作为多余的副本删除
+	testNameNodeService.BlockToDataNodeIds["0"] = append(testNameNodeService.BlockToDataNodeIds["0"], 2)
+	testNameNodeService.removeExcessReplicas("0")
+	testNameNodeService.checkAdminStates(time.Now())
+	util.Check(testNameNodeService.GetDataNodeAdminState("localhost:1234", &state))
+	if state != AdminStateDecommissioned || len(testNameNodeService.BlockToDataNodeIds["0"]) != 3 {
+		t.Errorf("Unable to finish decommission: %s %v", state, testNameNodeService.BlockToDataNodeIds["0"])
+	}
+
+	err = testNameNodeService.SetDataNodeAdminState(&DataNodeAdminRequest{DataNode: "localhost:1234", State: AdminStateNormal}, &reply)
+	util.Check(err)
+	if len(testNameNodeService.BlockToDataNodeIds["0"]) != 2 {
+		t.Errorf("Unable to remove excess replicas after recommission: %v", testNameNodeService.BlockToDataNodeIds["0"])
+	}
+}
+
+// TestNameNodeServiceMaintenance 测试维护中的节点dead时不重新复制，维护超时后恢复正常处理
+func TestNameNodeServiceMaintenance(t *testing.T) {
+	testNameNodeService := newDecommissionTestService()
+	var reply bool
+	err := testNameNodeService.SetDataNodeAdminState(&DataNodeAdminRequest{DataNode: "localhost:1234", State: AdminStateInMaintenance}, &reply)
+	if err == nil {
+		t.Errorf("Maintenance without duration should fail")
+	}
+	err = testNameNodeService.SetDataNodeAdminState(&DataNodeAdminRequest{DataNode: "localhost:1234", State: AdminStateInMaintenance, MaintenanceDuration: time.Hour}, &reply)
+	util.Check(err)
+	if _, ok := testNameNodeService.replicationQueue.poll(); ok {
+		t.Errorf("Replicas on DataNode in maintenance should still count")
+	}
+
+	err = testNameNodeService.ReDistributeData(&ReDistributeDataRequest{DataNodeUri: "localhost:1234"}, &reply)
+	util.Check(err)
+	if _, ok := testNameNodeService.IdToDataNodes[0]; !ok || len(testNameNodeService.BlockToDataNodeIds["0"]) != 2 {
+		t.Errorf("DataNode in maintenance should not be redistributed")
+	}
+
+	testNameNodeService.checkAdminStates(time.Now().Add(time.Hour * 2))
+	if state := testNameNodeService.adminState(0); state != AdminStateNormal {
+		t.Errorf("Maintenance should expire: %s", state)
+	}
+	err = testNameNodeService.ReDistributeData(&ReDistributeDataRequest{DataNodeUri: "localhost:1234"}, &reply)
+	util.Check(err)
+	if _, ok := testNameNodeService.IdToDataNodes[0]; ok {
+		t.Errorf("Unable to redistribute data after maintenance expired")
+	}
+}
@@ -0,0 +1,165 @@
+package namenode
+
+import (
+	"errors"
+	"github.com/liuzongzhou/GoDFS/encryption"
+	"sort"
+	"strings"
+)
+
+// EncryptionZone 加密区，目录下的文件由客户端用数据密钥加密后写入，数据密钥再用加密区密钥加密后记录在nameNode上，
+// nameNode和dataNode都不持有加密区密钥
+type EncryptionZone struct {
+	KeyName string //加密区密钥在客户端密钥库中的名字
+	Cipher  string //加密算法
+}
+
+// EncryptionZoneStatus 加密区的路径和设置，不在加密区中时Path为空
+type EncryptionZoneStatus struct {
+	Path string
+	EncryptionZone
+}
+
+// FileEncryptionInfo 加密文件的数据密钥信息，KeyName为空时文件没有加密
+type FileEncryptionInfo struct {
+	KeyName          string
+	Cipher           string
+	EncryptedDataKey []byte //用加密区密钥加密的数据密钥
+	IV               []byte
+}
+
+// NameNodeCreateEncryptionZoneRequest 把空目录设置为加密区的请求
+type NameNodeCreateEncryptionZoneRequest struct {
+	RemoteFilePath string
+	KeyName        string
+	Cipher         string
+	User           UserInfo
+}
+
+// encryptionZoneOf 路径所在的加密区，逐级向上查找最近的加密区目录，不在加密区中时返回false
+func (nameNode *Service) encryptionZoneOf(path string) (EncryptionZoneStatus, bool) {
+	for directory := path; directory != ""; directory = parentDirectory(directory) {
+		if zone, ok := nameNode.DirectoryToEncryptionZone[directory]; ok {
+			return EncryptionZoneStatus{Path: directory, EncryptionZone: zone}, true
+		}
+	}
+	return EncryptionZoneStatus{}, false
+}
+
+// CreateEncryptionZone 把空目录设置为加密区，只有超级用户可以设置，加密区不能嵌套
+func (nameNode *Service) CreateEncryptionZone(request *NameNodeCreateEncryptionZoneRequest, reply *bool) error {
+	nameNode.lock()
+	defer nameNode.unlock()
+	if !nameNode.isPrimary() {
+		return errors.New("当前nameNode不是主节点")
+	}
+	if err := nameNode.checkNotInSafeMode(); err != nil {
+		return err
+	}
+	path := request.RemoteFilePath
+	if !nameNode.isSuperUser(request.User) {
+		return permissionDenied(request.User, path)
+	}
+	if !strings.HasSuffix(path, "/") || isRoot(path) {
+		return errors.New("加密区必须是以/结尾的非根目录")
+	}
+	if request.KeyName == "" {
+		return errors.New("没有指定加密区密钥")
+	}
+	if !encryption.ValidCipher(request.Cipher) {
+		return errors.New("不支持的加密算法: " + request.Cipher + "，可选: " + encryption.CipherAESCTR + "," + encryption.CipherAESGCM)
+	}
+	if !nameNode.pathExists(path) {
+		return errors.New("目录不存在")
+	}
+	for fileName := range nameNode.FileNameToBlocks {
+		if strings.HasPrefix(fileName, path) {
+			return errors.New("只能把空目录设置为加密区")
+		}
+	}
+	if zone, ok := nameNode.encryptionZoneOf(path); ok {
+		return errors.New("目录已经在加密区" + zone.Path + "中")
+	}
+	for directory := range nameNode.DirectoryToEncryptionZone {
+		if strings.HasPrefix(directory, path) {
+			return errors.New("目录下已经有加密区" + directory)
+		}
+	}
+	nameNode.DirectoryToEncryptionZone[path] = EncryptionZone{KeyName: request.KeyName, Cipher: request.Cipher}
+	*reply = true
+	return nil
+}
+
+// ListEncryptionZones 列出所有加密区，按路径排序
+func (nameNode *Service) ListEncryptionZones(request bool, reply *[]EncryptionZoneStatus) error {
+	nameNode.rLock()
+	defer nameNode.rUnlock()
+	for path, zone := range nameNode.DirectoryToEncryptionZone {
+		*reply = append(*reply, EncryptionZoneStatus{Path: path, EncryptionZone: zone})
+	}
+	sort.Slice(*reply, func(i, j int) bool {
+		return (*reply)[i].Path < (*reply)[j].Path
+	})
+	return nil
+}
+
+// GetEncryptionZone 获取路径所在的加密区，客户端写入前据此决定是否加密，不在加密区中时返回的Path为空
+func (nameNode *Service) GetEncryptionZone(request string, reply *EncryptionZoneStatus) error {
+	nameNode.rLock()
+	defer nameNode.rUnlock()
+	*reply, _ = nameNode.encryptionZoneOf(request)
+	return nil
+}
+
+// GetFileEncryptionInfo 获取文件的数据密钥信息，需要文件的读权限，没有加密的文件返回空的KeyName
+func (nameNode *Service) GetFileEncryptionInfo(request *NameNodeReadRequest, reply *FileEncryptionInfo) error {
+	nameNode.rLock()
+	defer nameNode.rUnlock()
+	if inSnapshot(request.FileName) {
+		file, err := nameNode.readSnapshotFile(request.User, request.FileName)
+		if err != nil {
+			return err
+		}
+		*reply = file.Encryption
+		return nil
+	}
+	if err := nameNode.checkPermission(request.User, request.FileName, ActionRead); err != nil {
+		return err
+	}
+	if _, ok := nameNode.FileNameToBlocks[request.FileName]; !ok {
+		return errors.New("文件不存在")
+	}
+	*reply = nameNode.FileNameToEncryption[request.FileName]
+	return nil
+}
+
+// checkFileEncryption 检查写入的文件是否按所在加密区的设置加密，不在加密区中的文件不能携带数据密钥
+func (nameNode *Service) checkFileEncryption(remoteFilePath string, info FileEncryptionInfo) error {
+	zone, ok := nameNode.encryptionZoneOf(remoteFilePath)
+	if !ok {
+		if info.KeyName != "" {
+			return errors.New("目录不在加密区中")
+		}
+		return nil
+	}
+	if info.KeyName != zone.KeyName || info.Cipher != zone.Cipher || len(info.EncryptedDataKey) == 0 || len(info.IV) == 0 {
+		return errors.New("目录在加密区" + zone.Path + "中，文件需要用密钥" + zone.KeyName + "和" + zone.Cipher + "加密后写入")
+	}
+	return nil
+}
+
+// checkEncryptionZoneRename 检查重命名不会把文件移入或者移出加密区，加密区目录本身可以重命名，但不能移动到其他加密区中
+func (nameNode *Service) checkEncryptionZoneRename(srcPath string, destPath string) error {
+	if _, ok := nameNode.DirectoryToEncryptionZone[srcPath]; ok {
+		if zone, ok := nameNode.encryptionZoneOf(parentDirectory(destPath)); ok {
+			return errors.New("加密区不能移动到加密区" + zone.Path + "中")
+		}
+		return nil
+	}
+	srcZone, _ := nameNode.encryptionZoneOf(srcPath)
+	destZone, _ := nameNode.encryptionZoneOf(parentDirectory(destPath))
+	if srcZone.Path != destZone.Path {
+		return errors.New("不能在不同的加密区之间，或者加密区内外之间重命名")
+	}
+	return nil
+}
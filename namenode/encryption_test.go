@@ -0,0 +1,144 @@
+package namenode
+
+import (
+	"github.com/liuzongzhou/GoDFS/datanode"
+	"github.com/liuzongzhou/GoDFS/encryption"
+	"github.com/liuzongzhou/GoDFS/util"
+	"testing"
+)
+
+// TestNameNodeServiceEncryptionZone 测试加密区的创建规则、写入时必须携带数据密钥，以及读取数据密钥信息
+func TestNameNodeServiceEncryptionZone(t *testing.T) {
+	testNameNodeService := newTestService("1234", "4321")
+	root := UserInfo{Name: "root"}
+	alice := UserInfo{Name: "alice"}
+	var ok bool
+	util.Check(testNameNodeService.Mkdir(&NameNodeMkdirRequest{RemoteFilePath: "/secure/", User: root}, &ok))
+	util.Check(testNameNodeService.SetPermission(&NameNodeSetPermissionRequest{Path: "/secure/", Mode: 0777, User: root}, &ok))
+
+	request := NameNodeCreateEncryptionZoneRequest{RemoteFilePath: "/secure/", KeyName: "key1", Cipher: encryption.CipherAESCTR, User: alice}
+	if err := testNameNodeService.CreateEncryptionZone(&request, &ok); err == nil {
+		t.Errorf("Only super user should create encryption zone")
+	}
+	request.User = root
+	request.Cipher = "DES"
+	if err := testNameNodeService.CreateEncryptionZone(&request, &ok); err == nil {
+		t.Errorf("Unsupported cipher should be rejected")
+	}
+	request.Cipher = encryption.CipherAESCTR
+	request.RemoteFilePath = "/missing/"
+	if err := testNameNodeService.CreateEncryptionZone(&request, &ok); err == nil {
+		t.Errorf("Missing directory should be rejected")
+	}
+	request.RemoteFilePath = "/secure/"
+	util.Check(testNameNodeService.CreateEncryptionZone(&request, &ok))
+	// 加密区不能嵌套
+	util.Check(testNameNodeService.Mkdir(&NameNodeMkdirRequest{RemoteFilePath: "/secure/inner/", User: root}, &ok))
+	request.RemoteFilePath = "/secure/inner/"
+	if err := testNameNodeService.CreateEncryptionZone(&request, &ok); err == nil {
+		t.Errorf("Nested encryption zone should be rejected")
+	}
+	// 只能把空目录设置为加密区
+	var metadata []NameNodeMetaData
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/plain/", FileName: "foo", FileSize: 8, User: alice}, &metadata))
+	request.RemoteFilePath = "/plain/"
+	if err := testNameNodeService.CreateEncryptionZone(&request, &ok); err == nil {
+		t.Errorf("Non-empty directory should be rejected")
+	}
+
+	var zone EncryptionZoneStatus
+	util.Check(testNameNodeService.GetEncryptionZone("/secure/inner/", &zone))
+	if zone.Path != "/secure/" || zone.KeyName != "key1" || zone.Cipher != encryption.CipherAESCTR {
+		t.Errorf("Unable to get encryption zone: %+v", zone)
+	}
+	var zones []EncryptionZoneStatus
+	util.Check(testNameNodeService.ListEncryptionZones(true, &zones))
+	if len(zones) != 1 || zones[0].Path != "/secure/" {
+		t.Errorf("Unable to list encryption zones: %+v", zones)
+	}
+
+	// 加密区中的文件必须携带数据密钥，加密区外的文件不能携带
+	if err := testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/secure/", FileName: "foo", FileSize: 8, User: alice}, &metadata); err == nil {
+		t.Errorf("Plain file should be rejected in encryption zone")
+	}
+	info := FileEncryptionInfo{KeyName: "key1", Cipher: encryption.CipherAESCTR, EncryptedDataKey: []byte("wrapped"), IV: []byte("iv")}
+	if err := testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/plain/", FileName: "bar", FileSize: 8, User: alice, Encryption: info}, &metadata); err == nil {
+		t.Errorf("Encrypted file should be rejected outside encryption zone")
+	}
+	wrongKey := info
+	wrongKey.KeyName = "key2"
+	if err := testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/secure/", FileName: "foo", FileSize: 8, User: alice, Encryption: wrongKey}, &metadata); err == nil {
+		t.Errorf("File encrypted with another key should be rejected")
+	}
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/secure/", FileName: "foo", FileSize: 8, User: alice, Encryption: info}, &metadata))
+
+	var fileInfo FileEncryptionInfo
+	if err := testNameNodeService.GetFileEncryptionInfo(&NameNodeReadRequest{FileName: "/secure/foo", User: UserInfo{Name: "bob"}}, &fileInfo); err != nil {
+		t.Errorf("Readable file should return encryption info: %v", err)
+	}
+	if fileInfo.KeyName != "key1" || string(fileInfo.EncryptedDataKey) != "wrapped" {
+		t.Errorf("Unable to get file encryption info: %+v", fileInfo)
+	}
+	util.Check(testNameNodeService.SetPermission(&NameNodeSetPermissionRequest{Path: "/secure/foo", Mode: 0600, User: alice}, &ok))
+	if err := testNameNodeService.GetFileEncryptionInfo(&NameNodeReadRequest{FileName: "/secure/foo", User: UserInfo{Name: "bob"}}, &fileInfo); err == nil {
+		t.Errorf("Encryption info should require read permission")
+	}
+	util.Check(testNameNodeService.GetFileEncryptionInfo(&NameNodeReadRequest{FileName: "/plain/foo", User: alice}, &fileInfo))
+	if fileInfo.KeyName != "" {
+		t.Errorf("Plain file should not have encryption info: %+v", fileInfo)
+	}
+
+	util.Check(testNameNodeService.DeleteFileNameMetaData(&NameNodeDeleteRequest{RemoteFilePath: "/secure/", FileName: "foo", User: alice}, &ok))
+	if _, ok := testNameNodeService.FileNameToEncryption["/secure/foo"]; ok {
+		t.Errorf("Unable to delete file encryption info")
+	}
+}
+
+// TestNameNodeServiceEncryptionZoneRename 测试文件不能移入或者移出加密区，加密区目录整体重命名时迁移加密区和数据密钥信息
+func TestNameNodeServiceEncryptionZoneRename(t *testing.T) {
+	testNameNodeService := newTestService("1234", "4321")
+	root := UserInfo{Name: "root"}
+	var ok bool
+	util.Check(testNameNodeService.Mkdir(&NameNodeMkdirRequest{RemoteFilePath: "/zone1/", User: root}, &ok))
+	util.Check(testNameNodeService.Mkdir(&NameNodeMkdirRequest{RemoteFilePath: "/zone2/", User: root}, &ok))
+	util.Check(testNameNodeService.CreateEncryptionZone(&NameNodeCreateEncryptionZoneRequest{RemoteFilePath: "/zone1/", KeyName: "key1", Cipher: encryption.CipherAESGCM, User: root}, &ok))
+	util.Check(testNameNodeService.CreateEncryptionZone(&NameNodeCreateEncryptionZoneRequest{RemoteFilePath: "/zone2/", KeyName: "key2", Cipher: encryption.CipherAESCTR, User: root}, &ok))
+	info := FileEncryptionInfo{KeyName: "key1", Cipher: encryption.CipherAESGCM, EncryptedDataKey: []byte("wrapped"), IV: []byte("iv")}
+	var metadata []NameNodeMetaData
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/zone1/dir/", FileName: "foo", FileSize: 8, User: root, Encryption: info}, &metadata))
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/plain/", FileName: "bar", FileSize: 8, User: root}, &metadata))
+
+	if err := testNameNodeService.ReNameFile(&NameNodeReNameFileRequest{ReNameSrcFileName: "/zone1/dir/foo", ReNameDestFileName: "/plain/foo", User: root}, &ok); err == nil {
+		t.Errorf("File should not be moved out of encryption zone")
+	}
+	if err := testNameNodeService.ReNameFile(&NameNodeReNameFileRequest{ReNameSrcFileName: "/plain/bar", ReNameDestFileName: "/zone1/bar", User: root}, &ok); err == nil {
+		t.Errorf("File should not be moved into encryption zone")
+	}
+	if err := testNameNodeService.ReNameFile(&NameNodeReNameFileRequest{ReNameSrcFileName: "/zone1/dir/foo", ReNameDestFileName: "/zone2/foo", User: root}, &ok); err == nil {
+		t.Errorf("File should not be moved between encryption zones")
+	}
+	util.Check(testNameNodeService.ReNameFile(&NameNodeReNameFileRequest{ReNameSrcFileName: "/zone1/dir/foo", ReNameDestFileName: "/zone1/dir/baz", User: root}, &ok))
+	if _, ok := testNameNodeService.FileNameToEncryption["/zone1/dir/baz"]; !ok {
+		t.Errorf("Unable to rename file encryption info")
+	}
+	// 覆盖目标文件时不保留目标文件原来的数据密钥
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/plain/", FileName: "old", FileSize: 8, User: root}, &metadata))
+	testNameNodeService.FileNameToEncryption["/plain/old"] = info
+	util.Check(testNameNodeService.ReNameFile(&NameNodeReNameFileRequest{ReNameSrcFileName: "/plain/bar", ReNameDestFileName: "/plain/old", User: root}, &ok))
+	if _, ok := testNameNodeService.FileNameToEncryption["/plain/old"]; ok {
+		t.Errorf("Rename target should not keep its data key")
+	}
+
+	// 加密区目录可以整体重命名，但不能移动到其他加密区中
+	var dataNodes []datanode.DataNodeInstance
+	if err := testNameNodeService.ReName(&NameNodeReNameRequest{ReNameSrcPath: "/zone1/", ReNameDestPath: "/zone2/zone1/", User: root}, &dataNodes); err == nil {
+		t.Errorf("Encryption zone should not be moved into another encryption zone")
+	}
+	util.Check(testNameNodeService.ReName(&NameNodeReNameRequest{ReNameSrcPath: "/zone1/", ReNameDestPath: "/moved/", User: root}, &dataNodes))
+	if zone, ok := testNameNodeService.DirectoryToEncryptionZone["/moved/"]; !ok || zone.KeyName != "key1" {
+		t.Errorf("Unable to rename encryption zone: %+v", testNameNodeService.DirectoryToEncryptionZone)
+	}
+	if _, ok := testNameNodeService.FileNameToEncryption["/moved/dir/baz"]; !ok {
+		t.Errorf("Unable to rename file encryption info: %+v", testNameNodeService.FileNameToEncryption)
+	}
+}
@@ -0,0 +1,312 @@
+package namenode
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/liuzongzhou/GoDFS/auth"
+	"github.com/liuzongzhou/GoDFS/datanode"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ReplicationPolicyName 目录显式使用多副本存储，用于覆盖上级目录设置的纠删码策略
+const ReplicationPolicyName = "replication"
+
+// internalBlockSeparator 内部块id由块组id、分隔符和分片下标组成，uuid中不含该字符
+const internalBlockSeparator = "_"
+
+// ErasureCodingPolicy 纠删码策略，文件按块组条带化存储，每个块组切分成DataUnits个数据块，
+// 再用Reed-Solomon编码出ParityUnits个校验块，分别存放在不同的dataNode上，最多可以同时丢失ParityUnits个块
+type ErasureCodingPolicy struct {
+	Name        string
+	DataUnits   int
+	ParityUnits int
+}
+
+// BlockGroup 纠删码文件的块组，FileNameToBlocks中记录的是块组id，内部块像普通块一样记录在BlockToDataNodeIds中
+type BlockGroup struct {
+	Policy string   //纠删码策略名
+	Size   uint64   //块组中文件数据的字节数
+	Blocks []string //内部块id，按分片下标排列，前DataUnits个为数据块，其余为校验块
+}
+
+// NameNodeSetErasureCodingPolicyRequest 设置目录的纠删码策略，Policy为空时继承上级目录的策略
+type NameNodeSetErasureCodingPolicyRequest struct {
+	RemoteFilePath string
+	Policy         string
+	User           UserInfo
+}
+
+// erasureCodingPolicies 内置的纠删码策略
+var erasureCodingPolicies = map[string]ErasureCodingPolicy{
+	"RS-6-3": {Name: "RS-6-3", DataUnits: 6, ParityUnits: 3},
+	"RS-3-2": {Name: "RS-3-2", DataUnits: 3, ParityUnits: 2},
+}
+
+// GetErasureCodingPolicy 根据策略名获取内置的纠删码策略
+func GetErasureCodingPolicy(name string) (ErasureCodingPolicy, bool) {
+	policy, ok := erasureCodingPolicies[name]
+	return policy, ok
+}
+
+// ErasureCodingPolicyNames 所有内置纠删码策略的名字，按名字排序
+func ErasureCodingPolicyNames() (names []string) {
+	for name := range erasureCodingPolicies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// totalUnits 块组中内部块的总数
+func (policy ErasureCodingPolicy) totalUnits() int {
+	return policy.DataUnits + policy.ParityUnits
+}
+
+// InternalBlockId 块组中第index个内部块的id
+func InternalBlockId(groupId string, index int) string {
+	return groupId + internalBlockSeparator + strconv.Itoa(index)
+}
+
+// blockGroupOf 判断块是否为纠删码块组的内部块，是则返回块组id和分片下标
+func (nameNode *Service) blockGroupOf(blockId string) (groupId string, index int, ok bool) {
+	separator := strings.LastIndex(blockId, internalBlockSeparator)
+	if separator < 0 {
+		return "", 0, false
+	}
+	groupId = blockId[:separator]
+	if _, ok = nameNode.BlockGroups[groupId]; !ok {
+		return "", 0, false
+	}
+	index, err := strconv.Atoi(blockId[separator+1:])
+	if err != nil {
+		return "", 0, false
+	}
+	return groupId, index, true
+}
+
+// SetErasureCodingPolicy 设置目录的纠删码策略，之后写入该目录及子目录的文件按策略条带化存储，已有的文件不受影响。
+// Policy为replication时该目录显式使用多副本存储，为空时清除设置，继承上级目录的策略
+func (nameNode *Service) SetErasureCodingPolicy(request *NameNodeSetErasureCodingPolicyRequest, reply *bool) error {
+	nameNode.lock()
+	defer nameNode.unlock()
+	if !nameNode.isPrimary() {
+		return errors.New("当前nameNode不是主节点")
+	}
+	if err := nameNode.checkNotInSafeMode(); err != nil {
+		return err
+	}
+	if !strings.HasSuffix(request.RemoteFilePath, "/") {
+		return errors.New("目录路径必须以/结尾")
+	}
+	if err := nameNode.checkPermission(request.User, request.RemoteFilePath, ActionWrite); err != nil {
+		return err
+	}
+	switch request.Policy {
+	case "":
+		delete(nameNode.DirectoryToECPolicy, request.RemoteFilePath)
+	case ReplicationPolicyName:
+		nameNode.DirectoryToECPolicy[request.RemoteFilePath] = request.Policy
+	default:
+		if _, ok := erasureCodingPolicies[request.Policy]; !ok {
+			return errors.New("不支持的纠删码策略: " + request.Policy + "，可选: " + strings.Join(ErasureCodingPolicyNames(), ","))
+		}
+		nameNode.DirectoryToECPolicy[request.RemoteFilePath] = request.Policy
+	}
+	*reply = true
+	return nil
+}
+
+// GetErasureCodingPolicy 获取文件或者目录生效的纠删码策略，request为路径+文件名或者以/结尾的目录，多副本存储时返回replication
+func (nameNode *Service) GetErasureCodingPolicy(request string, reply *string) error {
+	nameNode.rLock()
+	defer nameNode.rUnlock()
+	if !strings.HasSuffix(request, "/") {
+		if _, ok := nameNode.FileNameToBlocks[request]; !ok {
+			return errors.New("文件不存在")
+		}
+		if policy, ok := nameNode.FileNameToECPolicy[request]; ok {
+			*reply = policy
+		} else {
+			*reply = ReplicationPolicyName
+		}
+		return nil
+	}
+	if policy, ok := nameNode.directoryECPolicy(request); ok {
+		*reply = policy.Name
+	} else {
+		*reply = ReplicationPolicyName
+	}
+	return nil
+}
+
+// directoryECPolicy 目录下新文件的纠删码策略，逐级向上查找最近的设置了策略的目录，没有设置或者显式使用多副本存储时返回false
+func (nameNode *Service) directoryECPolicy(remoteFilePath string) (ErasureCodingPolicy, bool) {
+	for directory := remoteFilePath; directory != ""; directory = parentDirectory(directory) {
+		if name, ok := nameNode.DirectoryToECPolicy[directory]; ok {
+			policy, ok := erasureCodingPolicies[name]
+			return policy, ok
+		}
+	}
+	return ErasureCodingPolicy{}, false
+}
+
+// allocateBlockGroups 为纠删码文件分配块组，每个块组最多存放DataUnits个块大小的数据，内部块分别放在不同的dataNode上
+func (nameNode *Service) allocateBlockGroups(fileSize uint64, policy ErasureCodingPolicy, clientHost string) (metadata []NameNodeMetaData) {
+	var dataNodesAvailable []uint64
+	for id := range nameNode.IdToDataNodes {
+		dataNodesAvailable = append(dataNodesAvailable, id)
+	}
+	groupCapacity := nameNode.BlockSize * uint64(policy.DataUnits)
+	for offset := uint64(0); offset < fileSize; offset += groupCapacity {
+		groupId := uuid.New().String()
+		group := BlockGroup{Policy: policy.Name, Size: fileSize - offset}
+		if group.Size > groupCapacity {
+			group.Size = groupCapacity
+		}
+		//同一个块组的内部块使用相同的generation stamp
+		nameNode.GenerationStamp++
+		var blockAddresses []datanode.DataNodeInstance
+		for index, dataNodeId := range nameNode.chooseTargets(clientHost, dataNodesAvailable, nil, policy.totalUnits()) {
+			blockId := InternalBlockId(groupId, index)
+			group.Blocks = append(group.Blocks, blockId)
+			nameNode.BlockToDataNodeIds[blockId] = []uint64{dataNodeId}
+			nameNode.BlockToGenerationStamp[blockId] = nameNode.GenerationStamp
+			blockAddresses = append(blockAddresses, nameNode.IdToDataNodes[dataNodeId])
+		}
+		nameNode.BlockGroups[groupId] = group
+		metadata = append(metadata, NameNodeMetaData{
+			BlockId:         groupId,
+			BlockAddresses:  blockAddresses,
+			GenerationStamp: nameNode.GenerationStamp,
+			ECPolicy:        policy,
+			GroupSize:       group.Size,
+		})
+	}
+	return
+}
+
+// blockGroupMetaData 块组的读取元数据，BlockAddresses按分片下标排列，丢失的内部块对应空的DataNodeInstance
+func (nameNode *Service) blockGroupMetaData(groupId string) NameNodeMetaData {
+	group := nameNode.BlockGroups[groupId]
+	metaData := NameNodeMetaData{BlockId: groupId, ECPolicy: erasureCodingPolicies[group.Policy], GroupSize: group.Size}
+	for _, blockId := range group.Blocks {
+		var address datanode.DataNodeInstance
+		if dataNodeIds := nameNode.BlockToDataNodeIds[blockId]; len(dataNodeIds) > 0 {
+			address = nameNode.IdToDataNodes[dataNodeIds[0]]
+		}
+		metaData.BlockAddresses = append(metaData.BlockAddresses, address)
+	}
+	if len(group.Blocks) > 0 {
+		metaData.GenerationStamp = nameNode.BlockToGenerationStamp[group.Blocks[0]]
+	}
+	return metaData
+}
+
+// deleteBlockMetaData 删除块的元数据，块组连同所有内部块一起删除
+func (nameNode *Service) deleteBlockMetaData(blockId string) {
+	if group, ok := nameNode.BlockGroups[blockId]; ok {
+		for _, internalBlockId := range group.Blocks {
+			delete(nameNode.BlockToDataNodeIds, internalBlockId)
+			delete(nameNode.BlockToGenerationStamp, internalBlockId)
+		}
+		delete(nameNode.BlockGroups, blockId)
+	}
+	delete(nameNode.BlockToDataNodeIds, blockId)
+	delete(nameNode.BlockToGenerationStamp, blockId)
+}
+
+// groupDataNodeIds 块组所有内部块所在的dataNode，重建和移动内部块时不能放到这些节点上，否则一个节点故障会丢失多个内部块
+func (nameNode *Service) groupDataNodeIds(groupId string) (dataNodeIds []uint64) {
+	for _, blockId := range nameNode.BlockGroups[groupId].Blocks {
+		for _, dataNodeId := range nameNode.BlockToDataNodeIds[blockId] {
+			if !containsDataNodeId(dataNodeIds, dataNodeId) {
+				dataNodeIds = append(dataNodeIds, dataNodeId)
+			}
+		}
+	}
+	return
+}
+
+// missingInternalBlocks 块组中没有可用副本的内部块数
+func (nameNode *Service) missingInternalBlocks(groupId string) (missing int) {
+	for _, blockId := range nameNode.BlockGroups[groupId].Blocks {
+		if len(nameNode.liveReplicas(blockId)) == 0 {
+			missing++
+		}
+	}
+	return
+}
+
+// internalBlockPriority 丢失内部块的重建优先级，丢失的内部块数达到校验块数时，再丢一个块数据就无法还原
+func (nameNode *Service) internalBlockPriority(groupId string) int {
+	policy := erasureCodingPolicies[nameNode.BlockGroups[groupId].Policy]
+	if nameNode.missingInternalBlocks(groupId) >= policy.ParityUnits {
+		return priorityHighest
+	}
+	return priorityUnderReplicated
+}
+
+// scheduleReconstruction 为丢失的内部块发送重建命令，由目标dataNode从其他内部块读取DataUnits个分片，解码出丢失的分片后写入本地
+func (nameNode *Service) scheduleReconstruction(blockId string, groupId string, index int, results chan<- replicationResult) {
+	group := nameNode.BlockGroups[groupId]
+	policy := erasureCodingPolicies[group.Policy]
+	request := datanode.DataNodeReconstructRequest{
+		RemoteFilePath:  nameNode.blockRemoteFilePath(blockId),
+		BlockId:         blockId,
+		Index:           index,
+		GenerationStamp: nameNode.BlockToGenerationStamp[blockId],
+		DataUnits:       policy.DataUnits,
+		ParityUnits:     policy.ParityUnits,
+	}
+	for i, internalBlockId := range group.Blocks {
+		if i == index {
+			continue
+		}
+		if sourceId, ok := nameNode.chooseReplicationSource(internalBlockId); ok {
+			request.Sources = append(request.Sources, datanode.ReconstructSource{
+				BlockId:         internalBlockId,
+				Index:           i,
+				GenerationStamp: nameNode.BlockToGenerationStamp[internalBlockId],
+				DataNode:        nameNode.IdToDataNodes[sourceId],
+			})
+		}
+	}
+	if len(request.Sources) < policy.DataUnits {
+		log.Printf("Block group %s has only %d internal blocks left, unable to reconstruct block %s\n", groupId, len(request.Sources), blockId)
+		nameNode.replicationQueue.done(blockId)
+		return
+	}
+	targetDataNodeIds := nameNode.chooseReplicationTargets(blockId)
+	if len(targetDataNodeIds) == 0 {
+		log.Printf("No DataNode available to reconstruct block %s\n", blockId)
+		nameNode.replicationQueue.done(blockId)
+		return
+	}
+	target := nameNode.IdToDataNodes[targetDataNodeIds[0]]
+	nameNode.replicationQueue.start(blockId, targetDataNodeIds[:1])
+	go func() {
+		err := sendReconstructCommand(target, request)
+		results <- replicationResult{blockId: blockId, err: err}
+	}()
+}
+
+// sendReconstructCommand 向目标dataNode发送重建命令
+func sendReconstructCommand(target datanode.DataNodeInstance, request datanode.DataNodeReconstructRequest) error {
+	targetInstance, err := auth.Dial(target.Host + ":" + target.ServicePort)
+	if err != nil {
+		return err
+	}
+	defer targetInstance.Close()
+	var reply datanode.DataNodeReplyStatus
+	err = targetInstance.Call("Service.ReconstructBlock", request, &reply)
+	if err != nil {
+		return err
+	}
+	if !reply.Status {
+		return errors.New("目标节点拒绝了重建命令")
+	}
+	return nil
+}
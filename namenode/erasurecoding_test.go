@@ -0,0 +1,120 @@
+package namenode
+
+import (
+	"github.com/liuzongzhou/GoDFS/util"
+	"testing"
+)
+
+// newErasureCodingTestService 6个dataNode，目录/Test1/使用RS-3-2纠删码策略
+func newErasureCodingTestService() *Service {
+	testNameNodeService := newTestService("1234", "4321", "5678", "8765", "1111", "2222")
+	var reply bool
+	err := testNameNodeService.SetErasureCodingPolicy(&NameNodeSetErasureCodingPolicyRequest{RemoteFilePath: "/Test1/", Policy: "RS-3-2"}, &reply)
+	util.Check(err)
+	return testNameNodeService
+}
+
+// TestNameNodeServiceWriteErasureCoded 测试纠删码目录下的文件按块组分配，内部块分别放在不同的dataNode上
+func TestNameNodeServiceWriteErasureCoded(t *testing.T) {
+	testNameNodeService := newErasureCodingTestService()
+
+	var reply []NameNodeMetaData
+	err := testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/Test1/sub/", FileName: "foo", FileSize: 20}, &reply)
+	util.Check(err)
+	if len(reply) != 2 || reply[0].GroupSize != 12 || reply[1].GroupSize != 8 {
+		t.Fatalf("Unable to allocate block groups: %v", reply)
+	}
+	for _, metaData := range reply {
+		if metaData.ECPolicy.Name != "RS-3-2" || len(metaData.BlockAddresses) != 5 {
+			t.Errorf("Unable to allocate internal blocks of block group %s", metaData.BlockId)
+		}
+		if len(testNameNodeService.groupDataNodeIds(metaData.BlockId)) != 5 {
+			t.Errorf("Internal blocks of block group %s should be on different DataNodes", metaData.BlockId)
+		}
+	}
+	var policy string
+	util.Check(testNameNodeService.GetErasureCodingPolicy("/Test1/sub/foo", &policy))
+	if policy != "RS-3-2" {
+		t.Errorf("Unable to record erasure coding policy of file")
+	}
+
+	// 子目录显式使用多副本存储
+	var ok bool
+	util.Check(testNameNodeService.SetErasureCodingPolicy(&NameNodeSetErasureCodingPolicyRequest{RemoteFilePath: "/Test1/rep/", Policy: ReplicationPolicyName}, &ok))
+	reply = nil
+	err = testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/Test1/rep/", FileName: "bar", FileSize: 4}, &reply)
+	util.Check(err)
+	if len(reply) != 1 || reply[0].ECPolicy.DataUnits != 0 || len(reply[0].BlockAddresses) != 2 {
+		t.Errorf("Unable to override erasure coding policy with replication")
+	}
+
+	// 删除文件时内部块的元数据一起删除
+	util.Check(testNameNodeService.DeleteFileNameMetaData(&NameNodeDeleteRequest{RemoteFilePath: "/Test1/sub/", FileName: "foo"}, &ok))
+	if len(testNameNodeService.BlockGroups) != 0 || len(testNameNodeService.BlockToDataNodeIds) != 1 {
+		t.Errorf("Unable to delete block groups")
+	}
+}
+
+// TestNameNodeServiceRenameOverErasureCoded 测试多副本文件重命名覆盖纠删码文件后，目标文件不再使用原来的纠删码策略
+func TestNameNodeServiceRenameOverErasureCoded(t *testing.T) {
+	testNameNodeService := newErasureCodingTestService()
+	var reply []NameNodeMetaData
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/Test1/", FileName: "dst", FileSize: 8}, &reply))
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/Test2/", FileName: "src", FileSize: 8}, &reply))
+	var ok bool
+	util.Check(testNameNodeService.ReNameFile(&NameNodeReNameFileRequest{ReNameSrcFileName: "/Test2/src", ReNameDestFileName: "/Test1/dst"}, &ok))
+	var policy string
+	util.Check(testNameNodeService.GetErasureCodingPolicy("/Test1/dst", &policy))
+	if policy != ReplicationPolicyName {
+		t.Errorf("Rename target should take storage policy of source: %s", policy)
+	}
+}
+
+// TestNameNodeServiceWriteErasureCodedNotEnoughDataNodes 测试dataNode数量少于内部块数时拒绝写入
+func TestNameNodeServiceWriteErasureCodedNotEnoughDataNodes(t *testing.T) {
+	testNameNodeService := newErasureCodingTestService()
+	delete(testNameNodeService.IdToDataNodes, 0)
+	delete(testNameNodeService.IdToDataNodes, 1)
+
+	var reply []NameNodeMetaData
+	err := testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/Test1/", FileName: "foo", FileSize: 20}, &reply)
+	if err == nil {
+		t.Errorf("Write should fail without enough DataNodes")
+	}
+	if _, exists := testNameNodeService.FileNameToBlocks["/Test1/foo"]; exists {
+		t.Errorf("Failed write should not leave metadata")
+	}
+	var ok bool
+	if err = testNameNodeService.SetErasureCodingPolicy(&NameNodeSetErasureCodingPolicyRequest{RemoteFilePath: "/Test1/", Policy: "RS-10-4"}, &ok); err == nil {
+		t.Errorf("Unknown erasure coding policy should fail")
+	}
+}
+
+// TestNameNodeServiceReconstructInternalBlock 测试dead节点上的内部块进入重建队列，重建的目标节点不能有同一块组的其他内部块
+func TestNameNodeServiceReconstructInternalBlock(t *testing.T) {
+	testNameNodeService := newErasureCodingTestService()
+	var reply []NameNodeMetaData
+	err := testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/Test1/", FileName: "foo", FileSize: 12}, &reply)
+	util.Check(err)
+	groupId := reply[0].BlockId
+	lost := reply[0].BlockAddresses[1]
+
+	var ok bool
+	err = testNameNodeService.ReDistributeData(&ReDistributeDataRequest{DataNodeUri: lost.Host + ":" + lost.ServicePort}, &ok)
+	util.Check(err)
+	blockId, queued := testNameNodeService.replicationQueue.poll()
+	if !queued || blockId != InternalBlockId(groupId, 1) {
+		t.Fatalf("Unable to queue lost internal block, got %s", blockId)
+	}
+	if testNameNodeService.expectedReplication(blockId) != 1 || testNameNodeService.blockRemoteFilePath(blockId) != "/Test1/" {
+		t.Errorf("Unable to resolve internal block")
+	}
+	// 剩下的5个节点中只有1个节点上没有该块组的内部块
+	targets := testNameNodeService.chooseReplicationTargets(blockId)
+	if len(targets) != 1 || containsDataNodeId(testNameNodeService.groupDataNodeIds(groupId), targets[0]) {
+		t.Errorf("Unable to choose reconstruction target: %v", targets)
+	}
+	if testNameNodeService.blockGroupMetaData(groupId).BlockAddresses[1].Host != "" {
+		t.Errorf("Lost internal block should have no address")
+	}
+}
@@ -0,0 +1,225 @@
+package namenode
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// NameNodeFsckRequest 检查命名空间的请求，Path以/结尾时检查目录及子目录下的所有文件，否则只检查该文件，为空时检查整个命名空间。
+// Delete为true时删除损坏的文件
+type NameNodeFsckRequest struct {
+	Path   string
+	Delete bool
+	User   UserInfo //删除损坏的文件只允许超级用户
+}
+
+// FsckFileReport 一个文件的检查结果，各列表中为有问题的块（纠删码文件为块组）
+type FsckFileReport struct {
+	FileName              string
+	FileSize              uint64
+	Policy                string //纠删码策略名，多副本文件为replication
+	Replication           uint64 //多副本文件的副本因子
+	Blocks                int
+	MissingBlocks         []string //没有任何可用副本的块，纠删码块组为可用的内部块不足DataUnits个
+	CorruptBlocks         []string //元数据不一致的块：没有generation stamp、块组不存在，或者副本都记录在未注册的dataNode上
+	UnderReplicatedBlocks []string //可用副本数低于副本因子的块，纠删码块组为有内部块丢失但仍可以还原
+	MisReplicatedBlocks   []string //多个副本都在同一个机架上的块，纠删码块组为有多个内部块在同一个dataNode上
+}
+
+// IsCorrupt 文件是否有丢失或者损坏的块，这样的文件无法完整读取
+func (file FsckFileReport) IsCorrupt() bool {
+	return len(file.MissingBlocks) > 0 || len(file.CorruptBlocks) > 0
+}
+
+// FsckReport 命名空间的检查结果
+type FsckReport struct {
+	Path                  string
+	Files                 []FsckFileReport
+	TotalFiles            int
+	TotalBlocks           int
+	MissingBlocks         int
+	CorruptBlocks         int
+	UnderReplicatedBlocks int
+	MisReplicatedBlocks   int
+	DataNodes             int
+	Racks                 int
+	CorruptFiles          []string
+	DeletedFiles          []string //Delete为true时删除的损坏文件
+	MovedFiles            []string //客户端-move时移动到lost+found的损坏文件
+	Healthy               bool
+}
+
+// Fsck 从指定路径遍历命名空间，将每个文件的块与BlockToDataNodeIds和已注册的dataNode进行核对，
+// 统计丢失、损坏、副本不足和副本放置不合理的块
+func (nameNode *Service) Fsck(request *NameNodeFsckRequest, reply *FsckReport) error {
+	nameNode.lock()
+	defer nameNode.unlock()
+	if request.Delete {
+		if !nameNode.isSuperUser(request.User) {
+			return permissionDenied(request.User, request.Path)
+		}
+		if !nameNode.isPrimary() {
+			return errors.New("当前nameNode不是主节点")
+		}
+		if err := nameNode.checkNotInSafeMode(); err != nil {
+			return err
+		}
+	}
+	fileNames := nameNode.fsckFileNames(request.Path)
+	if len(fileNames) == 0 && request.Path != "" && request.Path != "/" {
+		return errors.New("路径不存在")
+	}
+	report := FsckReport{Path: request.Path, DataNodes: len(nameNode.IdToDataNodes), Racks: nameNode.rackCount()}
+	for _, fileName := range fileNames {
+		file := nameNode.checkFile(fileName)
+		report.Files = append(report.Files, file)
+		report.TotalFiles++
+		report.TotalBlocks += file.Blocks
+		report.MissingBlocks += len(file.MissingBlocks)
+		report.CorruptBlocks += len(file.CorruptBlocks)
+		report.UnderReplicatedBlocks += len(file.UnderReplicatedBlocks)
+		report.MisReplicatedBlocks += len(file.MisReplicatedBlocks)
+		if file.IsCorrupt() {
+			report.CorruptFiles = append(report.CorruptFiles, fileName)
+		}
+	}
+	if request.Delete {
+		for _, fileName := range report.CorruptFiles {
+			nameNode.deleteFile(fileName)
+			report.DeletedFiles = append(report.DeletedFiles, fileName)
+		}
+	}
+	report.Healthy = len(report.CorruptFiles) == 0
+	*reply = report
+	return nil
+}
+
+// fsckFileNames 得到要检查的文件，按文件名排序
+func (nameNode *Service) fsckFileNames(path string) (fileNames []string) {
+	for fileName := range nameNode.FileNameToBlocks {
+		if path == "" || path == "/" || fileName == path || (strings.HasSuffix(path, "/") && strings.HasPrefix(fileName, path)) {
+			fileNames = append(fileNames, fileName)
+		}
+	}
+	sort.Strings(fileNames)
+	return
+}
+
+// checkFile 检查一个文件的所有块
+func (nameNode *Service) checkFile(fileName string) FsckFileReport {
+	blockIds := nameNode.FileNameToBlocks[fileName]
+	file := FsckFileReport{FileName: fileName, FileSize: nameNode.FileNameSize[fileName], Blocks: len(blockIds)}
+	if policy, ok := nameNode.FileNameToECPolicy[fileName]; ok {
+		file.Policy = policy
+		for _, groupId := range blockIds {
+			nameNode.checkBlockGroup(&file, groupId)
+		}
+		return file
+	}
+	file.Policy = ReplicationPolicyName
+	file.Replication = nameNode.FileNameToReplication[fileName]
+	if file.Replication == 0 {
+		file.Replication = nameNode.ReplicationFactor
+	}
+	for _, blockId := range blockIds {
+		nameNode.checkBlock(&file, blockId)
+	}
+	return file
+}
+
+// checkBlock 检查多副本文件的一个块
+func (nameNode *Service) checkBlock(file *FsckFileReport, blockId string) {
+	locations := nameNode.BlockToDataNodeIds[blockId]
+	registered := nameNode.registeredDataNodes(locations)
+	if _, ok := nameNode.BlockToGenerationStamp[blockId]; !ok || (len(locations) > 0 && len(registered) == 0) {
+		file.CorruptBlocks = append(file.CorruptBlocks, blockId)
+		return
+	}
+	if len(registered) == 0 {
+		file.MissingBlocks = append(file.MissingBlocks, blockId)
+		return
+	}
+	live := nameNode.registeredDataNodes(nameNode.liveReplicas(blockId))
+	if uint64(len(live)) < file.Replication {
+		file.UnderReplicatedBlocks = append(file.UnderReplicatedBlocks, blockId)
+	}
+	//集群有多个机架时，多个副本应该分布在至少两个机架上
+	if len(registered) > 1 && nameNode.rackCount() > 1 {
+		racks := make(map[string]bool)
+		for _, id := range registered {
+			racks[nameNode.RackOf(id)] = true
+		}
+		if len(racks) == 1 {
+			file.MisReplicatedBlocks = append(file.MisReplicatedBlocks, blockId)
+		}
+	}
+}
+
+// checkBlockGroup 检查纠删码文件的一个块组，可用的内部块不少于DataUnits个时数据可以还原
+func (nameNode *Service) checkBlockGroup(file *FsckFileReport, groupId string) {
+	group, ok := nameNode.BlockGroups[groupId]
+	if !ok {
+		file.CorruptBlocks = append(file.CorruptBlocks, groupId)
+		return
+	}
+	policy := erasureCodingPolicies[group.Policy]
+	available := 0
+	dataNodes := make(map[uint64]bool)
+	misReplicated := false
+	for _, blockId := range group.Blocks {
+		if _, ok := nameNode.BlockToGenerationStamp[blockId]; !ok {
+			file.CorruptBlocks = append(file.CorruptBlocks, groupId)
+			return
+		}
+		registered := nameNode.registeredDataNodes(nameNode.BlockToDataNodeIds[blockId])
+		if len(registered) > 0 {
+			available++
+		}
+		for _, id := range registered {
+			misReplicated = misReplicated || dataNodes[id]
+			dataNodes[id] = true
+		}
+	}
+	switch {
+	case available < policy.DataUnits:
+		file.MissingBlocks = append(file.MissingBlocks, groupId)
+		return
+	case available < policy.totalUnits():
+		file.UnderReplicatedBlocks = append(file.UnderReplicatedBlocks, groupId)
+	}
+	if misReplicated {
+		file.MisReplicatedBlocks = append(file.MisReplicatedBlocks, groupId)
+	}
+}
+
+// registeredDataNodes 过滤出已注册的dataNode
+func (nameNode *Service) registeredDataNodes(dataNodeIds []uint64) []uint64 {
+	return filterDataNodes(dataNodeIds, func(id uint64) bool {
+		_, ok := nameNode.IdToDataNodes[id]
+		return ok
+	})
+}
+
+// rackCount 已注册的dataNode分布的机架数
+func (nameNode *Service) rackCount() int {
+	racks := make(map[string]bool)
+	for id := range nameNode.IdToDataNodes {
+		racks[nameNode.RackOf(id)] = true
+	}
+	return len(racks)
+}
+
+// deleteFile 删除文件的元数据，并通知dataNode删除文件剩余的块
+func (nameNode *Service) deleteFile(fileName string) {
+	separator := strings.LastIndex(fileName, "/") + 1
+	remoteFilePath := fileName[:separator]
+	var fileNames []string
+	for _, name := range nameNode.DirectoryToFileName[remoteFilePath] {
+		if name != fileName[separator:] {
+			fileNames = append(fileNames, name)
+		}
+	}
+	nameNode.DirectoryToFileName[remoteFilePath] = fileNames
+	nameNode.discardFile(fileName)
+}
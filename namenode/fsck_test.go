@@ -0,0 +1,87 @@
+package namenode
+
+import (
+	"github.com/liuzongzhou/GoDFS/datanode"
+	"github.com/liuzongzhou/GoDFS/util"
+	"testing"
+)
+
+// TestNameNodeServiceFsck 测试识别丢失、损坏、副本不足和副本放置不合理的块，并删除损坏的文件
+func TestNameNodeServiceFsck(t *testing.T) {
+	testNameNodeService := NewService("9000", "localhost", 4, 2, 9000)
+	testNameNodeService.SuperUser = "root"
+	testNameNodeService.IdToDataNodes[0] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "1234", Rack: "/rack1"}
+	testNameNodeService.IdToDataNodes[1] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "4321", Rack: "/rack1"}
+	testNameNodeService.IdToDataNodes[2] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "5678", Rack: "/rack2"}
+	var foo, bar, baz []NameNodeMetaData
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/Test1/", FileName: "foo", FileSize: 8}, &foo))
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/Test1/", FileName: "bar", FileSize: 4}, &bar))
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/Test2/", FileName: "baz", FileSize: 4}, &baz))
+
+	var report FsckReport
+	util.Check(testNameNodeService.Fsck(&NameNodeFsckRequest{Path: "/"}, &report))
+	if !report.Healthy || report.TotalFiles != 3 || report.TotalBlocks != 4 || report.Racks != 2 {
+		t.Fatalf("Unable to check healthy namespace: %+v", report)
+	}
+
+	// foo的第一个块丢失，第二个块的副本记录在未注册的dataNode上，bar只剩一个副本，baz的副本都在同一个机架上
+	testNameNodeService.BlockToDataNodeIds[foo[0].BlockId] = nil
+	testNameNodeService.BlockToDataNodeIds[foo[1].BlockId] = []uint64{7}
+	testNameNodeService.BlockToDataNodeIds[bar[0].BlockId] = []uint64{2}
+	testNameNodeService.BlockToDataNodeIds[baz[0].BlockId] = []uint64{0, 1}
+	report = FsckReport{}
+	util.Check(testNameNodeService.Fsck(&NameNodeFsckRequest{Path: "/Test1/"}, &report))
+	if report.Healthy || report.TotalFiles != 2 || report.MissingBlocks != 1 || report.CorruptBlocks != 1 || report.UnderReplicatedBlocks != 1 {
+		t.Errorf("Unable to check blocks under directory: %+v", report)
+	}
+	if len(report.CorruptFiles) != 1 || report.CorruptFiles[0] != "/Test1/foo" {
+		t.Errorf("Unable to find corrupt files: %v", report.CorruptFiles)
+	}
+	report = FsckReport{}
+	util.Check(testNameNodeService.Fsck(&NameNodeFsckRequest{Path: "/Test2/baz"}, &report))
+	if !report.Healthy || report.TotalFiles != 1 || report.MisReplicatedBlocks != 1 {
+		t.Errorf("Unable to find mis-replicated blocks: %+v", report)
+	}
+	if err := testNameNodeService.Fsck(&NameNodeFsckRequest{Path: "/Test3/"}, &report); err == nil {
+		t.Errorf("Fsck of a path that does not exist should fail")
+	}
+
+	// 删除损坏的文件，只允许超级用户
+	if err := testNameNodeService.Fsck(&NameNodeFsckRequest{Path: "/", Delete: true, User: UserInfo{Name: "alice"}}, &report); err == nil {
+		t.Errorf("Only the super user should delete corrupt files")
+	}
+	report = FsckReport{}
+	util.Check(testNameNodeService.Fsck(&NameNodeFsckRequest{Path: "/", Delete: true, User: UserInfo{Name: "root"}}, &report))
+	if len(report.DeletedFiles) != 1 || report.DeletedFiles[0] != "/Test1/foo" {
+		t.Errorf("Unable to delete corrupt files: %v", report.DeletedFiles)
+	}
+	if _, ok := testNameNodeService.FileNameToBlocks["/Test1/foo"]; ok || len(testNameNodeService.DirectoryToFileName["/Test1/"]) != 1 {
+		t.Errorf("Unable to delete metadata of corrupt files")
+	}
+	if _, ok := testNameNodeService.BlockToGenerationStamp[foo[0].BlockId]; ok {
+		t.Errorf("Unable to delete blocks of corrupt files")
+	}
+}
+
+// TestNameNodeServiceFsckErasureCoded 测试纠删码块组丢失的内部块不超过校验块数时副本不足，超过时丢失
+func TestNameNodeServiceFsckErasureCoded(t *testing.T) {
+	testNameNodeService := newErasureCodingTestService()
+	var reply []NameNodeMetaData
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/Test1/", FileName: "foo", FileSize: 12}, &reply))
+	group := testNameNodeService.BlockGroups[reply[0].BlockId]
+
+	testNameNodeService.BlockToDataNodeIds[group.Blocks[0]] = nil
+	testNameNodeService.BlockToDataNodeIds[group.Blocks[4]] = nil
+	var report FsckReport
+	util.Check(testNameNodeService.Fsck(&NameNodeFsckRequest{Path: "/Test1/foo"}, &report))
+	if !report.Healthy || report.UnderReplicatedBlocks != 1 || report.Files[0].Policy != "RS-3-2" {
+		t.Errorf("Block group with recoverable internal blocks should be under-replicated: %+v", report)
+	}
+
+	testNameNodeService.BlockToDataNodeIds[group.Blocks[1]] = nil
+	report = FsckReport{}
+	util.Check(testNameNodeService.Fsck(&NameNodeFsckRequest{Path: "/Test1/foo"}, &report))
+	if report.Healthy || report.MissingBlocks != 1 {
+		t.Errorf("Block group with too few internal blocks should be missing: %+v", report)
+	}
+}
@@ -0,0 +1,130 @@
+package namenode
+
+import (
+	"bytes"
+	"encoding/gob"
+	"os"
+	"path/filepath"
+)
+
+// namespaceImage 持久化到磁盘的命名空间镜像，只包含文件和块的元数据，块的位置在重启后由dataNode的块汇报重建
+type namespaceImage struct {
+	FileNameToBlocks          map[string][]string
+	FileNameSize              map[string]uint64
+	DirectoryToFileName       map[string][]string
+	GenerationStamp           uint64
+	BlockToGenerationStamp    map[string]uint64
+	FileNameToReplication     map[string]uint64
+	DirectoryToReplication    map[string]uint64
+	DataNodeAdminStates       map[string]DataNodeAdminState
+	DirectoryToECPolicy       map[string]string
+	FileNameToECPolicy        map[string]string
+	BlockGroups               map[string]BlockGroup
+	PathToPermission          map[string]Permission
+	PathToAcl                 map[string][]AclEntry
+	DirectoryToEncryptionZone map[string]EncryptionZone
+	FileNameToEncryption      map[string]FileEncryptionInfo
+	DirectoryToQuota          map[string]Quota
+	DirectoryToSnapshots      map[string][]Snapshot
+	SnapshotBlockPaths        map[string]string
+	FileNameToConversion      map[string]Conversion
+}
+
+// SaveImage 把命名空间镜像写入文件，先写临时文件再重命名，保证镜像文件总是完整的
+func (nameNode *Service) SaveImage(imageFile string) error {
+	data, err := nameNode.encodeImage()
+	if err != nil {
+		return err
+	}
+	tempFile, err := os.CreateTemp(filepath.Dir(imageFile), filepath.Base(imageFile)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+	_, err = tempFile.Write(data)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tempFile.Name(), imageFile)
+}
+
+// encodeImage 在命名空间读锁下编码镜像，得到某一时刻完整的命名空间，写文件时不持有锁
+func (nameNode *Service) encodeImage() ([]byte, error) {
+	nameNode.rLock()
+	defer nameNode.rUnlock()
+	image := namespaceImage{
+		FileNameToBlocks:          nameNode.FileNameToBlocks,
+		FileNameSize:              nameNode.FileNameSize,
+		DirectoryToFileName:       nameNode.DirectoryToFileName,
+		GenerationStamp:           nameNode.GenerationStamp,
+		BlockToGenerationStamp:    nameNode.BlockToGenerationStamp,
+		FileNameToReplication:     nameNode.FileNameToReplication,
+		DirectoryToReplication:    nameNode.DirectoryToReplication,
+		DataNodeAdminStates:       nameNode.DataNodeAdminStates,
+		DirectoryToECPolicy:       nameNode.DirectoryToECPolicy,
+		FileNameToECPolicy:        nameNode.FileNameToECPolicy,
+		BlockGroups:               nameNode.BlockGroups,
+		PathToPermission:          nameNode.PathToPermission,
+		PathToAcl:                 nameNode.PathToAcl,
+		DirectoryToEncryptionZone: nameNode.DirectoryToEncryptionZone,
+		FileNameToEncryption:      nameNode.FileNameToEncryption,
+		DirectoryToQuota:          nameNode.DirectoryToQuota,
+		DirectoryToSnapshots:      nameNode.DirectoryToSnapshots,
+		SnapshotBlockPaths:        nameNode.SnapshotBlockPaths,
+		FileNameToConversion:      nameNode.FileNameToConversion,
+	}
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(image); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// LoadImage 从文件加载命名空间镜像，镜像中有块时进入启动安全模式，等待dataNode汇报块。
+// 加载镜像之后，离开安全模式时块汇报中不属于任何文件的孤儿副本才会被删除
+func (nameNode *Service) LoadImage(imageFile string) error {
+	file, err := os.Open(imageFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var image namespaceImage
+	err = gob.NewDecoder(file).Decode(&image)
+	if err != nil {
+		return err
+	}
+	nameNode.lock()
+	defer nameNode.unlock()
+	nameNode.FileNameToBlocks = image.FileNameToBlocks
+	nameNode.FileNameSize = image.FileNameSize
+	nameNode.DirectoryToFileName = image.DirectoryToFileName
+	nameNode.GenerationStamp = image.GenerationStamp
+	nameNode.BlockToGenerationStamp = image.BlockToGenerationStamp
+	nameNode.FileNameToReplication = image.FileNameToReplication
+	nameNode.DirectoryToReplication = image.DirectoryToReplication
+	nameNode.DataNodeAdminStates = image.DataNodeAdminStates
+	nameNode.DirectoryToECPolicy = image.DirectoryToECPolicy
+	nameNode.FileNameToECPolicy = image.FileNameToECPolicy
+	nameNode.BlockGroups = image.BlockGroups
+	nameNode.PathToPermission = image.PathToPermission
+	nameNode.PathToAcl = image.PathToAcl
+	nameNode.DirectoryToEncryptionZone = image.DirectoryToEncryptionZone
+	nameNode.FileNameToEncryption = image.FileNameToEncryption
+	nameNode.DirectoryToQuota = image.DirectoryToQuota
+	nameNode.DirectoryToSnapshots = image.DirectoryToSnapshots
+	nameNode.SnapshotBlockPaths = image.SnapshotBlockPaths
+	nameNode.FileNameToConversion = image.FileNameToConversion
+	nameNode.BlockToDataNodeIds = make(map[string][]uint64)
+	nameNode.BlockToFileName = nil
+	nameNode.quotaUsage = nil
+	// gob不编码空map，解码后为nil的map需要重新初始化，否则写入时panic；同时重建块的反向索引和配额用量
+	nameNode.initMetaData()
+	nameNode.namespaceLoaded = true
+	if len(nameNode.BlockToGenerationStamp) > 0 {
+		nameNode.enterSafeMode(false)
+	}
+	return nil
+}
@@ -1,24 +1,37 @@
 package namenode
 
 import (
+	"bytes"
+	"encoding/gob"
 	"errors"
 	"github.com/google/uuid"
+	"github.com/liuzongzhou/GoDFS/auth"
 	"github.com/liuzongzhou/GoDFS/datanode"
 	"log"
 	"math"
 	"math/rand"
-	"net/rpc"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 // NameNodeMetaData nameNode的元数据，包含块id，块地址
 type NameNodeMetaData struct {
-	BlockId        string
-	BlockAddresses []datanode.DataNodeInstance //datanode的实例数组
+	BlockId         string
+	BlockAddresses  []datanode.DataNodeInstance //datanode的实例数组
+	GenerationStamp uint64                      //块当前有效的generation stamp
+	ECPolicy        ErasureCodingPolicy         //纠删码策略，DataUnits为0时为多副本存储；纠删码块组的BlockAddresses按分片下标排列
+	GroupSize       uint64                      //纠删码块组中文件数据的字节数
+	BlockToken      string                      //访问块的令牌，纠删码块组的令牌包含所有内部块，没有设置密钥时为空
+	BlockPath       string                      //块在dataNode上所在的目录，为空时为文件所在的目录；快照中的文件的块可能在其他目录下
 }
 
 type NameNodeReadRequest struct {
 	FileName string
+	User     UserInfo
+	Delete   bool //为true时返回删除块的令牌，需要在上级目录中删除文件的权限
 }
 
 type NameNodeFileSize struct {
@@ -27,28 +40,37 @@ type NameNodeFileSize struct {
 
 // NameNodeWriteRequest nameNode写入请求
 type NameNodeWriteRequest struct {
-	RemoteFilePath string
-	FileName       string
-	FileSize       uint64
+	RemoteFilePath    string
+	FileName          string
+	FileSize          uint64
+	ReplicationFactor uint64 //文件的副本因子，为0时继承目录的默认副本因子
+	ClientHost        string //写入客户端所在的主机，用于就近放置第一个副本
+	User              UserInfo
+	Encryption        FileEncryptionInfo //加密区中的文件由客户端生成并用加密区密钥加密的数据密钥，FileSize为密文大小
 }
 
-type ReDistributeDataRequest struct {
-	DataNodeUri string
+// NameNodeSetReplicationRequest 修改副本因子请求，FileName为空时设置目录的默认副本因子
+type NameNodeSetReplicationRequest struct {
+	RemoteFilePath    string
+	FileName          string
+	ReplicationFactor uint64
+	User              UserInfo
 }
 
-type UnderReplicatedBlocks struct {
-	BlockId           string
-	HealthyDataNodeId uint64
+type ReDistributeDataRequest struct {
+	DataNodeUri string
 }
 
 type NameNodeReNameRequest struct {
 	ReNameSrcPath  string
 	ReNameDestPath string
+	User           UserInfo
 }
 
 type NameNodeDeleteRequest struct {
 	RemoteFilePath string
 	FileName       string
+	User           UserInfo
 }
 
 type ListMetaData struct {
@@ -58,56 +80,319 @@ type ListMetaData struct {
 
 type NameNodeListRequest struct {
 	RemoteDirPath string
+	User          UserInfo
 }
 
 type NameNodeReNameFileRequest struct {
 	ReNameSrcFileName  string
 	ReNameDestFileName string
+	User               UserInfo
+}
+
+// HeartbeatRequest dataNode心跳汇报的容量和负载
+type HeartbeatRequest struct {
+	DataNodeId uint64
+	Heartbeat  datanode.DataNodeHeartbeat
+}
+
+// BlockReportRequest dataNode的块汇报
+type BlockReportRequest struct {
+	DataNodeId uint64
+	Blocks     []datanode.BlockInfo
 }
 
 type Service struct {
-	PrimaryPort         string
-	Host                string
-	Port                uint16
-	BlockSize           uint64
-	ReplicationFactor   uint64
-	IdToDataNodes       map[uint64]datanode.DataNodeInstance
-	FileNameToBlocks    map[string][]string //key:path+filename
-	BlockToDataNodeIds  map[string][]uint64 //key:BlockId value：主+备份节点
-	FileNameSize        map[string]uint64   //文件大小 key:path+filename
-	DirectoryToFileName map[string][]string //目录下对应的文件 key:path value：该目录下所有文件名
+	PrimaryPort               string
+	Host                      string
+	Port                      uint16
+	BlockSize                 uint64
+	ReplicationFactor         uint64
+	IdToDataNodes             map[uint64]datanode.DataNodeInstance
+	FileNameToBlocks          map[string][]string                   //key:path+filename
+	BlockToFileName           map[string]string                     //FileNameToBlocks的反向索引 key:BlockId或者块组id value:path+filename，不随元数据同步，加载后重建
+	BlockToDataNodeIds        map[string][]uint64                   //key:BlockId value：主+备份节点
+	FileNameSize              map[string]uint64                     //文件大小 key:path+filename
+	DirectoryToFileName       map[string][]string                   //目录下对应的文件 key:path value：该目录下所有文件名
+	GenerationStamp           uint64                                //全局递增的generation stamp，分配新块或者副本恢复时递增
+	BlockToGenerationStamp    map[string]uint64                     //key:BlockId value:该块当前有效的generation stamp
+	DataNodeUsedSpace         map[uint64]uint64                     //key:dataNodeId value:根据块汇报统计的已用空间
+	FileNameToReplication     map[string]uint64                     //文件的副本因子 key:path+filename
+	DirectoryToReplication    map[string]uint64                     //目录下新文件默认的副本因子 key:path
+	DataNodeStats             map[uint64]datanode.DataNodeHeartbeat //key:dataNodeId value:最近一次心跳汇报的容量和负载
+	MinFreeSpace              uint64                                //写入时dataNode至少要剩余的空间，不足一个块大小时按一个块大小
+	DataNodeAdminStates       map[string]DataNodeAdminState         //dataNode的管理状态 key:host:port，不在其中的为正常状态
+	DirectoryToECPolicy       map[string]string                     //目录下新文件的纠删码策略 key:path value:策略名
+	FileNameToECPolicy        map[string]string                     //纠删码文件的策略 key:path+filename，不在其中的为多副本文件
+	BlockGroups               map[string]BlockGroup                 //纠删码块组 key:块组id
+	PathToPermission          map[string]Permission                 //文件和目录的权限 key:path+filename，目录以/结尾，没有记录的继承上级目录
+	PathToAcl                 map[string][]AclEntry                 //文件和目录的ACL key:path+filename，只记录有扩展条目或者默认ACL的路径
+	DirectoryToEncryptionZone map[string]EncryptionZone             //加密区 key:path
+	FileNameToEncryption      map[string]FileEncryptionInfo         //加密文件的数据密钥信息 key:path+filename，不在其中的文件没有加密
+	DirectoryToQuota          map[string]Quota                      //目录的名字配额和空间配额 key:path
+	DirectoryToSnapshots      map[string][]Snapshot                 //可以创建快照的目录的快照，按创建时间排列 key:path
+	SnapshotBlockPaths        map[string]string                     //只被快照引用的块在dataNode上所在的目录 key:BlockId或者块组id
+	FileNameToConversion      map[string]Conversion                 //正在转换存储方式的文件 key:path+filename
+	SuperUser                 string                                //超级用户，跳过权限检查，由启动nameNode的用户担任
+	MaxReplicationStreams     int                                   //后台副本复制同时进行的最大数量
+	SafeModeThreshold         float64                               //至少有一个副本汇报上来的块所占的比例，达到后自动离开启动安全模式
+	TrashInterval             time.Duration                         //回收站中的检查点保留的时间，为0时关闭回收站，删除立即生效
+	TrashCheckpointInterval   time.Duration                         //把回收站的Current目录保存为检查点的间隔，为0时与TrashInterval相同
+	replicationQueue          *underReplicatedQueue
+	topology                  map[string]string //拓扑映射 key:host:port或者host value:机架，各nameNode启动时各自加载
+	placementPolicy           BlockPlacementPolicy
+	pendingMoves              map[string]pendingMove //balancer正在移动的块 key:BlockId
+	safeMode                  safeModeState
+	lastHeartbeats            map[uint64]time.Time    //key:dataNodeId value:最近一次收到心跳的时间，各nameNode各自记录
+	deadDataNodes             map[string]deadDataNode //被判定为dead的dataNode key:host:port
+	blockKey                  []byte                  //与dataNode共享的密钥，设置后为块签发访问令牌，不随元数据同步
+	quotaUsage                map[string]quotaCharge  //设置了配额的目录当前的用量 key:path，没有配额时为nil，不随元数据同步，加载后重建
+	pathCharges               map[string]quotaCharge  //各路径计入上级目录配额的用量 key:path+filename，目录以/结尾，只在有配额时维护
+	namespaceLoaded           bool                    //命名空间从镜像加载或者从主节点同步过，之后才删除块汇报中的孤儿副本
+	pendingCommands           []dataNodeCommand       //持有命名空间写锁时记录的dataNode命令，释放锁之后发送
+	mu                        *sync.RWMutex           //命名空间锁，rpc方法和后台任务读写元数据前加锁；用指针使Service可以作为rpc的reply复制
+}
+
+// namespaceLockInit 保护结构体字面量创建的Service第一次使用时创建命名空间锁
+var namespaceLockInit sync.Mutex
+
+// namespaceLock 得到命名空间锁，结构体字面量创建的Service在第一次使用时创建
+func (nameNode *Service) namespaceLock() *sync.RWMutex {
+	namespaceLockInit.Lock()
+	defer namespaceLockInit.Unlock()
+	if nameNode.mu == nil {
+		nameNode.mu = new(sync.RWMutex)
+	}
+	return nameNode.mu
+}
+
+// lock 加命名空间写锁，修改元数据的rpc方法和后台任务使用；加锁后创建还没有初始化的map
+func (nameNode *Service) lock() {
+	nameNode.namespaceLock().Lock()
+	nameNode.initMetaData()
+}
+
+// initMetaData 创建为nil的元数据map：没有通过NewService创建的Service、gob解码的空map都为nil，写入时会panic
+func (nameNode *Service) initMetaData() {
+	if nameNode.IdToDataNodes == nil {
+		nameNode.IdToDataNodes = make(map[uint64]datanode.DataNodeInstance)
+	}
+	for _, m := range []*map[string][]string{&nameNode.FileNameToBlocks, &nameNode.DirectoryToFileName} {
+		if *m == nil {
+			*m = make(map[string][]string)
+		}
+	}
+	if nameNode.BlockToDataNodeIds == nil {
+		nameNode.BlockToDataNodeIds = make(map[string][]uint64)
+	}
+	//结构体字面量创建的Service第一次加锁时根据已有的FileNameToBlocks建立反向索引
+	if nameNode.BlockToFileName == nil {
+		nameNode.rebuildBlockIndex()
+	}
+	for _, m := range []*map[string]uint64{&nameNode.FileNameSize, &nameNode.BlockToGenerationStamp, &nameNode.FileNameToReplication, &nameNode.DirectoryToReplication} {
+		if *m == nil {
+			*m = make(map[string]uint64)
+		}
+	}
+	if nameNode.DataNodeUsedSpace == nil {
+		nameNode.DataNodeUsedSpace = make(map[uint64]uint64)
+	}
+	if nameNode.DataNodeStats == nil {
+		nameNode.DataNodeStats = make(map[uint64]datanode.DataNodeHeartbeat)
+	}
+	if nameNode.DataNodeAdminStates == nil {
+		nameNode.DataNodeAdminStates = make(map[string]DataNodeAdminState)
+	}
+	for _, m := range []*map[string]string{&nameNode.DirectoryToECPolicy, &nameNode.FileNameToECPolicy, &nameNode.SnapshotBlockPaths} {
+		if *m == nil {
+			*m = make(map[string]string)
+		}
+	}
+	if nameNode.BlockGroups == nil {
+		nameNode.BlockGroups = make(map[string]BlockGroup)
+	}
+	if nameNode.PathToPermission == nil {
+		nameNode.PathToPermission = make(map[string]Permission)
+	}
+	if nameNode.PathToAcl == nil {
+		nameNode.PathToAcl = make(map[string][]AclEntry)
+	}
+	if nameNode.DirectoryToEncryptionZone == nil {
+		nameNode.DirectoryToEncryptionZone = make(map[string]EncryptionZone)
+	}
+	if nameNode.FileNameToEncryption == nil {
+		nameNode.FileNameToEncryption = make(map[string]FileEncryptionInfo)
+	}
+	if nameNode.DirectoryToQuota == nil {
+		nameNode.DirectoryToQuota = make(map[string]Quota)
+	}
+	if nameNode.DirectoryToSnapshots == nil {
+		nameNode.DirectoryToSnapshots = make(map[string][]Snapshot)
+	}
+	if nameNode.pendingMoves == nil {
+		nameNode.pendingMoves = make(map[string]pendingMove)
+	}
+	if nameNode.FileNameToConversion == nil {
+		nameNode.FileNameToConversion = make(map[string]Conversion)
+	}
+	if nameNode.replicationQueue == nil {
+		nameNode.replicationQueue = newUnderReplicatedQueue()
+	}
+	if nameNode.lastHeartbeats == nil {
+		nameNode.lastHeartbeats = make(map[uint64]time.Time)
+	}
+	if nameNode.deadDataNodes == nil {
+		nameNode.deadDataNodes = make(map[string]deadDataNode)
+	}
+	if nameNode.quotaUsage == nil && len(nameNode.DirectoryToQuota) > 0 {
+		nameNode.rebuildQuotaUsage()
+	}
+}
+
+// unlock 释放命名空间写锁
+func (nameNode *Service) unlock() {
+	commands := nameNode.pendingCommands
+	nameNode.pendingCommands = nil
+	nameNode.mu.Unlock()
+	sendCommands(commands)
+}
+
+// rLock 加命名空间读锁，只读取元数据的rpc方法使用
+func (nameNode *Service) rLock() {
+	nameNode.namespaceLock().RLock()
+}
+
+// rUnlock 释放命名空间读锁
+func (nameNode *Service) rUnlock() {
+	nameNode.mu.RUnlock()
 }
 
 func NewService(primaryPort string, serverHost string, blockSize uint64, replicationFactor uint64, serverPort uint16) *Service {
 	return &Service{
-		PrimaryPort:         primaryPort,
-		Host:                serverHost,
-		Port:                serverPort,
-		BlockSize:           blockSize,
-		ReplicationFactor:   replicationFactor,
-		FileNameToBlocks:    make(map[string][]string),
-		IdToDataNodes:       make(map[uint64]datanode.DataNodeInstance),
-		BlockToDataNodeIds:  make(map[string][]uint64),
-		FileNameSize:        make(map[string]uint64),
-		DirectoryToFileName: make(map[string][]string),
+		PrimaryPort:               primaryPort,
+		Host:                      serverHost,
+		Port:                      serverPort,
+		BlockSize:                 blockSize,
+		ReplicationFactor:         replicationFactor,
+		FileNameToBlocks:          make(map[string][]string),
+		BlockToFileName:           make(map[string]string),
+		IdToDataNodes:             make(map[uint64]datanode.DataNodeInstance),
+		BlockToDataNodeIds:        make(map[string][]uint64),
+		FileNameSize:              make(map[string]uint64),
+		DirectoryToFileName:       make(map[string][]string),
+		BlockToGenerationStamp:    make(map[string]uint64),
+		DataNodeUsedSpace:         make(map[uint64]uint64),
+		FileNameToReplication:     make(map[string]uint64),
+		DirectoryToReplication:    make(map[string]uint64),
+		DataNodeStats:             make(map[uint64]datanode.DataNodeHeartbeat),
+		DataNodeAdminStates:       make(map[string]DataNodeAdminState),
+		DirectoryToECPolicy:       make(map[string]string),
+		FileNameToECPolicy:        make(map[string]string),
+		BlockGroups:               make(map[string]BlockGroup),
+		PathToPermission:          make(map[string]Permission),
+		PathToAcl:                 make(map[string][]AclEntry),
+		DirectoryToEncryptionZone: make(map[string]EncryptionZone),
+		FileNameToEncryption:      make(map[string]FileEncryptionInfo),
+		DirectoryToQuota:          make(map[string]Quota),
+		DirectoryToSnapshots:      make(map[string][]Snapshot),
+		SnapshotBlockPaths:        make(map[string]string),
+		FileNameToConversion:      make(map[string]Conversion),
+		MaxReplicationStreams:     defaultMaxReplicationStreams,
+		SafeModeThreshold:         defaultSafeModeThreshold,
+		replicationQueue:          newUnderReplicatedQueue(),
+		placementPolicy:           rackAwarePlacementPolicy{},
+		pendingMoves:              make(map[string]pendingMove),
+		lastHeartbeats:            make(map[uint64]time.Time),
+		deadDataNodes:             make(map[string]deadDataNode),
+		mu:                        new(sync.RWMutex),
 	}
 }
 
 // ReplicationnameNode 获取主nameNode节点的元数据信息：FileNameToBlocks，IdToDataNodes，BlockToDataNodeIds，FileNameSize,DirectoryToFileName
+// 返回的是加锁时元数据的深拷贝，rpc在方法返回后才编码reply，不能引用仍在被修改的map
 func (nameNode *Service) ReplicationnameNode(request *bool, reply *Service) error {
+	nameNode.rLock()
+	defer nameNode.rUnlock()
 	if *request {
-		*reply = Service{
-			IdToDataNodes:       nameNode.IdToDataNodes,
-			FileNameSize:        nameNode.FileNameSize,
-			BlockToDataNodeIds:  nameNode.BlockToDataNodeIds,
-			FileNameToBlocks:    nameNode.FileNameToBlocks,
-			DirectoryToFileName: nameNode.DirectoryToFileName,
+		metadata := Service{
+			IdToDataNodes:             nameNode.IdToDataNodes,
+			FileNameSize:              nameNode.FileNameSize,
+			BlockToDataNodeIds:        nameNode.BlockToDataNodeIds,
+			FileNameToBlocks:          nameNode.FileNameToBlocks,
+			DirectoryToFileName:       nameNode.DirectoryToFileName,
+			GenerationStamp:           nameNode.GenerationStamp,
+			BlockToGenerationStamp:    nameNode.BlockToGenerationStamp,
+			FileNameToReplication:     nameNode.FileNameToReplication,
+			DirectoryToReplication:    nameNode.DirectoryToReplication,
+			DataNodeAdminStates:       nameNode.DataNodeAdminStates,
+			DirectoryToECPolicy:       nameNode.DirectoryToECPolicy,
+			FileNameToECPolicy:        nameNode.FileNameToECPolicy,
+			BlockGroups:               nameNode.BlockGroups,
+			PathToPermission:          nameNode.PathToPermission,
+			PathToAcl:                 nameNode.PathToAcl,
+			DirectoryToEncryptionZone: nameNode.DirectoryToEncryptionZone,
+			FileNameToEncryption:      nameNode.FileNameToEncryption,
+			DirectoryToQuota:          nameNode.DirectoryToQuota,
+			DirectoryToSnapshots:      nameNode.DirectoryToSnapshots,
+			SnapshotBlockPaths:        nameNode.SnapshotBlockPaths,
+			FileNameToConversion:      nameNode.FileNameToConversion,
 		}
-		return nil
+		var buffer bytes.Buffer
+		if err := gob.NewEncoder(&buffer).Encode(&metadata); err != nil {
+			return err
+		}
+		return gob.NewDecoder(&buffer).Decode(reply)
 	}
 	return errors.New("获取元数据失败")
 }
 
+// SyncMetaData 备份nameNode用从主节点同步的元数据替换自己的元数据
+func (nameNode *Service) SyncMetaData(metadata *Service) {
+	nameNode.lock()
+	defer nameNode.unlock()
+	nameNode.FileNameToBlocks = metadata.FileNameToBlocks
+	nameNode.IdToDataNodes = metadata.IdToDataNodes
+	nameNode.BlockToDataNodeIds = metadata.BlockToDataNodeIds
+	nameNode.FileNameSize = metadata.FileNameSize
+	nameNode.DirectoryToFileName = metadata.DirectoryToFileName
+	nameNode.GenerationStamp = metadata.GenerationStamp
+	nameNode.BlockToGenerationStamp = metadata.BlockToGenerationStamp
+	nameNode.FileNameToReplication = metadata.FileNameToReplication
+	nameNode.DirectoryToReplication = metadata.DirectoryToReplication
+	nameNode.DataNodeAdminStates = metadata.DataNodeAdminStates
+	nameNode.DirectoryToECPolicy = metadata.DirectoryToECPolicy
+	nameNode.FileNameToECPolicy = metadata.FileNameToECPolicy
+	nameNode.BlockGroups = metadata.BlockGroups
+	nameNode.PathToPermission = metadata.PathToPermission
+	nameNode.PathToAcl = metadata.PathToAcl
+	nameNode.DirectoryToEncryptionZone = metadata.DirectoryToEncryptionZone
+	nameNode.FileNameToEncryption = metadata.FileNameToEncryption
+	nameNode.DirectoryToQuota = metadata.DirectoryToQuota
+	nameNode.DirectoryToSnapshots = metadata.DirectoryToSnapshots
+	nameNode.SnapshotBlockPaths = metadata.SnapshotBlockPaths
+	nameNode.FileNameToConversion = metadata.FileNameToConversion
+	nameNode.rebuildBlockIndex()
+	nameNode.rebuildQuotaUsage()
+	nameNode.namespaceLoaded = true
+}
+
+// BecomePrimary 主nameNode挂了之后备份nameNode升级为主节点
+func (nameNode *Service) BecomePrimary() {
+	nameNode.lock()
+	defer nameNode.unlock()
+	nameNode.PrimaryPort = strconv.FormatUint(uint64(nameNode.Port), 10)
+}
+
+// DataNodes 当前管理的dataNodes的拷贝，供后台心跳检测遍历
+func (nameNode *Service) DataNodes() map[uint64]datanode.DataNodeInstance {
+	nameNode.rLock()
+	defer nameNode.rUnlock()
+	dataNodes := make(map[uint64]datanode.DataNodeInstance)
+	for id, dn := range nameNode.IdToDataNodes {
+		dataNodes[id] = dn
+	}
+	return dataNodes
+}
+
 //selectRandomNumbers 随机选择存储节点，尽量做到负载均衡
 func selectRandomNumbers(dataNodesAvailable []uint64, replicationFactor uint64) (randomNumberSet []uint64) {
 	//当前已经选择的datanodeId,防止备份BlockId文件写再同一个节点上
@@ -126,6 +411,8 @@ func selectRandomNumbers(dataNodesAvailable []uint64, replicationFactor uint64)
 
 // GetBlockSize 获取存储的每个Block大小
 func (nameNode *Service) GetBlockSize(request bool, reply *uint64) error {
+	nameNode.rLock()
+	defer nameNode.rUnlock()
 	if request {
 		*reply = nameNode.BlockSize
 	}
@@ -136,10 +423,50 @@ func (nameNode *Service) GetBlockSize(request bool, reply *uint64) error {
 // request:文件名：path + fileName
 // 返回信息：BlockIds对应的BlockAddresses（datanode的host+port）
 func (nameNode *Service) ReadData(request *NameNodeReadRequest, reply *[]NameNodeMetaData) error {
+	nameNode.rLock()
+	defer nameNode.rUnlock()
+	if inSnapshot(request.FileName) {
+		return nameNode.readSnapshotData(request, reply)
+	}
+	mode := auth.BlockAccessRead
+	if request.Delete {
+		if err := nameNode.checkParentPermission(request.User, request.FileName); err != nil {
+			return err
+		}
+		mode = auth.BlockAccessDelete
+	} else if err := nameNode.checkPermission(request.User, request.FileName, ActionRead); err != nil {
+		return err
+	}
 	//读取nameNode里面FileNameToBlocks的元数据信息，通过key获取BlockIds
 	fileBlocks := nameNode.FileNameToBlocks[request.FileName]
+	//客户端删除文件时，被快照引用的块不返回，由nameNode保留；文件的状态由DeleteFileNameMetaData记录到快照，
+	//还没有被记录时文件当前的块都会被最近一个快照引用
+	if request.Delete {
+		if _, _, ok := nameNode.unpreservedSnapshot(request.FileName); ok {
+			fileBlocks = nil
+		}
+		var unreferenced []string
+		for _, block := range fileBlocks {
+			if _, ok := nameNode.snapshotReferencing(block); !ok {
+				unreferenced = append(unreferenced, block)
+			}
+		}
+		fileBlocks = unreferenced
+	}
+	*reply = append(*reply, nameNode.blocksMetaData(fileBlocks)...)
+	nameNode.attachBlockTokens(*reply, parentDirectory(request.FileName), mode)
+	return nil
+}
+
+// blocksMetaData 得到块的读取元数据，纠删码块组返回每个内部块的位置
+func (nameNode *Service) blocksMetaData(fileBlocks []string) (metadata []NameNodeMetaData) {
 	//遍历每个BlockId
 	for _, block := range fileBlocks {
+		//纠删码块组返回每个内部块的位置，由客户端读取并解码
+		if _, ok := nameNode.BlockGroups[block]; ok {
+			metadata = append(metadata, nameNode.blockGroupMetaData(block))
+			continue
+		}
 		var blockAddresses []datanode.DataNodeInstance
 		//存储每个BlockId所有的datanodeId,包含备份的
 		targetDataNodeIds := nameNode.BlockToDataNodeIds[block]
@@ -148,13 +475,23 @@ func (nameNode *Service) ReadData(request *NameNodeReadRequest, reply *[]NameNod
 			blockAddresses = append(blockAddresses, nameNode.IdToDataNodes[dataNodeId])
 		}
 		//返回打包的元数据信息
-		*reply = append(*reply, NameNodeMetaData{BlockId: block, BlockAddresses: blockAddresses})
+		metadata = append(metadata, NameNodeMetaData{BlockId: block, BlockAddresses: blockAddresses, GenerationStamp: nameNode.BlockToGenerationStamp[block]})
 	}
-	return nil
+	return
 }
 
 // FileSize 获取文件对应的文件大小
 func (nameNode *Service) FileSize(request *NameNodeReadRequest, reply *NameNodeFileSize) error {
+	nameNode.rLock()
+	defer nameNode.rUnlock()
+	if inSnapshot(request.FileName) {
+		file, err := nameNode.readSnapshotFile(request.User, request.FileName)
+		if err != nil {
+			return err
+		}
+		reply.FileSize = file.Size
+		return nil
+	}
 	//判断元数据信息FileNameSize是否含有key：path+filename,存在取得value:filesize,否则输出文件不存在
 	if value, ok := nameNode.FileNameSize[request.FileName]; ok {
 		reply.FileSize = value // 存在
@@ -166,26 +503,140 @@ func (nameNode *Service) FileSize(request *NameNodeReadRequest, reply *NameNodeF
 // WriteData 传入写入请求：{路径，文件名，文件大小}
 // 返回数据：元数据数组，包含每个BlockId对应多个datanodeId(备份)
 func (nameNode *Service) WriteData(request *NameNodeWriteRequest, reply *[]NameNodeMetaData) error {
-	// 维护FileNameToBlocks元数据信息，key:路径+文件名 value:blockIds 目的：防止出现同名文件无法判断，唯一性
-	nameNode.FileNameToBlocks[request.RemoteFilePath+request.FileName] = []string{}
-	// 维护DirectoryToFileName元数据信息 key:路径 value:该路径下的所有文件名
-	// 当存在此key，则往value数组里面添加值
-	if _, ok := nameNode.DirectoryToFileName[request.RemoteFilePath]; ok {
-		nameNode.DirectoryToFileName[request.RemoteFilePath] = append(nameNode.DirectoryToFileName[request.RemoteFilePath], request.FileName)
-	} else { //如果不存在，则新建key并添加值
-		nameNode.DirectoryToFileName[request.RemoteFilePath] = []string{request.FileName}
+	nameNode.lock()
+	defer nameNode.unlock()
+	if err := nameNode.checkNotInSafeMode(); err != nil {
+		return err
+	}
+	if err := checkNotInSnapshot(request.RemoteFilePath + request.FileName); err != nil {
+		return err
+	}
+	// 没有剩余空间足够的dataNode时直接拒绝写入，避免上传到一半失败
+	var dataNodeIds []uint64
+	for id := range nameNode.IdToDataNodes {
+		dataNodeIds = append(dataNodeIds, id)
+	}
+	writableDataNodes := nameNode.writableDataNodes(dataNodeIds)
+	if len(writableDataNodes) == 0 {
+		return errors.New("没有剩余空间足够的dataNode")
+	}
+	// 目录设置了纠删码策略时，块组的内部块需要放在不同的dataNode上
+	ecPolicy, erasureCoded := nameNode.directoryECPolicy(request.RemoteFilePath)
+	if erasureCoded && len(writableDataNodes) < ecPolicy.totalUnits() {
+		return errors.New("可用的dataNode数量不足以使用纠删码策略" + ecPolicy.Name)
+	}
+	// 加密区中的文件必须由客户端加密后写入
+	if err := nameNode.checkFileEncryption(request.RemoteFilePath, request.Encryption); err != nil {
+		return err
+	}
+	// 维护FileNameToReplication元数据信息 key:路径+文件 value:该文件的副本因子，未指定时继承目录的默认副本因子
+	replicationFactor := request.ReplicationFactor
+	if replicationFactor == 0 {
+		replicationFactor = nameNode.directoryReplication(request.RemoteFilePath)
 	}
+	// 新建的文件和上级目录占用名字配额，分配的块按副本数或者纠删码的校验块占用空间配额
+	if err := nameNode.checkWriteQuota(request.RemoteFilePath+request.FileName, request.FileSize, ecPolicy, erasureCoded, replicationFactor); err != nil {
+		return err
+	}
+	// 写入完成或者撤销后按文件和上级目录最新的状态更新配额用量
+	defer nameNode.chargeQuota(request.RemoteFilePath + request.FileName)
+	// 覆盖已有的文件需要文件的写权限，新建文件需要上级目录的写权限，并记录文件和新建的上级目录的所有者
+	if _, ok := nameNode.FileNameToBlocks[request.RemoteFilePath+request.FileName]; ok {
+		if err := nameNode.checkPermission(request.User, request.RemoteFilePath+request.FileName, ActionWrite); err != nil {
+			return err
+		}
+	} else if err := nameNode.createPermission(request.User, request.RemoteFilePath+request.FileName, defaultFileMode); err != nil {
+		return err
+	}
+	// 目录有快照时先记录文件原来的状态，覆盖已有的文件时被快照引用的原来的块保留下来，其余原来的块删除
+	nameNode.preserveFile(request.RemoteFilePath + request.FileName)
+	if oldBlocks, ok := nameNode.FileNameToBlocks[request.RemoteFilePath+request.FileName]; ok {
+		nameNode.discardBlocks(request.RemoteFilePath, nameNode.retainSnapshotBlocks(request.RemoteFilePath+request.FileName, oldBlocks))
+	}
+	// 覆盖正在转换存储方式的文件时放弃转换
+	nameNode.cancelConversions(request.RemoteFilePath + request.FileName)
+	// 维护FileNameToBlocks元数据信息，key:路径+文件名 value:blockIds 目的：防止出现同名文件无法判断，唯一性
+	nameNode.setFileBlocks(request.RemoteFilePath+request.FileName, []string{})
+	// 维护DirectoryToFileName元数据信息 key:路径 value:该路径下的所有文件名，覆盖已有的文件时不重复添加
+	nameNode.addFileName(request.RemoteFilePath, request.FileName)
 	// 维护FileNameSize元数据信息 key:路径+文件 value:该文件的大小
 	nameNode.FileNameSize[request.RemoteFilePath+request.FileName] = request.FileSize
-	//向上取整 计算上传文件需要分割的块数
-	numberOfBlocksToAllocate := uint64(math.Ceil(float64(request.FileSize) / float64(nameNode.BlockSize)))
-	// 实现分配方案：文件存在哪些datanode节点上（包含备份）
-	*reply = nameNode.allocateBlocks(request.RemoteFilePath+request.FileName, numberOfBlocksToAllocate)
+	// 实现分配方案：文件存在哪些datanode节点上（包含备份），纠删码文件按块组条带化存储，不使用副本因子
+	metadata, err := nameNode.allocateLayout(request.FileSize, ecPolicy, erasureCoded, replicationFactor, request.ClientHost)
+	// 写入过程中dataNode的剩余空间用完，有块分配不到节点，撤销这个文件的元数据
+	if err != nil {
+		nameNode.deleteFileMetaData(request.RemoteFilePath, request.FileName)
+		return err
+	}
+	nameNode.setFileLayout(request.RemoteFilePath+request.FileName, layoutBlockIds(metadata), layoutPolicyName(ecPolicy, erasureCoded), replicationFactor)
+	// 覆盖已有的文件时替换原来的数据密钥
+	if request.Encryption.KeyName != "" {
+		nameNode.FileNameToEncryption[request.RemoteFilePath+request.FileName] = request.Encryption
+	} else {
+		delete(nameNode.FileNameToEncryption, request.RemoteFilePath+request.FileName)
+	}
+	nameNode.attachBlockTokens(metadata, request.RemoteFilePath, auth.BlockAccessWrite)
+	*reply = metadata
 	return nil
 }
 
+// allocateLayout 按存储方式分配块：纠删码文件分配块组，多副本文件按副本因子分配块，
+// 有块分配不到足够的节点时删除已经分配的块并返回错误；分配的块由调用方挂到文件或者转换上
+func (nameNode *Service) allocateLayout(fileSize uint64, ecPolicy ErasureCodingPolicy, erasureCoded bool, replicationFactor uint64, clientHost string) ([]NameNodeMetaData, error) {
+	var metadata []NameNodeMetaData
+	required := 1
+	if erasureCoded {
+		metadata = nameNode.allocateBlockGroups(fileSize, ecPolicy, clientHost)
+		required = ecPolicy.totalUnits()
+	} else {
+		//向上取整 计算上传文件需要分割的块数
+		numberOfBlocksToAllocate := uint64(math.Ceil(float64(fileSize) / float64(nameNode.BlockSize)))
+		metadata = nameNode.allocateBlocks(numberOfBlocksToAllocate, replicationFactor, clientHost)
+	}
+	for _, blockMetaData := range metadata {
+		if len(blockMetaData.BlockAddresses) < required {
+			for _, blockId := range layoutBlockIds(metadata) {
+				nameNode.deleteBlockMetaData(blockId)
+			}
+			return nil, errors.New("dataNode剩余空间不足")
+		}
+	}
+	return metadata, nil
+}
+
+// layoutBlockIds 分配结果中按顺序排列的BlockId，纠删码文件为块组id
+func layoutBlockIds(metadata []NameNodeMetaData) []string {
+	blockIds := []string{}
+	for _, blockMetaData := range metadata {
+		blockIds = append(blockIds, blockMetaData.BlockId)
+	}
+	return blockIds
+}
+
+// layoutPolicyName 存储方式对应的纠删码策略名，多副本存储为空
+func layoutPolicyName(ecPolicy ErasureCodingPolicy, erasureCoded bool) string {
+	if erasureCoded {
+		return ecPolicy.Name
+	}
+	return ""
+}
+
+// setFileLayout 把块挂到文件上并记录文件的存储方式：ecPolicy不为空时为纠删码文件，否则按replicationFactor多副本存储
+func (nameNode *Service) setFileLayout(fileName string, blockIds []string, ecPolicy string, replicationFactor uint64) {
+	nameNode.setFileBlocks(fileName, blockIds)
+	if ecPolicy != "" {
+		nameNode.FileNameToECPolicy[fileName] = ecPolicy
+		delete(nameNode.FileNameToReplication, fileName)
+	} else {
+		nameNode.FileNameToReplication[fileName] = replicationFactor
+		delete(nameNode.FileNameToECPolicy, fileName)
+	}
+}
+
 //GetIdToDataNodes 获取当前存活的datanode元数据组信息：host+port
 func (nameNode *Service) GetIdToDataNodes(request *bool, reply *[]datanode.DataNodeInstance) error {
+	nameNode.rLock()
+	defer nameNode.rUnlock()
 	if *request {
 		for _, instance := range nameNode.IdToDataNodes {
 			*reply = append(*reply, instance)
@@ -194,40 +645,66 @@ func (nameNode *Service) GetIdToDataNodes(request *bool, reply *[]datanode.DataN
 	return nil
 }
 
-//DeleteMetaData 删除路径相关的元数据信息
+//DeleteMetaData 删除路径相关的元数据信息，并删除所有dataNode上的目录
 func (nameNode *Service) DeleteMetaData(request *NameNodeDeleteRequest, reply *bool) error {
+	nameNode.lock()
+	defer nameNode.unlock()
+	if err := nameNode.checkNotInSafeMode(); err != nil {
+		return err
+	}
 	ReMoteFilePath := request.RemoteFilePath
-	//遍历指定目录下的所有文件
-	for _, filename := range nameNode.DirectoryToFileName[ReMoteFilePath] {
-		//遍历指定文件名下的所有BlockId
-		for _, BlockId := range nameNode.FileNameToBlocks[ReMoteFilePath+filename] {
-			//删除BlockToDataNodeIds的这个key
-			delete(nameNode.BlockToDataNodeIds, BlockId)
-		}
-		//删除FileNameToBlocks的key：ReMoteFilePath+filename
-		delete(nameNode.FileNameToBlocks, ReMoteFilePath+filename)
-		//删除FileNameSize的key：ReMoteFilePath+filename
-		delete(nameNode.FileNameSize, ReMoteFilePath+filename)
-	}
-	//删除DirectoryToFileName的key：ReMoteFilePath
-	delete(nameNode.DirectoryToFileName, ReMoteFilePath)
+	if !strings.HasSuffix(ReMoteFilePath, "/") {
+		return errors.New("目录必须以/结尾")
+	}
+	if isRoot(ReMoteFilePath) {
+		return errors.New("不能删除根目录")
+	}
+	if err := nameNode.checkDeletable(request.User, ReMoteFilePath); err != nil {
+		return err
+	}
+	//递归删除目录下所有文件和子目录的元数据，被快照引用的块保留下来，移出将要删除的目录，
+	//再删除所有dataNode上的目录，删除失败的节点上留下的块不再属于任何文件，由块汇报识别后删除
+	nameNode.deleteTree(ReMoteFilePath)
 	*reply = true
 	return nil
 }
 
 //DeleteFileNameMetaData 删除文件相关的元数据信息
 func (nameNode *Service) DeleteFileNameMetaData(request *NameNodeDeleteRequest, reply *bool) error {
+	nameNode.lock()
+	defer nameNode.unlock()
+	if err := nameNode.checkNotInSafeMode(); err != nil {
+		return err
+	}
 	ReMoteFilePath := request.RemoteFilePath
 	fileName := request.FileName
+	if err := nameNode.checkDeletable(request.User, ReMoteFilePath+fileName); err != nil {
+		return err
+	}
+	nameNode.deleteFileMetaData(ReMoteFilePath, fileName)
+	*reply = true
+	return nil
+}
+
+// deleteFileMetaData 删除文件相关的元数据信息，调用方持有命名空间锁并已经检查过权限
+func (nameNode *Service) deleteFileMetaData(ReMoteFilePath string, fileName string) {
+	nameNode.cancelConversions(ReMoteFilePath + fileName)
+	//被快照引用的块保留下来，只删除其他块的元数据
+	nameNode.preserveFile(ReMoteFilePath + fileName)
+	blockIds := nameNode.retainSnapshotBlocks(ReMoteFilePath+fileName, nameNode.FileNameToBlocks[ReMoteFilePath+fileName])
 	//遍历指定文件名下的所有BlockId
-	for _, BlockId := range nameNode.FileNameToBlocks[ReMoteFilePath+fileName] {
-		//删除BlockToDataNodeIds的这个key
-		delete(nameNode.BlockToDataNodeIds, BlockId)
+	for _, BlockId := range blockIds {
+		//删除BlockToDataNodeIds的这个key，纠删码块组连同内部块一起删除
+		nameNode.deleteBlockMetaData(BlockId)
 	}
 	//删除FileNameToBlocks的key：ReMoteFilePath+filename
-	delete(nameNode.FileNameToBlocks, ReMoteFilePath+fileName)
+	nameNode.deleteFileBlocks(ReMoteFilePath + fileName)
 	//删除FileNameSize的key：ReMoteFilePath+filename
 	delete(nameNode.FileNameSize, ReMoteFilePath+fileName)
+	delete(nameNode.FileNameToReplication, ReMoteFilePath+fileName)
+	delete(nameNode.FileNameToECPolicy, ReMoteFilePath+fileName)
+	delete(nameNode.FileNameToEncryption, ReMoteFilePath+fileName)
+	nameNode.deletePermission(ReMoteFilePath + fileName)
 	//删除DirectoryToFileName[ReMoteFilePath]的value中filename的值，采取的方法是除filename以外遍历插入新的数组
 	filenames := nameNode.DirectoryToFileName[ReMoteFilePath]
 	var newfilenames []string
@@ -238,14 +715,11 @@ func (nameNode *Service) DeleteFileNameMetaData(request *NameNodeDeleteRequest,
 	}
 	//更新DirectoryToFileName[ReMoteFilePath] = newfilenames
 	nameNode.DirectoryToFileName[ReMoteFilePath] = newfilenames
-	*reply = true
-	return nil
+	nameNode.chargeQuota(ReMoteFilePath + fileName)
 }
 
 // allocateBlocks 实现分配方案：文件存在哪些datanode节点上（包含备份）
-func (nameNode *Service) allocateBlocks(fileName string, numberOfBlocks uint64) (metadata []NameNodeMetaData) {
-	//建立FileNameToBlocks的元数据库信息 key：path+filename
-	nameNode.FileNameToBlocks[fileName] = []string{}
+func (nameNode *Service) allocateBlocks(numberOfBlocks uint64, fileReplication uint64, clientHost string) (metadata []NameNodeMetaData) {
 	var dataNodesAvailable []uint64
 	//当前存活的，添加当前可用的datanodeId
 	for k, _ := range nameNode.IdToDataNodes {
@@ -257,56 +731,76 @@ func (nameNode *Service) allocateBlocks(fileName string, numberOfBlocks uint64)
 	for i := uint64(0); i < numberOfBlocks; i++ {
 		//生成uuid，作为datanode底层存储的文件名
 		blockId := uuid.New().String()
-		//维护FileNameToBlocks的元数据库信息
-		nameNode.FileNameToBlocks[fileName] = append(nameNode.FileNameToBlocks[fileName], blockId)
+		//为新块分配generation stamp
+		nameNode.GenerationStamp++
+		nameNode.BlockToGenerationStamp[blockId] = nameNode.GenerationStamp
 
 		var blockAddresses []datanode.DataNodeInstance
 		var replicationFactor uint64
-		// client设置的副本数大于可用的datanode节点数时，则副本数自动变成可用的节点数
-		if nameNode.ReplicationFactor > dataNodesAvailableCount {
+		// 文件的副本因子大于可用的datanode节点数时，则副本数自动变成可用的节点数
+		if fileReplication > dataNodesAvailableCount {
 			replicationFactor = dataNodesAvailableCount
-		} else { //否则就按照client 设置的来
-			replicationFactor = nameNode.ReplicationFactor
+		} else { //否则就按照文件的副本因子来
+			replicationFactor = fileReplication
 		}
 		// 具体安排这个BlockId文件存在哪些datanodes(包含备份)
-		targetDataNodeIds := nameNode.assignDataNodes(blockId, dataNodesAvailable, replicationFactor)
+		targetDataNodeIds := nameNode.assignDataNodes(blockId, dataNodesAvailable, replicationFactor, clientHost)
 		//获取blockId对应的datanodes元数据信息数组
 		for _, dataNodeId := range targetDataNodeIds {
 			blockAddresses = append(blockAddresses, nameNode.IdToDataNodes[dataNodeId])
 		}
 		//追加元数据信息
-		metadata = append(metadata, NameNodeMetaData{BlockId: blockId, BlockAddresses: blockAddresses})
+		metadata = append(metadata, NameNodeMetaData{BlockId: blockId, BlockAddresses: blockAddresses, GenerationStamp: nameNode.GenerationStamp})
 	}
 	return
 }
 
 // assignDataNodes 具体安排这个BlockId文件存在哪些dataNodes(包含备份)
-func (nameNode *Service) assignDataNodes(blockId string, dataNodesAvailable []uint64, replicationFactor uint64) []uint64 {
-	//随机选择存储节点，尽量做到负载均衡
-	targetDataNodeIds := selectRandomNumbers(dataNodesAvailable, replicationFactor)
+func (nameNode *Service) assignDataNodes(blockId string, dataNodesAvailable []uint64, replicationFactor uint64, clientHost string) []uint64 {
+	//通过副本放置策略选择存储节点
+	targetDataNodeIds := nameNode.chooseTargets(clientHost, dataNodesAvailable, nil, int(replicationFactor))
 	//维护BlockToDataNodeIds元数据信息，key:blockId value：存储的datanodeId
 	nameNode.BlockToDataNodeIds[blockId] = targetDataNodeIds
 	return targetDataNodeIds
 }
 
-// ReName 重命名文件目录
+// ReName 重命名文件目录，同时移动所有dataNode上的目录
 func (nameNode *Service) ReName(request *NameNodeReNameRequest, reply *[]datanode.DataNodeInstance) error {
+	nameNode.lock()
+	defer nameNode.unlock()
+	if err := nameNode.checkNotInSafeMode(); err != nil {
+		return err
+	}
 	renameSrcPath := request.ReNameSrcPath
 	renameDestPath := request.ReNameDestPath
+	if err := nameNode.checkRenamePermission(request.User, renameSrcPath, renameDestPath); err != nil {
+		return err
+	}
 	// 返回NameNodes的元数据信息
 	for _, instance := range nameNode.IdToDataNodes {
 		*reply = append(*reply, instance)
 	}
+	// 先放弃目录下文件的转换，再移动所有dataNode上的目录，最后修改元数据
+	nameNode.cancelConversions(renameSrcPath)
+	nameNode.cancelConversions(renameDestPath)
+	nameNode.callDataNodes("Service.MovePath", datanode.DataNodeMoveRequest{SrcPath: renameSrcPath, DestPath: renameDestPath})
+	nameNode.renameMetaData(renameSrcPath, renameDestPath)
+	return nil
+}
+
+// renameMetaData 把目录及其下所有文件和子目录的元数据从源路径迁移到目标路径
+func (nameNode *Service) renameMetaData(renameSrcPath string, renameDestPath string) {
+	nameNode.preserveRename(renameSrcPath, renameDestPath)
 	// 修改目录下文件的元数据信息（文件绝对路径FileName和存储Blocks的映射关系）
 	for fileName, Blocks := range nameNode.FileNameToBlocks {
 		// 获取当前目录下需要修改的文件元数据
 		if strings.HasPrefix(fileName, renameSrcPath) {
 			// 删除当前目录的元数据信息
-			delete(nameNode.FileNameToBlocks, fileName)
+			nameNode.deleteFileBlocks(fileName)
 			// 修改文件的绝对路径信息
 			fileName = strings.Replace(fileName, renameSrcPath, renameDestPath, 1)
 			// 修改文件绝对路径和Block的映射关系
-			nameNode.FileNameToBlocks[fileName] = Blocks
+			nameNode.setFileBlocks(fileName, Blocks)
 		}
 	}
 	// 修改目录下文件的元数据信息（文件绝对路径FileName和FileSize的映射关系）
@@ -320,27 +814,150 @@ func (nameNode *Service) ReName(request *NameNodeReNameRequest, reply *[]datanod
 			nameNode.FileNameSize[fileName] = FileSize
 		}
 	}
+	// 修改目录下文件的元数据信息（文件绝对路径FileName和副本因子的映射关系）
+	for fileName, replication := range nameNode.FileNameToReplication {
+		if strings.HasPrefix(fileName, renameSrcPath) {
+			delete(nameNode.FileNameToReplication, fileName)
+			fileName = strings.Replace(fileName, renameSrcPath, renameDestPath, 1)
+			nameNode.FileNameToReplication[fileName] = replication
+		}
+	}
+	// 修改目录及子目录的默认副本因子
+	for directory, replication := range nameNode.DirectoryToReplication {
+		if strings.HasPrefix(directory, renameSrcPath) {
+			delete(nameNode.DirectoryToReplication, directory)
+			directory = strings.Replace(directory, renameSrcPath, renameDestPath, 1)
+			nameNode.DirectoryToReplication[directory] = replication
+		}
+	}
+	// 修改目录下纠删码文件的策略和目录及子目录的纠删码策略
+	for fileName, policy := range nameNode.FileNameToECPolicy {
+		if strings.HasPrefix(fileName, renameSrcPath) {
+			delete(nameNode.FileNameToECPolicy, fileName)
+			fileName = strings.Replace(fileName, renameSrcPath, renameDestPath, 1)
+			nameNode.FileNameToECPolicy[fileName] = policy
+		}
+	}
+	for directory, policy := range nameNode.DirectoryToECPolicy {
+		if strings.HasPrefix(directory, renameSrcPath) {
+			delete(nameNode.DirectoryToECPolicy, directory)
+			directory = strings.Replace(directory, renameSrcPath, renameDestPath, 1)
+			nameNode.DirectoryToECPolicy[directory] = policy
+		}
+	}
+	// 修改目录下加密文件的数据密钥信息和目录及子目录的加密区
+	for fileName, info := range nameNode.FileNameToEncryption {
+		if strings.HasPrefix(fileName, renameSrcPath) {
+			delete(nameNode.FileNameToEncryption, fileName)
+			fileName = strings.Replace(fileName, renameSrcPath, renameDestPath, 1)
+			nameNode.FileNameToEncryption[fileName] = info
+		}
+	}
+	for directory, zone := range nameNode.DirectoryToEncryptionZone {
+		if strings.HasPrefix(directory, renameSrcPath) {
+			delete(nameNode.DirectoryToEncryptionZone, directory)
+			directory = strings.Replace(directory, renameSrcPath, renameDestPath, 1)
+			nameNode.DirectoryToEncryptionZone[directory] = zone
+		}
+	}
+	for directory, quota := range nameNode.DirectoryToQuota {
+		if strings.HasPrefix(directory, renameSrcPath) {
+			delete(nameNode.DirectoryToQuota, directory)
+			directory = strings.Replace(directory, renameSrcPath, renameDestPath, 1)
+			nameNode.DirectoryToQuota[directory] = quota
+		}
+	}
+	// 修改目录及子目录的快照，只被快照引用的块随目录一起移动
+	for directory, snapshots := range nameNode.DirectoryToSnapshots {
+		if strings.HasPrefix(directory, renameSrcPath) {
+			delete(nameNode.DirectoryToSnapshots, directory)
+			directory = strings.Replace(directory, renameSrcPath, renameDestPath, 1)
+			nameNode.DirectoryToSnapshots[directory] = snapshots
+		}
+	}
+	for blockId, directory := range nameNode.SnapshotBlockPaths {
+		if strings.HasPrefix(directory, renameSrcPath) {
+			nameNode.SnapshotBlockPaths[blockId] = strings.Replace(directory, renameSrcPath, renameDestPath, 1)
+		}
+	}
+	nameNode.renamePermissions(renameSrcPath, renameDestPath)
 	// 修改目录下文件的元数据信息（文件绝对路径Directory和FileName的映射关系）
-	fileNames := nameNode.DirectoryToFileName[renameSrcPath]
-	delete(nameNode.DirectoryToFileName, renameSrcPath)
-	nameNode.DirectoryToFileName[renameDestPath] = fileNames
-	return nil
+	// 子目录的文件列表一起迁移
+	for directory, fileNames := range nameNode.DirectoryToFileName {
+		if strings.HasPrefix(directory, renameSrcPath) {
+			delete(nameNode.DirectoryToFileName, directory)
+			directory = strings.Replace(directory, renameSrcPath, renameDestPath, 1)
+			nameNode.DirectoryToFileName[directory] = fileNames
+		}
+	}
+	// 整个目录树移动，和上面的迁移一样扫描一遍命名空间重建配额用量
+	nameNode.rebuildQuotaUsage()
 }
 
 // ReNameFile 修改文件名
 func (nameNode *Service) ReNameFile(request *NameNodeReNameFileRequest, reply *bool) error {
+	nameNode.lock()
+	defer nameNode.unlock()
+	if err := nameNode.checkNotInSafeMode(); err != nil {
+		return err
+	}
 	ReNameSrcFileName := request.ReNameSrcFileName
 	ReNameDestFileName := request.ReNameDestFileName
+	if err := nameNode.checkRenamePermission(request.User, ReNameSrcFileName, ReNameDestFileName); err != nil {
+		return err
+	}
+	// 重命名为自身时什么都不做，不能把文件当作被覆盖的目标文件删除
+	if ReNameSrcFileName == ReNameDestFileName {
+		*reply = true
+		return nil
+	}
+	// 先放弃源文件和被覆盖的目标文件的转换，dataNode上的块存放在文件所在的目录下，移动到其他目录时块一起移动
+	nameNode.cancelConversions(ReNameSrcFileName)
+	nameNode.cancelConversions(ReNameDestFileName)
+	if parentDirectory(ReNameSrcFileName) != parentDirectory(ReNameDestFileName) {
+		nameNode.moveOnDataNodes(ReNameSrcFileName, ReNameDestFileName)
+	}
+	nameNode.renameFileMetaData(ReNameSrcFileName, ReNameDestFileName)
+	*reply = true
+	return nil
+}
+
+// renameFileMetaData 把文件的元数据从源路径+文件名迁移到目标路径+文件名
+func (nameNode *Service) renameFileMetaData(ReNameSrcFileName string, ReNameDestFileName string) {
+	nameNode.preserveRename(ReNameSrcFileName, ReNameDestFileName)
+	// 目标文件已经存在时被覆盖，被快照引用的块保留下来，其余块删除
+	if oldBlocks, ok := nameNode.FileNameToBlocks[ReNameDestFileName]; ok {
+		nameNode.discardBlocks(parentDirectory(ReNameDestFileName), nameNode.retainSnapshotBlocks(ReNameDestFileName, oldBlocks))
+	}
 	// 修改文件的元数据信息（文件绝对路径FileName和存储Blocks的映射关系）
 	Blocks := nameNode.FileNameToBlocks[ReNameSrcFileName]
 	// 删除当前文件的元数据信息
-	delete(nameNode.FileNameToBlocks, ReNameSrcFileName)
-	nameNode.FileNameToBlocks[ReNameDestFileName] = Blocks
+	nameNode.deleteFileBlocks(ReNameSrcFileName)
+	nameNode.setFileBlocks(ReNameDestFileName, Blocks)
 	// 修改文件的元数据信息（文件绝对路径FileName和FileSize的映射关系）
 	FileSize := nameNode.FileNameSize[ReNameSrcFileName]
 	delete(nameNode.FileNameSize, ReNameSrcFileName)
 	// 修改文件绝对路径FileName和FileSize的映射关系
 	nameNode.FileNameSize[ReNameDestFileName] = FileSize
+	// 修改文件的副本因子，目标文件原来的记录删除，只迁移存在的记录
+	delete(nameNode.FileNameToReplication, ReNameDestFileName)
+	if replication, ok := nameNode.FileNameToReplication[ReNameSrcFileName]; ok {
+		delete(nameNode.FileNameToReplication, ReNameSrcFileName)
+		nameNode.FileNameToReplication[ReNameDestFileName] = replication
+	}
+	// 目标文件原来的纠删码策略删除，多副本的源文件覆盖纠删码文件后不能再按块组读取
+	delete(nameNode.FileNameToECPolicy, ReNameDestFileName)
+	if policy, ok := nameNode.FileNameToECPolicy[ReNameSrcFileName]; ok {
+		delete(nameNode.FileNameToECPolicy, ReNameSrcFileName)
+		nameNode.FileNameToECPolicy[ReNameDestFileName] = policy
+	}
+	// 目标文件原来的数据密钥删除，否则客户端会用它解密源文件的明文
+	delete(nameNode.FileNameToEncryption, ReNameDestFileName)
+	if info, ok := nameNode.FileNameToEncryption[ReNameSrcFileName]; ok {
+		delete(nameNode.FileNameToEncryption, ReNameSrcFileName)
+		nameNode.FileNameToEncryption[ReNameDestFileName] = info
+	}
+	nameNode.renamePermissions(ReNameSrcFileName, ReNameDestFileName)
 
 	// 处理路径+文件名的字符串
 	split := strings.Split(ReNameSrcFileName, "/")
@@ -354,23 +971,54 @@ func (nameNode *Service) ReNameFile(request *NameNodeReNameFileRequest, reply *b
 	for i := 0; i < len(split)-1; i++ {
 		directory += split[i] + "/"
 	}
+	destDirectory := parentDirectory(ReNameDestFileName)
 	// 修改目录下文件的元数据信息（文件绝对路径Directory和FileName的映射关系）
 	filenames := nameNode.DirectoryToFileName[directory]
 	for i, filename := range filenames {
 		if filename == srcFileName {
-			filenames[i] = destFileName
-			nameNode.DirectoryToFileName[directory] = filenames
+			if directory == destDirectory && !containsFileName(filenames, destFileName) {
+				filenames[i] = destFileName
+				nameNode.DirectoryToFileName[directory] = filenames
+			} else {
+				// 移动到其他目录或者覆盖已有的文件时从源目录中删除，目标目录中没有时再加入
+				nameNode.DirectoryToFileName[directory] = append(filenames[:i:i], filenames[i+1:]...)
+				nameNode.addFileName(destDirectory, destFileName)
+			}
 			break
 		}
 	}
+	nameNode.chargeQuota(ReNameSrcFileName)
+	nameNode.chargeQuota(ReNameDestFileName)
+}
+
+// addFileName 把文件名加入目录的文件列表，已经在列表中时不重复加入
+func (nameNode *Service) addFileName(directory string, fileName string) {
+	if !containsFileName(nameNode.DirectoryToFileName[directory], fileName) {
+		nameNode.DirectoryToFileName[directory] = append(nameNode.DirectoryToFileName[directory], fileName)
+	}
+}
 
-	*reply = true
-	return nil
+// containsFileName 判断文件名是否在文件列表中
+func containsFileName(fileNames []string, fileName string) bool {
+	for _, name := range fileNames {
+		if name == fileName {
+			return true
+		}
+	}
+	return false
 }
 
 // List 罗列出文件夹中的文件信息
 func (nameNode *Service) List(request *NameNodeListRequest, reply *[]ListMetaData) error {
+	nameNode.rLock()
+	defer nameNode.rUnlock()
 	RemoteDirPath := request.RemoteDirPath
+	if inSnapshot(RemoteDirPath) {
+		return nameNode.listSnapshotDirectory(request, reply)
+	}
+	if err := nameNode.checkPermission(request.User, RemoteDirPath, ActionRead|ActionExecute); err != nil {
+		return err
+	}
 	//获取当前目录下的所有文件名
 	filenames := nameNode.DirectoryToFileName[RemoteDirPath]
 	//遍历这些文件，获取文件的元数据信息
@@ -382,148 +1030,359 @@ func (nameNode *Service) List(request *NameNodeListRequest, reply *[]ListMetaDat
 	return nil
 }
 
-// ReDistributeData 当有dataNode节点dead，将死亡的节点上的数据进行备份，重新分配备份节点写入
+// ReDistributeData 当有dataNode节点dead，将死亡的节点上的块加入待复制队列，由后台副本复制监控重新分配备份节点写入
 func (nameNode *Service) ReDistributeData(request *ReDistributeDataRequest, reply *bool) error {
+	nameNode.lock()
+	defer nameNode.unlock()
 	log.Printf("DataNode %s is dead, trying to redistribute data\n", request.DataNodeUri)
 	deadDataNodeSlice := strings.Split(request.DataNodeUri, ":")
 	var deadDataNodeId uint64
+	found := false
 
 	// 遍历元数据IdToDataNodes，确定是IdToDataNodes哪个key：Id是dead的节点
 	for id, dn := range nameNode.IdToDataNodes {
 		if dn.Host == deadDataNodeSlice[0] && dn.ServicePort == deadDataNodeSlice[1] {
 			deadDataNodeId = id
+			found = true
 			break
 		}
 	}
-	//维护元数据IdToDataNodes，删除dead的key-value
+	//节点已经被移除过了，不需要重复处理
+	if !found {
+		return nil
+	}
+	//维护中的节点计划内短暂不可用，不重新复制，维护超时后仍然dead再处理
+	if nameNode.isInMaintenance(request.DataNodeUri) {
+		log.Printf("DataNode %s is in maintenance, skip redistributing data\n", request.DataNodeUri)
+		return nil
+	}
+	//维护元数据IdToDataNodes，删除dead的key-value，记录下来用于集群报告
+	nameNode.deadDataNodes[request.DataNodeUri] = deadDataNode{
+		instance:      nameNode.IdToDataNodes[deadDataNodeId],
+		uuid:          nameNode.DataNodeStats[deadDataNodeId].Uuid,
+		lastHeartbeat: nameNode.lastHeartbeats[deadDataNodeId],
+	}
 	delete(nameNode.IdToDataNodes, deadDataNodeId)
+	delete(nameNode.DataNodeStats, deadDataNodeId)
+	delete(nameNode.lastHeartbeats, deadDataNodeId)
 
 	//创建需要复制块列表
-	var underReplicatedBlocksList []UnderReplicatedBlocks
+	var underReplicatedBlocks []string
 	// 遍历BlockToDataNodeIds，得到blockId：{对应的dataNodes}
 	for blockId, dnIds := range nameNode.BlockToDataNodeIds {
-		for i, dnId := range dnIds {
-			//当blockId中的dataNodes匹配上deadDataNodeId时，记录1个备份的DataNodeId作为healthyDataNodeId
-			//并跳出循环，因为一个节点的BlockId损坏只会匹配一个DataNodeId
-			if dnId == deadDataNodeId {
-				//备份的DataNodeId有多个，都存在BlockToDataNodeIds[blockId]，如果是坏的恰好是最后一个就会有问题，所以取余
-				healthyDataNodeId := nameNode.BlockToDataNodeIds[blockId][(i+1)%len(dnIds)]
-				//包装UnderReplicatedBlocks{blockId，备份的节点Id}
-				underReplicatedBlocksList = append(
-					underReplicatedBlocksList,
-					UnderReplicatedBlocks{blockId, healthyDataNodeId},
-				)
-				break
-			}
+		if !containsDataNodeId(dnIds, deadDataNodeId) {
+			continue
 		}
+		//从块的副本位置中移除dead的节点，剩下的就是健康的副本
+		healthyDataNodeIds := removeDataNodeId(dnIds, deadDataNodeId)
+		nameNode.BlockToDataNodeIds[blockId] = healthyDataNodeIds
+		//纠删码的内部块只有一个副本，丢失后由其他内部块解码重建，不需要递增generation stamp
+		if _, _, ok := nameNode.blockGroupOf(blockId); ok {
+			underReplicatedBlocks = append(underReplicatedBlocks, blockId)
+			continue
+		}
+		if len(healthyDataNodeIds) == 0 {
+			log.Printf("Block %s has no healthy replica left\n", blockId)
+			continue
+		}
+		//递增健康副本的generation stamp，dead节点恢复后其上的副本就会被识别为过期副本
+		nameNode.bumpGenerationStamp(blockId, healthyDataNodeIds)
+		underReplicatedBlocks = append(underReplicatedBlocks, blockId)
 	}
 
-	// 判断当前可用dataNodes节点数是否足够
-	if len(nameNode.IdToDataNodes) < int(nameNode.ReplicationFactor) {
-		log.Println("Replication not possible due to unavailability of sufficient DataNode(s)")
-		return nil
+	// 交给后台副本复制监控按优先级进行复制
+	for _, blockId := range underReplicatedBlocks {
+		nameNode.checkReplication(blockId)
 	}
+	return nil
+}
 
-	// 遍历需要复制块列表
-	for _, blockToReplicate := range underReplicatedBlocksList {
-		var remoteFilePath string
-		flag := false
-		// 只有要复制的BlockId，要得到文件的有效的路径名（不包含文件名），为了读取数据BlockId中的数据
-		for filename, fileblockIds := range nameNode.FileNameToBlocks {
-			// 遍历每个文件对应的fileblockIds，当要复制的BlockId与其匹配时，当前的filename:path+name 就是路径
-			for _, id := range fileblockIds {
-				if blockToReplicate.BlockId == id {
-					flag = true
-					break
-				}
-			}
-			if flag {
-				// 将filename切分，得到只含路径名
-				split := strings.Split(filename, "/")
-				for i := 0; i < len(split)-1; i++ {
-					remoteFilePath += split[i] + "/"
-				}
-				break
+// RegisterDataNode dataNode启动时主动向nameNode注册，dead之后重新启动的节点也通过注册重新加入集群
+// 返回分配给该dataNode的Id
+func (nameNode *Service) RegisterDataNode(request *datanode.DataNodeInstance, reply *uint64) error {
+	nameNode.lock()
+	defer nameNode.unlock()
+	var maxId uint64
+	for id, dn := range nameNode.IdToDataNodes {
+		//已经在管理中的节点，直接返回原来的Id，节点汇报了机架位置时以汇报的为准
+		if dn.Host == request.Host && dn.ServicePort == request.ServicePort {
+			if request.Rack != "" {
+				dn.Rack = request.Rack
+				nameNode.IdToDataNodes[id] = dn
 			}
+			*reply = id
+			return nil
 		}
-		// 从要复制的节点读取数据
-		healthyDataNode := nameNode.IdToDataNodes[blockToReplicate.HealthyDataNodeId]
-		dataNodeInstance, rpcErr := rpc.Dial("tcp", healthyDataNode.Host+":"+healthyDataNode.ServicePort)
-		if rpcErr != nil {
-			log.Println(rpcErr)
-			continue
+		if id >= maxId {
+			maxId = id + 1
 		}
+	}
+	nameNode.IdToDataNodes[maxId] = *request
+	delete(nameNode.deadDataNodes, request.Host+":"+request.ServicePort)
+	log.Printf("DataNode %s:%s registered with id %d\n", request.Host, request.ServicePort, maxId)
+	//有新的节点加入，之前因为节点不够没能复制的块可以继续复制
+	nameNode.checkAllReplication()
+	*reply = maxId
+	return nil
+}
 
-		defer dataNodeInstance.Close()
+// ProcessHeartbeat 记录dataNode心跳汇报的容量和负载，用于选择写入的节点
+func (nameNode *Service) ProcessHeartbeat(request *HeartbeatRequest, reply *bool) error {
+	nameNode.lock()
+	defer nameNode.unlock()
+	if _, ok := nameNode.IdToDataNodes[request.DataNodeId]; !ok {
+		return errors.New("dataNode未注册")
+	}
+	nameNode.DataNodeStats[request.DataNodeId] = request.Heartbeat
+	nameNode.lastHeartbeats[request.DataNodeId] = time.Now()
+	*reply = true
+	return nil
+}
 
-		getRequest := datanode.DataNodeGetRequest{
-			RemoteFilePath: remoteFilePath,
-			BlockId:        blockToReplicate.BlockId,
+// ProcessBlockReport 处理dataNode的块汇报，根据generation stamp识别过期副本和孤儿副本并通知dataNode删除，
+// 同时以块汇报为准更新BlockToDataNodeIds
+func (nameNode *Service) ProcessBlockReport(request *BlockReportRequest, reply *bool) error {
+	nameNode.lock()
+	defer nameNode.unlock()
+	dataNodeId := request.DataNodeId
+	if _, ok := nameNode.IdToDataNodes[dataNodeId]; !ok {
+		return errors.New("dataNode未注册")
+	}
+	reportedBlocks := make(map[string]bool)
+	var usedSpace uint64
+	for _, block := range request.Blocks {
+		reportedBlocks[block.BlockId] = true
+		usedSpace += block.Size
+		nameNode.processReportedBlock(dataNodeId, block)
+	}
+	//元数据中记录在该节点上、块汇报中却没有的副本已经丢失，移除该副本位置
+	for blockId, dnIds := range nameNode.BlockToDataNodeIds {
+		if !reportedBlocks[blockId] && containsDataNodeId(dnIds, dataNodeId) {
+			nameNode.BlockToDataNodeIds[blockId] = removeDataNodeId(dnIds, dataNodeId)
 		}
-		var getReply datanode.DataNodeData
+	}
+	nameNode.DataNodeUsedSpace[dataNodeId] = usedSpace
+	nameNode.checkSafeMode()
+	//安全模式下块还没有全部汇报上来，不删除副本
+	if nameNode.inSafeMode() {
+		*reply = true
+		return nil
+	}
+	//dead节点重新加入后，块的副本数可能超过副本因子，删除多余的副本
+	for blockId := range reportedBlocks {
+		nameNode.removeExcessReplicas(blockId)
+	}
+	*reply = true
+	return nil
+}
 
-		rpcErr = dataNodeInstance.Call("Service.GetData", getRequest, &getReply)
-		if rpcErr != nil {
-			log.Println(rpcErr)
-			continue
+// BlockReceived dataNode写入一个副本后的增量块汇报，复制完成的目标节点通过它把新副本汇报给nameNode
+func (nameNode *Service) BlockReceived(request *datanode.BlockReceivedRequest, reply *bool) error {
+	nameNode.lock()
+	defer nameNode.unlock()
+	if !nameNode.isPrimary() {
+		return errors.New("当前nameNode不是主节点")
+	}
+	var dataNodeId uint64
+	found := false
+	for id, dn := range nameNode.IdToDataNodes {
+		if dn.Host == request.DataNode.Host && dn.ServicePort == request.DataNode.ServicePort {
+			dataNodeId = id
+			found = true
+			break
 		}
-		// 存放BlockId读取的数据
-		blockContents := getReply.Data
-
-		//重新写入数据给节点,更新BlockToDataNodeIds元数据
-		//分配给哪个备份节点，得到目标节点,必须得不在备份的所有节点上
-		var availableNodes []uint64
-		//遍历所有的当前存活的dataNodeId，只有这个Id与所有备份节点的Id不一样时，才说明是可用的节点，加入availableNodes
-		for id, _ := range nameNode.IdToDataNodes {
-			for i, BlockId := range nameNode.BlockToDataNodeIds[blockToReplicate.BlockId] {
-				if id == BlockId {
-					break
-				}
-				if i == len(nameNode.BlockToDataNodeIds[blockToReplicate.BlockId])-1 {
-					availableNodes = append(availableNodes, id)
-				}
-			}
+	}
+	if !found {
+		return errors.New("dataNode未注册")
+	}
+	if nameNode.processReportedBlock(dataNodeId, request.Block) {
+		nameNode.checkSafeMode()
+		nameNode.replicationQueue.received(request.Block.BlockId, dataNodeId)
+		nameNode.removeExcessReplicas(request.Block.BlockId)
+		nameNode.checkReplication(request.Block.BlockId)
+	}
+	*reply = true
+	return nil
+}
+
+// processReportedBlock 处理dataNode汇报的一个副本，过期副本和孤儿副本通知dataNode删除，有效副本记录到BlockToDataNodeIds
+// 返回该副本是否有效
+func (nameNode *Service) processReportedBlock(dataNodeId uint64, block datanode.BlockInfo) bool {
+	stamp, ok := nameNode.BlockToGenerationStamp[block.BlockId]
+	//不属于任何文件的副本，文件在该节点dead期间已经被删除
+	if !ok {
+		//命名空间没有持久化，或者还在等待块汇报时，无法区分孤儿副本和丢失了元数据的块，只记录下来不删除
+		if !nameNode.namespaceLoaded || nameNode.inSafeMode() {
+			log.Printf("Block %s on DataNode %d does not belong to any file, keeping it\n", block.BlockId, dataNodeId)
+			return false
+		}
+		log.Printf("Block %s on DataNode %d does not belong to any file, invalidating\n", block.BlockId, dataNodeId)
+		nameNode.invalidateBlock(dataNodeId, block.RemoteFilePath, block.BlockId)
+		return false
+	}
+	//generation stamp落后，说明是过期副本
+	if block.GenerationStamp < stamp {
+		log.Printf("Block %s on DataNode %d is stale (generation stamp %d < %d), invalidating\n", block.BlockId, dataNodeId, block.GenerationStamp, stamp)
+		nameNode.BlockToDataNodeIds[block.BlockId] = removeDataNodeId(nameNode.BlockToDataNodeIds[block.BlockId], dataNodeId)
+		nameNode.invalidateBlock(dataNodeId, block.RemoteFilePath, block.BlockId)
+		return false
+	}
+	if !containsDataNodeId(nameNode.BlockToDataNodeIds[block.BlockId], dataNodeId) {
+		nameNode.BlockToDataNodeIds[block.BlockId] = append(nameNode.BlockToDataNodeIds[block.BlockId], dataNodeId)
+	}
+	return true
+}
+
+// removeExcessReplicas 计算块多余的副本数，优先删除已用空间最多的节点上的副本
+func (nameNode *Service) removeExcessReplicas(blockId string) {
+	//下线中的节点上的副本不计入副本数，也不作为多余的副本删除
+	dataNodeIds := nameNode.liveReplicas(blockId)
+	excess := len(dataNodeIds) - int(nameNode.expectedReplication(blockId))
+	if excess <= 0 {
+		return
+	}
+	remoteFilePath := nameNode.blockRemoteFilePath(blockId)
+	excessDataNodeIds := nameNode.chooseExcessReplicas(blockId, dataNodeIds, excess)
+	//块已经复制到了balancer选择的目标节点，移动结束
+	delete(nameNode.pendingMoves, blockId)
+	for _, dataNodeId := range excessDataNodeIds {
+		log.Printf("Block %s is over-replicated, removing replica on DataNode %d\n", blockId, dataNodeId)
+		//先更新元数据，再通知dataNode删除，保证读请求不会再分配到这个副本
+		nameNode.BlockToDataNodeIds[blockId] = removeDataNodeId(nameNode.BlockToDataNodeIds[blockId], dataNodeId)
+		nameNode.invalidateBlock(dataNodeId, remoteFilePath, blockId)
+	}
+}
+
+// chooseExcessReplicas 从副本位置中选择要删除的副本，balancer移动的块优先删除源节点上的副本，其余按已用空间从多到少选择
+func (nameNode *Service) chooseExcessReplicas(blockId string, dataNodeIds []uint64, excess int) []uint64 {
+	candidates := make([]uint64, len(dataNodeIds))
+	copy(candidates, dataNodeIds)
+	move, moving := nameNode.pendingMoves[blockId]
+	sort.Slice(candidates, func(i, j int) bool {
+		if moving && (candidates[i] == move.sourceDataNodeId) != (candidates[j] == move.sourceDataNodeId) {
+			return candidates[i] == move.sourceDataNodeId
+		}
+		usedI := nameNode.DataNodeUsedSpace[candidates[i]]
+		usedJ := nameNode.DataNodeUsedSpace[candidates[j]]
+		if usedI != usedJ {
+			return usedI > usedJ
 		}
-		//副本数为1，在availableNodes中取1个随机数，返回的是一个长度为1的slice[]
-		targetDataNodeIds := selectRandomNumbers(availableNodes, 1)
-		//得到目标存储节点
-		targetDataNodeId := targetDataNodeIds[0]
-		//更新BlockToDataNodeIds元数据，不涉及其他元数据的变更，只是BlockId与dataNodes的映射
-		//删除坏的deadDataNodeId,添加新的targetDataNodeId
-		var newBlockToDataNodeIds []uint64
-		for _, dataNodeId := range nameNode.BlockToDataNodeIds[blockToReplicate.BlockId] {
-			if dataNodeId != deadDataNodeId {
-				newBlockToDataNodeIds = append(newBlockToDataNodeIds, dataNodeId)
+		return candidates[i] < candidates[j]
+	})
+	return candidates[:excess]
+}
+
+// bumpGenerationStamp 分配新的generation stamp并同步给健康的副本，只要有一个副本更新成功，块的generation stamp就更新为新的值
+func (nameNode *Service) bumpGenerationStamp(blockId string, healthyDataNodeIds []uint64) {
+	nameNode.GenerationStamp++
+	newStamp := nameNode.GenerationStamp
+	request := datanode.DataNodeGenerationStampRequest{
+		RemoteFilePath:  nameNode.blockRemoteFilePath(blockId),
+		BlockId:         blockId,
+		GenerationStamp: newStamp,
+	}
+	//没有任何副本更新成功时不能更新块的generation stamp，否则所有副本都会变成过期副本
+	var updated sync.Once
+	done := func(succeeded bool) {
+		if !succeeded {
+			return
+		}
+		updated.Do(func() {
+			nameNode.lock()
+			defer nameNode.unlock()
+			if stamp, ok := nameNode.BlockToGenerationStamp[blockId]; ok && stamp < newStamp {
+				nameNode.BlockToGenerationStamp[blockId] = newStamp
 			}
+		})
+	}
+	for _, dataNodeId := range healthyDataNodeIds {
+		nameNode.queueCommand(nameNode.IdToDataNodes[dataNodeId], "Service.UpdateGenerationStamp", request, done)
+	}
+}
+
+// invalidateBlock 通知dataNode删除指定的副本，安全模式下不删除，离开安全模式后由块汇报重新识别
+func (nameNode *Service) invalidateBlock(dataNodeId uint64, remoteFilePath string, blockId string) {
+	dn, ok := nameNode.IdToDataNodes[dataNodeId]
+	if !ok || nameNode.inSafeMode() {
+		return
+	}
+	request := datanode.DataNodeDeleteRequest{RemoteFilepath: remoteFilePath, BlockId: blockId, BlockToken: nameNode.blockToken([]string{blockId}, remoteFilePath, auth.BlockAccessDelete)}
+	nameNode.queueCommand(dn, "Service.DeleteFile", request, nil)
+}
+
+// blockRemoteFilePath 根据BlockId反查所属的文件或者转换，得到文件的路径名（不包含文件名）
+func (nameNode *Service) blockRemoteFilePath(blockId string) (remoteFilePath string) {
+	filename, ok := nameNode.blockFileName(blockId)
+	if !ok {
+		//正在转换的文件新布局的块
+		if filename, _, ok = nameNode.conversionOf(blockId); !ok {
+			//文件已经不再使用、只被快照引用的块
+			return nameNode.snapshotBlockPath(blockId)
 		}
-		newBlockToDataNodeIds = append(newBlockToDataNodeIds, targetDataNodeId)
-		nameNode.BlockToDataNodeIds[blockToReplicate.BlockId] = newBlockToDataNodeIds
+	}
+	// 将filename切分，得到只含路径名
+	return filename[:strings.LastIndex(filename, "/")+1]
+}
 
-		//写入节点赋值，remainingDataNodes为空
-		startingDataNode := nameNode.IdToDataNodes[targetDataNodeId]
+// blockFileName 根据BlockId反查所属的文件，返回路径+文件名，纠删码的内部块按所属的块组查找
+func (nameNode *Service) blockFileName(blockId string) (string, bool) {
+	if groupId, _, ok := nameNode.blockGroupOf(blockId); ok {
+		blockId = groupId
+	}
+	fileName, ok := nameNode.BlockToFileName[blockId]
+	return fileName, ok
+}
 
-		targetDataNodeInstance, rpcErr := rpc.Dial("tcp", startingDataNode.Host+":"+startingDataNode.ServicePort)
-		if rpcErr != nil {
-			log.Println(rpcErr)
-			continue
+// setFileBlocks 设置文件的块并维护反向索引，文件原来的块不再指向该文件
+func (nameNode *Service) setFileBlocks(fileName string, blockIds []string) {
+	nameNode.unindexFileBlocks(fileName)
+	nameNode.FileNameToBlocks[fileName] = blockIds
+	for _, blockId := range blockIds {
+		nameNode.BlockToFileName[blockId] = fileName
+	}
+}
+
+// deleteFileBlocks 删除文件的块列表并维护反向索引，块本身的元数据由调用方处理
+func (nameNode *Service) deleteFileBlocks(fileName string) {
+	nameNode.unindexFileBlocks(fileName)
+	delete(nameNode.FileNameToBlocks, fileName)
+}
+
+// unindexFileBlocks 从反向索引中删除文件当前的块，只删除仍然指向该文件的记录
+func (nameNode *Service) unindexFileBlocks(fileName string) {
+	for _, blockId := range nameNode.FileNameToBlocks[fileName] {
+		if nameNode.BlockToFileName[blockId] == fileName {
+			delete(nameNode.BlockToFileName, blockId)
 		}
-		defer targetDataNodeInstance.Close()
+	}
+}
 
-		putRequest := datanode.DataNodePutRequest{
-			RemoteFilePath: remoteFilePath,
-			BlockId:        blockToReplicate.BlockId,
-			Data:           blockContents,
+// rebuildBlockIndex 根据FileNameToBlocks重建块的反向索引，加载镜像或者同步元数据后调用
+func (nameNode *Service) rebuildBlockIndex() {
+	nameNode.BlockToFileName = make(map[string]string)
+	for fileName, blockIds := range nameNode.FileNameToBlocks {
+		for _, blockId := range blockIds {
+			nameNode.BlockToFileName[blockId] = fileName
 		}
-		var putReply datanode.DataNodeReplyStatus
-		// 将数据写入对用的节点
-		rpcErr = targetDataNodeInstance.Call("Service.PutData", putRequest, &putReply)
-		if rpcErr != nil {
-			log.Println(rpcErr)
-			continue
+	}
+}
+
+// containsDataNodeId 判断dataNodeId是否在副本位置中
+func containsDataNodeId(dataNodeIds []uint64, dataNodeId uint64) bool {
+	for _, id := range dataNodeIds {
+		if id == dataNodeId {
+			return true
 		}
-		// 打印重新分配的数据的写入分布情况
-		log.Printf("Block %s replication completed for %+v,current distribution is %+v\n", blockToReplicate.BlockId, targetDataNodeIds, newBlockToDataNodeIds)
 	}
+	return false
+}
 
-	return nil
+// removeDataNodeId 从副本位置中移除dataNodeId，返回新的切片
+func removeDataNodeId(dataNodeIds []uint64, dataNodeId uint64) (newDataNodeIds []uint64) {
+	for _, id := range dataNodeIds {
+		if id != dataNodeId {
+			newDataNodeIds = append(newDataNodeIds, id)
+		}
+	}
+	return
 }
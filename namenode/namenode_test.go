@@ -4,9 +4,23 @@ import (
 	"github.com/liuzongzhou/GoDFS/datanode"
 	"github.com/liuzongzhou/GoDFS/util"
 	"log"
+	"net"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 )
 
+// newTestService 创建测试用的nameNode：块大小为4，副本因子为2，超级用户为root，ports为依次加入的dataNode的端口
+func newTestService(ports ...string) *Service {
+	testNameNodeService := NewService("9000", "localhost", 4, 2, 9000)
+	testNameNodeService.SuperUser = "root"
+	for i, port := range ports {
+		testNameNodeService.IdToDataNodes[uint64(i)] = datanode.DataNodeInstance{Host: "localhost", ServicePort: port}
+	}
+	return testNameNodeService
+}
+
 // TestNameNodeCreation 创建一个NameNode服务
 func TestNameNodeCreation(t *testing.T) {
 	testNameNodeService := Service{
@@ -61,6 +75,9 @@ func TestNameNodeServiceWrite(t *testing.T) {
 	if len(reply) != 3 {
 		t.Errorf("Unable to set metadata correctly")
 	}
+	if reply[0].GenerationStamp == 0 || reply[0].GenerationStamp == reply[1].GenerationStamp {
+		t.Errorf("Unable to assign generation stamps correctly")
+	}
 }
 
 // TestNameNodeServiceGetIdToDataNodes 测试获取当前存活的datanode元数据组信息：host+port
@@ -354,3 +371,343 @@ func TestNameNodeServiceDeletePath(t *testing.T) {
 	err := testNameNodeService.DeleteMetaData(&request, &reply)
 	util.Check(err)
 }
+
+// TestNameNodeServiceDeletePathRecursive 测试删除目录时一起删除子目录下的文件和块的元数据
+func TestNameNodeServiceDeletePathRecursive(t *testing.T) {
+	testNameNodeService := newTestService("1234", "4321")
+	var metadata []NameNodeMetaData
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/Test1/", FileName: "foo", FileSize: 8}, &metadata))
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/Test1/logs/", FileName: "bar", FileSize: 8}, &metadata))
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/Test10/", FileName: "baz", FileSize: 8}, &metadata))
+	barBlocks := testNameNodeService.FileNameToBlocks["/Test1/logs/bar"]
+
+	var reply bool
+	if testNameNodeService.DeleteMetaData(&NameNodeDeleteRequest{RemoteFilePath: "/"}, &reply) == nil {
+		t.Errorf("Root directory should not be deleted")
+	}
+	util.Check(testNameNodeService.DeleteMetaData(&NameNodeDeleteRequest{RemoteFilePath: "/Test1/"}, &reply))
+	for _, fileName := range []string{"/Test1/foo", "/Test1/logs/bar"} {
+		if _, ok := testNameNodeService.FileNameToBlocks[fileName]; ok {
+			t.Errorf("File %s should be deleted", fileName)
+		}
+	}
+	if _, ok := testNameNodeService.DirectoryToFileName["/Test1/logs/"]; ok {
+		t.Errorf("Subdirectory should be deleted")
+	}
+	for _, blockId := range barBlocks {
+		if _, ok := testNameNodeService.BlockToDataNodeIds[blockId]; ok {
+			t.Errorf("Block %s in subdirectory should be deleted", blockId)
+		}
+	}
+	if _, ok := testNameNodeService.FileNameToBlocks["/Test10/baz"]; !ok {
+		t.Errorf("Directory with the same prefix should not be deleted")
+	}
+}
+
+// TestNameNodeServiceProcessBlockReport 测试块汇报：过期副本和孤儿副本不会被记录，丢失的副本会被移除
+func TestNameNodeServiceProcessBlockReport(t *testing.T) {
+	testNameNodeService := NewService("9000", "localhost", 4, 2, 9000)
+	testNameNodeService.IdToDataNodes[0] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "1234"}
+	testNameNodeService.IdToDataNodes[1] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "4321"}
+	testNameNodeService.setFileBlocks("/Test1/foo", []string{"0", "1", "2"})
+	testNameNodeService.BlockToDataNodeIds["0"] = []uint64{0}
+	testNameNodeService.BlockToDataNodeIds["1"] = []uint64{0}
+	testNameNodeService.BlockToDataNodeIds["2"] = []uint64{0, 1}
+	testNameNodeService.BlockToGenerationStamp["0"] = 1
+	testNameNodeService.BlockToGenerationStamp["1"] = 3
+	testNameNodeService.BlockToGenerationStamp["2"] = 1
+	testNameNodeService.GenerationStamp = 3
+
+	request := BlockReportRequest{
+		DataNodeId: 1,
+		Blocks: []datanode.BlockInfo{
+			{RemoteFilePath: "/Test1/", BlockId: "0", GenerationStamp: 1},
+			{RemoteFilePath: "/Test1/", BlockId: "1", GenerationStamp: 2},
+			{RemoteFilePath: "/Test1/", BlockId: "orphan", GenerationStamp: 1},
+		},
+	}
+	var reply bool
+	err := testNameNodeService.ProcessBlockReport(&request, &reply)
+	util.Check(err)
+	if !containsDataNodeId(testNameNodeService.BlockToDataNodeIds["0"], 1) {
+		t.Errorf("Unable to record reported replica")
+	}
+	if containsDataNodeId(testNameNodeService.BlockToDataNodeIds["1"], 1) {
+		t.Errorf("Stale replica should not be recorded")
+	}
+	if containsDataNodeId(testNameNodeService.BlockToDataNodeIds["2"], 1) {
+		t.Errorf("Missing replica should be removed")
+	}
+	if _, ok := testNameNodeService.BlockToDataNodeIds["orphan"]; ok {
+		t.Errorf("Orphan replica should not be recorded")
+	}
+}
+
+// TestNameNodeServiceRegisterDataNode 测试dataNode注册，重复注册返回原来的Id
+func TestNameNodeServiceRegisterDataNode(t *testing.T) {
+	testNameNodeService := NewService("9000", "localhost", 4, 2, 9000)
+	testNameNodeService.IdToDataNodes[0] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "1234"}
+
+	var id uint64
+	err := testNameNodeService.RegisterDataNode(&datanode.DataNodeInstance{Host: "localhost", ServicePort: "4321"}, &id)
+	util.Check(err)
+	if id != 1 || len(testNameNodeService.IdToDataNodes) != 2 {
+		t.Errorf("Unable to register new DataNode")
+	}
+	err = testNameNodeService.RegisterDataNode(&datanode.DataNodeInstance{Host: "localhost", ServicePort: "1234"}, &id)
+	util.Check(err)
+	if id != 0 || len(testNameNodeService.IdToDataNodes) != 2 {
+		t.Errorf("Unable to recognize registered DataNode")
+	}
+}
+
+// TestNameNodeServiceRemoveExcessReplicas 测试副本数超过副本因子时，优先删除已用空间最多的节点上的副本
+func TestNameNodeServiceRemoveExcessReplicas(t *testing.T) {
+	testNameNodeService := NewService("9000", "localhost", 4, 2, 9000)
+	testNameNodeService.IdToDataNodes[0] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "1234"}
+	testNameNodeService.IdToDataNodes[1] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "4321"}
+	testNameNodeService.IdToDataNodes[2] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "5678"}
+	testNameNodeService.setFileBlocks("/Test1/foo", []string{"0"})
+	testNameNodeService.BlockToDataNodeIds["0"] = []uint64{0, 1}
+	testNameNodeService.BlockToGenerationStamp["0"] = 1
+	testNameNodeService.DataNodeUsedSpace[0] = 100
+	testNameNodeService.DataNodeUsedSpace[1] = 10
+
+	// 节点2重新加入，汇报了块0，块0的副本数变成3
+	request := BlockReportRequest{
+		DataNodeId: 2,
+		Blocks:     []datanode.BlockInfo{{RemoteFilePath: "/Test1/", BlockId: "0", GenerationStamp: 1, Size: 4}},
+	}
+	var reply bool
+	err := testNameNodeService.ProcessBlockReport(&request, &reply)
+	util.Check(err)
+	dataNodeIds := testNameNodeService.BlockToDataNodeIds["0"]
+	if len(dataNodeIds) != 2 || containsDataNodeId(dataNodeIds, 0) {
+		t.Errorf("Unable to remove excess replica on the fullest DataNode: %v", dataNodeIds)
+	}
+}
+
+// TestNameNodeServiceMoveBlock 测试balancer移动块：目标节点汇报收到副本后删除源节点上的副本
+func TestNameNodeServiceMoveBlock(t *testing.T) {
+	testNameNodeService := NewService("9000", "localhost", 4, 2, 9000)
+	testNameNodeService.IdToDataNodes[0] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "1234"}
+	testNameNodeService.IdToDataNodes[1] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "4321"}
+	testNameNodeService.IdToDataNodes[2] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "5678"}
+	testNameNodeService.setFileBlocks("/Test1/foo", []string{"0", "1"})
+	testNameNodeService.FileNameSize["/Test1/foo"] = 6
+	testNameNodeService.BlockToDataNodeIds["0"] = []uint64{0, 1}
+	testNameNodeService.BlockToDataNodeIds["1"] = []uint64{0, 1}
+	testNameNodeService.BlockToGenerationStamp["0"] = 1
+	testNameNodeService.BlockToGenerationStamp["1"] = 2
+	// dataNode 1使用的空间更多，没有移动时应该删除它上面的副本
+	testNameNodeService.DataNodeUsedSpace[1] = 100
+
+	var locations []BlockLocation
+	err := testNameNodeService.GetBlockLocations(0, &locations)
+	util.Check(err)
+	if len(locations) != 2 || locations[0].Size != 4 || locations[1].Size != 2 {
+		t.Errorf("Unable to get block locations: %+v", locations)
+	}
+
+	var reply bool
+	if err = testNameNodeService.MoveBlock(&MoveBlockRequest{BlockId: "0", SourceDataNodeId: 0, TargetDataNodeId: 1}, &reply); err == nil {
+		t.Errorf("Block should not be moved to a DataNode holding it")
+	}
+	testNameNodeService.pendingMoves["0"] = pendingMove{sourceDataNodeId: 0, deadline: time.Now().Add(pendingMoveTimeout)}
+	request := datanode.BlockReceivedRequest{
+		DataNode: datanode.DataNodeInstance{Host: "localhost", ServicePort: "5678"},
+		Block:    datanode.BlockInfo{RemoteFilePath: "/Test1/", BlockId: "0", GenerationStamp: 1, Size: 4},
+	}
+	err = testNameNodeService.BlockReceived(&request, &reply)
+	util.Check(err)
+	dataNodeIds := testNameNodeService.BlockToDataNodeIds["0"]
+	if len(dataNodeIds) != 2 || containsDataNodeId(dataNodeIds, 0) {
+		t.Errorf("Unable to remove replica on source DataNode: %v", dataNodeIds)
+	}
+	if _, ok := testNameNodeService.pendingMoves["0"]; ok {
+		t.Errorf("Move should be finished")
+	}
+	// 目标节点一直没有汇报的移动超时后移除
+	testNameNodeService.pendingMoves["1"] = pendingMove{sourceDataNodeId: 0, deadline: time.Now().Add(pendingMoveTimeout)}
+	testNameNodeService.expireMoves(time.Now())
+	if _, ok := testNameNodeService.pendingMoves["1"]; !ok {
+		t.Errorf("Move should not expire before deadline")
+	}
+	testNameNodeService.expireMoves(time.Now().Add(pendingMoveTimeout * 2))
+	if _, ok := testNameNodeService.pendingMoves["1"]; ok {
+		t.Errorf("Unable to expire move")
+	}
+}
+
+// TestNameNodeServiceConcurrentAccess 测试rpc方法和后台任务并发读写元数据，用-race运行可以检查数据竞争
+func TestNameNodeServiceConcurrentAccess(t *testing.T) {
+	testNameNodeService := NewService("9000", "localhost", 4, 2, 9000)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var id uint64
+			var reply bool
+			util.Check(testNameNodeService.RegisterDataNode(&datanode.DataNodeInstance{Host: "localhost", ServicePort: strconv.Itoa(1234 + i)}, &id))
+			for j := 0; j < 50; j++ {
+				util.Check(testNameNodeService.ProcessHeartbeat(&HeartbeatRequest{DataNodeId: id, Heartbeat: datanode.DataNodeHeartbeat{Capacity: 100, Remaining: 100}}, &reply))
+				var report []DataNodeStorageReport
+				util.Check(testNameNodeService.GetStorageReport(true, &report))
+				var metadata Service
+				request := true
+				util.Check(testNameNodeService.ReplicationnameNode(&request, &metadata))
+			}
+		}(i)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results := make(chan replicationResult)
+		for j := 0; j < 50; j++ {
+			testNameNodeService.lock()
+			testNameNodeService.monitorReplication(time.Now(), results)
+			testNameNodeService.unlock()
+		}
+	}()
+	wg.Wait()
+	if len(testNameNodeService.DataNodes()) != 4 {
+		t.Errorf("Unable to register DataNodes concurrently: %v", testNameNodeService.DataNodes())
+	}
+}
+
+// TestNameNodeServiceHungDataNode 测试dataNode接受连接后没有响应时，等待该dataNode的命令不阻塞其他命名空间操作
+func TestNameNodeServiceHungDataNode(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	conns := make(chan net.Conn, 1)
+	go func() {
+		conn, acceptErr := listener.Accept()
+		if acceptErr == nil {
+			conns <- conn
+		}
+	}()
+	testNameNodeService := NewService("9000", "localhost", 4, 2, 9000)
+	testNameNodeService.SuperUser = "root"
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	testNameNodeService.IdToDataNodes[0] = datanode.DataNodeInstance{Host: "localhost", ServicePort: port}
+
+	done := make(chan error, 1)
+	go func() {
+		var reply bool
+		done <- testNameNodeService.Mkdir(&NameNodeMkdirRequest{RemoteFilePath: "/hung/", User: UserInfo{Name: "root"}}, &reply)
+	}()
+	var conn net.Conn
+	select {
+	case conn = <-conns:
+	case <-time.After(5 * time.Second):
+		t.Fatal("MakeDir command was not sent")
+	}
+	defer conn.Close()
+
+	// 命令已经发出但是dataNode没有响应，命名空间锁已经释放
+	permissions := make(chan Permission, 1)
+	go func() {
+		var permission Permission
+		util.Check(testNameNodeService.GetPermission("/hung/", &permission))
+		permissions <- permission
+	}()
+	select {
+	case permission := <-permissions:
+		if permission.Owner != "root" {
+			t.Errorf("Unable to create directory: %+v", permission)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Namespace operation should not wait for hung DataNode")
+	}
+	conn.Close()
+	util.Check(<-done)
+}
+
+// TestNameNodeServiceBlockIndex 测试写入、重命名和删除文件时维护块到文件的反向索引
+func TestNameNodeServiceBlockIndex(t *testing.T) {
+	testNameNodeService := newTestService("1234", "4321")
+	root := UserInfo{Name: "root"}
+	var written []NameNodeMetaData
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/Test1/", FileName: "foo", FileSize: 6, User: root}, &written))
+	if fileName, ok := testNameNodeService.blockFileName(written[1].BlockId); !ok || fileName != "/Test1/foo" {
+		t.Fatalf("Unable to index written blocks: %s", fileName)
+	}
+
+	var ok bool
+	util.Check(testNameNodeService.ReNameFile(&NameNodeReNameFileRequest{ReNameSrcFileName: "/Test1/foo", ReNameDestFileName: "/Test1/bar", User: root}, &ok))
+	if fileName, _ := testNameNodeService.blockFileName(written[0].BlockId); fileName != "/Test1/bar" {
+		t.Errorf("Unable to update index after rename: %s", fileName)
+	}
+	var dataNodes []datanode.DataNodeInstance
+	util.Check(testNameNodeService.ReName(&NameNodeReNameRequest{ReNameSrcPath: "/Test1/", ReNameDestPath: "/Test2/", User: root}, &dataNodes))
+	if fileName, _ := testNameNodeService.blockFileName(written[0].BlockId); fileName != "/Test2/bar" {
+		t.Errorf("Unable to update index after directory rename: %s", fileName)
+	}
+
+	util.Check(testNameNodeService.DeleteFileNameMetaData(&NameNodeDeleteRequest{RemoteFilePath: "/Test2/", FileName: "bar", User: root}, &ok))
+	if _, exists := testNameNodeService.blockFileName(written[0].BlockId); exists {
+		t.Errorf("Unable to remove deleted blocks from index")
+	}
+}
+
+// TestNameNodeServiceOverwriteReleasesBlocks 测试覆盖文件和重命名覆盖目标文件时删除原来的块，重命名为自身不删除
+func TestNameNodeServiceOverwriteReleasesBlocks(t *testing.T) {
+	testNameNodeService := newTestService("1234", "4321")
+	root := UserInfo{Name: "root"}
+	var old []NameNodeMetaData
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/D/", FileName: "f", FileSize: 8, User: root}, &old))
+	var written []NameNodeMetaData
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/D/", FileName: "f", FileSize: 8, User: root}, &written))
+	for _, metaData := range old {
+		if _, exists := testNameNodeService.BlockToDataNodeIds[metaData.BlockId]; exists {
+			t.Errorf("Unable to delete locations of overwritten block %s", metaData.BlockId)
+		}
+		if _, exists := testNameNodeService.BlockToGenerationStamp[metaData.BlockId]; exists {
+			t.Errorf("Unable to delete generation stamp of overwritten block %s", metaData.BlockId)
+		}
+	}
+
+	var ok bool
+	util.Check(testNameNodeService.ReNameFile(&NameNodeReNameFileRequest{ReNameSrcFileName: "/D/f", ReNameDestFileName: "/D/f", User: root}, &ok))
+	if _, exists := testNameNodeService.BlockToDataNodeIds[written[0].BlockId]; !exists {
+		t.Fatalf("Rename to itself should keep blocks")
+	}
+	var src []NameNodeMetaData
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/D/", FileName: "g", FileSize: 4, User: root}, &src))
+	util.Check(testNameNodeService.ReNameFile(&NameNodeReNameFileRequest{ReNameSrcFileName: "/D/g", ReNameDestFileName: "/D/f", User: root}, &ok))
+	for _, metaData := range written {
+		if _, exists := testNameNodeService.BlockToDataNodeIds[metaData.BlockId]; exists {
+			t.Errorf("Unable to delete block %s of rename target", metaData.BlockId)
+		}
+	}
+	if blocks := testNameNodeService.FileNameToBlocks["/D/f"]; len(blocks) != 1 || blocks[0] != src[0].BlockId {
+		t.Errorf("Unable to rename file over existing file: %v", blocks)
+	}
+}
+
+// TestNameNodeServiceOverwriteFileList 测试覆盖文件和重命名覆盖目标文件后，目录的文件列表中只有一个同名文件，目标文件原来的副本因子不保留
+func TestNameNodeServiceOverwriteFileList(t *testing.T) {
+	testNameNodeService := newTestService("1234", "4321")
+	root := UserInfo{Name: "root"}
+	var metadata []NameNodeMetaData
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/D/", FileName: "dst", FileSize: 8, ReplicationFactor: 1, User: root}, &metadata))
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/D/", FileName: "src", FileSize: 8, User: root}, &metadata))
+	var ok bool
+	util.Check(testNameNodeService.ReNameFile(&NameNodeReNameFileRequest{ReNameSrcFileName: "/D/src", ReNameDestFileName: "/D/dst", User: root}, &ok))
+	if replication := testNameNodeService.FileNameToReplication["/D/dst"]; replication != 2 {
+		t.Errorf("Rename target should take replication factor of source: %d", replication)
+	}
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/D/", FileName: "dst", FileSize: 8, User: root}, &metadata))
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/E/", FileName: "dst", FileSize: 8, User: root}, &metadata))
+	util.Check(testNameNodeService.ReNameFile(&NameNodeReNameFileRequest{ReNameSrcFileName: "/E/dst", ReNameDestFileName: "/D/dst", User: root}, &ok))
+
+	var list []ListMetaData
+	util.Check(testNameNodeService.List(&NameNodeListRequest{RemoteDirPath: "/D/", User: root}, &list))
+	if len(list) != 1 || list[0].FileName != "dst" {
+		t.Errorf("Directory should list overwritten file once: %v", list)
+	}
+}
@@ -0,0 +1,500 @@
+package namenode
+
+import (
+	"errors"
+	"github.com/liuzongzhou/GoDFS/auth"
+	"strings"
+)
+
+// FsAction 读、写、执行权限位，与Unix权限位的含义相同
+type FsAction uint32
+
+const (
+	ActionExecute FsAction = 1
+	ActionWrite   FsAction = 2
+	ActionRead    FsAction = 4
+)
+
+const (
+	// SuperGroup 超级用户组，组内的用户和SuperUser一样跳过权限检查
+	SuperGroup = "supergroup"
+	// StickyBit 目录设置了粘滞位时，只有文件或者子目录的所有者、目录的所有者和超级用户可以删除、重命名其中的文件或者子目录
+	StickyBit uint32 = 01000
+	// defaultFileMode 新建文件的权限
+	defaultFileMode uint32 = 0644
+	// defaultDirectoryMode 新建目录的权限
+	defaultDirectoryMode uint32 = 0755
+	// rootMode 根目录的默认权限，所有用户都可以在根目录下创建目录，但只能删除自己的
+	rootMode uint32 = 01777
+)
+
+// UserInfo 客户端的用户身份，由客户端在请求中携带
+type UserInfo struct {
+	Name   string
+	Groups []string
+}
+
+// Permission 文件或者目录的所有者、所属组和权限位
+type Permission struct {
+	Owner string
+	Group string
+	Mode  uint32
+}
+
+// NameNodeMkdirRequest 创建目录请求，不存在的上级目录一起创建
+type NameNodeMkdirRequest struct {
+	RemoteFilePath string
+	User           UserInfo
+}
+
+// NameNodeSetPermissionRequest 修改权限位请求，Path为路径+文件名，目录以/结尾
+type NameNodeSetPermissionRequest struct {
+	Path string
+	Mode uint32
+	User UserInfo
+}
+
+// NameNodeSetOwnerRequest 修改所有者和所属组请求，Owner或者Group为空时不修改
+type NameNodeSetOwnerRequest struct {
+	Path  string
+	Owner string
+	Group string
+	User  UserInfo
+}
+
+// NameNodeCheckAccessRequest 检查权限请求，先操作dataNode再修改nameNode元数据的客户端操作需要提前检查
+type NameNodeCheckAccessRequest struct {
+	Path     string
+	Access   FsAction //检查对Path的权限
+	Parent   bool     //为true时检查在上级目录中删除Path的权限
+	DestPath string   //不为空时检查把Path重命名为DestPath的权限
+	User     UserInfo
+}
+
+// permissionDeniedPrefix 权限检查失败的错误信息的前缀，审计日志据此区分被拒绝的操作
+const permissionDeniedPrefix = "权限不足: "
+
+// permissionDenied 权限检查失败的错误
+func permissionDenied(user UserInfo, path string) error {
+	return errors.New(permissionDeniedPrefix + "user=" + user.Name + ", path=" + path)
+}
+
+// isRoot 判断是否为根目录，路径可以以/开头，也可以不以/开头
+func isRoot(path string) bool {
+	return path == "" || path == "/"
+}
+
+// isSuperUser 判断用户是否为超级用户
+func (nameNode *Service) isSuperUser(user UserInfo) bool {
+	if nameNode.SuperUser != "" && user.Name == nameNode.SuperUser {
+		return true
+	}
+	for _, group := range user.Groups {
+		if group == SuperGroup {
+			return true
+		}
+	}
+	return false
+}
+
+// privilegedOperations 只允许服务进程和超级用户调用的rpc方法：dataNode和备份nameNode使用的内部方法，以及集群管理操作
+var privilegedOperations = map[string]bool{
+	"Service.ReplicationnameNode":   true,
+	"Service.RegisterDataNode":      true,
+	"Service.ProcessHeartbeat":      true,
+	"Service.ProcessBlockReport":    true,
+	"Service.BlockReceived":         true,
+	"Service.ReDistributeData":      true,
+	"Service.SetDataNodeAdminState": true,
+	"Service.MoveBlock":             true,
+}
+
+// Authorize 开启认证后检查每个rpc调用，内部方法和集群管理操作只允许服务进程和超级用户调用，
+// 安全模式只有get操作允许所有用户调用
+func (nameNode *Service) Authorize(serviceMethod string, identity auth.Identity, request any) error {
+	privileged := privilegedOperations[serviceMethod]
+	if action, ok := request.(*string); ok && serviceMethod == "Service.SafeMode" {
+		privileged = *action != SafeModeGet
+	}
+	user := UserInfo{Name: identity.Name, Groups: identity.Groups}
+	if !privileged || identity.IsService() || nameNode.isSuperUser(user) {
+		return nil
+	}
+	return errors.New(permissionDeniedPrefix + "user=" + user.Name + ", operation=" + strings.TrimPrefix(serviceMethod, "Service."))
+}
+
+// recordedPath 得到路径生效的权限记录所在的路径：路径本身或者最近的有记录的上级目录，都没有时为根目录
+func (nameNode *Service) recordedPath(path string) string {
+	for {
+		if _, ok := nameNode.PathToPermission[path]; ok {
+			return path
+		}
+		if isRoot(path) {
+			return "/"
+		}
+		path = parentDirectory(path)
+	}
+}
+
+// permissionOf 得到路径的权限，没有记录的路径使用最近的有记录的上级目录的权限，都没有时使用根目录的默认权限
+func (nameNode *Service) permissionOf(path string) Permission {
+	if permission, ok := nameNode.PathToPermission[nameNode.recordedPath(path)]; ok {
+		return permission
+	}
+	return Permission{Owner: nameNode.SuperUser, Group: SuperGroup, Mode: rootMode}
+}
+
+// hasAccess 检查用户对路径是否有access权限，路径有ACL时按ACL检查
+func (nameNode *Service) hasAccess(user UserInfo, path string, access FsAction) bool {
+	recorded := nameNode.recordedPath(path)
+	return aclAllows(user, nameNode.permissionOf(recorded), nameNode.PathToAcl[recorded], access)
+}
+
+// inGroup 判断用户是否属于该组
+func inGroup(user UserInfo, group string) bool {
+	for _, userGroup := range user.Groups {
+		if userGroup == group {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPermission 检查用户对所有上级目录有执行权限，并且对路径本身有access权限，access为0时只检查上级目录
+func (nameNode *Service) checkPermission(user UserInfo, path string, access FsAction) error {
+	if nameNode.isSuperUser(user) {
+		return nil
+	}
+	if !isRoot(path) {
+		for directory := parentDirectory(path); ; directory = parentDirectory(directory) {
+			if !nameNode.hasAccess(user, directory, ActionExecute) {
+				return permissionDenied(user, directory)
+			}
+			if isRoot(directory) {
+				break
+			}
+		}
+	}
+	if access != 0 && !nameNode.hasAccess(user, path, access) {
+		return permissionDenied(user, path)
+	}
+	return nil
+}
+
+// checkParentPermission 检查在上级目录中删除或者重命名路径的权限：对上级目录有写和执行权限，
+// 上级目录设置了粘滞位时，还需要是路径或者上级目录的所有者
+func (nameNode *Service) checkParentPermission(user UserInfo, path string) error {
+	if nameNode.isSuperUser(user) {
+		return nil
+	}
+	if isRoot(path) {
+		return permissionDenied(user, path)
+	}
+	parent := parentDirectory(path)
+	err := nameNode.checkPermission(user, parent, ActionWrite|ActionExecute)
+	if err != nil {
+		return err
+	}
+	parentPermission := nameNode.permissionOf(parent)
+	if parentPermission.Mode&StickyBit != 0 && user.Name != parentPermission.Owner && user.Name != nameNode.permissionOf(path).Owner {
+		return permissionDenied(user, path)
+	}
+	return nil
+}
+
+// checkSubtreePermission 检查递归删除目录的权限：目录树中每个非空目录都需要写和执行权限，
+// 设置了粘滞位的目录中，还需要是其中每个路径或者目录的所有者
+func (nameNode *Service) checkSubtreePermission(user UserInfo, directory string) error {
+	if nameNode.isSuperUser(user) || !strings.HasSuffix(directory, "/") {
+		return nil
+	}
+	paths := make(map[string]bool)
+	addPath := func(path string) {
+		for ; path != directory && strings.HasPrefix(path, directory); path = parentDirectory(path) {
+			paths[path] = true
+		}
+	}
+	for path := range nameNode.PathToPermission {
+		addPath(path)
+	}
+	for path := range nameNode.DirectoryToFileName {
+		addPath(path)
+	}
+	for path := range nameNode.FileNameToBlocks {
+		addPath(path)
+	}
+	nonEmpty := map[string]bool{}
+	for path := range paths {
+		parent := parentDirectory(path)
+		nonEmpty[parent] = true
+		parentPermission := nameNode.permissionOf(parent)
+		if parentPermission.Mode&StickyBit != 0 && user.Name != parentPermission.Owner && user.Name != nameNode.permissionOf(path).Owner {
+			return permissionDenied(user, path)
+		}
+	}
+	for path := range nonEmpty {
+		if err := nameNode.checkPermission(user, path, ActionWrite|ActionExecute); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createPermission 检查在上级目录中创建路径的权限，并为路径和不存在的上级目录记录权限，所有者为创建的用户，所属组和默认ACL继承上级目录
+func (nameNode *Service) createPermission(user UserInfo, path string, mode uint32) error {
+	// 从最近的已存在的上级目录开始创建
+	var missing []string
+	parent := parentDirectory(path)
+	for ; !isRoot(parent); parent = parentDirectory(parent) {
+		if _, ok := nameNode.PathToPermission[parent]; ok {
+			break
+		}
+		missing = append(missing, parent)
+	}
+	err := nameNode.checkPermission(user, parent, ActionWrite|ActionExecute)
+	if err != nil {
+		return err
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		nameNode.inheritPermission(user, missing[i], defaultDirectoryMode)
+	}
+	if _, ok := nameNode.PathToPermission[path]; !ok {
+		nameNode.inheritPermission(user, path, mode)
+	}
+	nameNode.chargeQuota(path)
+	return nil
+}
+
+// deletePermission 删除路径的权限和ACL记录
+func (nameNode *Service) deletePermission(path string) {
+	delete(nameNode.PathToPermission, path)
+	delete(nameNode.PathToAcl, path)
+}
+
+// pathExists 判断文件或者目录是否存在，目录以/结尾
+func (nameNode *Service) pathExists(path string) bool {
+	if isRoot(path) {
+		return true
+	}
+	if _, ok := nameNode.PathToPermission[path]; ok {
+		return true
+	}
+	if !strings.HasSuffix(path, "/") {
+		_, ok := nameNode.FileNameToBlocks[path]
+		return ok
+	}
+	if _, ok := nameNode.DirectoryToFileName[path]; ok {
+		return true
+	}
+	for fileName := range nameNode.FileNameToBlocks {
+		if strings.HasPrefix(fileName, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// Mkdir 创建目录，记录目录的权限，不存在的上级目录一起创建，并在所有dataNode上创建目录
+func (nameNode *Service) Mkdir(request *NameNodeMkdirRequest, reply *bool) error {
+	nameNode.lock()
+	defer nameNode.unlock()
+	if err := nameNode.checkNotInSafeMode(); err != nil {
+		return err
+	}
+	if !strings.HasSuffix(request.RemoteFilePath, "/") {
+		return errors.New("目录必须以/结尾")
+	}
+	if err := checkNotInSnapshot(request.RemoteFilePath); err != nil {
+		return err
+	}
+	if isRoot(request.RemoteFilePath) {
+		*reply = true
+		return nil
+	}
+	if _, ok := nameNode.PathToPermission[request.RemoteFilePath]; !ok {
+		if err := nameNode.checkQuota(request.RemoteFilePath, nameNode.missingDirectories(request.RemoteFilePath)+1, 0, ""); err != nil {
+			return err
+		}
+	}
+	err := nameNode.createPermission(request.User, request.RemoteFilePath, defaultDirectoryMode)
+	if err != nil {
+		return err
+	}
+	//在所有dataNode上创建目录，连接失败的dataNode跳过
+	nameNode.callDataNodes("Service.MakeDir", request.RemoteFilePath)
+	*reply = true
+	return nil
+}
+
+// SetPermission 修改文件或者目录的权限位，只有所有者和超级用户可以修改
+func (nameNode *Service) SetPermission(request *NameNodeSetPermissionRequest, reply *bool) error {
+	nameNode.lock()
+	defer nameNode.unlock()
+	if err := nameNode.checkNotInSafeMode(); err != nil {
+		return err
+	}
+	path := request.Path
+	if isRoot(path) {
+		path = "/"
+	}
+	if request.Mode > StickyBit|0777 {
+		return errors.New("权限位不合法")
+	}
+	err := nameNode.checkOwner(request.User, path)
+	if err != nil {
+		return err
+	}
+	// 有扩展ACL时权限位中的组权限就是ACL的mask
+	permission := nameNode.permissionOf(path)
+	permission.Mode = request.Mode
+	nameNode.PathToPermission[path] = permission
+	nameNode.chargeQuota(path)
+	*reply = true
+	return nil
+}
+
+// checkOwner 检查路径存在，并且用户是路径的所有者或者超级用户
+func (nameNode *Service) checkOwner(user UserInfo, path string) error {
+	if !nameNode.pathExists(path) {
+		return errors.New("路径不存在")
+	}
+	err := nameNode.checkPermission(user, path, 0)
+	if err != nil {
+		return err
+	}
+	if !nameNode.isSuperUser(user) && user.Name != nameNode.permissionOf(path).Owner {
+		return permissionDenied(user, path)
+	}
+	return nil
+}
+
+// SetOwner 修改文件或者目录的所有者和所属组，只有超级用户可以修改所有者；
+// 所有者可以把所属组修改为自己所在的组
+func (nameNode *Service) SetOwner(request *NameNodeSetOwnerRequest, reply *bool) error {
+	nameNode.lock()
+	defer nameNode.unlock()
+	if err := nameNode.checkNotInSafeMode(); err != nil {
+		return err
+	}
+	path := request.Path
+	if isRoot(path) {
+		path = "/"
+	}
+	if !nameNode.pathExists(path) {
+		return errors.New("路径不存在")
+	}
+	if request.Owner == "" && request.Group == "" {
+		return errors.New("所有者和所属组不能都为空")
+	}
+	err := nameNode.checkPermission(request.User, path, 0)
+	if err != nil {
+		return err
+	}
+	permission := nameNode.permissionOf(path)
+	if !nameNode.isSuperUser(request.User) {
+		if request.User.Name != permission.Owner || (request.Owner != "" && request.Owner != permission.Owner) {
+			return permissionDenied(request.User, path)
+		}
+		if request.Group != "" && !inGroup(request.User, request.Group) {
+			return permissionDenied(request.User, path)
+		}
+	}
+	if request.Owner != "" {
+		permission.Owner = request.Owner
+	}
+	if request.Group != "" {
+		permission.Group = request.Group
+	}
+	nameNode.PathToPermission[path] = permission
+	nameNode.chargeQuota(path)
+	*reply = true
+	return nil
+}
+
+// GetPermission 获取文件或者目录的权限
+func (nameNode *Service) GetPermission(request string, reply *Permission) error {
+	nameNode.rLock()
+	defer nameNode.rUnlock()
+	if inSnapshot(request) {
+		location, err := nameNode.resolveSnapshotPath(request)
+		if err != nil {
+			return err
+		}
+		if location.index < 0 || location.rel == "" || strings.HasSuffix(location.rel, "/") {
+			return errors.New("路径不存在")
+		}
+		file := nameNode.snapshotFile(location)
+		if !file.Exists {
+			return errors.New("路径不存在")
+		}
+		*reply = file.Permission
+		return nil
+	}
+	if !nameNode.pathExists(request) {
+		return errors.New("路径不存在")
+	}
+	*reply = nameNode.permissionOf(request)
+	return nil
+}
+
+// CheckAccess 检查用户的权限，客户端在修改dataNode上的数据之前调用，避免dataNode上的数据被修改后nameNode才拒绝
+func (nameNode *Service) CheckAccess(request *NameNodeCheckAccessRequest, reply *bool) error {
+	nameNode.rLock()
+	defer nameNode.rUnlock()
+	var err error
+	if request.DestPath != "" {
+		err = nameNode.checkRenamePermission(request.User, request.Path, request.DestPath)
+	} else if request.Parent {
+		err = nameNode.checkDeletable(request.User, request.Path)
+	} else {
+		err = nameNode.checkPermission(request.User, request.Path, request.Access)
+	}
+	if err != nil {
+		return err
+	}
+	*reply = true
+	return nil
+}
+
+// checkRenamePermission 检查重命名的权限：在源路径的上级目录中删除源路径，在目标路径的上级目录中创建目标路径，
+// 并且不能跨越加密区，也不能使目标路径所在的目录超出配额，快照中的路径不能重命名
+func (nameNode *Service) checkRenamePermission(user UserInfo, srcPath string, destPath string) error {
+	if err := checkNotInSnapshot(srcPath); err != nil {
+		return err
+	}
+	if err := checkNotInSnapshot(destPath); err != nil {
+		return err
+	}
+	if err := nameNode.checkParentPermission(user, srcPath); err != nil {
+		return err
+	}
+	if err := nameNode.checkEncryptionZoneRename(srcPath, destPath); err != nil {
+		return err
+	}
+	if err := nameNode.checkRenameQuota(srcPath, destPath); err != nil {
+		return err
+	}
+	return nameNode.checkPermission(user, parentDirectory(destPath), ActionWrite|ActionExecute)
+}
+
+// renamePermissions 重命名时迁移路径及其下所有路径的权限和ACL
+func (nameNode *Service) renamePermissions(srcPath string, destPath string) {
+	renamed := make(map[string]Permission)
+	renamedAcls := make(map[string][]AclEntry)
+	for path, permission := range nameNode.PathToPermission {
+		if path == srcPath || (strings.HasSuffix(srcPath, "/") && strings.HasPrefix(path, srcPath)) {
+			delete(nameNode.PathToPermission, path)
+			renamed[destPath+strings.TrimPrefix(path, srcPath)] = permission
+			if acl, ok := nameNode.PathToAcl[path]; ok {
+				delete(nameNode.PathToAcl, path)
+				renamedAcls[destPath+strings.TrimPrefix(path, srcPath)] = acl
+			}
+		}
+	}
+	for path, permission := range renamed {
+		nameNode.PathToPermission[path] = permission
+	}
+	for path, acl := range renamedAcls {
+		nameNode.PathToAcl[path] = acl
+	}
+}
@@ -0,0 +1,194 @@
+package namenode
+
+import (
+	"github.com/liuzongzhou/GoDFS/auth"
+	"github.com/liuzongzhou/GoDFS/datanode"
+	"github.com/liuzongzhou/GoDFS/util"
+	"testing"
+)
+
+// TestNameNodeServicePermission 测试创建文件时记录所有者，以及读、删除、重命名时的权限检查
+func TestNameNodeServicePermission(t *testing.T) {
+	testNameNodeService := newTestService("1234", "4321")
+	alice := UserInfo{Name: "alice", Groups: []string{"staff"}}
+	bob := UserInfo{Name: "bob", Groups: []string{"staff"}}
+
+	var metadata []NameNodeMetaData
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/alice/data/", FileName: "foo", FileSize: 8, User: alice}, &metadata))
+	// 不存在的上级目录一起创建，所有者为创建的用户
+	var permission Permission
+	util.Check(testNameNodeService.GetPermission("/alice/", &permission))
+	if permission != (Permission{Owner: "alice", Group: SuperGroup, Mode: defaultDirectoryMode}) {
+		t.Errorf("Unable to create parent directory permission: %+v", permission)
+	}
+	util.Check(testNameNodeService.GetPermission("/alice/data/foo", &permission))
+	if permission != (Permission{Owner: "alice", Group: SuperGroup, Mode: defaultFileMode}) {
+		t.Errorf("Unable to create file permission: %+v", permission)
+	}
+
+	// 其他用户可以读0644的文件，不能在0755的目录下创建文件
+	var blocks []NameNodeMetaData
+	util.Check(testNameNodeService.ReadData(&NameNodeReadRequest{FileName: "/alice/data/foo", User: bob}, &blocks))
+	if err := testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/alice/data/", FileName: "bar", FileSize: 8, User: bob}, &metadata); err == nil {
+		t.Errorf("Other user should not create file in 0755 directory")
+	}
+	if _, ok := testNameNodeService.FileNameToBlocks["/alice/data/bar"]; ok {
+		t.Errorf("Denied write should not create file metadata")
+	}
+
+	// 修改为0600之后其他用户不能读
+	var ok bool
+	util.Check(testNameNodeService.SetPermission(&NameNodeSetPermissionRequest{Path: "/alice/data/foo", Mode: 0600, User: alice}, &ok))
+	if err := testNameNodeService.ReadData(&NameNodeReadRequest{FileName: "/alice/data/foo", User: bob}, &blocks); err == nil {
+		t.Errorf("Other user should not read 0600 file")
+	}
+	if err := testNameNodeService.SetPermission(&NameNodeSetPermissionRequest{Path: "/alice/data/foo", Mode: 0666, User: bob}, &ok); err == nil {
+		t.Errorf("Only owner should change mode")
+	}
+
+	// 根目录设置了粘滞位，其他用户不能删除alice的目录，超级用户可以
+	if err := testNameNodeService.CheckAccess(&NameNodeCheckAccessRequest{Path: "/alice/", Parent: true, User: bob}, &ok); err == nil {
+		t.Errorf("Sticky root should not allow other user to delete directory")
+	}
+	if err := testNameNodeService.DeleteFileNameMetaData(&NameNodeDeleteRequest{RemoteFilePath: "/alice/data/", FileName: "foo", User: bob}, &ok); err == nil {
+		t.Errorf("Other user should not delete file in 0755 directory")
+	}
+	util.Check(testNameNodeService.CheckAccess(&NameNodeCheckAccessRequest{Path: "/alice/", Parent: true, User: UserInfo{Name: "root"}}, &ok))
+
+	// 递归删除时检查整个目录树，其他用户可以删除0777目录下的子目录，但不能删除其中0755的非空目录
+	root := UserInfo{Name: "root"}
+	util.Check(testNameNodeService.Mkdir(&NameNodeMkdirRequest{RemoteFilePath: "/pub/", User: root}, &ok))
+	util.Check(testNameNodeService.SetPermission(&NameNodeSetPermissionRequest{Path: "/pub/", Mode: 0777, User: root}, &ok))
+	util.Check(testNameNodeService.Mkdir(&NameNodeMkdirRequest{RemoteFilePath: "/pub/a/b/", User: alice}, &ok))
+	util.Check(testNameNodeService.SetPermission(&NameNodeSetPermissionRequest{Path: "/pub/a/", Mode: 0777, User: alice}, &ok))
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/pub/a/b/", FileName: "f", FileSize: 8, User: alice}, &metadata))
+	if err := testNameNodeService.DeleteMetaData(&NameNodeDeleteRequest{RemoteFilePath: "/pub/a/b/", User: bob}, &ok); err == nil {
+		t.Errorf("Other user should not delete non-empty 0755 directory")
+	}
+	if err := testNameNodeService.DeleteMetaData(&NameNodeDeleteRequest{RemoteFilePath: "/pub/a/", User: bob}, &ok); err == nil {
+		t.Errorf("Other user should not delete directory containing non-empty 0755 directory")
+	}
+	if _, ok := testNameNodeService.FileNameToBlocks["/pub/a/b/f"]; !ok {
+		t.Errorf("Denied delete should not remove file metadata")
+	}
+	util.Check(testNameNodeService.DeleteMetaData(&NameNodeDeleteRequest{RemoteFilePath: "/pub/a/", User: alice}, &ok))
+
+	// 重命名时权限随路径迁移
+	util.Check(testNameNodeService.ReName(&NameNodeReNameRequest{ReNameSrcPath: "/alice/data/", ReNameDestPath: "/alice/backup/", User: alice}, &[]datanode.DataNodeInstance{}))
+	util.Check(testNameNodeService.GetPermission("/alice/backup/foo", &permission))
+	if permission.Owner != "alice" || permission.Mode != 0600 {
+		t.Errorf("Unable to rename permission: %+v", permission)
+	}
+	if _, ok := testNameNodeService.PathToPermission["/alice/data/foo"]; ok {
+		t.Errorf("Renamed permission should be removed")
+	}
+
+	// 删除文件时删除权限记录
+	util.Check(testNameNodeService.DeleteFileNameMetaData(&NameNodeDeleteRequest{RemoteFilePath: "/alice/backup/", FileName: "foo", User: alice}, &ok))
+	if _, ok := testNameNodeService.PathToPermission["/alice/backup/foo"]; ok {
+		t.Errorf("Deleted file permission should be removed")
+	}
+}
+
+// TestNameNodeServiceSetOwner 测试只有超级用户可以修改所有者，所有者只能把所属组修改为自己所在的组
+func TestNameNodeServiceSetOwner(t *testing.T) {
+	testNameNodeService := newTestService("1234", "4321")
+	alice := UserInfo{Name: "alice", Groups: []string{"staff", "dev"}}
+	var ok bool
+	util.Check(testNameNodeService.Mkdir(&NameNodeMkdirRequest{RemoteFilePath: "/project/src/", User: alice}, &ok))
+	if _, ok := testNameNodeService.PathToPermission["/project/"]; !ok {
+		t.Fatalf("Unable to create parent directory")
+	}
+
+	if err := testNameNodeService.SetOwner(&NameNodeSetOwnerRequest{Path: "/project/", Owner: "bob", User: alice}, &ok); err == nil {
+		t.Errorf("Only super user should change owner")
+	}
+	if err := testNameNodeService.SetOwner(&NameNodeSetOwnerRequest{Path: "/project/", Group: "ops", User: alice}, &ok); err == nil {
+		t.Errorf("Owner should not change group to a group it is not in")
+	}
+	util.Check(testNameNodeService.SetOwner(&NameNodeSetOwnerRequest{Path: "/project/", Group: "dev", User: alice}, &ok))
+	util.Check(testNameNodeService.SetOwner(&NameNodeSetOwnerRequest{Path: "/project/src/", Owner: "bob", User: UserInfo{Name: "admin", Groups: []string{SuperGroup}}}, &ok))
+
+	var permission Permission
+	util.Check(testNameNodeService.GetPermission("/project/", &permission))
+	if permission.Owner != "alice" || permission.Group != "dev" {
+		t.Errorf("Unable to change group: %+v", permission)
+	}
+	util.Check(testNameNodeService.GetPermission("/project/src/", &permission))
+	if permission.Owner != "bob" {
+		t.Errorf("Unable to change owner: %+v", permission)
+	}
+
+	// 组成员通过组权限访问，0750的目录其他用户不能列出
+	util.Check(testNameNodeService.SetPermission(&NameNodeSetPermissionRequest{Path: "/project/", Mode: 0750, User: alice}, &ok))
+	var list []ListMetaData
+	util.Check(testNameNodeService.List(&NameNodeListRequest{RemoteDirPath: "/project/", User: UserInfo{Name: "carol", Groups: []string{"dev"}}}, &list))
+	if err := testNameNodeService.List(&NameNodeListRequest{RemoteDirPath: "/project/", User: UserInfo{Name: "dave"}}, &list); err == nil {
+		t.Errorf("Other user should not list 0750 directory")
+	}
+	if err := testNameNodeService.CheckAccess(&NameNodeCheckAccessRequest{Path: "/project/src/", Access: ActionRead, User: UserInfo{Name: "dave"}}, &ok); err == nil {
+		t.Errorf("Other user should not traverse 0750 directory")
+	}
+	if err := testNameNodeService.SetPermission(&NameNodeSetPermissionRequest{Path: "/missing/", Mode: 0700, User: alice}, &ok); err == nil {
+		t.Errorf("Unable to reject missing path")
+	}
+}
+
+// TestNameNodeServiceStoragePermission 测试修改副本因子、纠删码策略和转换存储方式都需要写权限
+func TestNameNodeServiceStoragePermission(t *testing.T) {
+	testNameNodeService := newTestService("1234", "4321")
+	alice := UserInfo{Name: "alice"}
+	bob := UserInfo{Name: "bob"}
+	var metadata []NameNodeMetaData
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/alice/", FileName: "foo", FileSize: 8, User: alice}, &metadata))
+
+	var ok bool
+	if err := testNameNodeService.SetReplication(&NameNodeSetReplicationRequest{RemoteFilePath: "/alice/", FileName: "foo", ReplicationFactor: 1, User: bob}, &ok); err == nil {
+		t.Errorf("Setting replication should require write permission")
+	}
+	if err := testNameNodeService.SetReplication(&NameNodeSetReplicationRequest{RemoteFilePath: "/alice/", ReplicationFactor: 1, User: bob}, &ok); err == nil {
+		t.Errorf("Setting directory replication should require write permission")
+	}
+	util.Check(testNameNodeService.SetReplication(&NameNodeSetReplicationRequest{RemoteFilePath: "/alice/", FileName: "foo", ReplicationFactor: 1, User: alice}, &ok))
+	if err := testNameNodeService.SetErasureCodingPolicy(&NameNodeSetErasureCodingPolicyRequest{RemoteFilePath: "/alice/", Policy: ReplicationPolicyName, User: bob}, &ok); err == nil {
+		t.Errorf("Setting erasure coding policy should require write permission")
+	}
+	util.Check(testNameNodeService.SetErasureCodingPolicy(&NameNodeSetErasureCodingPolicyRequest{RemoteFilePath: "/alice/", Policy: ReplicationPolicyName, User: alice}, &ok))
+
+	request := NameNodeConvertRequest{RemoteFilePath: "/alice/", FileName: "foo", Policy: "RS-3-2", User: bob}
+	if err := testNameNodeService.ConvertFile(&request, &metadata); err == nil {
+		t.Errorf("Converting a file should require write permission")
+	}
+}
+
+// TestNameNodeServiceAuthorize 测试内部方法和集群管理操作只允许服务进程和超级用户调用
+func TestNameNodeServiceAuthorize(t *testing.T) {
+	testNameNodeService := newTestService("1234", "4321")
+	alice := auth.Identity{Name: "alice", Groups: []string{"staff"}}
+	service := auth.Identity{Name: "datanode", Groups: []string{auth.ServiceGroup}}
+	root := auth.Identity{Name: "root"}
+	request := true
+	if err := testNameNodeService.Authorize("Service.ReplicationnameNode", alice, &request); err == nil {
+		t.Errorf("User should not replicate namespace")
+	}
+	if err := testNameNodeService.Authorize("Service.BlockReceived", alice, &datanode.BlockReceivedRequest{}); err == nil {
+		t.Errorf("User should not report blocks")
+	}
+	if err := testNameNodeService.Authorize("Service.BlockReceived", service, &datanode.BlockReceivedRequest{}); err != nil {
+		t.Errorf("Service should report blocks: %v", err)
+	}
+	if err := testNameNodeService.Authorize("Service.MoveBlock", root, &MoveBlockRequest{}); err != nil {
+		t.Errorf("Super user should move blocks: %v", err)
+	}
+	action := SafeModeGet
+	if err := testNameNodeService.Authorize("Service.SafeMode", alice, &action); err != nil {
+		t.Errorf("User should get safe mode: %v", err)
+	}
+	action = SafeModeLeave
+	if err := testNameNodeService.Authorize("Service.SafeMode", alice, &action); err == nil {
+		t.Errorf("User should not leave safe mode")
+	}
+	if err := testNameNodeService.Authorize("Service.List", alice, &NameNodeListRequest{}); err != nil {
+		t.Errorf("User should list directories: %v", err)
+	}
+}
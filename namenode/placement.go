@@ -0,0 +1,280 @@
+package namenode
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// PlacementRequest 选择副本目标节点的请求
+type PlacementRequest struct {
+	WriterHost string   //写入客户端所在的主机，副本复制时为空
+	Candidates []uint64 //可以选择的dataNode，不包含已经存有该块副本的节点
+	Chosen     []uint64 //已经存有该块副本的dataNode，新写入的块为空
+	Count      int      //需要选择的节点数
+}
+
+// BlockPlacementPolicy 副本放置策略，新写入的块和副本复制都通过它选择目标节点，
+// 返回的节点数不超过Count和Candidates的数量，且不能重复
+type BlockPlacementPolicy interface {
+	ChooseTargets(nameNode *Service, request PlacementRequest) []uint64
+}
+
+// 内置的副本放置策略名称
+const (
+	RandomPlacementPolicy           = "random"
+	RoundRobinPlacementPolicy       = "round-robin"
+	CapacityWeightedPlacementPolicy = "capacity-weighted"
+	RackAwarePlacementPolicy        = "rack-aware"
+)
+
+// placementPolicies 已注册的副本放置策略 key:策略名称 value:生成策略的函数
+var placementPolicies = map[string]func() BlockPlacementPolicy{
+	RandomPlacementPolicy:           func() BlockPlacementPolicy { return randomPlacementPolicy{} },
+	RoundRobinPlacementPolicy:       func() BlockPlacementPolicy { return &roundRobinPlacementPolicy{} },
+	CapacityWeightedPlacementPolicy: func() BlockPlacementPolicy { return capacityWeightedPlacementPolicy{} },
+	RackAwarePlacementPolicy:        func() BlockPlacementPolicy { return rackAwarePlacementPolicy{} },
+}
+
+// RegisterBlockPlacementPolicy 注册自定义的副本放置策略，之后可以通过名称选择
+func RegisterBlockPlacementPolicy(name string, newPolicy func() BlockPlacementPolicy) {
+	placementPolicies[name] = newPolicy
+}
+
+// NewBlockPlacementPolicy 根据名称生成副本放置策略
+func NewBlockPlacementPolicy(name string) (BlockPlacementPolicy, error) {
+	newPolicy, ok := placementPolicies[name]
+	if !ok {
+		return nil, errors.New("副本放置策略不存在: " + name)
+	}
+	return newPolicy(), nil
+}
+
+// SetPlacementPolicy 设置nameNode使用的副本放置策略
+func (nameNode *Service) SetPlacementPolicy(policy BlockPlacementPolicy) {
+	nameNode.placementPolicy = policy
+}
+
+// chooseTargets 通过副本放置策略，在已有副本chosen的基础上从candidates中再选择count个节点，
+// 剩余空间不足或者负载过高的节点不参与选择
+func (nameNode *Service) chooseTargets(writerHost string, candidates []uint64, chosen []uint64, count int) []uint64 {
+	candidates = nameNode.writableDataNodes(candidates)
+	if count <= 0 || len(candidates) == 0 {
+		return nil
+	}
+	policy := nameNode.placementPolicy
+	// 没有通过NewService创建、也没有设置放置策略时使用默认的机架感知策略
+	if policy == nil {
+		policy = rackAwarePlacementPolicy{}
+	}
+	targets := policy.ChooseTargets(nameNode, PlacementRequest{
+		WriterHost: writerHost,
+		Candidates: candidates,
+		Chosen:     chosen,
+		Count:      count,
+	})
+	nameNode.reserveSpace(targets)
+	return targets
+}
+
+// writableDataNodes 筛选可以写入的节点：处于正常管理状态，剩余空间不低于阈值，并且正在进行的传输数不超过平均值的两倍。
+// 还没有收到心跳的节点不知道容量，视为可以写入；排除负载过高的节点后没有节点可选时，不再按负载排除
+func (nameNode *Service) writableDataNodes(dataNodeIds []uint64) []uint64 {
+	minFreeSpace := nameNode.MinFreeSpace
+	if minFreeSpace < nameNode.BlockSize {
+		minFreeSpace = nameNode.BlockSize
+	}
+	hasSpace := filterDataNodes(dataNodeIds, func(id uint64) bool {
+		if nameNode.adminState(id) != AdminStateNormal {
+			return false
+		}
+		stats, ok := nameNode.DataNodeStats[id]
+		return !ok || stats.Remaining >= minFreeSpace
+	})
+	var totalTransfers int64
+	for _, id := range hasSpace {
+		totalTransfers += nameNode.DataNodeStats[id].ActiveTransfers
+	}
+	if totalTransfers == 0 {
+		return hasSpace
+	}
+	notBusy := filterDataNodes(hasSpace, func(id uint64) bool {
+		return nameNode.DataNodeStats[id].ActiveTransfers*int64(len(hasSpace)) <= 2*totalTransfers
+	})
+	if len(notBusy) == 0 {
+		return hasSpace
+	}
+	return notBusy
+}
+
+// reserveSpace 选中的节点预留一个块的空间，在下一次心跳之前，连续写入的块不会把节点写满
+func (nameNode *Service) reserveSpace(dataNodeIds []uint64) {
+	for _, id := range dataNodeIds {
+		stats, ok := nameNode.DataNodeStats[id]
+		if !ok {
+			continue
+		}
+		if stats.Remaining > nameNode.BlockSize {
+			stats.Remaining -= nameNode.BlockSize
+		} else {
+			stats.Remaining = 0
+		}
+		nameNode.DataNodeStats[id] = stats
+	}
+}
+
+// targetCount 实际能选择的节点数，可用节点不够时尽量多选
+func (request PlacementRequest) targetCount() int {
+	if request.Count > len(request.Candidates) {
+		return len(request.Candidates)
+	}
+	return request.Count
+}
+
+// randomPlacementPolicy 随机选择节点
+type randomPlacementPolicy struct{}
+
+func (randomPlacementPolicy) ChooseTargets(nameNode *Service, request PlacementRequest) []uint64 {
+	return selectRandomNumbers(request.Candidates, uint64(request.targetCount()))
+}
+
+// roundRobinPlacementPolicy 按dataNodeId轮流选择节点，使块均匀分布
+type roundRobinPlacementPolicy struct {
+	mu   sync.Mutex
+	next uint64 //下一次从不小于该Id的节点开始选择
+}
+
+func (policy *roundRobinPlacementPolicy) ChooseTargets(nameNode *Service, request PlacementRequest) (targets []uint64) {
+	policy.mu.Lock()
+	defer policy.mu.Unlock()
+	candidates := make([]uint64, len(request.Candidates))
+	copy(candidates, request.Candidates)
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i] < candidates[j] })
+	start := sort.Search(len(candidates), func(i int) bool { return candidates[i] >= policy.next })
+	for i := 0; i < request.targetCount(); i++ {
+		targets = append(targets, candidates[(start+i)%len(candidates)])
+	}
+	policy.next = targets[len(targets)-1] + 1
+	return
+}
+
+// capacityWeightedPlacementPolicy 按剩余空间和负载加权随机选择节点，剩余空间越多、负载越低的节点被选中的概率越大
+type capacityWeightedPlacementPolicy struct{}
+
+func (capacityWeightedPlacementPolicy) ChooseTargets(nameNode *Service, request PlacementRequest) (targets []uint64) {
+	remaining := make([]uint64, len(request.Candidates))
+	copy(remaining, request.Candidates)
+	for len(targets) < request.targetCount() {
+		dataNodeId := nameNode.chooseWeightedDataNode(remaining)
+		targets = append(targets, dataNodeId)
+		remaining = removeDataNodeId(remaining, dataNodeId)
+	}
+	return
+}
+
+// chooseWeightedDataNode 按权重随机选择一个节点，权重为心跳汇报的剩余空间除以（正在进行的传输数+1），
+// 还没有收到心跳的节点按已知节点的平均剩余空间计算
+func (nameNode *Service) chooseWeightedDataNode(dataNodeIds []uint64) uint64 {
+	var knownRemaining float64
+	var known int
+	for _, id := range dataNodeIds {
+		if stats, ok := nameNode.DataNodeStats[id]; ok {
+			knownRemaining += float64(stats.Remaining)
+			known++
+		}
+	}
+	averageRemaining := 1.0
+	if known > 0 {
+		averageRemaining = knownRemaining / float64(known)
+	}
+	weights := make([]float64, len(dataNodeIds))
+	var total float64
+	for i, id := range dataNodeIds {
+		stats, ok := nameNode.DataNodeStats[id]
+		remaining := averageRemaining
+		if ok {
+			remaining = float64(stats.Remaining)
+		}
+		weights[i] = remaining / float64(stats.ActiveTransfers+1)
+		total += weights[i]
+	}
+	if total <= 0 {
+		return dataNodeIds[rand.Intn(len(dataNodeIds))]
+	}
+	r := rand.Float64() * total
+	for i, weight := range weights {
+		if r < weight {
+			return dataNodeIds[i]
+		}
+		r -= weight
+	}
+	return dataNodeIds[len(dataNodeIds)-1]
+}
+
+// rackAwarePlacementPolicy 机架感知的副本放置策略：
+// 第一个副本放在写入客户端所在的节点，客户端不是dataNode时放在客户端所在的机架；
+// 第二个副本放在与第一个副本不同的机架；第三个副本放在与第二个副本相同机架的另一个节点；其余副本随机放置。
+// 满足不了机架要求时（例如只有一个机架）退化为随机选择，随机选择时按剩余空间和负载加权
+type rackAwarePlacementPolicy struct{}
+
+func (rackAwarePlacementPolicy) ChooseTargets(nameNode *Service, request PlacementRequest) (targets []uint64) {
+	remaining := make([]uint64, len(request.Candidates))
+	copy(remaining, request.Candidates)
+	placed := make([]uint64, len(request.Chosen))
+	copy(placed, request.Chosen)
+	for len(targets) < request.targetCount() {
+		dataNodeId := nameNode.chooseNextRackAwareTarget(request.WriterHost, remaining, placed)
+		targets = append(targets, dataNodeId)
+		placed = append(placed, dataNodeId)
+		remaining = removeDataNodeId(remaining, dataNodeId)
+	}
+	return
+}
+
+// chooseNextRackAwareTarget 根据已经放置的副本选择下一个副本的位置
+func (nameNode *Service) chooseNextRackAwareTarget(writerHost string, remaining []uint64, placed []uint64) uint64 {
+	var preferred []uint64
+	switch len(placed) {
+	case 0:
+		preferred = filterDataNodes(remaining, func(id uint64) bool {
+			return nameNode.IdToDataNodes[id].Host == writerHost
+		})
+		if len(preferred) == 0 {
+			if writerRack := nameNode.hostRack(writerHost); writerRack != "" {
+				preferred = nameNode.dataNodesOnRack(remaining, writerRack, true)
+			}
+		}
+	case 1:
+		preferred = nameNode.dataNodesOnRack(remaining, nameNode.RackOf(placed[0]), false)
+	case 2:
+		// 前两个副本在同一个机架时，第三个副本放到其他机架，保证至少跨两个机架
+		if nameNode.RackOf(placed[0]) == nameNode.RackOf(placed[1]) {
+			preferred = nameNode.dataNodesOnRack(remaining, nameNode.RackOf(placed[0]), false)
+		} else {
+			preferred = nameNode.dataNodesOnRack(remaining, nameNode.RackOf(placed[1]), true)
+		}
+	}
+	if len(preferred) == 0 {
+		preferred = remaining
+	}
+	// 满足机架要求的节点中，按剩余空间和负载加权选择
+	return nameNode.chooseWeightedDataNode(preferred)
+}
+
+// dataNodesOnRack 筛选在（onRack为false时不在）指定机架上的节点
+func (nameNode *Service) dataNodesOnRack(dataNodeIds []uint64, rack string, onRack bool) []uint64 {
+	return filterDataNodes(dataNodeIds, func(id uint64) bool {
+		return (nameNode.RackOf(id) == rack) == onRack
+	})
+}
+
+// filterDataNodes 筛选满足条件的节点
+func filterDataNodes(dataNodeIds []uint64, keep func(uint64) bool) (filtered []uint64) {
+	for _, id := range dataNodeIds {
+		if keep(id) {
+			filtered = append(filtered, id)
+		}
+	}
+	return
+}
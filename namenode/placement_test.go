@@ -0,0 +1,244 @@
+package namenode
+
+import (
+	"github.com/liuzongzhou/GoDFS/datanode"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newTopologyTestService 模拟两个机架的集群：dataNode 0-2在/rack1，3-5在/rack2，每个dataNode在不同的主机上
+func newTopologyTestService(t *testing.T) *Service {
+	testNameNodeService := newTestService()
+	testNameNodeService.ReplicationFactor = 3
+	topology, err := parseTopology(strings.NewReader(`
+# host rack
+host0 /rack1
+host1 /rack1
+host2:7000 /rack1
+host3 /rack2
+host4 /rack2
+client /rack2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testNameNodeService.topology = topology
+	for i := 0; i < 6; i++ {
+		testNameNodeService.IdToDataNodes[uint64(i)] = datanode.DataNodeInstance{Host: "host" + strconv.Itoa(i), ServicePort: "7000"}
+	}
+	//dataNode 5通过注册汇报机架位置
+	dn := testNameNodeService.IdToDataNodes[5]
+	dn.Rack = "/rack2"
+	testNameNodeService.IdToDataNodes[5] = dn
+	return testNameNodeService
+}
+
+// TestParseTopology 测试拓扑映射格式错误时报错
+func TestParseTopology(t *testing.T) {
+	if _, err := parseTopology(strings.NewReader("host0 /rack1 extra")); err == nil {
+		t.Errorf("Malformed topology should fail")
+	}
+}
+
+// TestNameNodeServiceRackOf 测试机架的查找顺序：汇报的机架、host:port、host、默认机架
+func TestNameNodeServiceRackOf(t *testing.T) {
+	testNameNodeService := newTopologyTestService(t)
+	testNameNodeService.IdToDataNodes[6] = datanode.DataNodeInstance{Host: "host6", ServicePort: "7000"}
+	expected := map[uint64]string{0: "/rack1", 2: "/rack1", 5: "/rack2", 6: defaultRack}
+	for id, rack := range expected {
+		if testNameNodeService.RackOf(id) != rack {
+			t.Errorf("Unable to resolve rack of DataNode %d: %s", id, testNameNodeService.RackOf(id))
+		}
+	}
+}
+
+// TestNameNodeServiceChooseTargetsWriterLocal 测试第一个副本在写入客户端所在的节点，第二个在其他机架，第三个与第二个同机架
+func TestNameNodeServiceChooseTargetsWriterLocal(t *testing.T) {
+	testNameNodeService := newTopologyTestService(t)
+	candidates := []uint64{0, 1, 2, 3, 4, 5}
+	for i := 0; i < 50; i++ {
+		targets := testNameNodeService.chooseTargets("host1", candidates, nil, 3)
+		if len(targets) != 3 || targets[0] != 1 {
+			t.Fatalf("First replica should be on writer's node: %v", targets)
+		}
+		if testNameNodeService.RackOf(targets[1]) != "/rack2" {
+			t.Fatalf("Second replica should be on a different rack: %v", targets)
+		}
+		if testNameNodeService.RackOf(targets[2]) != "/rack2" || targets[2] == targets[1] {
+			t.Fatalf("Third replica should be on the second replica's rack: %v", targets)
+		}
+	}
+}
+
+// TestNameNodeServiceChooseTargetsWriterRack 测试写入客户端不是dataNode时，第一个副本放在客户端所在的机架
+func TestNameNodeServiceChooseTargetsWriterRack(t *testing.T) {
+	testNameNodeService := newTopologyTestService(t)
+	candidates := []uint64{0, 1, 2, 3, 4, 5}
+	for i := 0; i < 50; i++ {
+		targets := testNameNodeService.chooseTargets("client", candidates, nil, 2)
+		if testNameNodeService.RackOf(targets[0]) != "/rack2" || testNameNodeService.RackOf(targets[1]) != "/rack1" {
+			t.Fatalf("Unable to place replicas by writer's rack: %v", targets)
+		}
+	}
+}
+
+// TestNameNodeServiceChooseTargetsSingleRack 测试只有一个机架时退化为随机选择不同的节点
+func TestNameNodeServiceChooseTargetsSingleRack(t *testing.T) {
+	testNameNodeService := newTopologyTestService(t)
+	targets := testNameNodeService.chooseTargets("unknown", []uint64{0, 1, 2}, nil, 3)
+	seen := make(map[uint64]bool)
+	for _, id := range targets {
+		seen[id] = true
+	}
+	if len(targets) != 3 || len(seen) != 3 {
+		t.Errorf("Unable to choose distinct targets on a single rack: %v", targets)
+	}
+}
+
+// TestNameNodeServiceChooseReplicationTargetsRackAware 测试已有副本都在同一机架时，新副本复制到其他机架
+func TestNameNodeServiceChooseReplicationTargetsRackAware(t *testing.T) {
+	testNameNodeService := newTopologyTestService(t)
+	testNameNodeService.BlockToDataNodeIds["0"] = []uint64{0, 1}
+	for i := 0; i < 50; i++ {
+		targets := testNameNodeService.chooseReplicationTargets("0")
+		if len(targets) != 1 || testNameNodeService.RackOf(targets[0]) != "/rack2" {
+			t.Fatalf("Replica should be placed on a different rack: %v", targets)
+		}
+	}
+}
+
+// TestNewBlockPlacementPolicy 测试按名称选择副本放置策略，以及注册自定义策略
+func TestNewBlockPlacementPolicy(t *testing.T) {
+	for _, name := range []string{RandomPlacementPolicy, RoundRobinPlacementPolicy, CapacityWeightedPlacementPolicy, RackAwarePlacementPolicy} {
+		if _, err := NewBlockPlacementPolicy(name); err != nil {
+			t.Errorf("Unable to create placement policy %s", name)
+		}
+	}
+	if _, err := NewBlockPlacementPolicy("unknown"); err == nil {
+		t.Errorf("Unknown placement policy should fail")
+	}
+	RegisterBlockPlacementPolicy("first", func() BlockPlacementPolicy { return firstPlacementPolicy{} })
+	policy, err := NewBlockPlacementPolicy("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testNameNodeService := newTopologyTestService(t)
+	testNameNodeService.SetPlacementPolicy(policy)
+	testNameNodeService.BlockToDataNodeIds["0"] = []uint64{0}
+	// 副本复制也使用设置的放置策略
+	targets := testNameNodeService.chooseReplicationTargets("0")
+	if len(targets) != 2 || targets[0] != 1 || targets[1] != 2 {
+		t.Errorf("Unable to use custom placement policy for re-replication: %v", targets)
+	}
+}
+
+// firstPlacementPolicy 按Id从小到大选择节点的自定义策略
+type firstPlacementPolicy struct{}
+
+func (firstPlacementPolicy) ChooseTargets(nameNode *Service, request PlacementRequest) []uint64 {
+	candidates := make([]uint64, len(request.Candidates))
+	copy(candidates, request.Candidates)
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i] < candidates[j] })
+	return candidates[:request.targetCount()]
+}
+
+// TestRandomPlacementPolicy 测试可用节点不够时尽量多选
+func TestRandomPlacementPolicy(t *testing.T) {
+	testNameNodeService := newTopologyTestService(t)
+	targets := randomPlacementPolicy{}.ChooseTargets(testNameNodeService, PlacementRequest{Candidates: []uint64{0, 1}, Count: 3})
+	if len(targets) != 2 || targets[0] == targets[1] {
+		t.Errorf("Unable to choose random targets: %v", targets)
+	}
+}
+
+// TestRoundRobinPlacementPolicy 测试按Id轮流选择节点，到末尾后从头开始
+func TestRoundRobinPlacementPolicy(t *testing.T) {
+	testNameNodeService := newTopologyTestService(t)
+	policy := &roundRobinPlacementPolicy{}
+	candidates := []uint64{3, 0, 2, 1}
+	expected := [][]uint64{{0, 1}, {2, 3}, {0, 1}}
+	for _, want := range expected {
+		targets := policy.ChooseTargets(testNameNodeService, PlacementRequest{Candidates: candidates, Count: 2})
+		if len(targets) != 2 || targets[0] != want[0] || targets[1] != want[1] {
+			t.Fatalf("Unable to choose targets round-robin: %v, want %v", targets, want)
+		}
+	}
+	// 上一次选到的节点不在候选中时，从下一个更大的Id开始
+	targets := policy.ChooseTargets(testNameNodeService, PlacementRequest{Candidates: []uint64{0, 3}, Count: 1})
+	if len(targets) != 1 || targets[0] != 3 {
+		t.Errorf("Unable to continue round-robin: %v", targets)
+	}
+}
+
+// TestCapacityWeightedPlacementPolicy 测试剩余空间多的节点被选中的次数更多
+func TestCapacityWeightedPlacementPolicy(t *testing.T) {
+	testNameNodeService := newTopologyTestService(t)
+	testNameNodeService.DataNodeStats[0] = datanode.DataNodeHeartbeat{Ack: true, Capacity: 10000, Remaining: 100}
+	testNameNodeService.DataNodeStats[1] = datanode.DataNodeHeartbeat{Ack: true, Capacity: 10000, Remaining: 10000}
+	counts := make(map[uint64]int)
+	for i := 0; i < 1000; i++ {
+		targets := capacityWeightedPlacementPolicy{}.ChooseTargets(testNameNodeService, PlacementRequest{Candidates: []uint64{0, 1}, Count: 1})
+		counts[targets[0]]++
+	}
+	if counts[1] <= counts[0]*10 {
+		t.Errorf("Node with more free space should be chosen more often: %v", counts)
+	}
+	targets := capacityWeightedPlacementPolicy{}.ChooseTargets(testNameNodeService, PlacementRequest{Candidates: []uint64{0, 1}, Count: 2})
+	if len(targets) != 2 || targets[0] == targets[1] {
+		t.Errorf("Unable to choose distinct targets: %v", targets)
+	}
+}
+
+// TestNameNodeServiceWritableDataNodes 测试剩余空间不足和负载过高的节点不参与选择
+func TestNameNodeServiceWritableDataNodes(t *testing.T) {
+	testNameNodeService := newTopologyTestService(t)
+	testNameNodeService.MinFreeSpace = 100
+	testNameNodeService.DataNodeStats[0] = datanode.DataNodeHeartbeat{Ack: true, Remaining: 50}
+	testNameNodeService.DataNodeStats[1] = datanode.DataNodeHeartbeat{Ack: true, Remaining: 1000, ActiveTransfers: 9}
+	testNameNodeService.DataNodeStats[2] = datanode.DataNodeHeartbeat{Ack: true, Remaining: 1000}
+	testNameNodeService.DataNodeStats[3] = datanode.DataNodeHeartbeat{Ack: true, Remaining: 1000, ActiveTransfers: 1}
+	//dataNode 4还没有收到心跳
+	writable := testNameNodeService.writableDataNodes([]uint64{0, 1, 2, 3, 4})
+	if len(writable) != 3 || writable[0] != 2 || writable[1] != 3 || writable[2] != 4 {
+		t.Errorf("Unable to exclude full and busy DataNodes: %v", writable)
+	}
+	// 只剩负载高的节点时仍然可以写入
+	writable = testNameNodeService.writableDataNodes([]uint64{0, 1})
+	if len(writable) != 1 || writable[0] != 1 {
+		t.Errorf("Busy DataNode should be used when no other DataNode is available: %v", writable)
+	}
+}
+
+// TestNameNodeServiceWriteFullDataNodes 测试写入时跳过已满的节点，所有节点都满时拒绝写入
+func TestNameNodeServiceWriteFullDataNodes(t *testing.T) {
+	testNameNodeService := NewService("9000", "localhost", 4, 2, 9000)
+	testNameNodeService.IdToDataNodes[0] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "1234"}
+	testNameNodeService.IdToDataNodes[1] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "4321"}
+	testNameNodeService.DataNodeStats[0] = datanode.DataNodeHeartbeat{Ack: true, Remaining: 0}
+	testNameNodeService.DataNodeStats[1] = datanode.DataNodeHeartbeat{Ack: true, Remaining: 8}
+
+	var reply []NameNodeMetaData
+	err := testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "Test1/", FileName: "foo", FileSize: 8}, &reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply) != 2 || len(reply[1].BlockAddresses) != 1 || reply[1].BlockAddresses[0].ServicePort != "4321" {
+		t.Errorf("Unable to skip full DataNodes: %v", reply)
+	}
+	// dataNode 1在下一次心跳之前已经没有剩余空间
+	reply = nil
+	err = testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "Test1/", FileName: "bar", FileSize: 4}, &reply)
+	if err == nil || len(reply) != 0 {
+		t.Errorf("Write should fail when all DataNodes are full")
+	}
+	testNameNodeService.DataNodeStats[1] = datanode.DataNodeHeartbeat{Ack: true, Remaining: 4}
+	err = testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "Test1/", FileName: "bar", FileSize: 8}, &reply)
+	if err == nil {
+		t.Errorf("Write should fail when DataNodes run out of space")
+	}
+	if _, ok := testNameNodeService.FileNameToBlocks["Test1/bar"]; ok {
+		t.Errorf("Unable to roll back metadata of failed write")
+	}
+}
@@ -0,0 +1,384 @@
+package namenode
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// quotaExceededPrefix 超出配额的错误信息的前缀
+const quotaExceededPrefix = "超出配额: "
+
+// Quota 目录的配额，为0时不限制
+type Quota struct {
+	NameQuota  uint64 //目录下文件和目录（包括目录自身）的最大数量
+	SpaceQuota uint64 //目录下文件占用的最大原始字节数，多副本文件按副本数计算，纠删码文件包括校验块
+}
+
+// ContentSummary 目录的用量和配额
+type ContentSummary struct {
+	Path           string
+	DirectoryCount uint64 //目录数，包括目录自身
+	FileCount      uint64
+	Length         uint64 //文件大小之和
+	SpaceConsumed  uint64 //文件占用的原始字节数
+	Quota
+}
+
+// NameNodeSetQuotaRequest 设置目录配额的请求，为0的配额保持不变
+type NameNodeSetQuotaRequest struct {
+	Path       string
+	NameQuota  uint64
+	SpaceQuota uint64
+	User       UserInfo
+}
+
+// NameNodeClearQuotaRequest 清除目录配额的请求，Name和Space都为false时清除两种配额
+type NameNodeClearQuotaRequest struct {
+	Path  string
+	Name  bool
+	Space bool
+	User  UserInfo
+}
+
+// quotaCharge 计入配额的名字数和原始空间
+type quotaCharge struct {
+	names uint64
+	space uint64
+}
+
+// quotaExceeded 超出配额的错误
+func quotaExceeded(directory string, kind string, quota uint64, used uint64, delta uint64) error {
+	return errors.New(quotaExceededPrefix + "目录" + directory + "的" + kind + "配额为" + strconv.FormatUint(quota, 10) +
+		"，已使用" + strconv.FormatUint(used, 10) + "，本次需要" + strconv.FormatUint(delta, 10))
+}
+
+// isUnder 判断路径是否在目录下，所有路径都在根目录下
+func isUnder(path string, directory string) bool {
+	return isRoot(directory) || strings.HasPrefix(path, directory)
+}
+
+// spaceConsumed 按存储方式计算文件占用的原始空间：多副本文件为大小乘以副本因子，
+// 纠删码文件为每个块组的分片大小乘以数据块和校验块的总数
+func (nameNode *Service) spaceConsumed(fileSize uint64, ecPolicy ErasureCodingPolicy, erasureCoded bool, replicationFactor uint64) uint64 {
+	if !erasureCoded {
+		return fileSize * replicationFactor
+	}
+	dataUnits := uint64(ecPolicy.DataUnits)
+	groupCapacity := nameNode.BlockSize * dataUnits
+	var space uint64
+	for offset := uint64(0); offset < fileSize; offset += groupCapacity {
+		groupSize := fileSize - offset
+		if groupSize > groupCapacity {
+			groupSize = groupCapacity
+		}
+		space += (groupSize + dataUnits - 1) / dataUnits * uint64(ecPolicy.totalUnits())
+	}
+	return space
+}
+
+// fileSpaceConsumed 已有文件占用的原始空间
+func (nameNode *Service) fileSpaceConsumed(fileName string) uint64 {
+	if policyName, ok := nameNode.FileNameToECPolicy[fileName]; ok {
+		return nameNode.spaceConsumed(nameNode.FileNameSize[fileName], erasureCodingPolicies[policyName], true, 0)
+	}
+	replicationFactor, ok := nameNode.FileNameToReplication[fileName]
+	if !ok {
+		replicationFactor = nameNode.ReplicationFactor
+	}
+	return nameNode.spaceConsumed(nameNode.FileNameSize[fileName], ErasureCodingPolicy{}, false, replicationFactor)
+}
+
+// conversionSpaceConsumed 正在转换的文件新布局占用的原始空间
+func (nameNode *Service) conversionSpaceConsumed(fileName string, conversion Conversion) uint64 {
+	return nameNode.spaceConsumed(nameNode.FileNameSize[fileName], erasureCodingPolicies[conversion.ECPolicy], conversion.ECPolicy != "", conversion.Replication)
+}
+
+// contentSummary 统计目录下的目录数、文件数和占用的空间
+func (nameNode *Service) contentSummary(directory string) ContentSummary {
+	summary := ContentSummary{Path: directory, Quota: nameNode.DirectoryToQuota[directory]}
+	directories := map[string]bool{directory: true}
+	for path := range nameNode.PathToPermission {
+		if strings.HasSuffix(path, "/") && isUnder(path, directory) {
+			directories[path] = true
+		}
+	}
+	for path := range nameNode.DirectoryToFileName {
+		if isUnder(path, directory) {
+			directories[path] = true
+		}
+	}
+	if isRoot(directory) {
+		delete(directories, "")
+		directories["/"] = true
+	}
+	summary.DirectoryCount = uint64(len(directories))
+	for fileName := range nameNode.FileNameToBlocks {
+		if isUnder(fileName, directory) {
+			summary.FileCount++
+			summary.Length += nameNode.FileNameSize[fileName]
+			summary.SpaceConsumed += nameNode.fileSpaceConsumed(fileName)
+		}
+	}
+	// 转换过程中新布局的块同样占用空间
+	for fileName, conversion := range nameNode.FileNameToConversion {
+		if isUnder(fileName, directory) {
+			summary.SpaceConsumed += nameNode.conversionSpaceConsumed(fileName, conversion)
+		}
+	}
+	return summary
+}
+
+// checkQuota 检查在path下新增names个文件或目录、space字节原始空间后，path所在的设置了配额的目录都不超出配额。
+// exclude不为空时跳过同样包含exclude的目录：重命名时源路径和目标路径共同的上级目录用量不变
+func (nameNode *Service) checkQuota(path string, names uint64, space uint64, exclude string) error {
+	if names == 0 && space == 0 {
+		return nil
+	}
+	for directory, quota := range nameNode.DirectoryToQuota {
+		if !isUnder(path, directory) || (exclude != "" && isUnder(exclude, directory)) {
+			continue
+		}
+		usage := nameNode.quotaUsageOf(directory)
+		if quota.NameQuota > 0 && names > 0 && usage.names+names > quota.NameQuota {
+			return quotaExceeded(directory, "名字", quota.NameQuota, usage.names, names)
+		}
+		if quota.SpaceQuota > 0 && space > 0 && usage.space+space > quota.SpaceQuota {
+			return quotaExceeded(directory, "空间", quota.SpaceQuota, usage.space, space)
+		}
+	}
+	return nil
+}
+
+// quotaUsageOf 设置了配额的目录当前的用量，还没有统计过的目录重建所有配额目录的用量
+func (nameNode *Service) quotaUsageOf(directory string) quotaCharge {
+	usage, ok := nameNode.quotaUsage[directory]
+	if !ok {
+		nameNode.rebuildQuotaUsage()
+		usage = nameNode.quotaUsage[directory]
+	}
+	return usage
+}
+
+// pathCharge 路径当前计入上级目录配额的用量：存在的文件和目录各占一个名字，文件按存储方式占用空间，转换中的文件加上新布局的空间
+func (nameNode *Service) pathCharge(path string) (charge quotaCharge) {
+	if strings.HasSuffix(path, "/") {
+		_, hasPermission := nameNode.PathToPermission[path]
+		_, hasFiles := nameNode.DirectoryToFileName[path]
+		if hasPermission || hasFiles {
+			charge.names = 1
+		}
+		return
+	}
+	if _, ok := nameNode.FileNameToBlocks[path]; ok {
+		charge.names = 1
+		charge.space = nameNode.fileSpaceConsumed(path)
+	}
+	if conversion, ok := nameNode.FileNameToConversion[path]; ok {
+		charge.space += nameNode.conversionSpaceConsumed(path, conversion)
+	}
+	return
+}
+
+// rechargePath 重新计算路径的用量，把和上次记录的差值加到包含该路径的配额目录上
+func (nameNode *Service) rechargePath(path string) {
+	if isRoot(path) {
+		return
+	}
+	charge := nameNode.pathCharge(path)
+	old := nameNode.pathCharges[path]
+	if charge == old {
+		return
+	}
+	for directory, usage := range nameNode.quotaUsage {
+		if path == directory || !isUnder(path, directory) {
+			continue
+		}
+		usage.names = usage.names - old.names + charge.names
+		usage.space = usage.space - old.space + charge.space
+		nameNode.quotaUsage[directory] = usage
+	}
+	if charge == (quotaCharge{}) {
+		delete(nameNode.pathCharges, path)
+	} else {
+		nameNode.pathCharges[path] = charge
+	}
+}
+
+// chargeQuota 文件或者目录新建、修改、删除后更新配额用量，上级目录可能一起创建，逐级向上更新；没有配额时不统计
+func (nameNode *Service) chargeQuota(path string) {
+	if nameNode.quotaUsage == nil {
+		return
+	}
+	for ; !isRoot(path); path = parentDirectory(path) {
+		nameNode.rechargePath(path)
+	}
+}
+
+// rebuildQuotaUsage 扫描整个命名空间重新统计所有配额目录的用量，设置新的配额、加载元数据以及移动或者删除整个目录树后调用
+func (nameNode *Service) rebuildQuotaUsage() {
+	nameNode.quotaUsage, nameNode.pathCharges = nil, nil
+	if len(nameNode.DirectoryToQuota) == 0 {
+		return
+	}
+	nameNode.quotaUsage = make(map[string]quotaCharge)
+	nameNode.pathCharges = make(map[string]quotaCharge)
+	for directory := range nameNode.DirectoryToQuota {
+		//目录自身占用一个名字
+		nameNode.quotaUsage[directory] = quotaCharge{names: 1}
+	}
+	paths := make(map[string]bool)
+	for path := range nameNode.PathToPermission {
+		paths[path] = true
+	}
+	for path := range nameNode.DirectoryToFileName {
+		paths[path] = true
+	}
+	for path := range nameNode.FileNameToBlocks {
+		paths[path] = true
+	}
+	for path := range nameNode.FileNameToConversion {
+		paths[path] = true
+	}
+	for path := range paths {
+		nameNode.rechargePath(path)
+	}
+}
+
+// checkWriteQuota 检查写入文件不会超出配额：新建文件时文件和需要一起创建的上级目录占用名字配额，
+// 覆盖已有的文件时只计算增加的空间
+func (nameNode *Service) checkWriteQuota(fileName string, fileSize uint64, ecPolicy ErasureCodingPolicy, erasureCoded bool, replicationFactor uint64) error {
+	var names uint64
+	space := nameNode.spaceConsumed(fileSize, ecPolicy, erasureCoded, replicationFactor)
+	if _, ok := nameNode.FileNameToBlocks[fileName]; ok {
+		if oldSpace := nameNode.fileSpaceConsumed(fileName); space > oldSpace {
+			space -= oldSpace
+		} else {
+			space = 0
+		}
+	} else {
+		names = nameNode.missingDirectories(fileName) + 1
+	}
+	return nameNode.checkQuota(fileName, names, space, "")
+}
+
+// missingDirectories 创建path时需要一起创建的上级目录数
+func (nameNode *Service) missingDirectories(path string) (missing uint64) {
+	for parent := parentDirectory(path); !isRoot(parent); parent = parentDirectory(parent) {
+		if _, ok := nameNode.PathToPermission[parent]; ok {
+			break
+		}
+		missing++
+	}
+	return
+}
+
+// checkRenameQuota 检查把源路径移动到目标路径后，目标路径所在而源路径不在的设置了配额的目录不超出配额
+func (nameNode *Service) checkRenameQuota(srcPath string, destPath string) error {
+	if !strings.HasSuffix(srcPath, "/") {
+		return nameNode.checkQuota(destPath, 1, nameNode.fileSpaceConsumed(srcPath), srcPath)
+	}
+	summary := nameNode.contentSummary(srcPath)
+	return nameNode.checkQuota(destPath, summary.DirectoryCount+summary.FileCount, summary.SpaceConsumed, srcPath)
+}
+
+// SetQuota 设置目录的名字配额和空间配额，只有超级用户可以设置，配额可以小于当前用量，之后新增的文件和目录会超出配额
+func (nameNode *Service) SetQuota(request *NameNodeSetQuotaRequest, reply *bool) error {
+	nameNode.lock()
+	defer nameNode.unlock()
+	if !nameNode.isPrimary() {
+		return errors.New("当前nameNode不是主节点")
+	}
+	if err := nameNode.checkNotInSafeMode(); err != nil {
+		return err
+	}
+	path := request.Path
+	if isRoot(path) {
+		path = "/"
+	}
+	if !nameNode.isSuperUser(request.User) {
+		return permissionDenied(request.User, path)
+	}
+	if !strings.HasSuffix(path, "/") {
+		return errors.New("只能为以/结尾的目录设置配额")
+	}
+	if !nameNode.pathExists(path) {
+		return errors.New("目录不存在")
+	}
+	if request.NameQuota == 0 && request.SpaceQuota == 0 {
+		return errors.New("没有指定名字配额或者空间配额")
+	}
+	quota := nameNode.DirectoryToQuota[path]
+	if request.NameQuota > 0 {
+		quota.NameQuota = request.NameQuota
+	}
+	if request.SpaceQuota > 0 {
+		quota.SpaceQuota = request.SpaceQuota
+	}
+	nameNode.DirectoryToQuota[path] = quota
+	if _, ok := nameNode.quotaUsage[path]; !ok {
+		nameNode.rebuildQuotaUsage()
+	}
+	*reply = true
+	return nil
+}
+
+// ClearQuota 清除目录的名字配额或者空间配额，只有超级用户可以清除
+func (nameNode *Service) ClearQuota(request *NameNodeClearQuotaRequest, reply *bool) error {
+	nameNode.lock()
+	defer nameNode.unlock()
+	if !nameNode.isPrimary() {
+		return errors.New("当前nameNode不是主节点")
+	}
+	if err := nameNode.checkNotInSafeMode(); err != nil {
+		return err
+	}
+	path := request.Path
+	if isRoot(path) {
+		path = "/"
+	}
+	if !nameNode.isSuperUser(request.User) {
+		return permissionDenied(request.User, path)
+	}
+	quota, ok := nameNode.DirectoryToQuota[path]
+	if !ok {
+		*reply = true
+		return nil
+	}
+	clearAll := !request.Name && !request.Space
+	if clearAll || request.Name {
+		quota.NameQuota = 0
+	}
+	if clearAll || request.Space {
+		quota.SpaceQuota = 0
+	}
+	if quota == (Quota{}) {
+		delete(nameNode.DirectoryToQuota, path)
+		delete(nameNode.quotaUsage, path)
+		if len(nameNode.DirectoryToQuota) == 0 {
+			nameNode.quotaUsage, nameNode.pathCharges = nil, nil
+		}
+	} else {
+		nameNode.DirectoryToQuota[path] = quota
+	}
+	*reply = true
+	return nil
+}
+
+// GetContentSummary 获取目录的目录数、文件数、占用的空间和配额，request为以/结尾的目录
+func (nameNode *Service) GetContentSummary(request string, reply *ContentSummary) error {
+	nameNode.rLock()
+	defer nameNode.rUnlock()
+	path := request
+	if isRoot(path) {
+		path = "/"
+	}
+	if !strings.HasSuffix(path, "/") {
+		return errors.New("只能统计以/结尾的目录")
+	}
+	if !nameNode.pathExists(path) {
+		return errors.New("目录不存在")
+	}
+	*reply = nameNode.contentSummary(path)
+	return nil
+}
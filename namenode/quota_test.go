@@ -0,0 +1,171 @@
+package namenode
+
+import (
+	"github.com/liuzongzhou/GoDFS/datanode"
+	"github.com/liuzongzhou/GoDFS/util"
+	"strings"
+	"testing"
+)
+
+// TestNameNodeServiceNameQuota 测试名字配额限制目录下文件和目录的数量，目录自身也计入配额
+func TestNameNodeServiceNameQuota(t *testing.T) {
+	testNameNodeService := newTestService("1234", "4321")
+	root := UserInfo{Name: "root"}
+	var ok bool
+	util.Check(testNameNodeService.Mkdir(&NameNodeMkdirRequest{RemoteFilePath: "/quota/", User: root}, &ok))
+	if err := testNameNodeService.SetQuota(&NameNodeSetQuotaRequest{Path: "/quota/", NameQuota: 3, User: UserInfo{Name: "alice"}}, &ok); err == nil {
+		t.Errorf("Only super user should set quota")
+	}
+	if err := testNameNodeService.SetQuota(&NameNodeSetQuotaRequest{Path: "/missing/", NameQuota: 3, User: root}, &ok); err == nil {
+		t.Errorf("Missing directory should be rejected")
+	}
+	util.Check(testNameNodeService.SetQuota(&NameNodeSetQuotaRequest{Path: "/quota/", NameQuota: 3, User: root}, &ok))
+
+	var metadata []NameNodeMetaData
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/quota/", FileName: "foo", FileSize: 8, User: root}, &metadata))
+	util.Check(testNameNodeService.Mkdir(&NameNodeMkdirRequest{RemoteFilePath: "/quota/dir/", User: root}, &ok))
+	err := testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/quota/", FileName: "bar", FileSize: 8, User: root}, &metadata)
+	if err == nil || !strings.HasPrefix(err.Error(), quotaExceededPrefix) {
+		t.Errorf("File exceeding name quota should be rejected: %v", err)
+	}
+	if err := testNameNodeService.Mkdir(&NameNodeMkdirRequest{RemoteFilePath: "/quota/dir2/", User: root}, &ok); err == nil {
+		t.Errorf("Directory exceeding name quota should be rejected")
+	}
+	// 覆盖已有的文件不占用新的名字配额
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/quota/", FileName: "foo", FileSize: 4, User: root}, &metadata))
+
+	var summary ContentSummary
+	util.Check(testNameNodeService.GetContentSummary("/quota/", &summary))
+	if summary.DirectoryCount != 2 || summary.FileCount != 1 || summary.Length != 4 || summary.SpaceConsumed != 8 || summary.NameQuota != 3 {
+		t.Errorf("Unable to get content summary: %+v", summary)
+	}
+
+	util.Check(testNameNodeService.ClearQuota(&NameNodeClearQuotaRequest{Path: "/quota/", Name: true, User: root}, &ok))
+	if _, ok := testNameNodeService.DirectoryToQuota["/quota/"]; ok {
+		t.Errorf("Unable to clear quota: %+v", testNameNodeService.DirectoryToQuota)
+	}
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/quota/", FileName: "bar", FileSize: 8, User: root}, &metadata))
+}
+
+// TestNameNodeServiceSpaceQuota 测试空间配额按副本数计算，覆盖文件只计算增加的空间，提高副本因子也受空间配额限制
+func TestNameNodeServiceSpaceQuota(t *testing.T) {
+	testNameNodeService := newTestService("1234", "4321")
+	root := UserInfo{Name: "root"}
+	var ok bool
+	util.Check(testNameNodeService.Mkdir(&NameNodeMkdirRequest{RemoteFilePath: "/quota/", User: root}, &ok))
+	util.Check(testNameNodeService.SetQuota(&NameNodeSetQuotaRequest{Path: "/quota/", SpaceQuota: 40, User: root}, &ok))
+
+	var metadata []NameNodeMetaData
+	// 副本因子为2，8字节的文件占用16字节
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/quota/", FileName: "foo", FileSize: 8, User: root}, &metadata))
+	if err := testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/quota/sub/", FileName: "bar", FileSize: 16, User: root}, &metadata); err == nil {
+		t.Errorf("File exceeding space quota should be rejected")
+	}
+	if _, ok := testNameNodeService.FileNameToBlocks["/quota/sub/bar"]; ok {
+		t.Errorf("Rejected file should not be created")
+	}
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/quota/", FileName: "foo", FileSize: 20, User: root}, &metadata))
+	if err := testNameNodeService.SetReplication(&NameNodeSetReplicationRequest{RemoteFilePath: "/quota/", FileName: "foo", ReplicationFactor: 3, User: root}, &ok); err == nil {
+		t.Errorf("Replication exceeding space quota should be rejected")
+	}
+	if testNameNodeService.FileNameToReplication["/quota/foo"] == 3 {
+		t.Errorf("Rejected replication factor should not be applied")
+	}
+	util.Check(testNameNodeService.SetReplication(&NameNodeSetReplicationRequest{RemoteFilePath: "/quota/", FileName: "foo", ReplicationFactor: 1, User: root}, &ok))
+
+	var summary ContentSummary
+	util.Check(testNameNodeService.GetContentSummary("/", &summary))
+	if summary.FileCount != 1 || summary.SpaceConsumed != 20 {
+		t.Errorf("Unable to get content summary of root: %+v", summary)
+	}
+}
+
+// TestNameNodeServiceQuotaRename 测试移入设置了配额的目录时检查配额，目录整体重命名时迁移配额
+func TestNameNodeServiceQuotaRename(t *testing.T) {
+	testNameNodeService := newTestService("1234", "4321")
+	root := UserInfo{Name: "root"}
+	var ok bool
+	util.Check(testNameNodeService.Mkdir(&NameNodeMkdirRequest{RemoteFilePath: "/quota/", User: root}, &ok))
+	util.Check(testNameNodeService.SetQuota(&NameNodeSetQuotaRequest{Path: "/quota/", NameQuota: 2, SpaceQuota: 20, User: root}, &ok))
+	var metadata []NameNodeMetaData
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/quota/", FileName: "foo", FileSize: 8, User: root}, &metadata))
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/plain/", FileName: "bar", FileSize: 8, User: root}, &metadata))
+
+	if err := testNameNodeService.ReNameFile(&NameNodeReNameFileRequest{ReNameSrcFileName: "/plain/bar", ReNameDestFileName: "/quota/bar", User: root}, &ok); err == nil {
+		t.Errorf("Rename exceeding quota should be rejected")
+	}
+	// 在同一个配额目录内重命名不改变用量
+	util.Check(testNameNodeService.ReNameFile(&NameNodeReNameFileRequest{ReNameSrcFileName: "/quota/foo", ReNameDestFileName: "/quota/baz", User: root}, &ok))
+
+	var dataNodes []datanode.DataNodeInstance
+	util.Check(testNameNodeService.ReName(&NameNodeReNameRequest{ReNameSrcPath: "/quota/", ReNameDestPath: "/moved/", User: root}, &dataNodes))
+	if quota, ok := testNameNodeService.DirectoryToQuota["/moved/"]; !ok || quota.NameQuota != 2 {
+		t.Errorf("Unable to rename quota: %+v", testNameNodeService.DirectoryToQuota)
+	}
+	if _, ok := testNameNodeService.DirectoryToQuota["/quota/"]; ok {
+		t.Errorf("Quota should be removed from the old path")
+	}
+}
+
+// TestNameNodeServiceErasureCodedQuota 测试纠删码文件的空间包括校验块
+func TestNameNodeServiceErasureCodedQuota(t *testing.T) {
+	testNameNodeService := newErasureCodingTestService()
+	testNameNodeService.SuperUser = "root"
+	root := UserInfo{Name: "root"}
+	var ok bool
+	var metadata []NameNodeMetaData
+	// RS-3-2，块大小为4：第一个块组12字节占用5个4字节的内部块，剩余8字节占用5个3字节的内部块
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/Test1/", FileName: "foo", FileSize: 20, User: root}, &metadata))
+	var summary ContentSummary
+	util.Check(testNameNodeService.GetContentSummary("/Test1/", &summary))
+	if summary.Length != 20 || summary.SpaceConsumed != 35 {
+		t.Errorf("Unable to get erasure coded space: %+v", summary)
+	}
+	util.Check(testNameNodeService.SetQuota(&NameNodeSetQuotaRequest{Path: "/Test1/", SpaceQuota: 40, User: root}, &ok))
+	if err := testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/Test1/", FileName: "bar", FileSize: 4, User: root}, &metadata); err == nil {
+		t.Errorf("Erasure coded file exceeding space quota should be rejected")
+	}
+}
+
+// TestNameNodeServiceQuotaUsage 测试写入、创建目录、修改副本因子、重命名、转换和删除后，增量维护的配额用量与重新统计的结果一致
+func TestNameNodeServiceQuotaUsage(t *testing.T) {
+	testNameNodeService := newErasureCodingTestService()
+	testNameNodeService.SuperUser = "root"
+	root := UserInfo{Name: "root"}
+	var ok bool
+	var metadata []NameNodeMetaData
+	util.Check(testNameNodeService.Mkdir(&NameNodeMkdirRequest{RemoteFilePath: "/quota/", User: root}, &ok))
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/quota/", FileName: "foo", FileSize: 8, User: root}, &metadata))
+	util.Check(testNameNodeService.SetQuota(&NameNodeSetQuotaRequest{Path: "/quota/", NameQuota: 100, SpaceQuota: 1000, User: root}, &ok))
+	util.Check(testNameNodeService.SetQuota(&NameNodeSetQuotaRequest{Path: "/", NameQuota: 100, User: root}, &ok))
+	checkUsage := func(step string) {
+		for directory := range testNameNodeService.DirectoryToQuota {
+			summary := testNameNodeService.contentSummary(directory)
+			usage := testNameNodeService.quotaUsage[directory]
+			if usage.names != summary.DirectoryCount+summary.FileCount || usage.space != summary.SpaceConsumed {
+				t.Errorf("%s: usage of %s is %+v, want %+v", step, directory, usage, summary)
+			}
+		}
+	}
+	checkUsage("set quota")
+
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/quota/sub/dir/", FileName: "bar", FileSize: 6, User: root}, &metadata))
+	util.Check(testNameNodeService.Mkdir(&NameNodeMkdirRequest{RemoteFilePath: "/quota/empty/", User: root}, &ok))
+	checkUsage("write")
+	util.Check(testNameNodeService.SetReplication(&NameNodeSetReplicationRequest{RemoteFilePath: "/quota/", FileName: "foo", ReplicationFactor: 3, User: root}, &ok))
+	checkUsage("setrep")
+	var converted []NameNodeMetaData
+	util.Check(testNameNodeService.ConvertFile(&NameNodeConvertRequest{RemoteFilePath: "/quota/", FileName: "foo", Policy: "RS-3-2", User: root}, &converted))
+	checkUsage("convert")
+	util.Check(testNameNodeService.CommitConversion(&NameNodeConvertRequest{RemoteFilePath: "/quota/", FileName: "foo", User: root}, &ok))
+	checkUsage("commit")
+	util.Check(testNameNodeService.ReNameFile(&NameNodeReNameFileRequest{ReNameSrcFileName: "/quota/foo", ReNameDestFileName: "/other/foo", User: root}, &ok))
+	checkUsage("rename file")
+	var dataNodes []datanode.DataNodeInstance
+	util.Check(testNameNodeService.ReName(&NameNodeReNameRequest{ReNameSrcPath: "/quota/sub/", ReNameDestPath: "/quota/moved/", User: root}, &dataNodes))
+	checkUsage("rename directory")
+	util.Check(testNameNodeService.DeleteFileNameMetaData(&NameNodeDeleteRequest{RemoteFilePath: "/quota/moved/dir/", FileName: "bar", User: root}, &ok))
+	checkUsage("delete file")
+	util.Check(testNameNodeService.DeleteMetaData(&NameNodeDeleteRequest{RemoteFilePath: "/quota/moved/", User: root}, &ok))
+	checkUsage("delete directory")
+}
@@ -0,0 +1,410 @@
+package namenode
+
+import (
+	"errors"
+	"github.com/liuzongzhou/GoDFS/auth"
+	"github.com/liuzongzhou/GoDFS/datanode"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 待复制块的优先级，数值越小越优先处理
+const (
+	priorityHighest         = iota //只剩1个副本，再丢一个节点数据就丢失了
+	priorityUnderReplicated        //副本数低于副本因子
+	priorityLevels
+)
+
+// replicationInterval 副本复制监控的调度间隔
+const replicationInterval = time.Second * 1
+
+// defaultMaxReplicationStreams 默认同时进行的最大副本复制数
+const defaultMaxReplicationStreams = 2
+
+// pendingReplicationTimeout 复制命令发出后等待目标节点汇报的超时时间，超时后重新复制
+const pendingReplicationTimeout = time.Second * 30
+
+// underReplicatedQueue 按优先级排列的待复制块队列
+type underReplicatedQueue struct {
+	mu       sync.Mutex
+	levels   [priorityLevels][]string
+	queued   map[string]bool                //已经在队列中的块，防止重复入队
+	inFlight map[string]*pendingReplication //正在复制中的块
+}
+
+// pendingReplication 已经发出复制命令、等待目标节点汇报的复制
+type pendingReplication struct {
+	targetDataNodeIds []uint64
+	deadline          time.Time
+}
+
+// replicationResult 发送复制命令的结果
+type replicationResult struct {
+	blockId string
+	err     error
+}
+
+func newUnderReplicatedQueue() *underReplicatedQueue {
+	return &underReplicatedQueue{
+		queued:   make(map[string]bool),
+		inFlight: make(map[string]*pendingReplication),
+	}
+}
+
+// add 将块按优先级加入队列，已在队列中或者正在复制中的块不重复加入
+func (queue *underReplicatedQueue) add(blockId string, priority int) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	if _, ok := queue.inFlight[blockId]; ok || queue.queued[blockId] {
+		return
+	}
+	queue.queued[blockId] = true
+	queue.levels[priority] = append(queue.levels[priority], blockId)
+}
+
+// poll 取出优先级最高的块，调用方需要通过start标记为正在复制或者通过done放弃
+func (queue *underReplicatedQueue) poll() (string, bool) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	for priority := range queue.levels {
+		if len(queue.levels[priority]) == 0 {
+			continue
+		}
+		blockId := queue.levels[priority][0]
+		queue.levels[priority] = queue.levels[priority][1:]
+		delete(queue.queued, blockId)
+		queue.inFlight[blockId] = &pendingReplication{}
+		return blockId, true
+	}
+	return "", false
+}
+
+// start 记录复制命令的目标节点，等待目标节点汇报
+func (queue *underReplicatedQueue) start(blockId string, targetDataNodeIds []uint64) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	queue.inFlight[blockId] = &pendingReplication{
+		targetDataNodeIds: targetDataNodeIds,
+		deadline:          time.Now().Add(pendingReplicationTimeout),
+	}
+}
+
+// received 目标节点汇报收到了副本，所有目标节点都汇报后复制结束
+func (queue *underReplicatedQueue) received(blockId string, dataNodeId uint64) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	pending, ok := queue.inFlight[blockId]
+	if !ok {
+		return
+	}
+	pending.targetDataNodeIds = removeDataNodeId(pending.targetDataNodeIds, dataNodeId)
+	if len(pending.targetDataNodeIds) == 0 {
+		delete(queue.inFlight, blockId)
+	}
+}
+
+// done 块复制结束，不论成功与否
+func (queue *underReplicatedQueue) done(blockId string) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	delete(queue.inFlight, blockId)
+}
+
+// timedOut 移除等待汇报超时的复制，返回超时的块
+func (queue *underReplicatedQueue) timedOut(now time.Time) (blockIds []string) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	for blockId, pending := range queue.inFlight {
+		if !pending.deadline.IsZero() && now.After(pending.deadline) {
+			delete(queue.inFlight, blockId)
+			blockIds = append(blockIds, blockId)
+		}
+	}
+	return
+}
+
+// inFlightCount 正在复制中的块数
+func (queue *underReplicatedQueue) inFlightCount() int {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	return len(queue.inFlight)
+}
+
+// IsPrimary 判断当前nameNode节点是否是主节点
+func (nameNode *Service) IsPrimary() bool {
+	nameNode.rLock()
+	defer nameNode.rUnlock()
+	return nameNode.isPrimary()
+}
+
+// isPrimary 判断当前nameNode节点是否是主节点，调用方持有命名空间锁
+func (nameNode *Service) isPrimary() bool {
+	return nameNode.PrimaryPort == strconv.FormatUint(uint64(nameNode.Port), 10)
+}
+
+// ReplicationMonitor 后台副本复制监控，持续从待复制块队列中按优先级取出块，向持有健康副本的源dataNode发送复制命令，
+// 由源dataNode直接把块传输给目标dataNode。同时进行中的复制数不超过MaxReplicationStreams，
+// 发送命令失败或者目标节点汇报超时的块重新入队等待下一轮重试
+func (nameNode *Service) ReplicationMonitor() {
+	results := make(chan replicationResult)
+	ticker := time.NewTicker(replicationInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case result := <-results:
+			nameNode.lock()
+			nameNode.finishReplication(result)
+			nameNode.unlock()
+		case now := <-ticker.C:
+			nameNode.lock()
+			nameNode.monitorReplication(now, results)
+			nameNode.unlock()
+		}
+	}
+}
+
+// monitorReplication 副本复制监控的一轮检查，调用方持有命名空间锁
+func (nameNode *Service) monitorReplication(now time.Time, results chan<- replicationResult) {
+	//备份nameNode不做副本复制，元数据从主节点同步；安全模式下块还没有全部汇报上来，也不做副本复制
+	if !nameNode.isPrimary() {
+		return
+	}
+	nameNode.checkSafeMode()
+	if nameNode.inSafeMode() {
+		return
+	}
+	for _, blockId := range nameNode.replicationQueue.timedOut(now) {
+		log.Printf("Block %s replication timed out, retrying\n", blockId)
+		nameNode.checkReplication(blockId)
+	}
+	nameNode.checkAdminStates(now)
+	nameNode.expireConversions(now)
+	nameNode.expireMoves(now)
+	nameNode.scheduleReplication(results)
+}
+
+// scheduleReplication 在并发数限制内，为队列中的块选择源节点和目标节点，发送复制命令
+func (nameNode *Service) scheduleReplication(results chan<- replicationResult) {
+	for nameNode.replicationQueue.inFlightCount() < nameNode.MaxReplicationStreams {
+		blockId, ok := nameNode.replicationQueue.poll()
+		if !ok {
+			return
+		}
+		//文件可能已经被删除
+		if _, exists := nameNode.BlockToGenerationStamp[blockId]; !exists {
+			nameNode.replicationQueue.done(blockId)
+			continue
+		}
+		//纠删码的内部块丢失后没有副本可以复制，由其他内部块解码重建
+		if groupId, index, ok := nameNode.blockGroupOf(blockId); ok && len(nameNode.liveReplicas(blockId)) == 0 {
+			nameNode.scheduleReconstruction(blockId, groupId, index, results)
+			continue
+		}
+		sourceId, ok := nameNode.chooseReplicationSource(blockId)
+		if !ok {
+			log.Printf("Block %s has no healthy replica left, unable to replicate\n", blockId)
+			nameNode.replicationQueue.done(blockId)
+			continue
+		}
+		targetDataNodeIds := nameNode.chooseReplicationTargets(blockId)
+		//没有可用的目标节点，等新的dataNode加入后再重新检查
+		if len(targetDataNodeIds) == 0 {
+			log.Printf("No DataNode available to replicate block %s\n", blockId)
+			nameNode.replicationQueue.done(blockId)
+			continue
+		}
+		source := nameNode.IdToDataNodes[sourceId]
+		request := datanode.DataNodeReplicateRequest{
+			RemoteFilePath:  nameNode.blockRemoteFilePath(blockId),
+			BlockId:         blockId,
+			GenerationStamp: nameNode.BlockToGenerationStamp[blockId],
+		}
+		for _, dataNodeId := range targetDataNodeIds {
+			request.Targets = append(request.Targets, nameNode.IdToDataNodes[dataNodeId])
+		}
+		nameNode.replicationQueue.start(blockId, targetDataNodeIds)
+		go func() {
+			err := sendReplicateCommand(source, request)
+			results <- replicationResult{blockId: blockId, err: err}
+		}()
+	}
+}
+
+// finishReplication 处理发送复制命令的结果，失败则重新入队重试，成功则等待目标节点通过BlockReceived汇报
+func (nameNode *Service) finishReplication(result replicationResult) {
+	if result.err == nil {
+		return
+	}
+	log.Printf("Block %s replication failed: %v, retrying\n", result.blockId, result.err)
+	nameNode.replicationQueue.done(result.blockId)
+	nameNode.checkReplication(result.blockId)
+}
+
+// checkReplication 检查块的副本数，低于副本因子则按优先级加入待复制队列，下线中的节点上的副本不计入副本数，但可以作为复制的源。
+// 纠删码的内部块没有副本时也加入队列，由其他内部块解码重建
+func (nameNode *Service) checkReplication(blockId string) {
+	groupId, _, internal := nameNode.blockGroupOf(blockId)
+	if len(nameNode.BlockToDataNodeIds[blockId]) == 0 && !internal {
+		return
+	}
+	live := len(nameNode.liveReplicas(blockId))
+	if live >= int(nameNode.expectedReplication(blockId)) {
+		return
+	}
+	priority := priorityUnderReplicated
+	if internal {
+		priority = nameNode.internalBlockPriority(groupId)
+	} else if live <= 1 {
+		priority = priorityHighest
+	}
+	nameNode.replicationQueue.add(blockId, priority)
+}
+
+// checkAllReplication 检查所有块的副本数，新的dataNode加入时调用，之前没有目标节点可用的块可以继续复制
+func (nameNode *Service) checkAllReplication() {
+	for blockId := range nameNode.BlockToDataNodeIds {
+		nameNode.checkReplication(blockId)
+	}
+}
+
+// expectedReplication 块期望的副本数，即块所属文件或者转换的副本因子，纠删码的内部块只有一个副本
+func (nameNode *Service) expectedReplication(blockId string) uint64 {
+	if _, _, ok := nameNode.blockGroupOf(blockId); ok {
+		return 1
+	}
+	if fileName, ok := nameNode.blockFileName(blockId); ok {
+		if replication, ok := nameNode.FileNameToReplication[fileName]; ok {
+			return replication
+		}
+	} else if _, conversion, ok := nameNode.conversionOf(blockId); ok {
+		return conversion.Replication
+	}
+	return nameNode.ReplicationFactor
+}
+
+// directoryReplication 目录下新文件默认的副本因子，逐级向上查找最近的设置了默认副本因子的目录，
+// 都没有设置时使用nameNode的副本因子
+func (nameNode *Service) directoryReplication(remoteFilePath string) uint64 {
+	for directory := remoteFilePath; directory != ""; directory = parentDirectory(directory) {
+		if replication, ok := nameNode.DirectoryToReplication[directory]; ok {
+			return replication
+		}
+	}
+	return nameNode.ReplicationFactor
+}
+
+// parentDirectory 得到目录的上一级目录，"/a/b/"的上一级是"/a/"，"/"没有上一级
+func parentDirectory(directory string) string {
+	trimmed := strings.TrimSuffix(directory, "/")
+	return trimmed[:strings.LastIndex(trimmed, "/")+1]
+}
+
+// SetReplication 修改文件的副本因子，FileName为空时设置目录的默认副本因子，并应用到目录下已有的文件。
+// 副本数不足的块加入待复制队列，多余的副本直接删除
+func (nameNode *Service) SetReplication(request *NameNodeSetReplicationRequest, reply *bool) error {
+	nameNode.lock()
+	defer nameNode.unlock()
+	if !nameNode.isPrimary() {
+		return errors.New("当前nameNode不是主节点")
+	}
+	if err := nameNode.checkNotInSafeMode(); err != nil {
+		return err
+	}
+	if request.ReplicationFactor == 0 {
+		return errors.New("副本因子必须大于0")
+	}
+	var fileNames []string
+	if request.FileName == "" {
+		if _, ok := nameNode.DirectoryToFileName[request.RemoteFilePath]; !ok {
+			return errors.New("目录不存在")
+		}
+		for _, fileName := range nameNode.DirectoryToFileName[request.RemoteFilePath] {
+			//目录下的纠删码文件不受副本因子影响
+			if _, ok := nameNode.FileNameToECPolicy[request.RemoteFilePath+fileName]; !ok {
+				fileNames = append(fileNames, request.RemoteFilePath+fileName)
+			}
+		}
+	} else {
+		if _, ok := nameNode.FileNameToBlocks[request.RemoteFilePath+request.FileName]; !ok {
+			return errors.New("文件不存在")
+		}
+		if _, ok := nameNode.FileNameToECPolicy[request.RemoteFilePath+request.FileName]; ok {
+			return errors.New("纠删码文件不支持修改副本因子")
+		}
+		fileNames = []string{request.RemoteFilePath + request.FileName}
+	}
+	// 修改文件或者目录的副本因子需要写权限
+	if err := nameNode.checkPermission(request.User, request.RemoteFilePath+request.FileName, ActionWrite); err != nil {
+		return err
+	}
+	// 提高副本因子时检查增加的空间不超出空间配额
+	var space uint64
+	for _, fileName := range fileNames {
+		newSpace := nameNode.FileNameSize[fileName] * request.ReplicationFactor
+		if oldSpace := nameNode.fileSpaceConsumed(fileName); newSpace > oldSpace {
+			space += newSpace - oldSpace
+		}
+	}
+	if err := nameNode.checkQuota(request.RemoteFilePath+request.FileName, 0, space, ""); err != nil {
+		return err
+	}
+	if request.FileName == "" {
+		nameNode.DirectoryToReplication[request.RemoteFilePath] = request.ReplicationFactor
+	}
+	for _, fileName := range fileNames {
+		nameNode.FileNameToReplication[fileName] = request.ReplicationFactor
+		nameNode.chargeQuota(fileName)
+		for _, blockId := range nameNode.FileNameToBlocks[fileName] {
+			nameNode.removeExcessReplicas(blockId)
+			nameNode.checkReplication(blockId)
+		}
+	}
+	*reply = true
+	return nil
+}
+
+// chooseReplicationTargets 为块选择复制的目标节点，目标节点不能已经存有该块的副本，通过副本放置策略结合已有副本选择，
+// 可用节点不够时尽量多复制，不再因为节点数少于副本因子而放弃
+func (nameNode *Service) chooseReplicationTargets(blockId string) []uint64 {
+	dataNodeIds := nameNode.BlockToDataNodeIds[blockId]
+	//纠删码的内部块不能放到同一块组其他内部块所在的节点上
+	if groupId, _, ok := nameNode.blockGroupOf(blockId); ok {
+		dataNodeIds = nameNode.groupDataNodeIds(groupId)
+	}
+	var availableNodes []uint64
+	for id := range nameNode.IdToDataNodes {
+		if !containsDataNodeId(dataNodeIds, id) {
+			availableNodes = append(availableNodes, id)
+		}
+	}
+	liveDataNodeIds := nameNode.liveReplicas(blockId)
+	needed := int(nameNode.expectedReplication(blockId)) - len(liveDataNodeIds)
+	if needed > len(availableNodes) {
+		needed = len(availableNodes)
+	}
+	if needed <= 0 {
+		return nil
+	}
+	return nameNode.chooseTargets("", availableNodes, liveDataNodeIds, needed)
+}
+
+// sendReplicateCommand 向源dataNode发送复制命令
+func sendReplicateCommand(source datanode.DataNodeInstance, request datanode.DataNodeReplicateRequest) error {
+	sourceInstance, err := auth.Dial(source.Host + ":" + source.ServicePort)
+	if err != nil {
+		return err
+	}
+	defer sourceInstance.Close()
+	var reply datanode.DataNodeReplyStatus
+	err = sourceInstance.Call("Service.ReplicateBlock", request, &reply)
+	if err != nil {
+		return err
+	}
+	if !reply.Status {
+		return errors.New("源节点拒绝了复制命令")
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package namenode
+
+import (
+	"github.com/liuzongzhou/GoDFS/datanode"
+	"github.com/liuzongzhou/GoDFS/util"
+	"testing"
+	"time"
+)
+
+// TestUnderReplicatedQueuePriority 测试只剩1个副本的块优先出队，重复入队无效
+func TestUnderReplicatedQueuePriority(t *testing.T) {
+	queue := newUnderReplicatedQueue()
+	queue.add("a", priorityUnderReplicated)
+	queue.add("b", priorityHighest)
+	queue.add("a", priorityUnderReplicated)
+
+	blockId, ok := queue.poll()
+	if !ok || blockId != "b" {
+		t.Errorf("Unable to poll block with highest priority first")
+	}
+	// 正在复制中的块不能再入队
+	queue.add("b", priorityHighest)
+	blockId, ok = queue.poll()
+	if !ok || blockId != "a" {
+		t.Errorf("Unable to poll under-replicated block")
+	}
+	if _, ok = queue.poll(); ok {
+		t.Errorf("Queue should be empty")
+	}
+	if queue.inFlightCount() != 2 {
+		t.Errorf("Unable to track in-flight replications")
+	}
+}
+
+// TestNameNodeServiceReDistributeData 测试dead节点上的块进入待复制队列
+func TestNameNodeServiceReDistributeData(t *testing.T) {
+	testNameNodeService := NewService("9000", "localhost", 4, 2, 9000)
+	testNameNodeService.IdToDataNodes[0] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "1234"}
+	testNameNodeService.IdToDataNodes[1] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "4321"}
+	testNameNodeService.setFileBlocks("/Test1/foo", []string{"0", "1"})
+	testNameNodeService.BlockToDataNodeIds["0"] = []uint64{0, 1}
+	testNameNodeService.BlockToDataNodeIds["1"] = []uint64{0}
+	testNameNodeService.BlockToGenerationStamp["0"] = 1
+	testNameNodeService.BlockToGenerationStamp["1"] = 2
+
+	var reply bool
+	err := testNameNodeService.ReDistributeData(&ReDistributeDataRequest{DataNodeUri: "localhost:1234"}, &reply)
+	util.Check(err)
+	if _, ok := testNameNodeService.IdToDataNodes[0]; ok {
+		t.Errorf("Unable to remove dead DataNode")
+	}
+	if containsDataNodeId(testNameNodeService.BlockToDataNodeIds["0"], 0) {
+		t.Errorf("Unable to remove dead replica")
+	}
+	blockId, ok := testNameNodeService.replicationQueue.poll()
+	if !ok || blockId != "0" {
+		t.Errorf("Unable to queue under-replicated block")
+	}
+	// 块1已经没有健康的副本，无法复制
+	if _, ok = testNameNodeService.replicationQueue.poll(); ok {
+		t.Errorf("Block without healthy replica should not be queued")
+	}
+}
+
+// TestNameNodeServiceChooseReplicationTargets 测试可用节点不够时尽量多复制
+func TestNameNodeServiceChooseReplicationTargets(t *testing.T) {
+	testNameNodeService := NewService("9000", "localhost", 4, 3, 9000)
+	testNameNodeService.IdToDataNodes[0] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "1234"}
+	testNameNodeService.IdToDataNodes[1] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "4321"}
+	testNameNodeService.BlockToDataNodeIds["0"] = []uint64{0}
+
+	targets := testNameNodeService.chooseReplicationTargets("0")
+	if len(targets) != 1 || targets[0] != 1 {
+		t.Errorf("Unable to choose replication targets: %v", targets)
+	}
+}
+
+// TestNameNodeServiceBlockReceived 测试目标节点汇报收到副本后，复制完成并记录新的副本位置
+func TestNameNodeServiceBlockReceived(t *testing.T) {
+	testNameNodeService := NewService("9000", "localhost", 4, 2, 9000)
+	testNameNodeService.IdToDataNodes[0] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "1234"}
+	testNameNodeService.IdToDataNodes[1] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "4321"}
+	testNameNodeService.setFileBlocks("/Test1/foo", []string{"0"})
+	testNameNodeService.BlockToDataNodeIds["0"] = []uint64{0}
+	testNameNodeService.BlockToGenerationStamp["0"] = 1
+	testNameNodeService.replicationQueue.add("0", priorityHighest)
+	testNameNodeService.replicationQueue.poll()
+	testNameNodeService.replicationQueue.start("0", []uint64{1})
+
+	request := datanode.BlockReceivedRequest{
+		DataNode: datanode.DataNodeInstance{Host: "localhost", ServicePort: "4321"},
+		Block:    datanode.BlockInfo{RemoteFilePath: "/Test1/", BlockId: "0", GenerationStamp: 1, Size: 4},
+	}
+	var reply bool
+	err := testNameNodeService.BlockReceived(&request, &reply)
+	util.Check(err)
+	if !containsDataNodeId(testNameNodeService.BlockToDataNodeIds["0"], 1) {
+		t.Errorf("Unable to record received replica")
+	}
+	if testNameNodeService.replicationQueue.inFlightCount() != 0 {
+		t.Errorf("Replication should be finished")
+	}
+}
+
+// TestUnderReplicatedQueueTimedOut 测试等待汇报超时的复制会被移除，以便重新复制
+func TestUnderReplicatedQueueTimedOut(t *testing.T) {
+	queue := newUnderReplicatedQueue()
+	queue.add("a", priorityHighest)
+	queue.poll()
+	queue.start("a", []uint64{1, 2})
+	queue.received("a", 1)
+	if timedOut := queue.timedOut(time.Now()); len(timedOut) != 0 {
+		t.Errorf("Replication should not time out yet")
+	}
+	timedOut := queue.timedOut(time.Now().Add(pendingReplicationTimeout * 2))
+	if len(timedOut) != 1 || timedOut[0] != "a" || queue.inFlightCount() != 0 {
+		t.Errorf("Unable to time out pending replication")
+	}
+}
+
+// TestNameNodeServiceWriteReplication 测试文件指定的副本因子和继承目录的默认副本因子
+func TestNameNodeServiceWriteReplication(t *testing.T) {
+	testNameNodeService := NewService("9000", "localhost", 4, 1, 9000)
+	testNameNodeService.IdToDataNodes[0] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "1234"}
+	testNameNodeService.IdToDataNodes[1] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "4321"}
+	testNameNodeService.IdToDataNodes[2] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "5678"}
+	testNameNodeService.DirectoryToReplication["Test1/"] = 3
+
+	var reply []NameNodeMetaData
+	err := testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "Test1/sub/", FileName: "foo", FileSize: 4}, &reply)
+	util.Check(err)
+	if len(reply[0].BlockAddresses) != 3 || testNameNodeService.FileNameToReplication["Test1/sub/foo"] != 3 {
+		t.Errorf("Unable to inherit replication factor from ancestor directory")
+	}
+
+	reply = nil
+	err = testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "Test1/", FileName: "bar", FileSize: 4, ReplicationFactor: 2}, &reply)
+	util.Check(err)
+	if len(reply[0].BlockAddresses) != 2 || testNameNodeService.expectedReplication(reply[0].BlockId) != 2 {
+		t.Errorf("Unable to set replication factor of file")
+	}
+}
+
+// TestNameNodeServiceSetReplication 测试调高副本因子后块进入待复制队列，调低后删除多余的副本
+func TestNameNodeServiceSetReplication(t *testing.T) {
+	testNameNodeService := NewService("9000", "localhost", 4, 2, 9000)
+	testNameNodeService.IdToDataNodes[0] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "1234"}
+	testNameNodeService.IdToDataNodes[1] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "4321"}
+	testNameNodeService.IdToDataNodes[2] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "5678"}
+	testNameNodeService.DirectoryToFileName["/Test1/"] = []string{"foo"}
+	testNameNodeService.setFileBlocks("/Test1/foo", []string{"0"})
+	testNameNodeService.FileNameToReplication["/Test1/foo"] = 2
+	testNameNodeService.BlockToDataNodeIds["0"] = []uint64{0, 1}
+	testNameNodeService.BlockToGenerationStamp["0"] = 1
+
+	var reply bool
+	err := testNameNodeService.SetReplication(&NameNodeSetReplicationRequest{RemoteFilePath: "/Test1/", FileName: "foo", ReplicationFactor: 3}, &reply)
+	util.Check(err)
+	if blockId, ok := testNameNodeService.replicationQueue.poll(); !ok || blockId != "0" {
+		t.Errorf("Unable to queue block after raising replication factor")
+	}
+	testNameNodeService.replicationQueue.done("0")
+
+	err = testNameNodeService.SetReplication(&NameNodeSetReplicationRequest{RemoteFilePath: "/Test1/", ReplicationFactor: 1}, &reply)
+	util.Check(err)
+	if len(testNameNodeService.BlockToDataNodeIds["0"]) != 1 {
+		t.Errorf("Unable to remove excess replicas after lowering replication factor")
+	}
+	if testNameNodeService.DirectoryToReplication["/Test1/"] != 1 || testNameNodeService.FileNameToReplication["/Test1/foo"] != 1 {
+		t.Errorf("Unable to set replication factor of directory")
+	}
+	if err = testNameNodeService.SetReplication(&NameNodeSetReplicationRequest{RemoteFilePath: "/Test1/", FileName: "bar", ReplicationFactor: 1}, &reply); err == nil {
+		t.Errorf("Setting replication factor of missing file should fail")
+	}
+}
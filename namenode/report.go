@@ -0,0 +1,144 @@
+package namenode
+
+import (
+	"github.com/liuzongzhou/GoDFS/datanode"
+	"sort"
+	"time"
+)
+
+// staleDataNodeInterval 超过该时间没有收到心跳的dataNode为stale状态，nameNode每5秒检测一次心跳
+const staleDataNodeInterval = 15 * time.Second
+
+// 集群报告中dataNode的状态，管理状态不是正常时使用管理状态
+const (
+	DataNodeStateLive  = "live"
+	DataNodeStateStale = "stale"
+	DataNodeStateDead  = "dead"
+)
+
+// DataNodeReport 集群报告中一个dataNode的信息
+type DataNodeReport struct {
+	Id              uint64
+	Host            string
+	ServicePort     string
+	Rack            string
+	Uuid            string
+	State           string
+	Capacity        uint64
+	Used            uint64 //块汇报统计的已用空间
+	Remaining       uint64
+	ActiveTransfers int64
+	Blocks          int
+	LastHeartbeat   time.Duration //距离最近一次心跳的时间，为负数时还没有收到过心跳
+}
+
+// ClusterReport 集群报告：nameNode的角色、dataNode列表和集群汇总信息
+type ClusterReport struct {
+	Role                  string //primary或者secondary
+	Host                  string
+	Port                  uint16
+	PrimaryPort           string
+	SafeMode              bool
+	DataNodes             []DataNodeReport
+	LiveDataNodes         int
+	StaleDataNodes        int
+	DeadDataNodes         int
+	Capacity              uint64 //未dead的dataNode的汇总
+	Used                  uint64
+	Remaining             uint64
+	Files                 int
+	Blocks                int
+	MissingBlocks         int
+	CorruptBlocks         int
+	UnderReplicatedBlocks int
+}
+
+// deadDataNode 被判定为dead、已经从IdToDataNodes中移除的dataNode，重新注册后移除
+type deadDataNode struct {
+	instance      datanode.DataNodeInstance
+	uuid          string
+	lastHeartbeat time.Time
+}
+
+// Report 生成集群报告，列出每个dataNode的状态、容量、块数和最近一次心跳距今的时间，以及集群的汇总信息
+func (nameNode *Service) Report(request bool, reply *ClusterReport) error {
+	nameNode.rLock()
+	defer nameNode.rUnlock()
+	now := time.Now()
+	report := ClusterReport{
+		Role:        "secondary",
+		Host:        nameNode.Host,
+		Port:        nameNode.Port,
+		PrimaryPort: nameNode.PrimaryPort,
+		SafeMode:    nameNode.inSafeMode(),
+	}
+	if nameNode.isPrimary() {
+		report.Role = "primary"
+	}
+	for id, dn := range nameNode.IdToDataNodes {
+		stats := nameNode.DataNodeStats[id]
+		dataNode := DataNodeReport{
+			Id:              id,
+			Host:            dn.Host,
+			ServicePort:     dn.ServicePort,
+			Rack:            nameNode.RackOf(id),
+			Uuid:            stats.Uuid,
+			State:           nameNode.adminState(id),
+			Capacity:        stats.Capacity,
+			Used:            nameNode.DataNodeUsedSpace[id],
+			Remaining:       stats.Remaining,
+			ActiveTransfers: stats.ActiveTransfers,
+			Blocks:          len(nameNode.blocksOnDataNode(id)),
+			LastHeartbeat:   -1,
+		}
+		if lastHeartbeat, ok := nameNode.lastHeartbeats[id]; ok {
+			dataNode.LastHeartbeat = now.Sub(lastHeartbeat)
+		}
+		if dataNode.LastHeartbeat < 0 || dataNode.LastHeartbeat > staleDataNodeInterval {
+			report.StaleDataNodes++
+			if dataNode.State == AdminStateNormal {
+				dataNode.State = DataNodeStateStale
+			}
+		} else {
+			report.LiveDataNodes++
+			if dataNode.State == AdminStateNormal {
+				dataNode.State = DataNodeStateLive
+			}
+		}
+		report.Capacity += dataNode.Capacity
+		report.Used += dataNode.Used
+		report.Remaining += dataNode.Remaining
+		report.DataNodes = append(report.DataNodes, dataNode)
+	}
+	sort.Slice(report.DataNodes, func(i, j int) bool { return report.DataNodes[i].Id < report.DataNodes[j].Id })
+	var deadDataNodes []DataNodeReport
+	for _, dead := range nameNode.deadDataNodes {
+		dataNode := DataNodeReport{
+			Host:          dead.instance.Host,
+			ServicePort:   dead.instance.ServicePort,
+			Rack:          dead.instance.Rack,
+			Uuid:          dead.uuid,
+			State:         DataNodeStateDead,
+			LastHeartbeat: -1,
+		}
+		if !dead.lastHeartbeat.IsZero() {
+			dataNode.LastHeartbeat = now.Sub(dead.lastHeartbeat)
+		}
+		deadDataNodes = append(deadDataNodes, dataNode)
+	}
+	sort.Slice(deadDataNodes, func(i, j int) bool {
+		return deadDataNodes[i].Host+":"+deadDataNodes[i].ServicePort < deadDataNodes[j].Host+":"+deadDataNodes[j].ServicePort
+	})
+	report.DataNodes = append(report.DataNodes, deadDataNodes...)
+	report.DeadDataNodes = len(deadDataNodes)
+	for _, fileName := range nameNode.fsckFileNames("") {
+		file := nameNode.checkFile(fileName)
+		report.Files++
+		report.Blocks += file.Blocks
+		report.MissingBlocks += len(file.MissingBlocks)
+		report.CorruptBlocks += len(file.CorruptBlocks)
+		report.UnderReplicatedBlocks += len(file.UnderReplicatedBlocks)
+	}
+	*reply = report
+	return nil
+}
@@ -0,0 +1,57 @@
+package namenode
+
+import (
+	"github.com/liuzongzhou/GoDFS/datanode"
+	"github.com/liuzongzhou/GoDFS/util"
+	"testing"
+)
+
+// TestNameNodeServiceReport 测试集群报告中dataNode的状态、容量、块数和集群汇总信息
+func TestNameNodeServiceReport(t *testing.T) {
+	testNameNodeService := NewService("9000", "localhost", 4, 2, 9000)
+	testNameNodeService.IdToDataNodes[0] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "1234"}
+	testNameNodeService.IdToDataNodes[1] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "4321"}
+	testNameNodeService.IdToDataNodes[2] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "5678"}
+	var ok bool
+	util.Check(testNameNodeService.ProcessHeartbeat(&HeartbeatRequest{DataNodeId: 0, Heartbeat: datanode.DataNodeHeartbeat{Ack: true, Capacity: 100, Remaining: 60, Uuid: "uuid-0"}}, &ok))
+	util.Check(testNameNodeService.ProcessHeartbeat(&HeartbeatRequest{DataNodeId: 2, Heartbeat: datanode.DataNodeHeartbeat{Ack: true, Capacity: 50, Remaining: 50, Uuid: "uuid-2"}}, &ok))
+	var metadata []NameNodeMetaData
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/Test1/", FileName: "foo", FileSize: 8}, &metadata))
+	// 块都在0和1上，dataNode 2 dead之后只剩1没有收到过心跳
+	for _, block := range metadata {
+		testNameNodeService.BlockToDataNodeIds[block.BlockId] = []uint64{0, 1}
+	}
+	testNameNodeService.BlockToDataNodeIds[metadata[1].BlockId] = []uint64{0}
+	util.Check(testNameNodeService.ReDistributeData(&ReDistributeDataRequest{DataNodeUri: "localhost:5678"}, &ok))
+
+	var report ClusterReport
+	util.Check(testNameNodeService.Report(true, &report))
+	if report.Role != "primary" || report.PrimaryPort != "9000" || len(report.DataNodes) != 3 {
+		t.Fatalf("Unable to report cluster: %+v", report)
+	}
+	if report.LiveDataNodes != 1 || report.StaleDataNodes != 1 || report.DeadDataNodes != 1 || report.Capacity != 100 {
+		t.Errorf("Unable to summarize DataNodes: %+v", report)
+	}
+	if report.Files != 1 || report.Blocks != 2 || report.UnderReplicatedBlocks != 1 || report.MissingBlocks != 0 {
+		t.Errorf("Unable to summarize blocks: %+v", report)
+	}
+	live, stale, dead := report.DataNodes[0], report.DataNodes[1], report.DataNodes[2]
+	if live.State != DataNodeStateLive || live.Uuid != "uuid-0" || live.Blocks != 2 || live.LastHeartbeat < 0 {
+		t.Errorf("Unable to report live DataNode: %+v", live)
+	}
+	if stale.State != DataNodeStateStale || stale.Blocks != 1 || stale.LastHeartbeat >= 0 {
+		t.Errorf("Unable to report stale DataNode: %+v", stale)
+	}
+	if dead.State != DataNodeStateDead || dead.Uuid != "uuid-2" || dead.ServicePort != "5678" {
+		t.Errorf("Unable to report dead DataNode: %+v", dead)
+	}
+
+	// dead的dataNode重新注册后不再是dead
+	var id uint64
+	util.Check(testNameNodeService.RegisterDataNode(&datanode.DataNodeInstance{Host: "localhost", ServicePort: "5678"}, &id))
+	report = ClusterReport{}
+	util.Check(testNameNodeService.Report(true, &report))
+	if report.DeadDataNodes != 0 || len(report.DataNodes) != 3 {
+		t.Errorf("Re-registered DataNode should not be dead: %+v", report)
+	}
+}
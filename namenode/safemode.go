@@ -0,0 +1,122 @@
+package namenode
+
+import (
+	"errors"
+	"log"
+)
+
+// 安全模式操作
+const (
+	SafeModeEnter = "enter"
+	SafeModeLeave = "leave"
+	SafeModeGet   = "get"
+)
+
+// defaultSafeModeThreshold 默认至少有一个副本汇报上来的块所占的比例，达到后自动离开启动安全模式
+const defaultSafeModeThreshold = 0.999
+
+// errSafeMode 安全模式下拒绝修改命名空间的请求
+var errSafeMode = errors.New("nameNode处于安全模式，暂不接受修改请求")
+
+// SafeModeStatus 安全模式状态
+type SafeModeStatus struct {
+	On             bool
+	Manual         bool    //管理员手动进入的安全模式，不会自动离开
+	ReportedBlocks int     //至少有一个副本汇报上来的块数
+	TotalBlocks    int     //块总数
+	Threshold      float64 //自动离开安全模式的比例
+}
+
+// safeModeState 安全模式状态，只在当前nameNode生效，不同步给备份nameNode
+type safeModeState struct {
+	on     bool
+	manual bool
+}
+
+// SafeMode 管理安全模式：enter手动进入，leave离开，get获取状态。安全模式下nameNode不接受写入、删除等修改命名空间的请求，
+// 也不进行副本复制和删除，避免重启后块还没汇报上来时把所有块都当作丢失
+func (nameNode *Service) SafeMode(request string, reply *SafeModeStatus) error {
+	nameNode.lock()
+	defer nameNode.unlock()
+	switch request {
+	case SafeModeEnter:
+		nameNode.enterSafeMode(true)
+	case SafeModeLeave:
+		nameNode.leaveSafeMode()
+	case SafeModeGet:
+	default:
+		return errors.New("不支持的安全模式操作: " + request)
+	}
+	reported, total := nameNode.reportedBlocks()
+	*reply = SafeModeStatus{
+		On:             nameNode.safeMode.on,
+		Manual:         nameNode.safeMode.manual,
+		ReportedBlocks: reported,
+		TotalBlocks:    total,
+		Threshold:      nameNode.SafeModeThreshold,
+	}
+	return nil
+}
+
+// InSafeMode 判断nameNode是否处于安全模式
+func (nameNode *Service) InSafeMode() bool {
+	nameNode.rLock()
+	defer nameNode.rUnlock()
+	return nameNode.inSafeMode()
+}
+
+// inSafeMode 判断nameNode是否处于安全模式，调用方持有命名空间锁
+func (nameNode *Service) inSafeMode() bool {
+	return nameNode.safeMode.on
+}
+
+// enterSafeMode 进入安全模式，manual为false时是启动安全模式，块汇报达到比例后自动离开
+func (nameNode *Service) enterSafeMode(manual bool) {
+	if !nameNode.safeMode.on {
+		log.Printf("NameNode enters safe mode\n")
+	}
+	nameNode.safeMode = safeModeState{on: true, manual: manual || nameNode.safeMode.manual}
+}
+
+// leaveSafeMode 离开安全模式，之后检查所有块的副本数，包括一个副本都没有汇报上来的纠删码内部块
+func (nameNode *Service) leaveSafeMode() {
+	if !nameNode.safeMode.on {
+		return
+	}
+	nameNode.safeMode = safeModeState{}
+	reported, total := nameNode.reportedBlocks()
+	log.Printf("NameNode leaves safe mode, %d/%d blocks reported\n", reported, total)
+	for blockId := range nameNode.BlockToGenerationStamp {
+		nameNode.checkReplication(blockId)
+	}
+}
+
+// checkSafeMode 处于启动安全模式时，汇报上来的块达到比例后自动离开
+func (nameNode *Service) checkSafeMode() {
+	if !nameNode.safeMode.on || nameNode.safeMode.manual {
+		return
+	}
+	reported, total := nameNode.reportedBlocks()
+	if total == 0 || float64(reported) >= float64(total)*nameNode.SafeModeThreshold {
+		nameNode.leaveSafeMode()
+	}
+}
+
+// reportedBlocks 至少有一个副本汇报上来的块数和块总数，纠删码的内部块分别计数
+func (nameNode *Service) reportedBlocks() (reported int, total int) {
+	for blockId := range nameNode.BlockToGenerationStamp {
+		total++
+		if len(nameNode.BlockToDataNodeIds[blockId]) > 0 {
+			reported++
+		}
+	}
+	return
+}
+
+// checkNotInSafeMode 修改命名空间的请求先检查是否处于安全模式
+func (nameNode *Service) checkNotInSafeMode() error {
+	if nameNode.safeMode.on {
+		return errSafeMode
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package namenode
+
+import (
+	"github.com/liuzongzhou/GoDFS/datanode"
+	"github.com/liuzongzhou/GoDFS/util"
+	"path/filepath"
+	"testing"
+)
+
+// TestNameNodeServiceSafeMode 测试手动进入安全模式后拒绝修改请求，离开后恢复
+func TestNameNodeServiceSafeMode(t *testing.T) {
+	testNameNodeService := NewService("9000", "localhost", 4, 1, 9000)
+	testNameNodeService.IdToDataNodes[0] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "1234"}
+
+	var status SafeModeStatus
+	util.Check(testNameNodeService.SafeMode(SafeModeEnter, &status))
+	if !status.On || !status.Manual {
+		t.Errorf("Unable to enter safe mode")
+	}
+	var reply []NameNodeMetaData
+	if err := testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/Test1/", FileName: "foo", FileSize: 4}, &reply); err == nil {
+		t.Errorf("Write should be rejected in safe mode")
+	}
+	// 手动进入的安全模式不会自动离开
+	testNameNodeService.checkSafeMode()
+	if !testNameNodeService.InSafeMode() {
+		t.Errorf("Manual safe mode should not be left automatically")
+	}
+	util.Check(testNameNodeService.SafeMode(SafeModeLeave, &status))
+	if status.On {
+		t.Errorf("Unable to leave safe mode")
+	}
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/Test1/", FileName: "foo", FileSize: 4}, &reply))
+	if err := testNameNodeService.SafeMode("unknown", &status); err == nil {
+		t.Errorf("Unknown safe mode action should fail")
+	}
+}
+
+// TestNameNodeServiceStartupSafeMode 测试从镜像恢复后进入启动安全模式，块汇报达到比例后自动离开
+func TestNameNodeServiceStartupSafeMode(t *testing.T) {
+	testNameNodeService := NewService("9000", "localhost", 4, 1, 9000)
+	testNameNodeService.IdToDataNodes[0] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "1234"}
+	var written []NameNodeMetaData
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/Test1/", FileName: "foo", FileSize: 8}, &written))
+	imageFile := filepath.Join(t.TempDir(), "fsimage")
+	util.Check(testNameNodeService.SaveImage(imageFile))
+
+	restarted := NewService("9000", "localhost", 4, 1, 9000)
+	restarted.IdToDataNodes[0] = datanode.DataNodeInstance{Host: "localhost", ServicePort: "1234"}
+	util.Check(restarted.LoadImage(imageFile))
+	if !restarted.InSafeMode() || len(restarted.FileNameToBlocks["/Test1/foo"]) != 2 || len(restarted.BlockToDataNodeIds) != 0 {
+		t.Fatalf("Unable to load image and enter startup safe mode")
+	}
+	if restarted.GenerationStamp != testNameNodeService.GenerationStamp {
+		t.Errorf("Unable to restore generation stamp")
+	}
+	var ok bool
+	if err := restarted.DeleteFileNameMetaData(&NameNodeDeleteRequest{RemoteFilePath: "/Test1/", FileName: "foo"}, &ok); err == nil {
+		t.Errorf("Delete should be rejected in safe mode")
+	}
+
+	// 只汇报了一半的块，仍然处于安全模式
+	report := func(blocks ...NameNodeMetaData) {
+		request := BlockReportRequest{DataNodeId: 0}
+		for _, block := range blocks {
+			request.Blocks = append(request.Blocks, datanode.BlockInfo{RemoteFilePath: "/Test1/", BlockId: block.BlockId, GenerationStamp: block.GenerationStamp, Size: 4})
+		}
+		util.Check(restarted.ProcessBlockReport(&request, &ok))
+	}
+	report(written[0])
+	if !restarted.InSafeMode() {
+		t.Errorf("Should stay in safe mode before enough blocks are reported")
+	}
+	report(written...)
+	if restarted.InSafeMode() {
+		t.Errorf("Unable to leave startup safe mode after blocks are reported")
+	}
+}
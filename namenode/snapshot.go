@@ -0,0 +1,573 @@
+package namenode
+
+import (
+	"errors"
+	"github.com/liuzongzhou/GoDFS/auth"
+	"github.com/liuzongzhou/GoDFS/datanode"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// snapshotDirectoryName 快照所在的只读目录，快照name中的文件通过<目录>/.snapshot/<name>/读取，
+	// 只被快照引用的块在dataNode上移到<目录>/.snapshot/中保存
+	snapshotDirectoryName = ".snapshot/"
+	// defaultSnapshotNameFormat 没有指定快照名时按创建时间生成的快照名
+	defaultSnapshotNameFormat = "s20060102-150405.000"
+)
+
+// Snapshot 目录的一个只读快照。创建快照时不复制元数据，快照创建后文件第一次被修改、删除、重命名或者新建之前，
+// 把文件当时的状态记录到最近一个快照的Diff中；读取快照时依次查找该快照及之后快照的Diff，都没有记录的文件与当前相同
+type Snapshot struct {
+	Name      string
+	CreatedAt time.Time
+	Diff      map[string]SnapshotFile //key:相对可以创建快照的目录的路径+文件名
+}
+
+// SnapshotFile 快照中文件的状态，Exists为false时文件在快照创建时不存在
+type SnapshotFile struct {
+	Exists      bool
+	Blocks      []string
+	Size        uint64
+	Replication uint64
+	ECPolicy    string
+	Encryption  FileEncryptionInfo
+	Permission  Permission
+	Acl         []AclEntry
+}
+
+// SnapshotStatus 快照的名字和创建时间
+type SnapshotStatus struct {
+	Name      string
+	CreatedAt time.Time
+}
+
+// SnapshottableDirectoryStatus 可以创建快照的目录及其所有快照，按创建时间排列
+type SnapshottableDirectoryStatus struct {
+	Path      string
+	Snapshots []SnapshotStatus
+}
+
+// NameNodeSnapshotRequest 快照相关的请求，Path为可以创建快照的目录
+type NameNodeSnapshotRequest struct {
+	Path         string
+	SnapshotName string //创建快照时为空则按创建时间生成
+	User         UserInfo
+}
+
+// snapshotLocation 快照路径<目录>/.snapshot/<name>/<相对路径>解析得到的位置
+type snapshotLocation struct {
+	root  string //可以创建快照的目录
+	index int    //快照在目录的快照列表中的下标
+	rel   string //相对目录的路径，为空时为快照本身
+}
+
+// inSnapshot 判断路径是否在快照的只读目录中
+func inSnapshot(path string) bool {
+	return strings.HasPrefix(path, snapshotDirectoryName) || strings.Contains(path+"/", "/"+snapshotDirectoryName)
+}
+
+// checkNotInSnapshot 快照是只读的，不能在其中创建、修改、重命名或者删除路径
+func checkNotInSnapshot(path string) error {
+	if inSnapshot(path) {
+		return errors.New("快照是只读的: " + path)
+	}
+	return nil
+}
+
+// normalizeDirectory 把目录统一为以/结尾，根目录为/
+func normalizeDirectory(path string) string {
+	if isRoot(path) {
+		return "/"
+	}
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+	return path
+}
+
+// resolveSnapshotPath 解析快照路径，目录不可以创建快照或者快照不存在时返回错误；
+// <目录>/.snapshot/本身解析为下标-1
+func (nameNode *Service) resolveSnapshotPath(path string) (snapshotLocation, error) {
+	separator := strings.Index(path+"/", "/"+snapshotDirectoryName)
+	if separator < 0 {
+		return snapshotLocation{}, errors.New("不是快照路径: " + path)
+	}
+	root := normalizeDirectory(path[:separator+1])
+	snapshots, ok := nameNode.DirectoryToSnapshots[root]
+	if !ok {
+		return snapshotLocation{}, errors.New("目录" + root + "不可以创建快照")
+	}
+	start := separator + 1 + len(snapshotDirectoryName)
+	if start >= len(path) {
+		return snapshotLocation{root: root, index: -1}, nil
+	}
+	name, rel, _ := strings.Cut(path[start:], "/")
+	for index, snapshot := range snapshots {
+		if snapshot.Name == name {
+			return snapshotLocation{root: root, index: index, rel: rel}, nil
+		}
+	}
+	return snapshotLocation{}, errors.New("快照" + root + snapshotDirectoryName + name + "不存在")
+}
+
+// snapshotRootOf 路径所在的可以创建快照的目录，可以创建快照的目录不能嵌套，最多只有一个
+func (nameNode *Service) snapshotRootOf(path string) (string, bool) {
+	for root := range nameNode.DirectoryToSnapshots {
+		if isUnder(path, root) {
+			return root, true
+		}
+	}
+	return "", false
+}
+
+// checkNoSnapshots 目录下有快照的目录不能删除，也不能移到回收站，需要先删除所有快照
+func (nameNode *Service) checkNoSnapshots(directory string) error {
+	if !strings.HasSuffix(directory, "/") && !isRoot(directory) {
+		return nil
+	}
+	for root, snapshots := range nameNode.DirectoryToSnapshots {
+		if len(snapshots) > 0 && isUnder(root, directory) {
+			return errors.New("目录" + root + "有快照，需要先删除所有快照")
+		}
+	}
+	return nil
+}
+
+// checkDeletable 检查删除路径的权限，快照中的路径和有快照的目录不能删除，删除目录时检查整个目录树
+func (nameNode *Service) checkDeletable(user UserInfo, path string) error {
+	if err := checkNotInSnapshot(path); err != nil {
+		return err
+	}
+	if err := nameNode.checkNoSnapshots(path); err != nil {
+		return err
+	}
+	if err := nameNode.checkParentPermission(user, path); err != nil {
+		return err
+	}
+	return nameNode.checkSubtreePermission(user, path)
+}
+
+// currentFileState 文件当前的状态，文件不存在时Exists为false
+func (nameNode *Service) currentFileState(fileName string) SnapshotFile {
+	blockIds, ok := nameNode.FileNameToBlocks[fileName]
+	if !ok {
+		return SnapshotFile{}
+	}
+	return SnapshotFile{
+		Exists:      true,
+		Blocks:      append([]string(nil), blockIds...),
+		Size:        nameNode.FileNameSize[fileName],
+		Replication: nameNode.FileNameToReplication[fileName],
+		ECPolicy:    nameNode.FileNameToECPolicy[fileName],
+		Encryption:  nameNode.FileNameToEncryption[fileName],
+		Permission:  nameNode.permissionOf(fileName),
+		Acl:         nameNode.PathToAcl[fileName],
+	}
+}
+
+// unpreservedSnapshot 文件所在目录有快照并且文件在最近一个快照之后还没有被记录时，返回最近一个快照和文件相对快照目录的路径
+func (nameNode *Service) unpreservedSnapshot(fileName string) (*Snapshot, string, bool) {
+	root, ok := nameNode.snapshotRootOf(fileName)
+	if !ok {
+		return nil, "", false
+	}
+	snapshots := nameNode.DirectoryToSnapshots[root]
+	if len(snapshots) == 0 {
+		return nil, "", false
+	}
+	latest := &snapshots[len(snapshots)-1]
+	rel := strings.TrimPrefix(fileName, root)
+	if _, ok := latest.Diff[rel]; ok {
+		return nil, "", false
+	}
+	return latest, rel, true
+}
+
+// preserveFile 文件将要被修改、删除或者新建，所在目录有快照并且文件在最近一个快照之后还没有被记录时，记录文件当前的状态。
+// 多记录一次当前状态不影响快照的内容，不确定是否修改时也可以调用
+func (nameNode *Service) preserveFile(fileName string) {
+	latest, rel, ok := nameNode.unpreservedSnapshot(fileName)
+	if !ok {
+		return
+	}
+	if latest.Diff == nil {
+		latest.Diff = make(map[string]SnapshotFile)
+	}
+	latest.Diff[rel] = nameNode.currentFileState(fileName)
+}
+
+// preserveRename 重命名之前记录源路径下所有文件和对应的目标路径的状态
+func (nameNode *Service) preserveRename(srcPath string, destPath string) {
+	if len(nameNode.DirectoryToSnapshots) == 0 {
+		return
+	}
+	for fileName := range nameNode.FileNameToBlocks {
+		if fileName == srcPath || (strings.HasSuffix(srcPath, "/") && strings.HasPrefix(fileName, srcPath)) {
+			nameNode.preserveFile(fileName)
+			nameNode.preserveFile(destPath + strings.TrimPrefix(fileName, srcPath))
+		}
+	}
+}
+
+// snapshotFile 快照中文件的状态：依次查找该快照及之后快照的Diff，都没有记录时与当前相同
+func (nameNode *Service) snapshotFile(location snapshotLocation) SnapshotFile {
+	snapshots := nameNode.DirectoryToSnapshots[location.root]
+	for index := location.index; index < len(snapshots); index++ {
+		if file, ok := snapshots[index].Diff[location.rel]; ok {
+			return file
+		}
+	}
+	return nameNode.currentFileState(location.root + location.rel)
+}
+
+// snapshotReferencing 引用块的快照所在的目录，纠删码的内部块按所属的块组查找
+func (nameNode *Service) snapshotReferencing(blockId string) (string, bool) {
+	if groupId, _, ok := nameNode.blockGroupOf(blockId); ok {
+		blockId = groupId
+	}
+	for root, snapshots := range nameNode.DirectoryToSnapshots {
+		for _, snapshot := range snapshots {
+			for _, file := range snapshot.Diff {
+				for _, id := range file.Blocks {
+					if id == blockId {
+						return root, true
+					}
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// snapshotBlockPath 只被快照引用的块在dataNode上所在的目录
+func (nameNode *Service) snapshotBlockPath(blockId string) string {
+	if groupId, _, ok := nameNode.blockGroupOf(blockId); ok {
+		blockId = groupId
+	}
+	return nameNode.SnapshotBlockPaths[blockId]
+}
+
+// physicalBlockIds dataNode上实际存储的块，纠删码块组展开为所有内部块
+func (nameNode *Service) physicalBlockIds(blockIds []string) (physical []string) {
+	for _, blockId := range blockIds {
+		if group, ok := nameNode.BlockGroups[blockId]; ok {
+			physical = append(physical, group.Blocks...)
+		} else {
+			physical = append(physical, blockId)
+		}
+	}
+	return
+}
+
+// retainSnapshotBlocks 文件将要不再使用这些块：被快照引用的块保留元数据，在dataNode上从文件所在的目录移到快照目录的.snapshot/中，
+// 避免删除文件所在的目录时一起被删除；返回没有被快照引用、可以删除的块。调用前需要先preserveFile
+func (nameNode *Service) retainSnapshotBlocks(fileName string, blockIds []string) (unreferenced []string) {
+	retained := make(map[string][]string)
+	for _, blockId := range blockIds {
+		root, ok := nameNode.snapshotReferencing(blockId)
+		if !ok {
+			unreferenced = append(unreferenced, blockId)
+			continue
+		}
+		retained[root] = append(retained[root], blockId)
+	}
+	for root, ids := range retained {
+		destPath := root + snapshotDirectoryName
+		request := datanode.DataNodeMoveRequest{SrcPath: parentDirectory(fileName), DestPath: destPath, BlockIds: nameNode.physicalBlockIds(ids)}
+		nameNode.callDataNodes("Service.MovePath", request)
+		for _, blockId := range ids {
+			nameNode.SnapshotBlockPaths[blockId] = destPath
+		}
+	}
+	return
+}
+
+// releaseSnapshotBlocks 删除快照后，删除不再被当前文件和其他快照引用的块
+func (nameNode *Service) releaseSnapshotBlocks(blockIds []string) {
+	released := make(map[string]bool)
+	for _, blockId := range blockIds {
+		if released[blockId] {
+			continue
+		}
+		released[blockId] = true
+		if _, ok := nameNode.blockFileName(blockId); ok {
+			continue
+		}
+		if _, ok := nameNode.snapshotReferencing(blockId); ok {
+			continue
+		}
+		remoteFilePath := nameNode.snapshotBlockPath(blockId)
+		delete(nameNode.SnapshotBlockPaths, blockId)
+		log.Printf("Block %s is no longer referenced by any snapshot, deleting\n", blockId)
+		nameNode.discardBlocks(remoteFilePath, []string{blockId})
+	}
+}
+
+// checkSnapshotAccess 检查读取快照中文件的权限：快照目录的读和执行权限，以及快照中记录的文件的access权限
+func (nameNode *Service) checkSnapshotAccess(user UserInfo, location snapshotLocation, file SnapshotFile, access FsAction) error {
+	if err := nameNode.checkPermission(user, location.root, ActionRead|ActionExecute); err != nil {
+		return err
+	}
+	if access != 0 && !nameNode.isSuperUser(user) && !aclAllows(user, file.Permission, file.Acl, access) {
+		return permissionDenied(user, location.root+snapshotDirectoryName+nameNode.DirectoryToSnapshots[location.root][location.index].Name+"/"+location.rel)
+	}
+	return nil
+}
+
+// readSnapshotFile 解析快照中的文件并检查读取权限
+func (nameNode *Service) readSnapshotFile(user UserInfo, path string) (SnapshotFile, error) {
+	location, err := nameNode.resolveSnapshotPath(path)
+	if err != nil {
+		return SnapshotFile{}, err
+	}
+	if location.index < 0 || location.rel == "" || strings.HasSuffix(location.rel, "/") {
+		return SnapshotFile{}, errors.New("文件不存在")
+	}
+	file := nameNode.snapshotFile(location)
+	if !file.Exists {
+		return SnapshotFile{}, errors.New("文件不存在")
+	}
+	if err := nameNode.checkSnapshotAccess(user, location, file, ActionRead); err != nil {
+		return SnapshotFile{}, err
+	}
+	return file, nil
+}
+
+// readSnapshotData 读取快照中文件的元数据，块可能在文件现在所在的目录或者快照目录的.snapshot/中，由BlockPath指明
+func (nameNode *Service) readSnapshotData(request *NameNodeReadRequest, reply *[]NameNodeMetaData) error {
+	if request.Delete {
+		return checkNotInSnapshot(request.FileName)
+	}
+	file, err := nameNode.readSnapshotFile(request.User, request.FileName)
+	if err != nil {
+		return err
+	}
+	metadata := nameNode.blocksMetaData(file.Blocks)
+	for i := range metadata {
+		metadata[i].BlockPath = nameNode.blockRemoteFilePath(metadata[i].BlockId)
+	}
+	nameNode.attachBlockTokens(metadata, "", auth.BlockAccessRead)
+	*reply = metadata
+	return nil
+}
+
+// listSnapshotDirectory 列出<目录>/.snapshot/下的快照，或者快照中一个目录下的文件
+func (nameNode *Service) listSnapshotDirectory(request *NameNodeListRequest, reply *[]ListMetaData) error {
+	location, err := nameNode.resolveSnapshotPath(request.RemoteDirPath)
+	if err != nil {
+		return err
+	}
+	if err := nameNode.checkPermission(request.User, location.root, ActionRead|ActionExecute); err != nil {
+		return err
+	}
+	snapshots := nameNode.DirectoryToSnapshots[location.root]
+	if location.index < 0 {
+		for _, snapshot := range snapshots {
+			*reply = append(*reply, ListMetaData{FileName: snapshot.Name + "/"})
+		}
+		return nil
+	}
+	directory := normalizeDirectory(location.rel)
+	if directory == "/" {
+		directory = ""
+	}
+	// 快照中的文件为当前的文件和之后的快照中记录过的文件
+	candidates := make(map[string]bool)
+	for fileName := range nameNode.FileNameToBlocks {
+		if strings.HasPrefix(fileName, location.root) {
+			candidates[strings.TrimPrefix(fileName, location.root)] = true
+		}
+	}
+	for index := location.index; index < len(snapshots); index++ {
+		for rel := range snapshots[index].Diff {
+			candidates[rel] = true
+		}
+	}
+	var fileNames []string
+	for rel := range candidates {
+		if !strings.HasPrefix(rel, directory) || strings.Contains(strings.TrimPrefix(rel, directory), "/") {
+			continue
+		}
+		fileNames = append(fileNames, rel)
+	}
+	sort.Strings(fileNames)
+	for _, rel := range fileNames {
+		file := nameNode.snapshotFile(snapshotLocation{root: location.root, index: location.index, rel: rel})
+		if file.Exists {
+			*reply = append(*reply, ListMetaData{FileName: strings.TrimPrefix(rel, directory), FileSize: file.Size})
+		}
+	}
+	return nil
+}
+
+// AllowSnapshot 允许在目录上创建快照，只有超级用户可以设置；可以创建快照的目录不能嵌套
+func (nameNode *Service) AllowSnapshot(request *NameNodeSnapshotRequest, reply *bool) error {
+	nameNode.lock()
+	defer nameNode.unlock()
+	if !nameNode.isPrimary() {
+		return errors.New("当前nameNode不是主节点")
+	}
+	if err := nameNode.checkNotInSafeMode(); err != nil {
+		return err
+	}
+	path := normalizeDirectory(request.Path)
+	if !nameNode.isSuperUser(request.User) {
+		return permissionDenied(request.User, path)
+	}
+	if err := checkNotInSnapshot(path); err != nil {
+		return err
+	}
+	if !nameNode.pathExists(path) {
+		return errors.New("目录不存在")
+	}
+	if _, ok := nameNode.DirectoryToSnapshots[path]; ok {
+		*reply = true
+		return nil
+	}
+	for root := range nameNode.DirectoryToSnapshots {
+		if isUnder(path, root) || isUnder(root, path) {
+			return errors.New("可以创建快照的目录不能嵌套，" + root + "已经可以创建快照")
+		}
+	}
+	nameNode.DirectoryToSnapshots[path] = []Snapshot{}
+	*reply = true
+	return nil
+}
+
+// DisallowSnapshot 不再允许在目录上创建快照，只有超级用户可以设置，目录还有快照时需要先删除
+func (nameNode *Service) DisallowSnapshot(request *NameNodeSnapshotRequest, reply *bool) error {
+	nameNode.lock()
+	defer nameNode.unlock()
+	if !nameNode.isPrimary() {
+		return errors.New("当前nameNode不是主节点")
+	}
+	if err := nameNode.checkNotInSafeMode(); err != nil {
+		return err
+	}
+	path := normalizeDirectory(request.Path)
+	if !nameNode.isSuperUser(request.User) {
+		return permissionDenied(request.User, path)
+	}
+	if len(nameNode.DirectoryToSnapshots[path]) > 0 {
+		return errors.New("目录" + path + "有快照，需要先删除所有快照")
+	}
+	delete(nameNode.DirectoryToSnapshots, path)
+	*reply = true
+	return nil
+}
+
+// CreateSnapshot 为可以创建快照的目录创建只读快照，需要是目录的所有者或者超级用户，返回快照的路径
+func (nameNode *Service) CreateSnapshot(request *NameNodeSnapshotRequest, reply *string) error {
+	nameNode.lock()
+	defer nameNode.unlock()
+	if !nameNode.isPrimary() {
+		return errors.New("当前nameNode不是主节点")
+	}
+	if err := nameNode.checkNotInSafeMode(); err != nil {
+		return err
+	}
+	path := normalizeDirectory(request.Path)
+	snapshots, ok := nameNode.DirectoryToSnapshots[path]
+	if !ok {
+		return errors.New("目录" + path + "不可以创建快照")
+	}
+	if err := nameNode.checkOwner(request.User, path); err != nil {
+		return err
+	}
+	now := time.Now()
+	name := request.SnapshotName
+	if name == "" {
+		name = now.Format(defaultSnapshotNameFormat)
+	}
+	if name == "." || name == ".." || strings.Contains(name, "/") {
+		return errors.New("快照名不合法: " + name)
+	}
+	for _, snapshot := range snapshots {
+		if snapshot.Name == name {
+			return errors.New("快照" + name + "已经存在")
+		}
+	}
+	nameNode.DirectoryToSnapshots[path] = append(snapshots, Snapshot{Name: name, CreatedAt: now, Diff: make(map[string]SnapshotFile)})
+	*reply = path + snapshotDirectoryName + name + "/"
+	return nil
+}
+
+// DeleteSnapshot 删除快照，需要是目录的所有者或者超级用户。快照中记录的、前一个快照中没有记录的文件状态
+// 也是前一个快照中的状态，合并到前一个快照；不再被引用的块随后删除
+func (nameNode *Service) DeleteSnapshot(request *NameNodeSnapshotRequest, reply *bool) error {
+	nameNode.lock()
+	defer nameNode.unlock()
+	if !nameNode.isPrimary() {
+		return errors.New("当前nameNode不是主节点")
+	}
+	if err := nameNode.checkNotInSafeMode(); err != nil {
+		return err
+	}
+	path := normalizeDirectory(request.Path)
+	snapshots, ok := nameNode.DirectoryToSnapshots[path]
+	if !ok {
+		return errors.New("目录" + path + "不可以创建快照")
+	}
+	if err := nameNode.checkOwner(request.User, path); err != nil {
+		return err
+	}
+	index := -1
+	for i, snapshot := range snapshots {
+		if snapshot.Name == request.SnapshotName {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return errors.New("快照" + request.SnapshotName + "不存在")
+	}
+	deleted := snapshots[index]
+	var blockIds []string
+	for rel, file := range deleted.Diff {
+		blockIds = append(blockIds, file.Blocks...)
+		if index == 0 {
+			continue
+		}
+		previous := &snapshots[index-1]
+		if _, ok := previous.Diff[rel]; !ok {
+			if previous.Diff == nil {
+				previous.Diff = make(map[string]SnapshotFile)
+			}
+			previous.Diff[rel] = file
+		}
+	}
+	nameNode.DirectoryToSnapshots[path] = append(snapshots[:index:index], snapshots[index+1:]...)
+	nameNode.releaseSnapshotBlocks(blockIds)
+	*reply = true
+	return nil
+}
+
+// ListSnapshots 列出目录的所有快照，request为空时列出所有可以创建快照的目录
+func (nameNode *Service) ListSnapshots(request string, reply *[]SnapshottableDirectoryStatus) error {
+	nameNode.rLock()
+	defer nameNode.rUnlock()
+	var roots []string
+	if request == "" {
+		for root := range nameNode.DirectoryToSnapshots {
+			roots = append(roots, root)
+		}
+		sort.Strings(roots)
+	} else {
+		path := normalizeDirectory(request)
+		if _, ok := nameNode.DirectoryToSnapshots[path]; !ok {
+			return errors.New("目录" + path + "不可以创建快照")
+		}
+		roots = []string{path}
+	}
+	for _, root := range roots {
+		status := SnapshottableDirectoryStatus{Path: root}
+		for _, snapshot := range nameNode.DirectoryToSnapshots[root] {
+			status.Snapshots = append(status.Snapshots, SnapshotStatus{Name: snapshot.Name, CreatedAt: snapshot.CreatedAt})
+		}
+		*reply = append(*reply, status)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package namenode
+
+import (
+	"github.com/liuzongzhou/GoDFS/util"
+	"path/filepath"
+	"testing"
+)
+
+// TestNameNodeServiceSnapshot 测试快照创建后修改、删除、新建和重命名文件，快照中仍然是创建时的内容，被快照引用的块保留下来
+func TestNameNodeServiceSnapshot(t *testing.T) {
+	testNameNodeService := newTestService("1234", "4321")
+	root := UserInfo{Name: "root"}
+	alice := UserInfo{Name: "alice"}
+	var metadata []NameNodeMetaData
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/alice/data/", FileName: "foo", FileSize: 8, User: alice}, &metadata))
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/alice/data/", FileName: "bar", FileSize: 4, User: alice}, &metadata))
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/alice/data/logs/", FileName: "baz", FileSize: 4, User: alice}, &metadata))
+	fooBlocks := testNameNodeService.FileNameToBlocks["/alice/data/foo"]
+	barBlocks := testNameNodeService.FileNameToBlocks["/alice/data/bar"]
+
+	var ok bool
+	var snapshotPath string
+	if err := testNameNodeService.CreateSnapshot(&NameNodeSnapshotRequest{Path: "/alice/data/", SnapshotName: "s1", User: alice}, &snapshotPath); err == nil {
+		t.Errorf("Snapshot should not be created before the directory is allowed")
+	}
+	if err := testNameNodeService.AllowSnapshot(&NameNodeSnapshotRequest{Path: "/alice/data/", User: alice}, &ok); err == nil {
+		t.Errorf("Only the super user should allow snapshots")
+	}
+	util.Check(testNameNodeService.AllowSnapshot(&NameNodeSnapshotRequest{Path: "/alice/data", User: root}, &ok))
+	if err := testNameNodeService.AllowSnapshot(&NameNodeSnapshotRequest{Path: "/alice/", User: root}, &ok); err == nil {
+		t.Errorf("Snapshottable directories should not be nested")
+	}
+	util.Check(testNameNodeService.CreateSnapshot(&NameNodeSnapshotRequest{Path: "/alice/data/", SnapshotName: "s1", User: alice}, &snapshotPath))
+	if snapshotPath != "/alice/data/.snapshot/s1/" {
+		t.Errorf("Unexpected snapshot path: %v", snapshotPath)
+	}
+	if err := testNameNodeService.CreateSnapshot(&NameNodeSnapshotRequest{Path: "/alice/data/", SnapshotName: "s1", User: alice}, &snapshotPath); err == nil {
+		t.Errorf("Duplicate snapshot name should be rejected")
+	}
+
+	// 覆盖foo、删除bar、新建qux、把logs/baz移出快照目录
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/alice/data/", FileName: "foo", FileSize: 12, User: alice}, &metadata))
+	metadata = nil
+	util.Check(testNameNodeService.ReadData(&NameNodeReadRequest{FileName: "/alice/data/bar", User: alice, Delete: true}, &metadata))
+	if len(metadata) != 0 {
+		t.Errorf("Blocks referenced by a snapshot should not be returned for deletion: %v", metadata)
+	}
+	if _, ok := testNameNodeService.DirectoryToSnapshots["/alice/data/"][0].Diff["bar"]; ok {
+		t.Errorf("Reading blocks for deletion should not change the snapshot")
+	}
+	util.Check(testNameNodeService.DeleteFileNameMetaData(&NameNodeDeleteRequest{RemoteFilePath: "/alice/data/", FileName: "bar", User: alice}, &ok))
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/alice/data/", FileName: "qux", FileSize: 4, User: alice}, &metadata))
+	util.Check(testNameNodeService.ReNameFile(&NameNodeReNameFileRequest{ReNameSrcFileName: "/alice/data/logs/baz", ReNameDestFileName: "/alice/baz", User: alice}, &ok))
+	for _, blockId := range append(append([]string(nil), fooBlocks...), barBlocks...) {
+		if _, ok := testNameNodeService.BlockToGenerationStamp[blockId]; !ok {
+			t.Errorf("Block %s referenced by a snapshot should be retained", blockId)
+		}
+		if testNameNodeService.blockRemoteFilePath(blockId) != "/alice/data/.snapshot/" {
+			t.Errorf("Block %s should be moved to the snapshot directory", blockId)
+		}
+	}
+
+	var list []ListMetaData
+	util.Check(testNameNodeService.List(&NameNodeListRequest{RemoteDirPath: "/alice/data/.snapshot/s1/", User: alice}, &list))
+	if len(list) != 2 || list[0] != (ListMetaData{FileName: "bar", FileSize: 4}) || list[1] != (ListMetaData{FileName: "foo", FileSize: 8}) {
+		t.Errorf("Snapshot should list files at creation time: %v", list)
+	}
+	list = nil
+	util.Check(testNameNodeService.List(&NameNodeListRequest{RemoteDirPath: "/alice/data/.snapshot/s1/logs/", User: alice}, &list))
+	if len(list) != 1 || list[0].FileName != "baz" {
+		t.Errorf("Snapshot should list files in subdirectories: %v", list)
+	}
+	metadata = nil
+	util.Check(testNameNodeService.ReadData(&NameNodeReadRequest{FileName: "/alice/data/.snapshot/s1/foo", User: alice}, &metadata))
+	if len(metadata) != len(fooBlocks) || metadata[0].BlockId != fooBlocks[0] || metadata[0].BlockPath != "/alice/data/.snapshot/" {
+		t.Errorf("Snapshot should read the original blocks: %+v", metadata)
+	}
+	metadata = nil
+	util.Check(testNameNodeService.ReadData(&NameNodeReadRequest{FileName: "/alice/data/.snapshot/s1/logs/baz", User: alice}, &metadata))
+	if len(metadata) != 1 || metadata[0].BlockPath != "/alice/" {
+		t.Errorf("Snapshot should read blocks of a renamed file from its current directory: %+v", metadata)
+	}
+	var fileSize NameNodeFileSize
+	if err := testNameNodeService.FileSize(&NameNodeReadRequest{FileName: "/alice/data/.snapshot/s1/qux", User: alice}, &fileSize); err == nil {
+		t.Errorf("File created after the snapshot should not be in the snapshot")
+	}
+	if err := testNameNodeService.ReadData(&NameNodeReadRequest{FileName: "/alice/data/.snapshot/s1/foo", User: UserInfo{Name: "bob"}}, &metadata); err != nil {
+		t.Errorf("Snapshot should keep the file permission: %v", err)
+	}
+	var permission Permission
+	util.Check(testNameNodeService.GetPermission("/alice/data/.snapshot/s1/foo", &permission))
+	if permission.Owner != "alice" {
+		t.Errorf("Snapshot should return the permission of the file: %+v", permission)
+	}
+
+	// 快照是只读的，有快照的目录不能删除
+	if err := testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/alice/data/.snapshot/s1/", FileName: "foo", FileSize: 4, User: alice}, &metadata); err == nil {
+		t.Errorf("Snapshot should be read-only")
+	}
+	if err := testNameNodeService.DeleteFileNameMetaData(&NameNodeDeleteRequest{RemoteFilePath: "/alice/data/.snapshot/s1/", FileName: "foo", User: alice}, &ok); err == nil {
+		t.Errorf("Files in a snapshot should not be deleted")
+	}
+	if err := testNameNodeService.DeleteMetaData(&NameNodeDeleteRequest{RemoteFilePath: "/alice/", User: alice}, &ok); err == nil {
+		t.Errorf("Directory with snapshots should not be deleted")
+	}
+	if err := testNameNodeService.DisallowSnapshot(&NameNodeSnapshotRequest{Path: "/alice/data/", User: root}, &ok); err == nil {
+		t.Errorf("Directory with snapshots should not be disallowed")
+	}
+
+	// 重命名快照目录后快照和保留的块一起移动
+	testNameNodeService.renameMetaData("/alice/data/", "/alice/archive/")
+	util.Check(testNameNodeService.FileSize(&NameNodeReadRequest{FileName: "/alice/archive/.snapshot/s1/bar", User: alice}, &fileSize))
+	if fileSize.FileSize != 4 || testNameNodeService.blockRemoteFilePath(barBlocks[0]) != "/alice/archive/.snapshot/" {
+		t.Errorf("Snapshot should move with the directory")
+	}
+
+	// 镜像中保存快照
+	imageFile := filepath.Join(t.TempDir(), "fsimage")
+	util.Check(testNameNodeService.SaveImage(imageFile))
+	loaded := newTestService("1234", "4321")
+	util.Check(loaded.LoadImage(imageFile))
+	list = nil
+	util.Check(loaded.List(&NameNodeListRequest{RemoteDirPath: "/alice/archive/.snapshot/s1/", User: alice}, &list))
+	if len(list) != 2 || loaded.SnapshotBlockPaths[barBlocks[0]] != "/alice/archive/.snapshot/" {
+		t.Errorf("Unable to load snapshots from image: %v", list)
+	}
+}
+
+// TestNameNodeServiceDeleteSnapshot 测试删除快照时记录合并到前一个快照，不再被引用的块被删除
+func TestNameNodeServiceDeleteSnapshot(t *testing.T) {
+	testNameNodeService := newTestService("1234", "4321")
+	root := UserInfo{Name: "root"}
+	var metadata []NameNodeMetaData
+	var ok bool
+	var snapshotPath string
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/data/", FileName: "foo", FileSize: 4, User: root}, &metadata))
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/data/", FileName: "bar", FileSize: 4, User: root}, &metadata))
+	fooBlocks := testNameNodeService.FileNameToBlocks["/data/foo"]
+	barBlocks := testNameNodeService.FileNameToBlocks["/data/bar"]
+	util.Check(testNameNodeService.AllowSnapshot(&NameNodeSnapshotRequest{Path: "/data/", User: root}, &ok))
+	util.Check(testNameNodeService.CreateSnapshot(&NameNodeSnapshotRequest{Path: "/data/", SnapshotName: "s1", User: root}, &snapshotPath))
+	util.Check(testNameNodeService.CreateSnapshot(&NameNodeSnapshotRequest{Path: "/data/", SnapshotName: "s2", User: root}, &snapshotPath))
+	util.Check(testNameNodeService.DeleteFileNameMetaData(&NameNodeDeleteRequest{RemoteFilePath: "/data/", FileName: "foo", User: root}, &ok))
+	util.Check(testNameNodeService.CreateSnapshot(&NameNodeSnapshotRequest{Path: "/data/", User: root}, &snapshotPath))
+	util.Check(testNameNodeService.DeleteFileNameMetaData(&NameNodeDeleteRequest{RemoteFilePath: "/data/", FileName: "bar", User: root}, &ok))
+
+	var directories []SnapshottableDirectoryStatus
+	util.Check(testNameNodeService.ListSnapshots("", &directories))
+	if len(directories) != 1 || directories[0].Path != "/data/" || len(directories[0].Snapshots) != 3 || directories[0].Snapshots[1].Name != "s2" {
+		t.Fatalf("Unable to list snapshots: %+v", directories)
+	}
+	s3 := directories[0].Snapshots[2].Name
+
+	// foo的记录在s2中，删除s2后合并到s1
+	util.Check(testNameNodeService.DeleteSnapshot(&NameNodeSnapshotRequest{Path: "/data/", SnapshotName: "s2", User: root}, &ok))
+	var fileSize NameNodeFileSize
+	util.Check(testNameNodeService.FileSize(&NameNodeReadRequest{FileName: "/data/.snapshot/s1/foo", User: root}, &fileSize))
+	if _, ok := testNameNodeService.BlockToGenerationStamp[fooBlocks[0]]; !ok {
+		t.Errorf("Block still referenced by s1 should be retained")
+	}
+	util.Check(testNameNodeService.DeleteSnapshot(&NameNodeSnapshotRequest{Path: "/data/", SnapshotName: "s1", User: root}, &ok))
+	if _, ok := testNameNodeService.BlockToGenerationStamp[fooBlocks[0]]; ok {
+		t.Errorf("Block no longer referenced by any snapshot should be deleted")
+	}
+	if _, ok := testNameNodeService.SnapshotBlockPaths[fooBlocks[0]]; ok {
+		t.Errorf("Snapshot block path should be deleted")
+	}
+	if _, ok := testNameNodeService.BlockToGenerationStamp[barBlocks[0]]; !ok {
+		t.Errorf("Block referenced by the remaining snapshot should be retained")
+	}
+	util.Check(testNameNodeService.FileSize(&NameNodeReadRequest{FileName: "/data/.snapshot/" + s3 + "/bar", User: root}, &fileSize))
+	util.Check(testNameNodeService.DeleteSnapshot(&NameNodeSnapshotRequest{Path: "/data/", SnapshotName: s3, User: root}, &ok))
+	if _, ok := testNameNodeService.BlockToGenerationStamp[barBlocks[0]]; ok {
+		t.Errorf("Block of the last snapshot should be deleted")
+	}
+	util.Check(testNameNodeService.DisallowSnapshot(&NameNodeSnapshotRequest{Path: "/data/", User: root}, &ok))
+	if err := testNameNodeService.CreateSnapshot(&NameNodeSnapshotRequest{Path: "/data/", User: root}, &snapshotPath); err == nil {
+		t.Errorf("Snapshot should not be created after the directory is disallowed")
+	}
+}
@@ -0,0 +1,77 @@
+package namenode
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// defaultRack 既没有汇报机架位置、拓扑映射文件中也没有配置的dataNode所在的机架
+const defaultRack = "/default-rack"
+
+// LoadTopology 读取拓扑映射文件，用于确定dataNode所在的机架
+func (nameNode *Service) LoadTopology(topologyFile string) error {
+	file, err := os.Open(topologyFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	topology, err := parseTopology(file)
+	if err != nil {
+		return err
+	}
+	nameNode.topology = topology
+	return nil
+}
+
+// parseTopology 解析拓扑映射，每行格式为"host[:port] rack"，#开头的行和空行忽略
+func parseTopology(reader io.Reader) (map[string]string, error) {
+	topology := make(map[string]string)
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, errors.New("拓扑映射格式错误: " + line)
+		}
+		topology[fields[0]] = fields[1]
+	}
+	return topology, scanner.Err()
+}
+
+// RackOf 得到dataNode所在的机架，优先使用dataNode注册时汇报的机架，其次按host:port、host查找拓扑映射，
+// 自定义的副本放置策略也可以通过它获取机架
+func (nameNode *Service) RackOf(dataNodeId uint64) string {
+	dn := nameNode.IdToDataNodes[dataNodeId]
+	if dn.Rack != "" {
+		return dn.Rack
+	}
+	if rack, ok := nameNode.topology[dn.Host+":"+dn.ServicePort]; ok {
+		return rack
+	}
+	if rack, ok := nameNode.topology[dn.Host]; ok {
+		return rack
+	}
+	return defaultRack
+}
+
+// hostRack 得到写入客户端所在的机架，客户端不在拓扑映射中时，使用同一台机器上dataNode的机架，都找不到时返回空
+func (nameNode *Service) hostRack(host string) string {
+	if host == "" {
+		return ""
+	}
+	if rack, ok := nameNode.topology[host]; ok {
+		return rack
+	}
+	for id, dn := range nameNode.IdToDataNodes {
+		if dn.Host == host {
+			return nameNode.RackOf(id)
+		}
+	}
+	return ""
+}
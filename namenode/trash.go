@@ -0,0 +1,305 @@
+package namenode
+
+import (
+	"errors"
+	"github.com/liuzongzhou/GoDFS/datanode"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// userHomeDirectory 用户主目录所在的目录，用户的回收站为/user/<用户名>/.Trash/
+	userHomeDirectory = "/user/"
+	// trashDirectoryName 回收站目录名，加密区中的路径移到加密区的<加密区>/.Trash/<用户名>/中，不离开加密区
+	trashDirectoryName = ".Trash/"
+	// trashCurrentName 回收站中存放最近删除的路径的目录，由后台任务定期保存为检查点
+	trashCurrentName = "Current/"
+	// trashCheckpointFormat 检查点目录名的时间格式
+	trashCheckpointFormat = "060102150405"
+)
+
+// NameNodeMoveToTrashRequest 把文件或者目录移到回收站的请求，FileName为空时移动目录RemoteFilePath
+type NameNodeMoveToTrashRequest struct {
+	RemoteFilePath string
+	FileName       string
+	User           UserInfo
+}
+
+// NameNodeMoveToTrashReply 移到回收站的结果，Moved为false时回收站没有开启或者路径已经在回收站中，需要直接删除
+type NameNodeMoveToTrashReply struct {
+	Moved     bool
+	TrashPath string //路径在回收站中的位置
+}
+
+// inTrash 判断路径是否在回收站中
+func inTrash(path string) bool {
+	return strings.HasPrefix(path, trashDirectoryName) || strings.Contains(path, "/"+trashDirectoryName)
+}
+
+// trashRoot 用户删除path时使用的回收站目录，加密区中的路径使用加密区自己的回收站
+func (nameNode *Service) trashRoot(path string, user UserInfo) string {
+	if zone, ok := nameNode.encryptionZoneOf(path); ok && zone.Path != path {
+		return zone.Path + trashDirectoryName + user.Name + "/"
+	}
+	return userHomeDirectory + user.Name + "/" + trashDirectoryName
+}
+
+// createTrashDirectories 记录回收站目录及其不存在的上级目录的权限：/user/和加密区的.Trash/由超级用户所有，
+// 用户主目录为0755，回收站中的目录只有用户自己可以访问
+func (nameNode *Service) createTrashDirectories(user UserInfo, root string, directory string) {
+	var missing []string
+	for parent := directory; !isRoot(parent); parent = parentDirectory(parent) {
+		if _, ok := nameNode.PathToPermission[parent]; ok {
+			break
+		}
+		missing = append(missing, parent)
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		path := missing[i]
+		permission := Permission{Owner: user.Name, Group: nameNode.permissionOf(parentDirectory(path)).Group, Mode: 0700}
+		switch {
+		case path == userHomeDirectory:
+			permission = Permission{Owner: nameNode.SuperUser, Group: SuperGroup, Mode: defaultDirectoryMode}
+		case path == userHomeDirectory+user.Name+"/":
+			permission.Mode = defaultDirectoryMode
+		case path+user.Name+"/" == root:
+			permission = Permission{Owner: nameNode.SuperUser, Group: SuperGroup, Mode: rootMode}
+		}
+		nameNode.PathToPermission[path] = permission
+	}
+	nameNode.chargeQuota(directory)
+}
+
+// MoveToTrash 把文件或者目录移到用户回收站的Current目录下，保留原来的路径；回收站中已有同名路径时加上当前时间的毫秒数。
+// 需要有删除路径的权限，dataNode上的块一起移动，之后可以用重命名恢复
+func (nameNode *Service) MoveToTrash(request *NameNodeMoveToTrashRequest, reply *NameNodeMoveToTrashReply) error {
+	nameNode.lock()
+	defer nameNode.unlock()
+	if !nameNode.isPrimary() {
+		return errors.New("当前nameNode不是主节点")
+	}
+	if err := nameNode.checkNotInSafeMode(); err != nil {
+		return err
+	}
+	path := request.RemoteFilePath + request.FileName
+	if isRoot(path) {
+		return errors.New("不能删除根目录")
+	}
+	if !nameNode.pathExists(path) {
+		return errors.New("路径不存在")
+	}
+	if err := checkNotInSnapshot(path); err != nil {
+		return err
+	}
+	if err := nameNode.checkNoSnapshots(path); err != nil {
+		return err
+	}
+	*reply = NameNodeMoveToTrashReply{}
+	if nameNode.TrashInterval <= 0 || inTrash(path) {
+		return nil
+	}
+	if request.User.Name == "" {
+		return errors.New("没有指定用户，无法确定回收站")
+	}
+	if err := nameNode.checkParentPermission(request.User, path); err != nil {
+		return err
+	}
+	if err := nameNode.checkSubtreePermission(request.User, path); err != nil {
+		return err
+	}
+	root := nameNode.trashRoot(path, request.User)
+	if strings.HasSuffix(path, "/") && strings.HasPrefix(root, path) {
+		return errors.New("目录包含回收站" + root + "，只能直接删除")
+	}
+	trashPath := root + trashCurrentName + strings.TrimPrefix(path, "/")
+	if nameNode.pathExists(trashPath) {
+		suffix := strconv.FormatInt(time.Now().UnixMilli(), 10)
+		if strings.HasSuffix(trashPath, "/") {
+			trashPath = strings.TrimSuffix(trashPath, "/") + suffix + "/"
+		} else {
+			trashPath += suffix
+		}
+	}
+	if err := nameNode.checkEncryptionZoneRename(path, trashPath); err != nil {
+		return err
+	}
+	if err := nameNode.checkRenameQuota(path, trashPath); err != nil {
+		return err
+	}
+	nameNode.cancelConversions(path)
+	nameNode.moveOnDataNodes(path, trashPath)
+	nameNode.createTrashDirectories(request.User, root, parentDirectory(trashPath))
+	if strings.HasSuffix(path, "/") {
+		nameNode.renameMetaData(path, trashPath)
+	} else {
+		nameNode.renameFileMetaData(path, trashPath)
+	}
+	*reply = NameNodeMoveToTrashReply{Moved: true, TrashPath: trashPath}
+	return nil
+}
+
+// moveOnDataNodes 在所有dataNode上把目录，或者文件的块从源路径移动到目标路径，连接失败的dataNode跳过，
+// 其上的块留在原来的位置，由副本复制补足
+func (nameNode *Service) moveOnDataNodes(srcPath string, destPath string) {
+	request := datanode.DataNodeMoveRequest{SrcPath: srcPath, DestPath: destPath}
+	if !strings.HasSuffix(srcPath, "/") {
+		request = datanode.DataNodeMoveRequest{SrcPath: parentDirectory(srcPath), DestPath: parentDirectory(destPath)}
+		request.BlockIds = nameNode.physicalBlockIds(nameNode.FileNameToBlocks[srcPath])
+		if len(request.BlockIds) == 0 {
+			return
+		}
+	}
+	nameNode.callDataNodes("Service.MovePath", request)
+}
+
+// callDataNodes 记录发给所有dataNode的命令，释放命名空间锁之后发送，失败时记录日志并继续
+func (nameNode *Service) callDataNodes(serviceMethod string, request any) {
+	for _, dn := range nameNode.IdToDataNodes {
+		nameNode.queueCommand(dn, serviceMethod, request, nil)
+	}
+}
+
+// deleteTree 删除目录及其下所有文件和子目录的元数据，并删除所有dataNode上的目录；被快照引用的块保留下来，
+// 目录下有快照时不删除。删除回收站检查点和DeleteMetaData都使用
+func (nameNode *Service) deleteTree(directory string) {
+	if err := nameNode.checkNoSnapshots(directory); err != nil {
+		log.Printf("Unable to delete %s: %v\n", directory, err)
+		return
+	}
+	nameNode.cancelConversions(directory)
+	for fileName, blockIds := range nameNode.FileNameToBlocks {
+		if !strings.HasPrefix(fileName, directory) {
+			continue
+		}
+		nameNode.preserveFile(fileName)
+		for _, blockId := range nameNode.retainSnapshotBlocks(fileName, blockIds) {
+			nameNode.deleteBlockMetaData(blockId)
+		}
+		nameNode.deleteFileBlocks(fileName)
+		delete(nameNode.FileNameSize, fileName)
+		delete(nameNode.FileNameToReplication, fileName)
+		delete(nameNode.FileNameToECPolicy, fileName)
+		delete(nameNode.FileNameToEncryption, fileName)
+	}
+	for path := range nameNode.DirectoryToFileName {
+		if strings.HasPrefix(path, directory) {
+			delete(nameNode.DirectoryToFileName, path)
+		}
+	}
+	for path := range nameNode.DirectoryToReplication {
+		if strings.HasPrefix(path, directory) {
+			delete(nameNode.DirectoryToReplication, path)
+		}
+	}
+	for path := range nameNode.DirectoryToECPolicy {
+		if strings.HasPrefix(path, directory) {
+			delete(nameNode.DirectoryToECPolicy, path)
+		}
+	}
+	for path := range nameNode.DirectoryToEncryptionZone {
+		if strings.HasPrefix(path, directory) {
+			delete(nameNode.DirectoryToEncryptionZone, path)
+		}
+	}
+	for path := range nameNode.DirectoryToQuota {
+		if strings.HasPrefix(path, directory) {
+			delete(nameNode.DirectoryToQuota, path)
+		}
+	}
+	for path := range nameNode.DirectoryToSnapshots {
+		if strings.HasPrefix(path, directory) {
+			delete(nameNode.DirectoryToSnapshots, path)
+		}
+	}
+	for path := range nameNode.PathToPermission {
+		if strings.HasPrefix(path, directory) {
+			nameNode.deletePermission(path)
+		}
+	}
+	nameNode.rebuildQuotaUsage()
+	nameNode.callDataNodes("Service.DeletePath", datanode.DataNodeDeleteRequest{RemoteFilepath: directory})
+}
+
+// isTrashRoot 判断目录是否为回收站：/user/<用户名>/.Trash/或者<加密区>/.Trash/<用户名>/
+func (nameNode *Service) isTrashRoot(directory string) bool {
+	parent := parentDirectory(directory)
+	if strings.TrimPrefix(directory, parent) == trashDirectoryName {
+		return parentDirectory(parent) == userHomeDirectory
+	}
+	_, ok := nameNode.DirectoryToEncryptionZone[parentDirectory(parent)]
+	return ok && strings.TrimPrefix(parent, parentDirectory(parent)) == trashDirectoryName
+}
+
+// trashCheckpoints 所有回收站中的Current目录和检查点目录
+func (nameNode *Service) trashCheckpoints() []string {
+	directories := make(map[string]bool)
+	for path := range nameNode.PathToPermission {
+		if strings.HasSuffix(path, "/") {
+			directories[path] = true
+		}
+	}
+	for path := range nameNode.DirectoryToFileName {
+		directories[path] = true
+	}
+	var checkpoints []string
+	for path := range directories {
+		parent := parentDirectory(path)
+		if !nameNode.isTrashRoot(parent) {
+			continue
+		}
+		name := strings.TrimPrefix(path, parent)
+		if name == trashCurrentName {
+			checkpoints = append(checkpoints, path)
+		} else if _, err := time.ParseInLocation(trashCheckpointFormat, strings.TrimSuffix(name, "/"), time.Local); err == nil {
+			checkpoints = append(checkpoints, path)
+		}
+	}
+	return checkpoints
+}
+
+// emptyTrash 删除创建时间超过TrashInterval的检查点，再把各回收站的Current目录保存为以当前时间命名的检查点
+func (nameNode *Service) emptyTrash(now time.Time) {
+	for _, checkpoint := range nameNode.trashCheckpoints() {
+		parent := parentDirectory(checkpoint)
+		name := strings.TrimSuffix(strings.TrimPrefix(checkpoint, parent), "/")
+		if name+"/" == trashCurrentName {
+			newCheckpoint := parent + now.Format(trashCheckpointFormat) + "/"
+			if nameNode.pathExists(newCheckpoint) {
+				continue
+			}
+			log.Printf("Trash checkpoint %s created\n", newCheckpoint)
+			nameNode.moveOnDataNodes(checkpoint, newCheckpoint)
+			nameNode.renameMetaData(checkpoint, newCheckpoint)
+			continue
+		}
+		created, _ := time.ParseInLocation(trashCheckpointFormat, name, time.Local)
+		if now.Sub(created) >= nameNode.TrashInterval {
+			log.Printf("Trash checkpoint %s expired, deleting\n", checkpoint)
+			nameNode.deleteTree(checkpoint)
+		}
+	}
+}
+
+// TrashEmptier 回收站后台任务，每隔TrashCheckpointInterval把Current目录保存为检查点，并删除过期的检查点，
+// 删除的路径在回收站中保留TrashInterval到TrashInterval+TrashCheckpointInterval之间。TrashInterval为0时不启动
+func (nameNode *Service) TrashEmptier() {
+	if nameNode.TrashInterval <= 0 {
+		return
+	}
+	interval := nameNode.TrashCheckpointInterval
+	if interval <= 0 || interval > nameNode.TrashInterval {
+		interval = nameNode.TrashInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		nameNode.lock()
+		//备份nameNode的元数据从主节点同步；安全模式下不修改命名空间
+		if nameNode.isPrimary() && !nameNode.inSafeMode() {
+			nameNode.emptyTrash(now)
+		}
+		nameNode.unlock()
+	}
+}
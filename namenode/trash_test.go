@@ -0,0 +1,142 @@
+package namenode
+
+import (
+	"github.com/liuzongzhou/GoDFS/encryption"
+	"github.com/liuzongzhou/GoDFS/util"
+	"testing"
+	"time"
+)
+
+// TestNameNodeServiceMoveToTrash 测试删除时文件和目录移到用户回收站的Current目录下，回收站只有用户自己可以访问
+func TestNameNodeServiceMoveToTrash(t *testing.T) {
+	testNameNodeService := newTestService("1234", "4321")
+	testNameNodeService.TrashInterval = time.Hour
+	alice := UserInfo{Name: "alice"}
+	bob := UserInfo{Name: "bob"}
+	var metadata []NameNodeMetaData
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/alice/data/", FileName: "foo", FileSize: 8, User: alice}, &metadata))
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/alice/data/", FileName: "bar", FileSize: 8, User: alice}, &metadata))
+	blocks := testNameNodeService.FileNameToBlocks["/alice/data/foo"]
+
+	var reply NameNodeMoveToTrashReply
+	if err := testNameNodeService.MoveToTrash(&NameNodeMoveToTrashRequest{RemoteFilePath: "/alice/data/", FileName: "foo", User: bob}, &reply); err == nil {
+		t.Errorf("Moving to trash should require delete permission")
+	}
+	util.Check(testNameNodeService.MoveToTrash(&NameNodeMoveToTrashRequest{RemoteFilePath: "/alice/data/", FileName: "foo", User: alice}, &reply))
+	trashPath := "/user/alice/.Trash/Current/alice/data/foo"
+	if !reply.Moved || reply.TrashPath != trashPath {
+		t.Fatalf("Unable to move file to trash: %+v", reply)
+	}
+	if _, ok := testNameNodeService.FileNameToBlocks["/alice/data/foo"]; ok {
+		t.Errorf("File should be removed from the original path")
+	}
+	if len(testNameNodeService.FileNameToBlocks[trashPath]) != len(blocks) || testNameNodeService.FileNameSize[trashPath] != 8 {
+		t.Errorf("Unable to move file metadata to trash")
+	}
+	if fileNames := testNameNodeService.DirectoryToFileName["/alice/data/"]; len(fileNames) != 1 || fileNames[0] != "bar" {
+		t.Errorf("File should be removed from the original directory: %v", fileNames)
+	}
+	if fileNames := testNameNodeService.DirectoryToFileName["/user/alice/.Trash/Current/alice/data/"]; len(fileNames) != 1 || fileNames[0] != "foo" {
+		t.Errorf("File should be listed in trash: %v", fileNames)
+	}
+	if permission := testNameNodeService.PathToPermission["/user/alice/.Trash/"]; permission.Owner != "alice" || permission.Mode != 0700 {
+		t.Errorf("Trash should only be accessible to its user: %+v", permission)
+	}
+	var list []ListMetaData
+	if err := testNameNodeService.List(&NameNodeListRequest{RemoteDirPath: "/user/alice/.Trash/Current/alice/data/", User: bob}, &list); err == nil {
+		t.Errorf("Other users should not list the trash")
+	}
+
+	// 回收站中已有同名文件时加上时间后缀
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/alice/data/", FileName: "foo", FileSize: 4, User: alice}, &metadata))
+	util.Check(testNameNodeService.MoveToTrash(&NameNodeMoveToTrashRequest{RemoteFilePath: "/alice/data/", FileName: "foo", User: alice}, &reply))
+	if !reply.Moved || reply.TrashPath == trashPath || testNameNodeService.FileNameSize[reply.TrashPath] != 4 {
+		t.Errorf("Unable to move file with the same name to trash: %+v", reply)
+	}
+
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/alice/logs/old/", FileName: "baz", FileSize: 8, User: alice}, &metadata))
+	util.Check(testNameNodeService.MoveToTrash(&NameNodeMoveToTrashRequest{RemoteFilePath: "/alice/logs/", User: alice}, &reply))
+	if !reply.Moved || reply.TrashPath != "/user/alice/.Trash/Current/alice/logs/" || testNameNodeService.FileNameSize["/user/alice/.Trash/Current/alice/logs/old/baz"] != 8 {
+		t.Errorf("Unable to move directory to trash: %+v", reply)
+	}
+	if fileNames := testNameNodeService.DirectoryToFileName["/user/alice/.Trash/Current/alice/logs/old/"]; len(fileNames) != 1 {
+		t.Errorf("Subdirectories should be moved to trash: %v", fileNames)
+	}
+
+	// 回收站中的路径直接删除
+	util.Check(testNameNodeService.MoveToTrash(&NameNodeMoveToTrashRequest{RemoteFilePath: "/user/alice/.Trash/Current/alice/logs/old/", FileName: "baz", User: alice}, &reply))
+	if reply.Moved {
+		t.Errorf("Path in trash should not be moved to trash again")
+	}
+	if err := testNameNodeService.MoveToTrash(&NameNodeMoveToTrashRequest{RemoteFilePath: "/user/", User: alice}, &reply); err == nil {
+		t.Errorf("Directory containing the trash should not be moved to trash")
+	}
+	testNameNodeService.TrashInterval = 0
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/bob/", FileName: "foo", FileSize: 8, User: bob}, &metadata))
+	util.Check(testNameNodeService.MoveToTrash(&NameNodeMoveToTrashRequest{RemoteFilePath: "/bob/", FileName: "foo", User: bob}, &reply))
+	if reply.Moved {
+		t.Errorf("Disabled trash should not move files")
+	}
+}
+
+// TestNameNodeServiceEncryptionZoneTrash 测试加密区中的文件移到加密区自己的回收站，不离开加密区
+func TestNameNodeServiceEncryptionZoneTrash(t *testing.T) {
+	testNameNodeService := newTestService("1234", "4321")
+	testNameNodeService.TrashInterval = time.Hour
+	root := UserInfo{Name: "root"}
+	var ok bool
+	util.Check(testNameNodeService.Mkdir(&NameNodeMkdirRequest{RemoteFilePath: "/secure/", User: root}, &ok))
+	util.Check(testNameNodeService.CreateEncryptionZone(&NameNodeCreateEncryptionZoneRequest{RemoteFilePath: "/secure/", KeyName: "key1", Cipher: encryption.CipherAESCTR, User: root}, &ok))
+	info := FileEncryptionInfo{KeyName: "key1", Cipher: encryption.CipherAESCTR, EncryptedDataKey: []byte("wrapped"), IV: []byte("iv")}
+	var metadata []NameNodeMetaData
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/secure/", FileName: "foo", FileSize: 8, User: root, Encryption: info}, &metadata))
+
+	var reply NameNodeMoveToTrashReply
+	util.Check(testNameNodeService.MoveToTrash(&NameNodeMoveToTrashRequest{RemoteFilePath: "/secure/", FileName: "foo", User: root}, &reply))
+	if reply.TrashPath != "/secure/.Trash/root/Current/secure/foo" {
+		t.Errorf("Encrypted file should be moved to the trash of its encryption zone: %+v", reply)
+	}
+	if _, ok := testNameNodeService.FileNameToEncryption[reply.TrashPath]; !ok {
+		t.Errorf("Unable to move file encryption info to trash")
+	}
+	if permission := testNameNodeService.PathToPermission["/secure/.Trash/"]; permission.Mode != rootMode {
+		t.Errorf("Trash of encryption zone should be shared by all users: %+v", permission)
+	}
+}
+
+// TestNameNodeServiceEmptyTrash 测试Current目录保存为检查点，检查点超过TrashInterval后删除
+func TestNameNodeServiceEmptyTrash(t *testing.T) {
+	testNameNodeService := newTestService("1234", "4321")
+	testNameNodeService.TrashInterval = time.Hour
+	alice := UserInfo{Name: "alice"}
+	var metadata []NameNodeMetaData
+	util.Check(testNameNodeService.WriteData(&NameNodeWriteRequest{RemoteFilePath: "/alice/", FileName: "foo", FileSize: 8, User: alice}, &metadata))
+	blockId := testNameNodeService.FileNameToBlocks["/alice/foo"][0]
+	var reply NameNodeMoveToTrashReply
+	util.Check(testNameNodeService.MoveToTrash(&NameNodeMoveToTrashRequest{RemoteFilePath: "/alice/", FileName: "foo", User: alice}, &reply))
+
+	now := time.Now()
+	testNameNodeService.emptyTrash(now)
+	checkpoint := "/user/alice/.Trash/" + now.Format(trashCheckpointFormat) + "/"
+	if _, ok := testNameNodeService.FileNameToBlocks[checkpoint+"alice/foo"]; !ok {
+		t.Fatalf("Unable to create trash checkpoint: %v", testNameNodeService.FileNameToBlocks)
+	}
+	if testNameNodeService.pathExists("/user/alice/.Trash/Current/") {
+		t.Errorf("Current should be renamed to the checkpoint")
+	}
+
+	testNameNodeService.emptyTrash(now.Add(30 * time.Minute))
+	if _, ok := testNameNodeService.FileNameToBlocks[checkpoint+"alice/foo"]; !ok {
+		t.Errorf("Checkpoint should be kept within the trash interval")
+	}
+	testNameNodeService.emptyTrash(now.Add(time.Hour + time.Second))
+	if testNameNodeService.pathExists(checkpoint) {
+		t.Errorf("Expired checkpoint should be deleted")
+	}
+	if _, ok := testNameNodeService.BlockToDataNodeIds[blockId]; ok {
+		t.Errorf("Blocks of expired checkpoint should be deleted")
+	}
+	if !testNameNodeService.pathExists("/user/alice/.Trash/") {
+		t.Errorf("Trash should be kept after emptying")
+	}
+}